@@ -0,0 +1,419 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/asm"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// debugHelp is the list of commands accepted by the interactive debugger.
+const debugHelp = `Commands:
+  s, step               execute the current instruction and pause at the next one
+  n, next               like step, but do not pause inside nested calls
+  o, out                continue until the current call frame returns
+  c, continue           continue until a breakpoint is hit
+  b, break <kind> <v>   add a breakpoint on a pc, op, depth or storage key
+  d, delete <id>        remove a breakpoint
+  i, breakpoints        list the breakpoints
+  st, stack             show the stack
+  m, memory             show the memory
+  sto, storage          show the storage of the current contract
+  l, list               show the disassembled code around the current instruction
+  q, quit               abort the execution
+  h, help               show this help
+An empty line repeats the previous command.`
+
+// debugMode determines when the debugger pauses the execution next.
+type debugMode int
+
+const (
+	modeStep     debugMode = iota // Pause before every instruction
+	modeNext                      // Pause at the next instruction not deeper than the origin
+	modeOut                       // Pause at the first instruction above the origin
+	modeContinue                  // Pause only on breakpoints and errors
+	modeDetached                  // Never pause again
+)
+
+// breakpointKind is the condition type a breakpoint triggers on.
+type breakpointKind int
+
+const (
+	breakOnPC breakpointKind = iota
+	breakOnOp
+	breakOnDepth
+	breakOnStorage
+)
+
+// breakpoint is a condition that pauses the execution when met.
+type breakpoint struct {
+	id    int
+	kind  breakpointKind
+	pc    uint64
+	op    vm.OpCode
+	depth int
+	key   common.Hash
+}
+
+// parseBreakpoint creates a breakpoint out of its kind and value as entered in
+// the debugger prompt.
+func parseBreakpoint(kind, value string) (*breakpoint, error) {
+	switch kind {
+	case "pc":
+		pc, err := strconv.ParseUint(value, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pc %q", value)
+		}
+		return &breakpoint{kind: breakOnPC, pc: pc}, nil
+
+	case "op":
+		op := vm.StringToOp(strings.ToUpper(value))
+		if op.String() != strings.ToUpper(value) {
+			return nil, fmt.Errorf("unknown opcode %q", value)
+		}
+		return &breakpoint{kind: breakOnOp, op: op}, nil
+
+	case "depth":
+		depth, err := strconv.Atoi(value)
+		if err != nil || depth < 1 {
+			return nil, fmt.Errorf("invalid depth %q", value)
+		}
+		return &breakpoint{kind: breakOnDepth, depth: depth}, nil
+
+	case "storage":
+		key, ok := new(big.Int).SetString(value, 0)
+		if !ok || key.Sign() < 0 || key.BitLen() > 256 {
+			return nil, fmt.Errorf("invalid storage key %q", value)
+		}
+		return &breakpoint{kind: breakOnStorage, key: common.BigToHash(key)}, nil
+	}
+	return nil, fmt.Errorf("unknown breakpoint kind %q, want pc, op, depth or storage", kind)
+}
+
+// matches checks whether the breakpoint triggers on the given instruction. Storage
+// breakpoints trigger on any SLOAD or SSTORE accessing the key.
+func (bp *breakpoint) matches(pc uint64, op vm.OpCode, stack *vm.Stack, depth int) bool {
+	switch bp.kind {
+	case breakOnPC:
+		return bp.pc == pc
+	case breakOnOp:
+		return bp.op == op
+	case breakOnDepth:
+		return bp.depth == depth
+	case breakOnStorage:
+		if (op == vm.SLOAD || op == vm.SSTORE) && len(stack.Data()) > 0 {
+			return common.BigToHash(stack.Back(0)) == bp.key
+		}
+	}
+	return false
+}
+
+// String implements fmt.Stringer.
+func (bp *breakpoint) String() string {
+	switch bp.kind {
+	case breakOnPC:
+		return fmt.Sprintf("#%d pc %d", bp.id, bp.pc)
+	case breakOnOp:
+		return fmt.Sprintf("#%d op %v", bp.id, bp.op)
+	case breakOnDepth:
+		return fmt.Sprintf("#%d depth %d", bp.id, bp.depth)
+	default:
+		return fmt.Sprintf("#%d storage %x", bp.id, bp.key)
+	}
+}
+
+// Debugger is an EVM tracer which pauses the execution on breakpoints and lets
+// the user inspect the machine state and step through the code interactively.
+type Debugger struct {
+	in  *bufio.Scanner
+	out io.Writer
+
+	mode   debugMode // Condition for pausing the execution next
+	origin int       // Call depth at which the last next or out command was given
+	last   string    // Last command entered, repeated on empty input
+
+	breakpoints []*breakpoint
+	nextID      int
+}
+
+// NewDebugger creates an interactive debugger reading commands from in and
+// printing the machine state to out. Execution pauses before the first
+// instruction.
+func NewDebugger(in io.Reader, out io.Writer) *Debugger {
+	return &Debugger{
+		in:     bufio.NewScanner(in),
+		out:    out,
+		mode:   modeStep,
+		nextID: 1,
+	}
+}
+
+// CaptureStart is triggered when the top level call or create starts.
+func (d *Debugger) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	kind := "call"
+	if create {
+		kind = "create"
+	}
+	fmt.Fprintf(d.out, "Starting %s from %x to %x, gas %d, value %v\n", kind, from, to, gas, value)
+	return nil
+}
+
+// CaptureState pauses the execution before the instruction at pc if any of the
+// stepping conditions or breakpoints is met.
+func (d *Debugger) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if d.mode == modeDetached {
+		return nil
+	}
+	var pause bool
+	switch d.mode {
+	case modeStep:
+		pause = true
+	case modeNext:
+		pause = depth <= d.origin
+	case modeOut:
+		pause = depth < d.origin
+	}
+	for _, bp := range d.breakpoints {
+		if bp.matches(pc, op, stack, depth) {
+			fmt.Fprintf(d.out, "Breakpoint %v hit\n", bp)
+			pause = true
+		}
+	}
+	if pause || err != nil {
+		d.pause(env, pc, op, gas, cost, memory, stack, contract, depth, err)
+	}
+	return nil
+}
+
+// CaptureFault pauses the execution after an instruction failed.
+func (d *Debugger) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if d.mode != modeDetached {
+		d.pause(env, pc, op, gas, cost, memory, stack, contract, depth, err)
+	}
+	return nil
+}
+
+// CaptureEnd is triggered when the top level call or create finishes.
+func (d *Debugger) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error {
+	fmt.Fprintf(d.out, "Execution finished, gas used %d, output 0x%x\n", gasUsed, output)
+	if err != nil {
+		fmt.Fprintf(d.out, "Execution error: %v\n", err)
+	}
+	return nil
+}
+
+// pause prints the current instruction and processes user commands until one
+// of them resumes the execution.
+func (d *Debugger) pause(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) {
+	instrs, index := disassemble(contract.Code, pc)
+
+	current := fmt.Sprintf("%06v: %v", pc, op)
+	if index >= 0 {
+		current = instrs[index]
+	}
+	fmt.Fprintf(d.out, "[depth %d] %x %s (gas %d, cost %d)\n", depth, contract.Address(), current, gas, cost)
+	if err != nil {
+		fmt.Fprintf(d.out, "Error: %v\n", err)
+	}
+	for {
+		fmt.Fprint(d.out, "> ")
+		if !d.in.Scan() {
+			// Input exhausted, run the remainder of the code unattended
+			fmt.Fprintln(d.out)
+			d.mode = modeDetached
+			return
+		}
+		line := strings.TrimSpace(d.in.Text())
+		if line == "" {
+			line = d.last
+		}
+		d.last = line
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "s", "step":
+			d.mode = modeStep
+			return
+
+		case "n", "next":
+			d.mode, d.origin = modeNext, depth
+			return
+
+		case "o", "out":
+			d.mode, d.origin = modeOut, depth
+			return
+
+		case "c", "continue":
+			d.mode = modeContinue
+			return
+
+		case "q", "quit":
+			fmt.Fprintln(d.out, "Aborting execution")
+			d.mode = modeDetached
+			env.Cancel()
+			return
+
+		case "b", "break":
+			if len(fields) != 3 {
+				fmt.Fprintln(d.out, "Usage: break <pc|op|depth|storage> <value>")
+				continue
+			}
+			bp, err := parseBreakpoint(fields[1], fields[2])
+			if err != nil {
+				fmt.Fprintf(d.out, "Error: %v\n", err)
+				continue
+			}
+			bp.id = d.nextID
+			d.nextID++
+			d.breakpoints = append(d.breakpoints, bp)
+			fmt.Fprintf(d.out, "Breakpoint %v added\n", bp)
+
+		case "d", "delete":
+			if len(fields) != 2 {
+				fmt.Fprintln(d.out, "Usage: delete <id>")
+				continue
+			}
+			id, err := strconv.Atoi(strings.TrimPrefix(fields[1], "#"))
+			if err != nil || !d.deleteBreakpoint(id) {
+				fmt.Fprintf(d.out, "Error: unknown breakpoint %q\n", fields[1])
+			}
+
+		case "i", "breakpoints":
+			if len(d.breakpoints) == 0 {
+				fmt.Fprintln(d.out, "No breakpoints")
+			}
+			for _, bp := range d.breakpoints {
+				fmt.Fprintln(d.out, bp)
+			}
+
+		case "st", "stack":
+			data := stack.Data()
+			if len(data) == 0 {
+				fmt.Fprintln(d.out, "Stack is empty")
+			}
+			for i := len(data) - 1; i >= 0; i-- {
+				fmt.Fprintf(d.out, "%08d  %x\n", len(data)-i-1, math.PaddedBigBytes(data[i], 32))
+			}
+
+		case "m", "memory":
+			if memory.Len() == 0 {
+				fmt.Fprintln(d.out, "Memory is empty")
+			}
+			fmt.Fprint(d.out, hex.Dump(memory.Data()))
+
+		case "sto", "storage":
+			d.printStorage(env, contract.Address())
+
+		case "l", "list":
+			if index < 0 {
+				fmt.Fprintln(d.out, "Current instruction is outside of the code")
+				continue
+			}
+			start, end := index-5, index+6
+			if start < 0 {
+				start = 0
+			}
+			if end > len(instrs) {
+				end = len(instrs)
+			}
+			for i := start; i < end; i++ {
+				marker := "  "
+				if i == index {
+					marker = "=>"
+				}
+				fmt.Fprintf(d.out, "%s %s\n", marker, instrs[i])
+			}
+
+		case "h", "help":
+			fmt.Fprintln(d.out, debugHelp)
+
+		default:
+			fmt.Fprintf(d.out, "Unknown command %q, type help for the list of commands\n", fields[0])
+		}
+	}
+}
+
+// deleteBreakpoint removes the breakpoint with the given id, returning whether
+// it existed.
+func (d *Debugger) deleteBreakpoint(id int) bool {
+	for i, bp := range d.breakpoints {
+		if bp.id == id {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// printStorage prints the storage slots of the given account sorted by key.
+func (d *Debugger) printStorage(env *vm.EVM, addr common.Address) {
+	storage := make(map[common.Hash]common.Hash)
+	env.StateDB.ForEachStorage(addr, func(key, value common.Hash) bool {
+		storage[key] = value
+		return true
+	})
+	if len(storage) == 0 {
+		fmt.Fprintln(d.out, "Storage is empty")
+		return
+	}
+	keys := make([]common.Hash, 0, len(storage))
+	for key := range storage {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i][:], keys[j][:]) < 0 })
+
+	for _, key := range keys {
+		fmt.Fprintf(d.out, "%x: %x\n", key, storage[key])
+	}
+}
+
+// disassemble converts the code into human readable instructions, returning them
+// along with the index of the instruction located at pc (-1 if there is none).
+func disassemble(code []byte, pc uint64) ([]string, int) {
+	var (
+		instrs []string
+		index  = -1
+	)
+	it := asm.NewInstructionIterator(code)
+	for it.Next() {
+		if it.PC() == pc {
+			index = len(instrs)
+		}
+		if len(it.Arg()) > 0 {
+			instrs = append(instrs, fmt.Sprintf("%06v: %v 0x%x", it.PC(), it.Op(), it.Arg()))
+		} else {
+			instrs = append(instrs, fmt.Sprintf("%06v: %v", it.PC(), it.Op()))
+		}
+	}
+	return instrs, index
+}
@@ -0,0 +1,181 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/runtime"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// calleeCode is deployed at address 0xca for the tests to call into. It stores
+// 1 in slot 0 and stops.
+var calleeCode = common.Hex2Bytes("600160005500")
+
+// runDebugger executes the code with a debugger driven by the given commands,
+// returning everything the debugger printed.
+func runDebugger(t *testing.T, code []byte, commands ...string) string {
+	db, _ := ethdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+	statedb.SetCode(common.BytesToAddress([]byte{0xca}), calleeCode)
+
+	out := new(bytes.Buffer)
+	debugger := NewDebugger(strings.NewReader(strings.Join(commands, "\n")+"\n"), out)
+
+	cfg := &runtime.Config{State: statedb, EVMConfig: vm.Config{Debug: true, Tracer: debugger}}
+	if _, _, err := runtime.Execute(code, nil, cfg); err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+	return out.String()
+}
+
+// Tests that the debugger pauses on the first instruction and steps through the
+// code one instruction at a time.
+func TestDebuggerStep(t *testing.T) {
+	// PUSH1 0x02 PUSH1 0x03 ADD STOP
+	code := common.Hex2Bytes("600260030100")
+	out := runDebugger(t, code, "step", "", "stack", "continue")
+
+	for _, want := range []string{
+		"000000: PUSH1 0x02",
+		"000002: PUSH1 0x03",
+		"000004: ADD",
+		"00000000  0000000000000000000000000000000000000000000000000000000000000003",
+		"00000001  0000000000000000000000000000000000000000000000000000000000000002",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "000005: STOP") {
+		t.Errorf("debugger paused after continue:\n%s", out)
+	}
+}
+
+// Tests that breakpoints on pcs, opcodes and storage keys pause the execution.
+func TestDebuggerBreakpoints(t *testing.T) {
+	// PUSH1 0x2a PUSH1 0x07 SSTORE PUSH1 0x07 SLOAD POP STOP
+	code := common.Hex2Bytes("602a60075560075450" + "00")
+
+	out := runDebugger(t, code, "break storage 7", "continue", "continue", "storage", "continue")
+	if n := strings.Count(out, "Breakpoint #1 storage"); n != 3 {
+		t.Errorf("storage breakpoint added/hit %d times, want 3:\n%s", n, out)
+	}
+	want := "0000000000000000000000000000000000000000000000000000000000000007: 000000000000000000000000000000000000000000000000000000000000002a"
+	if !strings.Contains(out, want) {
+		t.Errorf("storage not shown after SSTORE:\n%s", out)
+	}
+	out = runDebugger(t, code, "break op SLOAD", "break pc 2", "breakpoints", "continue", "delete 1", "continue")
+	if !strings.Contains(out, "Breakpoint #2 pc 2 hit") {
+		t.Errorf("pc breakpoint not hit:\n%s", out)
+	}
+	if strings.Contains(out, "Breakpoint #1 op SLOAD hit") {
+		t.Errorf("deleted breakpoint hit:\n%s", out)
+	}
+}
+
+// Tests that next steps over nested calls while out returns to the caller.
+func TestDebuggerCallFrames(t *testing.T) {
+	// CALL(gas, 0xca, 0, 0, 0, 0, 0) POP STOP
+	code := common.Hex2Bytes("6000600060006000600060ca5af15000")
+
+	var (
+		caller   = fmt.Sprintf("[depth 1] %x 000014: POP", common.StringToAddress("contract"))
+		callee   = fmt.Sprintf("[depth 2] %x 000000: PUSH1 0x01", common.BytesToAddress([]byte{0xca}))
+		internal = "[depth 2]"
+	)
+	out := runDebugger(t, code, "break op CALL", "continue", "next", "continue")
+	if strings.Contains(out, internal) {
+		t.Errorf("next paused in nested call:\n%s", out)
+	}
+	if !strings.Contains(out, caller) {
+		t.Errorf("next did not pause after the call:\n%s", out)
+	}
+	out = runDebugger(t, code, "break op CALL", "continue", "step", "out", "continue")
+	if !strings.Contains(out, callee) {
+		t.Errorf("step did not enter the call:\n%s", out)
+	}
+	if !strings.Contains(out, caller) {
+		t.Errorf("out did not return to the caller:\n%s", out)
+	}
+	if strings.Count(out, internal) != 1 {
+		t.Errorf("out paused in nested call:\n%s", out)
+	}
+}
+
+// Tests that a signed transaction can be replayed on top of a prestate with the
+// debugger attached, taking the sender and nonce from the transaction.
+func TestDebuggerReplayTx(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	callee := common.BytesToAddress([]byte{0xca})
+
+	db, _ := ethdb.NewMemDatabase()
+	gspec := &core.Genesis{
+		Config:   params.AllEthashProtocolChanges,
+		GasLimit: 1000000,
+		Alloc: core.GenesisAlloc{
+			sender: {Balance: big.NewInt(1000000000)},
+			callee: {Code: calleeCode, Balance: new(big.Int)},
+		},
+	}
+	genesis := gspec.MustCommit(db)
+	signer := types.MakeSigner(gspec.Config, big.NewInt(1))
+
+	for i, nonce := range []uint64{1, 0} {
+		statedb, _ := state.New(genesis.Root(), state.NewDatabase(db))
+		tx, _ := types.SignTx(types.NewTransaction(nonce, callee, big.NewInt(0), 100000, big.NewInt(1), nil), signer, key)
+
+		out := new(bytes.Buffer)
+		debugger := NewDebugger(strings.NewReader("continue\n"), out)
+
+		_, left, err := replayTx(tx, genesis, gspec.Config, statedb, vm.Config{Debug: true, Tracer: debugger})
+		if nonce != 0 {
+			if err != core.ErrNonceTooHigh {
+				t.Errorf("test %d: error mismatch: have %v, want %v", i, err, core.ErrNonceTooHigh)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("test %d: replay failed: %v", i, err)
+		}
+		if left == 0 || left >= tx.Gas() {
+			t.Errorf("test %d: leftover gas out of range: %d", i, left)
+		}
+		if !strings.Contains(out.String(), "000000: PUSH1 0x01") {
+			t.Errorf("test %d: debugger did not pause in the callee:\n%s", i, out)
+		}
+		if n := statedb.GetNonce(sender); n != 1 {
+			t.Errorf("test %d: sender nonce mismatch: have %d, want 1", i, n)
+		}
+		if v := statedb.GetState(callee, common.Hash{}); v != common.BigToHash(common.Big1) {
+			t.Errorf("test %d: storage mismatch: have %x, want 1", i, v)
+		}
+	}
+}
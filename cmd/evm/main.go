@@ -110,6 +110,26 @@ var (
 		Name:  "nostack",
 		Usage: "disable stack output",
 	}
+	DebuggerFlag = cli.BoolFlag{
+		Name:  "debugger",
+		Usage: "step through the execution in an interactive debugger (commands are read from stdin)",
+	}
+	GasProfileFlag = cli.StringFlag{
+		Name:  "gasprofile",
+		Usage: "writes a profile of the gas used by the EVM code in pprof format to the given path",
+	}
+	GasFoldedFlag = cli.StringFlag{
+		Name:  "gasfolded",
+		Usage: "writes a profile of the gas used by the EVM code as folded stacks (for flamegraphs) to the given path",
+	}
+	GasSourcesFlag = cli.StringFlag{
+		Name:  "gassources",
+		Usage: "JSON file with the compiled contracts by address, used to map gas profiles to source lines",
+	}
+	TxFlag = cli.StringFlag{
+		Name:  "tx",
+		Usage: "RLP encoded signed transaction to replay on top of the prestate (sender, nonce, value, gas and input are taken from the transaction)",
+	}
 )
 
 func init() {
@@ -133,12 +153,18 @@ func init() {
 		ReceiverFlag,
 		DisableMemoryFlag,
 		DisableStackFlag,
+		DebuggerFlag,
+		GasProfileFlag,
+		GasFoldedFlag,
+		GasSourcesFlag,
+		TxFlag,
 	}
 	app.Commands = []cli.Command{
 		compileCommand,
 		disasmCommand,
 		runCommand,
 		stateTestCommand,
+		transitionCommand,
 	}
 }
 
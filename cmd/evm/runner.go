@@ -19,8 +19,11 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/big"
 	"os"
 	"runtime/pprof"
 	"time"
@@ -30,22 +33,31 @@ import (
 	"github.com/ethereum/go-ethereum/cmd/evm/internal/compiler"
 	"github.com/ethereum/go-ethereum/cmd/utils"
 	"github.com/ethereum/go-ethereum/common"
+	solc "github.com/ethereum/go-ethereum/common/compiler"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/core/vm/runtime"
+	"github.com/ethereum/go-ethereum/eth/tracers/profiler"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
 	cli "gopkg.in/urfave/cli.v1"
 )
 
+// errTxFailed is returned if a replayed transaction was included but its
+// execution failed.
+var errTxFailed = errors.New("transaction execution failed")
+
 var runCommand = cli.Command{
-	Action:      runCmd,
-	Name:        "run",
-	Usage:       "run arbitrary evm binary",
-	ArgsUsage:   "<code>",
-	Description: `The run command runs arbitrary EVM code.`,
+	Action:    runCmd,
+	Name:      "run",
+	Usage:     "run arbitrary evm binary",
+	ArgsUsage: "<code>",
+	Description: `The run command runs arbitrary EVM code, or replays a signed transaction (--tx)
+on top of a --prestate genesis.`,
 }
 
 // readGenesis will read the given JSON format genesis file and return
@@ -81,12 +93,20 @@ func runCmd(ctx *cli.Context) error {
 	var (
 		tracer      vm.Tracer
 		debugLogger *vm.StructLogger
+		gasProfiler *profiler.Profiler
+		genesis     *types.Block
+		tx          *types.Transaction
 		statedb     *state.StateDB
 		chainConfig *params.ChainConfig
 		sender      = common.StringToAddress("sender")
 		receiver    = common.StringToAddress("receiver")
 	)
-	if ctx.GlobalBool(MachineFlag.Name) {
+	if ctx.GlobalBool(DebuggerFlag.Name) {
+		if ctx.GlobalString(CodeFileFlag.Name) == "-" {
+			utils.Fatalf("The debugger reads its commands from stdin, code cannot be read from there")
+		}
+		tracer = NewDebugger(os.Stdin, os.Stdout)
+	} else if ctx.GlobalBool(MachineFlag.Name) {
 		tracer = NewJSONLogger(logconfig, os.Stdout)
 	} else if ctx.GlobalBool(DebugFlag.Name) {
 		debugLogger = vm.NewStructLogger(logconfig)
@@ -94,20 +114,51 @@ func runCmd(ctx *cli.Context) error {
 	} else {
 		debugLogger = vm.NewStructLogger(logconfig)
 	}
+	if ctx.GlobalString(GasProfileFlag.Name) != "" || ctx.GlobalString(GasFoldedFlag.Name) != "" {
+		if tracer != nil {
+			utils.Fatalf("Gas profiling cannot be combined with --json, --debug or --debugger")
+		}
+		var contracts map[common.Address]*solc.Contract
+		if path := ctx.GlobalString(GasSourcesFlag.Name); path != "" {
+			blob, err := ioutil.ReadFile(path)
+			if err != nil {
+				utils.Fatalf("Failed to read contract sources: %v", err)
+			}
+			if err := json.Unmarshal(blob, &contracts); err != nil {
+				utils.Fatalf("Invalid contract sources: %v", err)
+			}
+		}
+		gasProfiler = profiler.New(contracts)
+		tracer = gasProfiler
+	}
 	if ctx.GlobalString(GenesisFlag.Name) != "" {
 		gen := readGenesis(ctx.GlobalString(GenesisFlag.Name))
 		db, _ := ethdb.NewMemDatabase()
-		genesis := gen.ToBlock(db)
+		genesis = gen.ToBlock(db)
 		statedb, _ = state.New(genesis.Root(), state.NewDatabase(db))
 		chainConfig = gen.Config
 	} else {
 		db, _ := ethdb.NewMemDatabase()
 		statedb, _ = state.New(common.Hash{}, state.NewDatabase(db))
 	}
+	if ctx.GlobalString(TxFlag.Name) != "" {
+		if genesis == nil {
+			utils.Fatalf("Replaying a transaction requires a --prestate genesis")
+		}
+		if ctx.GlobalString(CodeFlag.Name) != "" || ctx.GlobalString(CodeFileFlag.Name) != "" || ctx.NArg() > 0 || ctx.GlobalBool(CreateFlag.Name) {
+			utils.Fatalf("Transactions are replayed against the prestate code, --code, --codefile and --create cannot be used")
+		}
+		tx = new(types.Transaction)
+		if err := rlp.DecodeBytes(common.FromHex(ctx.GlobalString(TxFlag.Name)), tx); err != nil {
+			utils.Fatalf("Invalid transaction: %v", err)
+		}
+	}
 	if ctx.GlobalString(SenderFlag.Name) != "" {
 		sender = common.HexToAddress(ctx.GlobalString(SenderFlag.Name))
 	}
-	statedb.CreateAccount(sender)
+	if tx == nil {
+		statedb.CreateAccount(sender)
+	}
 
 	if ctx.GlobalString(ReceiverFlag.Name) != "" {
 		receiver = common.HexToAddress(ctx.GlobalString(ReceiverFlag.Name))
@@ -162,7 +213,7 @@ func runCmd(ctx *cli.Context) error {
 		Value:    utils.GlobalBig(ctx, ValueFlag.Name),
 		EVMConfig: vm.Config{
 			Tracer: tracer,
-			Debug:  ctx.GlobalBool(DebugFlag.Name) || ctx.GlobalBool(MachineFlag.Name),
+			Debug:  tracer != nil,
 		},
 	}
 
@@ -184,7 +235,10 @@ func runCmd(ctx *cli.Context) error {
 	}
 	tstart := time.Now()
 	var leftOverGas uint64
-	if ctx.GlobalBool(CreateFlag.Name) {
+	if tx != nil {
+		initialGas = tx.Gas()
+		ret, leftOverGas, err = replayTx(tx, genesis, chainConfig, statedb, runtimeConfig.EVMConfig)
+	} else if ctx.GlobalBool(CreateFlag.Name) {
 		input := append(code, common.Hex2Bytes(ctx.GlobalString(InputFlag.Name))...)
 		ret, _, leftOverGas, err = runtime.Create(input, &runtimeConfig)
 	} else {
@@ -200,6 +254,11 @@ func runCmd(ctx *cli.Context) error {
 		fmt.Println(string(statedb.Dump()))
 	}
 
+	if gasProfiler != nil {
+		writeGasProfile(ctx.GlobalString(GasProfileFlag.Name), gasProfiler.WritePprof)
+		writeGasProfile(ctx.GlobalString(GasFoldedFlag.Name), gasProfiler.WriteFolded)
+	}
+
 	if memProfilePath := ctx.GlobalString(MemProfileFlag.Name); memProfilePath != "" {
 		f, err := os.Create(memProfilePath)
 		if err != nil {
@@ -234,14 +293,77 @@ Gas used:           %d
 
 `, execTime, mem.HeapObjects, mem.Alloc, mem.TotalAlloc, mem.NumGC, initialGas-leftOverGas)
 	}
-	if tracer != nil {
-		tracer.CaptureEnd(ret, initialGas-leftOverGas, execTime, err)
-	} else {
+	// The debugger and the gas profiler don't output the result of the execution
+	switch tracer.(type) {
+	case nil, *Debugger, *profiler.Profiler:
 		fmt.Printf("0x%x\n", ret)
 		if err != nil {
 			fmt.Printf(" error: %v\n", err)
 		}
+	default:
+		tracer.CaptureEnd(ret, initialGas-leftOverGas, execTime, err)
 	}
 
 	return nil
 }
+
+// replayTx executes a signed transaction on top of the prestate in the context of
+// the block following the genesis, with the sender recovered from the signature
+// and the nonce, value, gas and input taken from the transaction. It returns the
+// output of the execution and the gas left over.
+func replayTx(tx *types.Transaction, genesis *types.Block, config *params.ChainConfig, statedb *state.StateDB, vmConfig vm.Config) ([]byte, uint64, error) {
+	if config == nil {
+		config = params.AllEthashProtocolChanges
+	}
+	number := new(big.Int).Add(genesis.Number(), common.Big1)
+
+	msg, err := tx.AsMessage(types.MakeSigner(config, number))
+	if err != nil {
+		return nil, tx.Gas(), err
+	}
+	context := vm.Context{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		GetHash: func(n uint64) common.Hash {
+			if n == genesis.NumberU64() {
+				return genesis.Hash()
+			}
+			return common.Hash{}
+		},
+		Origin:      msg.From(),
+		Coinbase:    genesis.Coinbase(),
+		BlockNumber: number,
+		Time:        genesis.Time(),
+		Difficulty:  genesis.Difficulty(),
+		GasLimit:    genesis.GasLimit(),
+		GasPrice:    msg.GasPrice(),
+	}
+	evm := vm.NewEVM(context, statedb, config, vmConfig)
+
+	ret, used, failed, err := core.ApplyMessage(evm, msg, new(core.GasPool).AddGas(genesis.GasLimit()))
+	if err != nil {
+		return nil, tx.Gas(), err
+	}
+	if failed {
+		err = errTxFailed
+	}
+	return ret, tx.Gas() - used, err
+}
+
+// writeGasProfile exports the gas profile to the given path, if any.
+func writeGasProfile(path string, write func(io.Writer) error) {
+	if path == "" {
+		return
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Println("could not create gas profile: ", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	if err := write(f); err != nil {
+		fmt.Println("could not write gas profile: ", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,447 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/sha3"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/tests"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+var (
+	InputAllocFlag = cli.StringFlag{
+		Name:  "input.alloc",
+		Usage: "JSON file with the prestate alloc",
+		Value: "alloc.json",
+	}
+	InputEnvFlag = cli.StringFlag{
+		Name:  "input.env",
+		Usage: "JSON file with the block environment",
+		Value: "env.json",
+	}
+	InputTxsFlag = cli.StringFlag{
+		Name:  "input.txs",
+		Usage: "JSON file with the list of transactions to apply",
+		Value: "txs.json",
+	}
+	OutputAllocFlag = cli.StringFlag{
+		Name:  "output.alloc",
+		Usage: "file to write the post-state alloc to ('stdout' and 'stderr' are also accepted)",
+		Value: "alloc.json",
+	}
+	OutputResultFlag = cli.StringFlag{
+		Name:  "output.result",
+		Usage: "file to write the execution result to ('stdout' and 'stderr' are also accepted)",
+		Value: "result.json",
+	}
+	OutputBasedirFlag = cli.StringFlag{
+		Name:  "output.basedir",
+		Usage: "directory to place the output and trace files in",
+		Value: ".",
+	}
+	ForkFlag = cli.StringFlag{
+		Name:  "state.fork",
+		Usage: "name of the fork ruleset to apply the transactions with",
+		Value: "Byzantium",
+	}
+	ChainIDFlag = cli.Int64Flag{
+		Name:  "state.chainid",
+		Usage: "chain id to sign and validate the transactions with",
+		Value: 1,
+	}
+	TraceFlag = cli.BoolFlag{
+		Name:  "trace",
+		Usage: "write a JSON trace of each transaction to trace-<index>-<hash>.jsonl",
+	}
+)
+
+var transitionCommand = cli.Command{
+	Action: transitionCmd,
+	Name:   "t8n",
+	Usage:  "applies a list of transactions to a prestate and outputs the post-state",
+	Description: `The t8n command applies the transactions of the input list on top of the
+prestate alloc, within the given block environment and fork ruleset. The
+post-state alloc and the execution result (state root, receipts, logs bloom
+and the rejected transactions) are written out as JSON.`,
+	Flags: []cli.Flag{
+		InputAllocFlag,
+		InputEnvFlag,
+		InputTxsFlag,
+		OutputAllocFlag,
+		OutputResultFlag,
+		OutputBasedirFlag,
+		ForkFlag,
+		ChainIDFlag,
+		TraceFlag,
+	},
+}
+
+// transitionEnv is the block environment the transactions are executed in.
+type transitionEnv struct {
+	Coinbase    common.Address                      `json:"currentCoinbase"`
+	Difficulty  *math.HexOrDecimal256               `json:"currentDifficulty"`
+	GasLimit    math.HexOrDecimal64                 `json:"currentGasLimit"`
+	Number      math.HexOrDecimal64                 `json:"currentNumber"`
+	Timestamp   math.HexOrDecimal64                 `json:"currentTimestamp"`
+	BlockHashes map[math.HexOrDecimal64]common.Hash `json:"blockHashes,omitempty"`
+}
+
+// transitionTx is a transaction of the input list. Transactions which carry a
+// secret key are signed by the tool, all others must already be signed.
+type transitionTx struct {
+	tx  *types.Transaction
+	key *ecdsa.PrivateKey
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (t *transitionTx) UnmarshalJSON(input []byte) error {
+	var dec struct {
+		Nonce    math.HexOrDecimal64   `json:"nonce"`
+		GasPrice *math.HexOrDecimal256 `json:"gasPrice"`
+		Gas      math.HexOrDecimal64   `json:"gas"`
+		To       *common.Address       `json:"to"`
+		Value    *math.HexOrDecimal256 `json:"value"`
+		Input    hexutil.Bytes         `json:"input"`
+		Key      hexutil.Bytes         `json:"secretKey"`
+	}
+	if err := json.Unmarshal(input, &dec); err != nil {
+		return err
+	}
+	if len(dec.Key) == 0 {
+		t.tx = new(types.Transaction)
+		return t.tx.UnmarshalJSON(input)
+	}
+	key, err := crypto.ToECDSA(dec.Key)
+	if err != nil {
+		return fmt.Errorf("invalid secret key: %v", err)
+	}
+	price, value := new(big.Int), new(big.Int)
+	if dec.GasPrice != nil {
+		price = (*big.Int)(dec.GasPrice)
+	}
+	if dec.Value != nil {
+		value = (*big.Int)(dec.Value)
+	}
+	if dec.To == nil {
+		t.tx = types.NewContractCreation(uint64(dec.Nonce), value, uint64(dec.Gas), price, dec.Input)
+	} else {
+		t.tx = types.NewTransaction(uint64(dec.Nonce), *dec.To, value, uint64(dec.Gas), price, dec.Input)
+	}
+	t.key = key
+	return nil
+}
+
+// rejectedTx is a transaction of the input list which could not be applied.
+type rejectedTx struct {
+	Index int    `json:"index"`
+	Err   string `json:"error"`
+}
+
+// transitionResult is the outcome of applying the transactions.
+type transitionResult struct {
+	StateRoot   common.Hash         `json:"stateRoot"`
+	TxRoot      common.Hash         `json:"txRoot"`
+	ReceiptRoot common.Hash         `json:"receiptRoot"`
+	LogsHash    common.Hash         `json:"logsHash"`
+	Bloom       types.Bloom         `json:"logsBloom"`
+	Receipts    types.Receipts      `json:"receipts"`
+	Rejected    []rejectedTx        `json:"rejected,omitempty"`
+	GasUsed     math.HexOrDecimal64 `json:"gasUsed"`
+}
+
+// transitionChain is a core.ChainContext serving the block hashes listed in the
+// environment to the BLOCKHASH opcode.
+type transitionChain struct {
+	number uint64
+	hashes map[math.HexOrDecimal64]common.Hash
+	oldest uint64
+}
+
+// newTransitionChain creates a chain context for a block environment.
+func newTransitionChain(env *transitionEnv) *transitionChain {
+	chain := &transitionChain{
+		number: uint64(env.Number),
+		hashes: env.BlockHashes,
+		oldest: uint64(env.Number),
+	}
+	for number := range env.BlockHashes {
+		if uint64(number) < chain.oldest {
+			chain.oldest = uint64(number)
+		}
+	}
+	return chain
+}
+
+// Engine implements core.ChainContext. The transactions are always applied with
+// an explicit coinbase, so there is no need for a consensus engine.
+func (c *transitionChain) Engine() consensus.Engine {
+	return nil
+}
+
+// GetHeader implements core.ChainContext, returning a stub header which only
+// carries the number and the hash of its parent. Headers are only available
+// above the oldest known block hash to keep the hash lookups bounded.
+func (c *transitionChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if number <= c.oldest || number >= c.number {
+		return nil
+	}
+	return &types.Header{
+		Number:     new(big.Int).SetUint64(number),
+		ParentHash: c.hashes[math.HexOrDecimal64(number-1)],
+	}
+}
+
+// applyTransactions executes the transactions on top of the prestate in the
+// given environment. Transactions which cannot be applied are skipped and
+// reported as rejected. If traceDir is not empty, a JSON trace of each applied
+// transaction is written into it.
+func applyTransactions(config *params.ChainConfig, env *transitionEnv, alloc core.GenesisAlloc, txs []*transitionTx, traceDir string, logconfig *vm.LogConfig) (*state.StateDB, *transitionResult, error) {
+	if env.Difficulty == nil {
+		return nil, nil, errors.New("missing currentDifficulty in environment")
+	}
+	var (
+		db, _   = ethdb.NewMemDatabase()
+		statedb = tests.MakePreState(db, alloc)
+		chain   = newTransitionChain(env)
+		signer  = types.MakeSigner(config, new(big.Int).SetUint64(uint64(env.Number)))
+		gaspool = new(core.GasPool).AddGas(uint64(env.GasLimit))
+		header  = &types.Header{
+			Coinbase:   env.Coinbase,
+			Difficulty: (*big.Int)(env.Difficulty),
+			GasLimit:   uint64(env.GasLimit),
+			Number:     new(big.Int).SetUint64(uint64(env.Number)),
+			Time:       new(big.Int).SetUint64(uint64(env.Timestamp)),
+		}
+		included types.Transactions
+		receipts types.Receipts
+		logs     []*types.Log
+		rejected []rejectedTx
+		usedGas  uint64
+	)
+	if env.Number > 0 {
+		header.ParentHash = env.BlockHashes[env.Number-1]
+	}
+	for i, ttx := range txs {
+		tx := ttx.tx
+		if ttx.key != nil {
+			signed, err := types.SignTx(tx, signer, ttx.key)
+			if err != nil {
+				rejected = append(rejected, rejectedTx{i, err.Error()})
+				continue
+			}
+			tx = signed
+		}
+		// Set up the optional tracer and apply the transaction
+		var (
+			cfg   vm.Config
+			trace *os.File
+		)
+		if traceDir != "" {
+			var err error
+			if trace, err = os.Create(filepath.Join(traceDir, fmt.Sprintf("trace-%d-%x.jsonl", i, tx.Hash()))); err != nil {
+				return nil, nil, err
+			}
+			cfg.Debug, cfg.Tracer = true, NewJSONLogger(logconfig, trace)
+		}
+		statedb.Prepare(tx.Hash(), common.Hash{}, len(included))
+
+		snapshot, gas := statedb.Snapshot(), gaspool.Gas()
+		receipt, _, err := core.ApplyTransaction(config, chain, &env.Coinbase, gaspool, statedb, header, tx, &usedGas, cfg)
+		if trace != nil {
+			trace.Close()
+			if err != nil {
+				os.Remove(trace.Name())
+			}
+		}
+		if err != nil {
+			log.Info("Rejected transaction", "index", i, "hash", tx.Hash(), "err", err)
+			statedb.RevertToSnapshot(snapshot)
+			*gaspool = core.GasPool(gas)
+
+			rejected = append(rejected, rejectedTx{i, err.Error()})
+			continue
+		}
+		included = append(included, tx)
+		receipts = append(receipts, receipt)
+		logs = append(logs, receipt.Logs...)
+	}
+	root, err := statedb.Commit(config.IsEIP158(header.Number))
+	if err != nil {
+		return nil, nil, err
+	}
+	result := &transitionResult{
+		StateRoot:   root,
+		TxRoot:      types.DeriveSha(included),
+		ReceiptRoot: types.DeriveSha(receipts),
+		LogsHash:    rlpHash(logs),
+		Bloom:       types.CreateBloom(receipts),
+		Receipts:    receipts,
+		Rejected:    rejected,
+		GasUsed:     math.HexOrDecimal64(usedGas),
+	}
+	return statedb, result, nil
+}
+
+// dumpAlloc converts the contents of the state database into a genesis alloc.
+func dumpAlloc(statedb *state.StateDB) (core.GenesisAlloc, error) {
+	alloc := make(core.GenesisAlloc)
+	for addr, dump := range statedb.RawDump().Accounts {
+		balance, _ := new(big.Int).SetString(dump.Balance, 10)
+		account := core.GenesisAccount{
+			Balance: balance,
+			Nonce:   dump.Nonce,
+			Code:    common.FromHex(dump.Code),
+		}
+		if len(dump.Storage) > 0 {
+			account.Storage = make(map[common.Hash]common.Hash)
+			for key, value := range dump.Storage {
+				// Storage values are dumped in their RLP encoded trie form
+				_, content, _, err := rlp.Split(common.FromHex(value))
+				if err != nil {
+					return nil, err
+				}
+				account.Storage[common.HexToHash(key)] = common.BytesToHash(content)
+			}
+		}
+		alloc[common.HexToAddress(addr)] = account
+	}
+	return alloc, nil
+}
+
+func rlpHash(x interface{}) (h common.Hash) {
+	hw := sha3.NewKeccak256()
+	rlp.Encode(hw, x)
+	hw.Sum(h[:0])
+	return h
+}
+
+func transitionCmd(ctx *cli.Context) error {
+	// Configure the go-ethereum logger
+	glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(false)))
+	glogger.Verbosity(log.Lvl(ctx.GlobalInt(VerbosityFlag.Name)))
+	log.Root().SetHandler(glogger)
+
+	// Assemble the chain rules to apply the transactions with
+	fork, ok := tests.Forks[ctx.String(ForkFlag.Name)]
+	if !ok {
+		return fmt.Errorf("unknown fork %q", ctx.String(ForkFlag.Name))
+	}
+	config := *fork
+	config.ChainId = big.NewInt(ctx.Int64(ChainIDFlag.Name))
+
+	// Load the prestate, the environment and the transactions
+	var (
+		alloc core.GenesisAlloc
+		env   transitionEnv
+		txs   []*transitionTx
+	)
+	if err := readJSONFile(ctx.String(InputAllocFlag.Name), &alloc); err != nil {
+		return err
+	}
+	if err := readJSONFile(ctx.String(InputEnvFlag.Name), &env); err != nil {
+		return err
+	}
+	if err := readJSONFile(ctx.String(InputTxsFlag.Name), &txs); err != nil {
+		return err
+	}
+	// Apply the transactions and write out the results
+	basedir := ctx.String(OutputBasedirFlag.Name)
+	if err := os.MkdirAll(basedir, 0755); err != nil {
+		return err
+	}
+	var traceDir string
+	if ctx.Bool(TraceFlag.Name) {
+		traceDir = basedir
+	}
+	logconfig := &vm.LogConfig{
+		DisableMemory: ctx.GlobalBool(DisableMemoryFlag.Name),
+		DisableStack:  ctx.GlobalBool(DisableStackFlag.Name),
+	}
+	statedb, result, err := applyTransactions(&config, &env, alloc, txs, traceDir, logconfig)
+	if err != nil {
+		return err
+	}
+	alloc, err = dumpAlloc(statedb)
+	if err != nil {
+		return err
+	}
+	outAlloc, outResult := ctx.String(OutputAllocFlag.Name), ctx.String(OutputResultFlag.Name)
+	if outAlloc == outResult && (outAlloc == "stdout" || outAlloc == "stderr") {
+		// Both go to the same stream, emit them as a single JSON object
+		combined := map[string]interface{}{"alloc": alloc, "result": result}
+		return writeJSONFile(basedir, outAlloc, combined)
+	}
+	if err := writeJSONFile(basedir, outAlloc, alloc); err != nil {
+		return err
+	}
+	return writeJSONFile(basedir, outResult, result)
+}
+
+// readJSONFile decodes the JSON content of the given file into val.
+func readJSONFile(path string, val interface{}) error {
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(blob, val); err != nil {
+		return fmt.Errorf("failed to decode %s: %v", path, err)
+	}
+	return nil
+}
+
+// writeJSONFile encodes val as indented JSON into the given file, or into the
+// standard output or error streams if the name is 'stdout' or 'stderr'.
+func writeJSONFile(basedir, name string, val interface{}) error {
+	blob, err := json.MarshalIndent(val, "", "  ")
+	if err != nil {
+		return err
+	}
+	var out io.Writer
+	switch name {
+	case "stdout":
+		out = os.Stdout
+	case "stderr":
+		out = os.Stderr
+	default:
+		return ioutil.WriteFile(filepath.Join(basedir, name), append(blob, '\n'), 0644)
+	}
+	_, err = fmt.Fprintln(out, string(blob))
+	return err
+}
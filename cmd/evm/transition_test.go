@@ -0,0 +1,120 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/tests"
+)
+
+// Tests that transactions are applied on top of the prestate within the block
+// environment, and that the invalid ones get rejected.
+func TestTransition(t *testing.T) {
+	var (
+		key, _   = crypto.HexToECDSA("45a915e4d060149eb4365960e6a7a45f334393093061116b197e3240065ff2d8")
+		sender   = crypto.PubkeyToAddress(key.PublicKey)
+		contract = common.HexToAddress("0xaaaa")
+		coinbase = common.HexToAddress("0xc014ba5e")
+	)
+	alloc := core.GenesisAlloc{
+		sender: {Balance: big.NewInt(1000000000)},
+		contract: {
+			Balance: big.NewInt(0),
+			// sstore(0, blockhash(1)) sstore(1, number) log0(0, 0)
+			Code:    common.Hex2Bytes("6001406000554360015560006000a000"),
+			Storage: map[common.Hash]common.Hash{common.HexToHash("0x05"): common.HexToHash("0x1234")},
+		},
+	}
+	var env transitionEnv
+	if err := json.Unmarshal([]byte(`{
+		"currentCoinbase":   "0x00000000000000000000000000000000c014ba5e",
+		"currentDifficulty": "0x20000",
+		"currentGasLimit":   "0x1000000",
+		"currentNumber":     "3",
+		"currentTimestamp":  "1000",
+		"blockHashes":       {"1": "0x0000000000000000000000000000000000000000000000000000000000000bbb"}
+	}`), &env); err != nil {
+		t.Fatalf("failed to decode environment: %v", err)
+	}
+	var txs []*transitionTx
+	if err := json.Unmarshal([]byte(`[
+		{"nonce": "0", "gasPrice": "1", "gas": "100000", "to": "0x000000000000000000000000000000000000aaaa", "value": "1", "input": "0x",
+		 "secretKey": "0x45a915e4d060149eb4365960e6a7a45f334393093061116b197e3240065ff2d8"},
+		{"nonce": "0", "gasPrice": "1", "gas": "100000", "to": "0x000000000000000000000000000000000000aaaa", "value": "1", "input": "0x",
+		 "secretKey": "0x45a915e4d060149eb4365960e6a7a45f334393093061116b197e3240065ff2d8"}
+	]`), &txs); err != nil {
+		t.Fatalf("failed to decode transactions: %v", err)
+	}
+	// Apply the transactions, tracing them into a temporary folder
+	dir, err := ioutil.TempDir("", "t8n")
+	if err != nil {
+		t.Fatalf("failed to create trace folder: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	statedb, result, err := applyTransactions(tests.Forks["Byzantium"], &env, alloc, txs, dir, &vm.LogConfig{})
+	if err != nil {
+		t.Fatalf("failed to apply transactions: %v", err)
+	}
+	if len(result.Receipts) != 1 || result.Receipts[0].Status != types.ReceiptStatusSuccessful {
+		t.Fatalf("receipts mismatch: have %v, want 1 successful", result.Receipts)
+	}
+	if len(result.Rejected) != 1 || result.Rejected[0].Index != 1 {
+		t.Errorf("rejections mismatch: have %v, want transaction 1", result.Rejected)
+	}
+	if len(result.Receipts[0].Logs) != 1 || result.Bloom != types.CreateBloom(result.Receipts) {
+		t.Errorf("logs mismatch: have %v", result.Receipts[0].Logs)
+	}
+	if traces, _ := filepath.Glob(filepath.Join(dir, "trace-*.jsonl")); len(traces) != 1 {
+		t.Errorf("trace files mismatch: have %v, want 1", traces)
+	}
+	// Ensure the post-state alloc reflects the execution
+	post, err := dumpAlloc(statedb)
+	if err != nil {
+		t.Fatalf("failed to dump post-state: %v", err)
+	}
+	if root := statedb.IntermediateRoot(true); root != result.StateRoot {
+		t.Errorf("state root mismatch: have %x, want %x", result.StateRoot, root)
+	}
+	storage := post[contract].Storage
+	for key, want := range map[common.Hash]common.Hash{
+		common.HexToHash("0x00"): common.HexToHash("0x0bbb"),
+		common.HexToHash("0x01"): common.HexToHash("0x03"),
+		common.HexToHash("0x05"): common.HexToHash("0x1234"),
+	} {
+		if have := storage[key]; have != want {
+			t.Errorf("storage slot %x mismatch: have %x, want %x", key, have, want)
+		}
+	}
+	if nonce := post[sender].Nonce; nonce != 1 {
+		t.Errorf("sender nonce mismatch: have %d, want 1", nonce)
+	}
+	if fee := post[coinbase].Balance; fee == nil || fee.Uint64() != result.Receipts[0].GasUsed {
+		t.Errorf("coinbase fee mismatch: have %v, want %d", fee, result.Receipts[0].GasUsed)
+	}
+}
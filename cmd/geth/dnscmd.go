@@ -0,0 +1,146 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/dnsdisc"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	dnsSignerFlag = cli.StringFlag{
+		Name:  "signer",
+		Usage: "Private key file of the node list publisher",
+	}
+	dnsSeqFlag = cli.UintFlag{
+		Name:  "seq",
+		Usage: "Sequence number of the tree, must be increased on every update",
+		Value: 1,
+	}
+	dnsLinksFlag = cli.StringFlag{
+		Name:  "links",
+		Usage: "Comma separated enrtree:// URLs of other node lists to link to",
+	}
+
+	dnsCommand = cli.Command{
+		Name:      "dns",
+		Usage:     "Manage DNS node lists",
+		ArgsUsage: "",
+		Category:  "MISCELLANEOUS COMMANDS",
+		Description: `
+Node lists can be published as signed trees of node records in DNS TXT records.
+Clients find peers in them with the --discovery.dns flag.`,
+		Subcommands: []cli.Command{
+			{
+				Name:      "sign",
+				Usage:     "Build and sign a node list tree",
+				ArgsUsage: "<nodesfile> <domain>",
+				Action:    utils.MigrateFlags(dnsSign),
+				Flags: []cli.Flag{
+					dnsSignerFlag,
+					dnsSeqFlag,
+					dnsLinksFlag,
+				},
+				Description: `
+    geth dns sign --signer <keyfile> [--seq <n>] [--links <urls>] <nodesfile> <domain>
+
+builds a tree of the node records listed in <nodesfile>, one "enr:" record per
+line, and signs it with the given key. The enr of a running node is shown in
+admin.nodeInfo. Lines starting with # are ignored.
+
+The output is a JSON object containing the tree URL for clients and the TXT
+records to publish below <domain>.`,
+			},
+		},
+	}
+)
+
+// dnsSign builds a node list tree from a file of node records, signs it and
+// prints its TXT records.
+func dnsSign(ctx *cli.Context) error {
+	if len(ctx.Args()) != 2 {
+		utils.Fatalf("This command requires two arguments.")
+	}
+	nodesfile, domain := ctx.Args().Get(0), ctx.Args().Get(1)
+
+	keyfile := ctx.String(dnsSignerFlag.Name)
+	if keyfile == "" {
+		utils.Fatalf("The --%s flag is required.", dnsSignerFlag.Name)
+	}
+	key, err := crypto.LoadECDSA(keyfile)
+	if err != nil {
+		utils.Fatalf("Failed to load the signing key: %v", err)
+	}
+	records, err := loadNodeRecords(nodesfile)
+	if err != nil {
+		utils.Fatalf("Failed to load node records: %v", err)
+	}
+	var links []string
+	if ctx.IsSet(dnsLinksFlag.Name) {
+		links = strings.Split(ctx.String(dnsLinksFlag.Name), ",")
+	}
+	tree, err := dnsdisc.MakeTree(ctx.Uint(dnsSeqFlag.Name), records, links)
+	if err != nil {
+		utils.Fatalf("Failed to create tree: %v", err)
+	}
+	url, err := tree.Sign(key, domain)
+	if err != nil {
+		utils.Fatalf("Failed to sign tree: %v", err)
+	}
+	out, _ := json.MarshalIndent(map[string]interface{}{
+		"url":     url,
+		"seq":     tree.Seq(),
+		"records": tree.ToTXT(domain),
+	}, "", "  ")
+	fmt.Println(string(out))
+	return nil
+}
+
+// loadNodeRecords reads the node records contained in a file, one per line.
+func loadNodeRecords(file string) ([]*enr.Record, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var (
+		records []*enr.Record
+		scanner = bufio.NewScanner(fd)
+	)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		r, err := dnsdisc.DecodeRecord(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
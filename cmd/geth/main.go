@@ -59,6 +59,8 @@ var (
 		utils.BootnodesV4Flag,
 		utils.BootnodesV5Flag,
 		utils.DataDirFlag,
+		utils.AncientFlag,
+		utils.AncientNoSnappyFlag,
 		utils.KeyStoreDirFlag,
 		utils.NoUSBFlag,
 		utils.DashboardEnabledFlag,
@@ -81,10 +83,14 @@ var (
 		utils.TxPoolAccountQueueFlag,
 		utils.TxPoolGlobalQueueFlag,
 		utils.TxPoolLifetimeFlag,
+		utils.TxPoolSenderCapFlag,
+		utils.TxPoolPriceBumpGrowthFlag,
+		utils.TxPoolMaxAgeFlag,
 		utils.FastSyncFlag,
 		utils.LightModeFlag,
 		utils.SyncModeFlag,
 		utils.GCModeFlag,
+		utils.DatabaseEngineFlag,
 		utils.LightServFlag,
 		utils.LightPeersFlag,
 		utils.LightKDFFlag,
@@ -92,6 +98,8 @@ var (
 		utils.CacheDatabaseFlag,
 		utils.CacheGCFlag,
 		utils.TrieCacheGenFlag,
+		utils.SnapshotFlag,
+		utils.AddressIndexFlag,
 		utils.ListenPortFlag,
 		utils.MaxPeersFlag,
 		utils.MaxPendingPeersFlag,
@@ -103,6 +111,7 @@ var (
 		utils.NATFlag,
 		utils.NoDiscoverFlag,
 		utils.DiscoveryV5Flag,
+		utils.DNSDiscoveryFlag,
 		utils.NetrestrictFlag,
 		utils.NodeKeyFileFlag,
 		utils.NodeKeyHexFlag,
@@ -116,6 +125,9 @@ var (
 		utils.RPCVirtualHostsFlag,
 		utils.EthStatsURLFlag,
 		utils.MetricsEnabledFlag,
+		utils.MetricsPrometheusFlag,
+		utils.MetricsPrometheusAddrFlag,
+		utils.MetricsPrometheusPortFlag,
 		utils.FakePoWFlag,
 		utils.NoCompactionFlag,
 		utils.GpoBlocksFlag,
@@ -134,6 +146,12 @@ var (
 		utils.WSPortFlag,
 		utils.WSApiFlag,
 		utils.WSAllowedOriginsFlag,
+		utils.RPCBatchLimitFlag,
+		utils.RPCBatchResponseMaxSizeFlag,
+		utils.RPCRateLimitFlag,
+		utils.RPCMethodRateLimitsFlag,
+		utils.RPCAuthTokensFlag,
+		utils.RPCJWTSecretFlag,
 		utils.IPCDisabledFlag,
 		utils.IPCPathFlag,
 	}
@@ -177,6 +195,10 @@ func init() {
 		licenseCommand,
 		// See config.go
 		dumpConfigCommand,
+		// See dnscmd.go:
+		dnsCommand,
+		// See snapshot.go:
+		snapshotCommand,
 	}
 	sort.Sort(cli.CommandsByName(app.Commands))
 
@@ -195,6 +217,7 @@ func init() {
 		go metrics.CollectProcessMetrics(3 * time.Second)
 
 		utils.SetupNetwork(ctx)
+		utils.SetupMetrics(ctx)
 		return nil
 	}
 
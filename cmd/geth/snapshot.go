@@ -0,0 +1,95 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/core/state/pruner"
+	"github.com/ethereum/go-ethereum/log"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	pruneBloomSizeFlag = cli.Uint64Flag{
+		Name:  "bloomfilter.size",
+		Usage: "Megabytes of memory allocated to the bloom filter of the retained state",
+		Value: 2048,
+	}
+	pruneRecentFlag = cli.Uint64Flag{
+		Name:  "recent",
+		Usage: "Number of recent blocks whose state is retained",
+		Value: 128,
+	}
+
+	snapshotCommand = cli.Command{
+		Name:      "snapshot",
+		Usage:     "Manage the state data",
+		ArgsUsage: "",
+		Category:  "BLOCKCHAIN COMMANDS",
+		Description: `
+Offline maintenance of the state data in the chain database.`,
+		Subcommands: []cli.Command{
+			{
+				Name:      "prune-state",
+				Usage:     "Delete the state data not reachable from the recent blocks",
+				ArgsUsage: "",
+				Action:    utils.MigrateFlags(pruneState),
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.AncientNoSnappyFlag,
+					utils.CacheFlag,
+					utils.TestnetFlag,
+					utils.RinkebyFlag,
+					pruneBloomSizeFlag,
+					pruneRecentFlag,
+				},
+				Description: `
+    geth snapshot prune-state [--recent <n>] [--bloomfilter.size <mb>]
+
+deletes all the trie nodes and contract codes which are not part of the state of
+the most recent blocks or the genesis, then compacts the database. Nodes flushed
+to disk are otherwise never deleted, so the database keeps growing until resync.
+
+The node must not be running. The pruning may take hours on mainnet. It is safe
+to interrupt the command, the deletion is resumed by the next run of either this
+command or geth itself.
+
+A larger bloom filter lets less unreachable data survive the pruning.`,
+			},
+		},
+	}
+)
+
+// pruneState deletes the state data not reachable from the recent blocks.
+func pruneState(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	chaindb := utils.MakeChainDatabase(ctx, stack)
+	defer chaindb.Close()
+
+	p := pruner.NewPruner(chaindb, stack.ResolvePath(""), ctx.Uint64(pruneBloomSizeFlag.Name))
+
+	// Roots are irrelevant when resuming, the persisted bloom filter is used instead
+	roots, err := pruner.RetainedRoots(chaindb, ctx.Uint64(pruneRecentFlag.Name))
+	if err != nil {
+		log.Warn("Failed to find the state to retain", "err", err)
+	}
+	if err := p.Prune(roots); err != nil {
+		utils.Fatalf("Failed to prune state: %v", err)
+	}
+	return nil
+}
@@ -69,6 +69,8 @@ var AppHelpFlagGroups = []flagGroup{
 		Flags: []cli.Flag{
 			configFileFlag,
 			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.AncientNoSnappyFlag,
 			utils.KeyStoreDirFlag,
 			utils.NoUSBFlag,
 			utils.NetworkIdFlag,
@@ -76,6 +78,7 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.RinkebyFlag,
 			utils.SyncModeFlag,
 			utils.GCModeFlag,
+			utils.DatabaseEngineFlag,
 			utils.EthStatsURLFlag,
 			utils.IdentityFlag,
 			utils.LightServFlag,
@@ -123,6 +126,9 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.TxPoolAccountQueueFlag,
 			utils.TxPoolGlobalQueueFlag,
 			utils.TxPoolLifetimeFlag,
+			utils.TxPoolSenderCapFlag,
+			utils.TxPoolPriceBumpGrowthFlag,
+			utils.TxPoolMaxAgeFlag,
 		},
 	},
 	{
@@ -132,6 +138,8 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.CacheDatabaseFlag,
 			utils.CacheGCFlag,
 			utils.TrieCacheGenFlag,
+			utils.SnapshotFlag,
+			utils.AddressIndexFlag,
 		},
 	},
 	{
@@ -153,6 +161,12 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.WSPortFlag,
 			utils.WSApiFlag,
 			utils.WSAllowedOriginsFlag,
+			utils.RPCBatchLimitFlag,
+			utils.RPCBatchResponseMaxSizeFlag,
+			utils.RPCRateLimitFlag,
+			utils.RPCMethodRateLimitsFlag,
+			utils.RPCAuthTokensFlag,
+			utils.RPCJWTSecretFlag,
 			utils.IPCDisabledFlag,
 			utils.IPCPathFlag,
 			utils.RPCCORSDomainFlag,
@@ -174,6 +188,7 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.NATFlag,
 			utils.NoDiscoverFlag,
 			utils.DiscoveryV5Flag,
+			utils.DNSDiscoveryFlag,
 			utils.NetrestrictFlag,
 			utils.NodeKeyFileFlag,
 			utils.NodeKeyHexFlag,
@@ -207,6 +222,9 @@ var AppHelpFlagGroups = []flagGroup{
 		Name: "LOGGING AND DEBUGGING",
 		Flags: append([]cli.Flag{
 			utils.MetricsEnabledFlag,
+			utils.MetricsPrometheusFlag,
+			utils.MetricsPrometheusAddrFlag,
+			utils.MetricsPrometheusPortFlag,
 			utils.FakePoWFlag,
 			utils.NoCompactionFlag,
 		}, debug.Flags...),
@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math/big"
+	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -48,6 +49,7 @@ import (
 	"github.com/ethereum/go-ethereum/les"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/metrics/prometheus"
 	"github.com/ethereum/go-ethereum/node"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/discover"
@@ -118,6 +120,14 @@ var (
 		Usage: "Data directory for the databases and keystore",
 		Value: DirectoryString{node.DefaultDataDir()},
 	}
+	AncientFlag = DirectoryFlag{
+		Name:  "datadir.ancient",
+		Usage: "Data directory for ancient chain segments (freezer disabled if unset)",
+	}
+	AncientNoSnappyFlag = cli.BoolFlag{
+		Name:  "datadir.ancient.nosnappy",
+		Usage: "Store newly created ancient chain segment tables without snappy compression",
+	}
 	KeyStoreDirFlag = DirectoryFlag{
 		Name:  "keystore",
 		Usage: "Directory for the keystore (default = inside the datadir)",
@@ -167,14 +177,19 @@ var (
 	defaultSyncMode = eth.DefaultConfig.SyncMode
 	SyncModeFlag    = TextMarshalerFlag{
 		Name:  "syncmode",
-		Usage: `Blockchain sync mode ("fast", "full", or "light")`,
+		Usage: `Blockchain sync mode ("fast", "full", "light" or "snap")`,
 		Value: &defaultSyncMode,
 	}
 	GCModeFlag = cli.StringFlag{
 		Name:  "gcmode",
-		Usage: `Blockchain garbage collection mode ("full", "archive")`,
+		Usage: `Blockchain garbage collection mode ("full", "archive", "diff")`,
 		Value: "full",
 	}
+	DatabaseEngineFlag = cli.StringFlag{
+		Name:  "db.engine",
+		Usage: fmt.Sprintf("Backing database implementation to use (one of: %s; memory only in --dev mode)", strings.Join(ethdb.Engines(), ", ")),
+		Value: node.DatabaseEngineLevelDB,
+	}
 	LightServFlag = cli.IntFlag{
 		Name:  "lightserv",
 		Usage: "Maximum percentage of time allowed for serving LES requests (0-90)",
@@ -289,6 +304,21 @@ var (
 		Usage: "Maximum amount of time non-executable transaction are queued",
 		Value: eth.DefaultConfig.TxPool.Lifetime,
 	}
+	TxPoolSenderCapFlag = cli.Uint64Flag{
+		Name:  "txpool.sendercap",
+		Usage: "Maximum number of transactions permitted per remote account (0 = unlimited)",
+		Value: eth.DefaultConfig.TxPool.SenderCap,
+	}
+	TxPoolPriceBumpGrowthFlag = cli.Uint64Flag{
+		Name:  "txpool.pricebumpgrowth",
+		Usage: "Percentage the price bump grows by with each replacement of a remote transaction (0 = flat)",
+		Value: eth.DefaultConfig.TxPool.PriceBumpGrowth,
+	}
+	TxPoolMaxAgeFlag = cli.DurationFlag{
+		Name:  "txpool.maxage",
+		Usage: "Maximum amount of time remote transactions are pooled (0 = unlimited)",
+		Value: eth.DefaultConfig.TxPool.MaxAge,
+	}
 	// Performance tuning settings
 	CacheFlag = cli.IntFlag{
 		Name:  "cache",
@@ -305,6 +335,14 @@ var (
 		Usage: "Percentage of cache memory allowance to use for trie pruning",
 		Value: 25,
 	}
+	SnapshotFlag = cli.BoolFlag{
+		Name:  "snapshot",
+		Usage: "Maintain a flat snapshot of the state for faster state access (experimental)",
+	}
+	AddressIndexFlag = cli.BoolFlag{
+		Name:  "addrindex",
+		Usage: "Maintain an index of the logs and transactions by address for faster filtering",
+	}
 	TrieCacheGenFlag = cli.IntFlag{
 		Name:  "trie-cache-gens",
 		Usage: "Number of trie node generations to keep in memory",
@@ -364,6 +402,20 @@ var (
 		Name:  metrics.MetricsEnabledFlag,
 		Usage: "Enable metrics collection and reporting",
 	}
+	MetricsPrometheusFlag = cli.BoolFlag{
+		Name:  "metrics.prometheus",
+		Usage: "Enable the Prometheus metrics HTTP endpoint",
+	}
+	MetricsPrometheusAddrFlag = cli.StringFlag{
+		Name:  "metrics.prometheus.addr",
+		Usage: "Prometheus metrics HTTP endpoint listening interface",
+		Value: "127.0.0.1",
+	}
+	MetricsPrometheusPortFlag = cli.IntFlag{
+		Name:  "metrics.prometheus.port",
+		Usage: "Prometheus metrics HTTP endpoint listening port",
+		Value: 6061,
+	}
 	FakePoWFlag = cli.BoolFlag{
 		Name:  "fakepow",
 		Usage: "Disables proof-of-work verification",
@@ -402,6 +454,33 @@ var (
 		Usage: "API's offered over the HTTP-RPC interface",
 		Value: "",
 	}
+	RPCBatchLimitFlag = cli.IntFlag{
+		Name:  "rpc.batchlimit",
+		Usage: "Maximum number of requests in a batch over HTTP-RPC and WS-RPC (0 = unlimited)",
+	}
+	RPCBatchResponseMaxSizeFlag = cli.IntFlag{
+		Name:  "rpc.batchresponsemaxsize",
+		Usage: "Maximum number of bytes returned from a batch over HTTP-RPC and WS-RPC (0 = unlimited)",
+	}
+	RPCRateLimitFlag = cli.Float64Flag{
+		Name:  "rpc.ratelimit",
+		Usage: "Requests per second allowed per WS-RPC connection or HTTP-RPC remote host (0 = unlimited)",
+	}
+	RPCMethodRateLimitsFlag = cli.StringFlag{
+		Name:  "rpc.methodratelimits",
+		Usage: "Comma separated method=rate list of requests per second allowed per method (e.g. eth_getLogs=10)",
+		Value: "",
+	}
+	RPCAuthTokensFlag = cli.StringFlag{
+		Name:  "rpc.authtokens",
+		Usage: "Comma separated list of bearer tokens authorizing HTTP-RPC and WS-RPC requests",
+		Value: "",
+	}
+	RPCJWTSecretFlag = cli.StringFlag{
+		Name:  "rpc.jwtsecret",
+		Usage: "Path to a hex encoded HMAC secret verifying JWT bearer tokens of HTTP-RPC and WS-RPC requests (tokens must be issued within 5s of the request)",
+		Value: "",
+	}
 	IPCDisabledFlag = cli.BoolFlag{
 		Name:  "ipcdisable",
 		Usage: "Disable the IPC-RPC server",
@@ -495,6 +574,10 @@ var (
 		Name:  "v5disc",
 		Usage: "Enables the experimental RLPx V5 (Topic Discovery) mechanism",
 	}
+	DNSDiscoveryFlag = cli.StringFlag{
+		Name:  "discovery.dns",
+		Usage: "Comma separated enrtree:// URLs of DNS node lists to find peers in",
+	}
 	NetrestrictFlag = cli.StringFlag{
 		Name:  "netrestrict",
 		Usage: "Restricts network communication to the given IP networks (CIDR masks)",
@@ -716,6 +799,40 @@ func setWS(ctx *cli.Context, cfg *node.Config) {
 	}
 }
 
+// setRPCLimits applies the RPC quota and authentication related command line
+// flags to the config.
+func setRPCLimits(ctx *cli.Context, cfg *node.Config) {
+	if ctx.GlobalIsSet(RPCBatchLimitFlag.Name) {
+		cfg.RPCBatchLimit = ctx.GlobalInt(RPCBatchLimitFlag.Name)
+	}
+	if ctx.GlobalIsSet(RPCBatchResponseMaxSizeFlag.Name) {
+		cfg.RPCBatchResponseMaxSize = ctx.GlobalInt(RPCBatchResponseMaxSizeFlag.Name)
+	}
+	if ctx.GlobalIsSet(RPCRateLimitFlag.Name) {
+		cfg.RPCConnRateLimit = ctx.GlobalFloat64(RPCRateLimitFlag.Name)
+	}
+	if ctx.GlobalIsSet(RPCMethodRateLimitsFlag.Name) {
+		cfg.RPCMethodRateLimits = make(map[string]float64)
+		for _, entry := range splitAndTrim(ctx.GlobalString(RPCMethodRateLimitsFlag.Name)) {
+			parts := strings.SplitN(entry, "=", 2)
+			if len(parts) != 2 {
+				Fatalf("Invalid --%s entry %q, expected method=rate", RPCMethodRateLimitsFlag.Name, entry)
+			}
+			rate, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+			if err != nil {
+				Fatalf("Invalid --%s rate for %s: %v", RPCMethodRateLimitsFlag.Name, parts[0], err)
+			}
+			cfg.RPCMethodRateLimits[strings.TrimSpace(parts[0])] = rate
+		}
+	}
+	if ctx.GlobalIsSet(RPCAuthTokensFlag.Name) {
+		cfg.RPCAuthTokens = splitAndTrim(ctx.GlobalString(RPCAuthTokensFlag.Name))
+	}
+	if ctx.GlobalIsSet(RPCJWTSecretFlag.Name) {
+		cfg.RPCJWTSecret = ctx.GlobalString(RPCJWTSecretFlag.Name)
+	}
+}
+
 // setIPC creates an IPC path configuration from the set command line flags,
 // returning an empty string if IPC was explicitly disabled, or the set path.
 func setIPC(ctx *cli.Context, cfg *node.Config) {
@@ -851,6 +968,9 @@ func SetP2PConfig(ctx *cli.Context, cfg *p2p.Config) {
 		cfg.DiscoveryV5 = true
 	}
 
+	if urls := ctx.GlobalString(DNSDiscoveryFlag.Name); urls != "" {
+		cfg.DNSDiscoveryURLs = strings.Split(urls, ",")
+	}
 	if netrestrict := ctx.GlobalString(NetrestrictFlag.Name); netrestrict != "" {
 		list, err := netutil.ParseNetlist(netrestrict)
 		if err != nil {
@@ -874,6 +994,7 @@ func SetNodeConfig(ctx *cli.Context, cfg *node.Config) {
 	setIPC(ctx, cfg)
 	setHTTP(ctx, cfg)
 	setWS(ctx, cfg)
+	setRPCLimits(ctx, cfg)
 	setNodeUserIdent(ctx, cfg)
 
 	switch {
@@ -887,6 +1008,22 @@ func SetNodeConfig(ctx *cli.Context, cfg *node.Config) {
 		cfg.DataDir = filepath.Join(node.DefaultDataDir(), "rinkeby")
 	}
 
+	if ctx.GlobalIsSet(DatabaseEngineFlag.Name) {
+		engine, engines := ctx.GlobalString(DatabaseEngineFlag.Name), ethdb.Engines()
+
+		known := false
+		for _, name := range engines {
+			known = known || name == engine
+		}
+		if !known {
+			Fatalf("--%s must be one of: %s", DatabaseEngineFlag.Name, strings.Join(engines, ", "))
+		}
+		// Memory databases lose all chain data on exit, only allow them for throwaway chains
+		if engine == node.DatabaseEngineMemory && !ctx.GlobalBool(DeveloperFlag.Name) {
+			Fatalf("--%s=%s loses all data on exit, it is only permitted in --%s mode", DatabaseEngineFlag.Name, engine, DeveloperFlag.Name)
+		}
+		cfg.DatabaseEngine = engine
+	}
 	if ctx.GlobalIsSet(KeyStoreDirFlag.Name) {
 		cfg.KeyStoreDir = ctx.GlobalString(KeyStoreDirFlag.Name)
 	}
@@ -938,6 +1075,15 @@ func setTxPool(ctx *cli.Context, cfg *core.TxPoolConfig) {
 	if ctx.GlobalIsSet(TxPoolLifetimeFlag.Name) {
 		cfg.Lifetime = ctx.GlobalDuration(TxPoolLifetimeFlag.Name)
 	}
+	if ctx.GlobalIsSet(TxPoolSenderCapFlag.Name) {
+		cfg.SenderCap = ctx.GlobalUint64(TxPoolSenderCapFlag.Name)
+	}
+	if ctx.GlobalIsSet(TxPoolPriceBumpGrowthFlag.Name) {
+		cfg.PriceBumpGrowth = ctx.GlobalUint64(TxPoolPriceBumpGrowthFlag.Name)
+	}
+	if ctx.GlobalIsSet(TxPoolMaxAgeFlag.Name) {
+		cfg.MaxAge = ctx.GlobalDuration(TxPoolMaxAgeFlag.Name)
+	}
 }
 
 func setEthash(ctx *cli.Context, cfg *eth.Config) {
@@ -1045,15 +1191,28 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 		cfg.DatabaseCache = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheDatabaseFlag.Name) / 100
 	}
 	cfg.DatabaseHandles = makeDatabaseHandles()
+	if ctx.GlobalIsSet(AncientFlag.Name) {
+		cfg.DatabaseFreezer = ctx.GlobalString(AncientFlag.Name)
+	}
+	if ctx.GlobalIsSet(AncientNoSnappyFlag.Name) {
+		cfg.DatabaseNoSnappy = ctx.GlobalBool(AncientNoSnappyFlag.Name)
+	}
 
-	if gcmode := ctx.GlobalString(GCModeFlag.Name); gcmode != "full" && gcmode != "archive" {
-		Fatalf("--%s must be either 'full' or 'archive'", GCModeFlag.Name)
+	if gcmode := ctx.GlobalString(GCModeFlag.Name); gcmode != "full" && gcmode != "archive" && gcmode != "diff" {
+		Fatalf("--%s must be either 'full', 'archive' or 'diff'", GCModeFlag.Name)
 	}
 	cfg.NoPruning = ctx.GlobalString(GCModeFlag.Name) == "archive"
+	cfg.StateHistory = ctx.GlobalString(GCModeFlag.Name) == "diff"
 
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheGCFlag.Name) {
 		cfg.TrieCache = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheGCFlag.Name) / 100
 	}
+	if ctx.GlobalIsSet(SnapshotFlag.Name) {
+		cfg.Snapshot = ctx.GlobalBool(SnapshotFlag.Name)
+	}
+	if ctx.GlobalIsSet(AddressIndexFlag.Name) {
+		cfg.AddressIndex = ctx.GlobalBool(AddressIndexFlag.Name)
+	}
 	if ctx.GlobalIsSet(MinerThreadsFlag.Name) {
 		cfg.MinerThreads = ctx.GlobalInt(MinerThreadsFlag.Name)
 	}
@@ -1181,6 +1340,26 @@ func SetupNetwork(ctx *cli.Context) {
 	params.TargetGasLimit = ctx.GlobalUint64(TargetGasLimitFlag.Name)
 }
 
+// SetupMetrics starts the Prometheus metrics HTTP endpoint if it was requested.
+func SetupMetrics(ctx *cli.Context) {
+	if !ctx.GlobalBool(MetricsPrometheusFlag.Name) {
+		return
+	}
+	if !metrics.Enabled {
+		log.Warn("Prometheus endpoint enabled without metrics collection", "flag", "--"+MetricsEnabledFlag.Name)
+	}
+	address := fmt.Sprintf("%s:%d", ctx.GlobalString(MetricsPrometheusAddrFlag.Name), ctx.GlobalInt(MetricsPrometheusPortFlag.Name))
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/metrics/prometheus", prometheus.Handler(metrics.DefaultRegistry))
+	go func() {
+		log.Info("Starting Prometheus metrics exporter", "addr", fmt.Sprintf("http://%s/debug/metrics/prometheus", address))
+		if err := http.ListenAndServe(address, mux); err != nil {
+			log.Error("Failure in running Prometheus metrics exporter", "err", err)
+		}
+	}()
+}
+
 // MakeChainDatabase open an LevelDB using the flags passed to the client and will hard crash if it fails.
 func MakeChainDatabase(ctx *cli.Context, stack *node.Node) ethdb.Database {
 	var (
@@ -1195,6 +1374,15 @@ func MakeChainDatabase(ctx *cli.Context, stack *node.Node) ethdb.Database {
 	if err != nil {
 		Fatalf("Could not open database: %v", err)
 	}
+	// Always route through the freezer setup, so a database depending on frozen
+	// chain segments is refused if the ancient directory is missing
+	var ancient string
+	if dir := ctx.GlobalString(AncientFlag.Name); dir != "" && !ctx.GlobalBool(LightModeFlag.Name) {
+		ancient = stack.ResolvePath(dir)
+	}
+	if chainDb, err = core.NewDatabaseWithFreezer(chainDb, ancient, "eth/db/ancient/", ctx.GlobalBool(AncientNoSnappyFlag.Name)); err != nil {
+		Fatalf("Could not open ancient database: %v", err)
+	}
 	return chainDb
 }
 
@@ -1236,13 +1424,15 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 			})
 		}
 	}
-	if gcmode := ctx.GlobalString(GCModeFlag.Name); gcmode != "full" && gcmode != "archive" {
-		Fatalf("--%s must be either 'full' or 'archive'", GCModeFlag.Name)
+	if gcmode := ctx.GlobalString(GCModeFlag.Name); gcmode != "full" && gcmode != "archive" && gcmode != "diff" {
+		Fatalf("--%s must be either 'full', 'archive' or 'diff'", GCModeFlag.Name)
 	}
 	cache := &core.CacheConfig{
 		Disabled:      ctx.GlobalString(GCModeFlag.Name) == "archive",
 		TrieNodeLimit: eth.DefaultConfig.TrieCache,
 		TrieTimeLimit: eth.DefaultConfig.TrieTimeout,
+		Snapshot:      ctx.GlobalBool(SnapshotFlag.Name),
+		StateHistory:  ctx.GlobalString(GCModeFlag.Name) == "diff",
 	}
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheGCFlag.Name) {
 		cache.TrieNodeLimit = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheGCFlag.Name) / 100
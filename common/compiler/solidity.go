@@ -46,6 +46,8 @@ type ContractInfo struct {
 	UserDoc         interface{} `json:"userDoc"`
 	DeveloperDoc    interface{} `json:"developerDoc"`
 	Metadata        string      `json:"metadata"`
+	SrcMap          string      `json:"srcMap"`        // Source mapping of the creation code
+	SrcMapRuntime   string      `json:"srcMapRuntime"` // Source mapping of the runtime code
 }
 
 // Solidity contains information about the solidity compiler.
@@ -58,6 +60,9 @@ type Solidity struct {
 type solcOutput struct {
 	Contracts map[string]struct {
 		Bin, Abi, Devdoc, Userdoc, Metadata string
+
+		SrcMap        string `json:"srcmap"`
+		SrcMapRuntime string `json:"srcmap-runtime"`
 	}
 	Version string
 }
@@ -70,6 +75,9 @@ func (s *Solidity) makeArgs() []string {
 	if s.Major > 0 || s.Minor > 4 || s.Patch > 6 {
 		p[1] += ",metadata"
 	}
+	if s.Major > 0 || s.Minor > 3 {
+		p[1] += ",srcmap,srcmap-runtime"
+	}
 	return p
 }
 
@@ -175,6 +183,8 @@ func (s *Solidity) run(cmd *exec.Cmd, source string) (map[string]*Contract, erro
 				UserDoc:         userdoc,
 				DeveloperDoc:    devdoc,
 				Metadata:        info.Metadata,
+				SrcMap:          info.SrcMap,
+				SrcMapRuntime:   info.SrcMapRuntime,
 			},
 		}
 	}
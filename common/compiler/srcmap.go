@@ -0,0 +1,81 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package compiler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SourceRange is the section of the source code an instruction was generated
+// from, as described by a solc source mapping.
+type SourceRange struct {
+	Start  int  // Byte offset of the section in the source file
+	Length int  // Length of the section in bytes
+	File   int  // Index of the source file, -1 for compiler generated code
+	Jump   byte // Whether the instruction jumps into ('i') or out of ('o') a function, '-' otherwise
+}
+
+// ParseSourceMap decompresses a solc source mapping into the source ranges of
+// the individual instructions, indexed by instruction (not by byte offset).
+//
+// Entries of the mapping are separated by semicolons and consist of the start,
+// length, file and jump fields separated by colons. Empty or missing fields are
+// inherited from the previous entry.
+func ParseSourceMap(srcmap string) ([]SourceRange, error) {
+	if srcmap == "" {
+		return nil, nil
+	}
+	var (
+		entries = strings.Split(srcmap, ";")
+		ranges  = make([]SourceRange, 0, len(entries))
+		last    = SourceRange{File: -1, Jump: '-'}
+	)
+	for i, entry := range entries {
+		fields := strings.Split(entry, ":")
+		for j, field := range fields {
+			if field == "" {
+				continue
+			}
+			if j == 3 {
+				if len(field) != 1 {
+					return nil, fmt.Errorf("entry %d: invalid jump type %q", i, field)
+				}
+				last.Jump = field[0]
+				continue
+			}
+			if j > 3 {
+				break // Newer compilers append extra fields, ignore them
+			}
+			n, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("entry %d: invalid field %q", i, field)
+			}
+			switch j {
+			case 0:
+				last.Start = n
+			case 1:
+				last.Length = n
+			case 2:
+				last.File = n
+			}
+		}
+		ranges = append(ranges, last)
+	}
+	return ranges, nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package compiler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSourceMap(t *testing.T) {
+	tests := []struct {
+		srcmap string
+		ranges []SourceRange
+		fail   bool
+	}{
+		{srcmap: "", ranges: nil},
+		{
+			srcmap: "0:10:0:-;;2:5;:3::i;-1:-1:-1:o;4",
+			ranges: []SourceRange{
+				{Start: 0, Length: 10, File: 0, Jump: '-'},
+				{Start: 0, Length: 10, File: 0, Jump: '-'},
+				{Start: 2, Length: 5, File: 0, Jump: '-'},
+				{Start: 2, Length: 3, File: 0, Jump: 'i'},
+				{Start: -1, Length: -1, File: -1, Jump: 'o'},
+				{Start: 4, Length: -1, File: -1, Jump: 'o'},
+			},
+		},
+		{
+			srcmap: "26:120:0:-:0;;",
+			ranges: []SourceRange{
+				{Start: 26, Length: 120, File: 0, Jump: '-'},
+				{Start: 26, Length: 120, File: 0, Jump: '-'},
+				{Start: 26, Length: 120, File: 0, Jump: '-'},
+			},
+		},
+		{srcmap: "0:x", fail: true},
+		{srcmap: "0:1:0:io", fail: true},
+	}
+	for i, tt := range tests {
+		ranges, err := ParseSourceMap(tt.srcmap)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("test %d: expected failure, got %v", i, ranges)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: failed to parse: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(ranges, tt.ranges) {
+			t.Errorf("test %d: ranges mismatch:\nhave %v\nwant %v", i, ranges, tt.ranges)
+		}
+	}
+}
@@ -101,7 +101,7 @@ func (api *API) Proposals() map[common.Address]bool {
 }
 
 // Propose injects a new authorization proposal that the signer will attempt to
-// push through.
+// push through. Proposals are never cast if the signers are managed by a contract.
 func (api *API) Propose(address common.Address, auth bool) {
 	api.clique.lock.Lock()
 	defer api.clique.lock.Unlock()
@@ -105,6 +105,14 @@ var (
 	// ones).
 	errInvalidCheckpointSigners = errors.New("invalid signer list on checkpoint block")
 
+	// errVotingDisabled is returned if a block casts a signer vote while the signer
+	// list is managed by a contract.
+	errVotingDisabled = errors.New("signer voting disabled by signer contract")
+
+	// errNoContractSigners is returned if the signer contract returns an empty
+	// list of signers for a checkpoint block.
+	errNoContractSigners = errors.New("signer contract returned no signers")
+
 	// errInvalidMixDigest is returned if a block's mix digest is non-zero.
 	errInvalidMixDigest = errors.New("non-zero mix digest")
 
@@ -192,6 +200,16 @@ func ecrecover(header *types.Header, sigcache *lru.ARCCache) (common.Address, er
 	return signer, nil
 }
 
+// checkpointSigners extracts the list of signers from the extra-data section of
+// a checkpoint header.
+func checkpointSigners(header *types.Header) []common.Address {
+	signers := make([]common.Address, (len(header.Extra)-extraVanity-extraSeal)/common.AddressLength)
+	for i := 0; i < len(signers); i++ {
+		copy(signers[i][:], header.Extra[extraVanity+i*common.AddressLength:])
+	}
+	return signers
+}
+
 // Clique is the proof-of-authority consensus engine proposed to support the
 // Ethereum testnet following the Ropsten attacks.
 type Clique struct {
@@ -287,6 +305,12 @@ func (c *Clique) verifyHeader(chain consensus.ChainReader, header *types.Header,
 	if checkpoint && !bytes.Equal(header.Nonce[:], nonceDropVote) {
 		return errInvalidCheckpointVote
 	}
+	// Votes are not allowed at all if the signers are managed by a contract
+	if c.config.SignerContract != nil {
+		if header.Coinbase != (common.Address{}) || !bytes.Equal(header.Nonce[:], nonceDropVote) {
+			return errVotingDisabled
+		}
+	}
 	// Check that the extra-data contains both the vanity and signature
 	if len(header.Extra) < extraVanity {
 		return errMissingVanity
@@ -302,6 +326,9 @@ func (c *Clique) verifyHeader(chain consensus.ChainReader, header *types.Header,
 	if checkpoint && signersBytes%common.AddressLength != 0 {
 		return errInvalidCheckpointSigners
 	}
+	if checkpoint && c.config.SignerContract != nil && signersBytes == 0 {
+		return errInvalidCheckpointSigners
+	}
 	// Ensure that the mix digest is zero as we don't have fork protection currently
 	if header.MixDigest != (common.Hash{}) {
 		return errInvalidMixDigest
@@ -352,8 +379,9 @@ func (c *Clique) verifyCascadingFields(chain consensus.ChainReader, header *type
 	if err != nil {
 		return err
 	}
-	// If the block is a checkpoint block, verify the signer list
-	if number%c.config.Epoch == 0 {
+	// If the block is a checkpoint block, verify the signer list. Contract defined
+	// signer lists can only be verified against the state in Finalize.
+	if number%c.config.Epoch == 0 && c.config.SignerContract == nil {
 		signers := make([]byte, len(snap.Signers)*common.AddressLength)
 		for i, signer := range snap.signers() {
 			copy(signers[i*common.AddressLength:], signer[:])
@@ -394,11 +422,7 @@ func (c *Clique) snapshot(chain consensus.ChainReader, number uint64, hash commo
 			if err := c.VerifyHeader(chain, genesis, false); err != nil {
 				return nil, err
 			}
-			signers := make([]common.Address, (len(genesis.Extra)-extraVanity-extraSeal)/common.AddressLength)
-			for i := 0; i < len(signers); i++ {
-				copy(signers[i][:], genesis.Extra[extraVanity+i*common.AddressLength:])
-			}
-			snap = newSnapshot(c.config, c.signatures, 0, genesis.Hash(), signers)
+			snap = newSnapshot(c.config, c.signatures, 0, genesis.Hash(), checkpointSigners(genesis))
 			if err := snap.store(c.db); err != nil {
 				return nil, err
 			}
@@ -515,7 +539,7 @@ func (c *Clique) Prepare(chain consensus.ChainReader, header *types.Header) erro
 	if err != nil {
 		return err
 	}
-	if number%c.config.Epoch != 0 {
+	if number%c.config.Epoch != 0 && c.config.SignerContract == nil {
 		c.lock.RLock()
 
 		// Gather all the proposals that make sense voting on
@@ -545,7 +569,8 @@ func (c *Clique) Prepare(chain consensus.ChainReader, header *types.Header) erro
 	}
 	header.Extra = header.Extra[:extraVanity]
 
-	if number%c.config.Epoch == 0 {
+	// Contract defined signer lists are only known after running the transactions
+	if number%c.config.Epoch == 0 && c.config.SignerContract == nil {
 		for _, signer := range snap.signers() {
 			header.Extra = append(header.Extra, signer[:]...)
 		}
@@ -568,8 +593,15 @@ func (c *Clique) Prepare(chain consensus.ChainReader, header *types.Header) erro
 }
 
 // Finalize implements consensus.Engine, ensuring no uncles are set, nor block
-// rewards given, and returns the final block.
+// rewards given, and returns the final block. If the signers are managed by a
+// contract, the signer list of checkpoint blocks is filled in from, or verified
+// against, the contract's state.
 func (c *Clique) Finalize(chain consensus.ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
+	if number := header.Number.Uint64(); c.config.SignerContract != nil && number > 0 && number%c.config.Epoch == 0 {
+		if err := c.finalizeSigners(chain, header, state); err != nil {
+			return nil, err
+		}
+	}
 	// No block rewards in PoA, so the state remains as is and uncles are dropped
 	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
 	header.UncleHash = types.CalcUncleHash(nil)
@@ -578,6 +610,35 @@ func (c *Clique) Finalize(chain consensus.ChainReader, header *types.Header, sta
 	return types.NewBlock(header, txs, nil, receipts), nil
 }
 
+// finalizeSigners retrieves the signer list of a checkpoint block from the signer
+// contract. Headers without a signer list (i.e. the ones being mined) get it
+// inserted, otherwise the contained list is checked against the contract's.
+func (c *Clique) finalizeSigners(chain consensus.ChainReader, header *types.Header, state *state.StateDB) error {
+	signers, err := c.contractSigners(chain, header, state)
+	if err != nil {
+		return err
+	}
+	list := make([]byte, 0, len(signers)*common.AddressLength)
+	for _, signer := range signers {
+		list = append(list, signer[:]...)
+	}
+	if len(header.Extra) < extraVanity+extraSeal {
+		header.Extra = append(header.Extra, make([]byte, extraVanity+extraSeal-len(header.Extra))...)
+	}
+	extraSuffix := len(header.Extra) - extraSeal
+	if extraSuffix == extraVanity {
+		extra := make([]byte, 0, extraVanity+len(list)+extraSeal)
+		extra = append(extra, header.Extra[:extraVanity]...)
+		extra = append(extra, list...)
+		header.Extra = append(extra, header.Extra[extraSuffix:]...)
+		return nil
+	}
+	if !bytes.Equal(header.Extra[extraVanity:extraSuffix], list) {
+		return errInvalidCheckpointSigners
+	}
+	return nil
+}
+
 // Authorize injects a private key into the consensus engine to mint new blocks
 // with.
 func (c *Clique) Authorize(signer common.Address, signFn SignerFn) {
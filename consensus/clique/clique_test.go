@@ -0,0 +1,312 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package clique
+
+import (
+	"bytes"
+	"math/big"
+	"sort"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// testSignerContractCode is a minimal signer contract storing the number of
+// signers in slot 0 and the signers themselves in the subsequent slots. Calls
+// with a 4 byte input return the stored list ABI encoded, any other call data
+// is stored word by word starting at slot 0 (i.e. count, signer1, signer2, ...).
+var testSignerContractCode = common.FromHex(
+	"3660041415603c57" + // if calldatasize != 4, jump to the setter
+		"6020600052600054806020526000" + // mem[0] = 0x20, mem[0x20] = n, i = 0
+		"5b8181101560315760018101548160200260400152600101601656" + // while i < n: mem[0x40+i*32] = sload(i+1)
+		"5b506020026040016000f3" + // return mem[0:0x40+n*32]
+		"5b6000" + // setter: i = 0
+		"5b36816020021015605757" + // while i*32 < calldatasize
+		"80602002358155600101603f56" + // sstore(i, calldataload(i*32)), i++
+		"5b00")
+
+// signerContractInput assembles the call data to update the signers stored in
+// the test signer contract.
+func signerContractInput(signers ...common.Address) []byte {
+	input := common.LeftPadBytes(big.NewInt(int64(len(signers))).Bytes(), 32)
+	for _, signer := range signers {
+		input = append(input, common.LeftPadBytes(signer[:], 32)...)
+	}
+	return input
+}
+
+// Tests that if the signers are managed by a contract, checkpoint blocks pick up
+// the signer list from the contract state and the snapshots switch over to it.
+func TestContractSigners(t *testing.T) {
+	var (
+		accounts = newTesterAccountPool()
+		contract = common.HexToAddress("0x0000000000000000000000000000000000001000")
+		db, _    = ethdb.NewMemDatabase()
+	)
+	config := *params.AllCliqueProtocolChanges
+	config.Clique = &params.CliqueConfig{Period: 0, Epoch: 3, SignerContract: &contract}
+
+	genesis := &core.Genesis{
+		Config:    &config,
+		ExtraData: make([]byte, extraVanity+common.AddressLength+extraSeal),
+		Alloc: core.GenesisAlloc{
+			accounts.address("admin"): {Balance: big.NewInt(1000000000000000000)},
+			contract: {
+				Balance: new(big.Int),
+				Code:    testSignerContractCode,
+				Storage: map[common.Hash]common.Hash{
+					common.BigToHash(common.Big0): common.BigToHash(common.Big1),
+					common.BigToHash(common.Big1): accounts.address("A").Hash(),
+				},
+			},
+		},
+	}
+	copy(genesis.ExtraData[extraVanity:], accounts.address("A").Bytes())
+	genesisBlock := genesis.MustCommit(db)
+
+	// Generate a chain swapping the signers over to A and B at block 3, and then
+	// to C alone at block 6
+	updates := map[int][]common.Address{
+		1: {accounts.address("A"), accounts.address("B")},
+		4: {accounts.address("C")},
+	}
+	blocks, _ := core.GenerateChain(&config, genesisBlock, New(config.Clique, db), db, 9, func(i int, block *core.BlockGen) {
+		block.SetExtra(make([]byte, extraVanity+extraSeal))
+		block.SetDifficulty(diffInTurn)
+
+		if signers, ok := updates[i]; ok {
+			tx, _ := types.SignTx(types.NewTransaction(block.TxNonce(accounts.address("admin")), contract, new(big.Int), 200000, nil, signerContractInput(signers...)), types.HomesteadSigner{}, accounts.accounts["admin"])
+			block.AddTx(tx)
+		}
+	})
+	// Ensure the checkpoints contain the contract defined signers
+	for number, want := range map[int][]common.Address{
+		3: {accounts.address("A"), accounts.address("B")},
+		6: {accounts.address("C")},
+		9: {accounts.address("C")},
+	} {
+		sort.Slice(want, func(i, j int) bool { return bytes.Compare(want[i][:], want[j][:]) < 0 })
+		if have := checkpointSigners(blocks[number-1].Header()); !equalSigners(have, want) {
+			t.Errorf("block %d: checkpoint signers mismatch: have %x, want %x", number, have, want)
+		}
+	}
+	// Sign the chain and ensure it's accepted with the signers switching over
+	schedule := []string{"A", "A", "A", "B", "A", "B", "C", "C", "C"}
+	sealChain(accounts, genesisBlock, blocks, schedule)
+
+	engine := New(config.Clique, db)
+	chain, _ := core.NewBlockChain(db, nil, &config, engine, vm.Config{})
+	defer chain.Stop()
+
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	for number, want := range map[uint64][]string{2: {"A"}, 3: {"A", "B"}, 5: {"A", "B"}, 9: {"C"}} {
+		snap, err := engine.snapshot(chain, number, chain.GetHeaderByNumber(number).Hash(), nil)
+		if err != nil {
+			t.Fatalf("block %d: failed to retrieve snapshot: %v", number, err)
+		}
+		if len(snap.Signers) != len(want) {
+			t.Errorf("block %d: signer count mismatch: have %d, want %d", number, len(snap.Signers), len(want))
+		}
+		for _, name := range want {
+			if _, ok := snap.Signers[accounts.address(name)]; !ok {
+				t.Errorf("block %d: signer %s missing", number, name)
+			}
+		}
+	}
+	// Ensure the signers removed by the contract are rejected
+	header := types.CopyHeader(chain.CurrentHeader())
+	header.ParentHash, header.Number = header.Hash(), new(big.Int).Add(header.Number, common.Big1)
+	header.Time = new(big.Int).Add(header.Time, common.Big1)
+	header.Extra = make([]byte, extraVanity+extraSeal)
+	header.Difficulty = diffNoTurn
+	accounts.sign(header, "A")
+	if err := engine.VerifyHeader(chain, header, true); err != errUnauthorized {
+		t.Errorf("removed signer error mismatch: have %v, want %v", err, errUnauthorized)
+	}
+	// Ensure votes in headers are rejected
+	header.Coinbase = accounts.address("D")
+	accounts.sign(header, "C")
+	if err := engine.VerifyHeader(chain, header, true); err != errVotingDisabled {
+		t.Errorf("vote error mismatch: have %v, want %v", err, errVotingDisabled)
+	}
+}
+
+// Tests that checkpoint blocks with a signer list not matching the contract's
+// are rejected during block processing.
+func TestContractSignersMismatch(t *testing.T) {
+	var (
+		accounts = newTesterAccountPool()
+		contract = common.HexToAddress("0x0000000000000000000000000000000000001000")
+		db, _    = ethdb.NewMemDatabase()
+	)
+	config := *params.AllCliqueProtocolChanges
+	config.Clique = &params.CliqueConfig{Period: 0, Epoch: 3, SignerContract: &contract}
+
+	genesis := &core.Genesis{
+		Config:    &config,
+		ExtraData: make([]byte, extraVanity+common.AddressLength+extraSeal),
+		Alloc: core.GenesisAlloc{
+			contract: {
+				Balance: new(big.Int),
+				Code:    testSignerContractCode,
+				Storage: map[common.Hash]common.Hash{
+					common.BigToHash(common.Big0): common.BigToHash(common.Big1),
+					common.BigToHash(common.Big1): accounts.address("B").Hash(),
+				},
+			},
+		},
+	}
+	copy(genesis.ExtraData[extraVanity:], accounts.address("A").Bytes())
+	genesisBlock := genesis.MustCommit(db)
+
+	blocks, _ := core.GenerateChain(&config, genesisBlock, New(config.Clique, db), db, 3, func(i int, block *core.BlockGen) {
+		block.SetExtra(make([]byte, extraVanity+extraSeal))
+		block.SetDifficulty(diffInTurn)
+	})
+	// Replace the contract defined signers of the checkpoint with the old ones
+	header := blocks[2].Header()
+	header.Extra = make([]byte, extraVanity+common.AddressLength+extraSeal)
+	copy(header.Extra[extraVanity:], accounts.address("A").Bytes())
+	blocks[2] = blocks[2].WithSeal(header)
+
+	sealChain(accounts, genesisBlock, blocks, []string{"A", "A", "A"})
+
+	chain, _ := core.NewBlockChain(db, nil, &config, New(config.Clique, db), vm.Config{})
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks); err != errInvalidCheckpointSigners || n != 2 {
+		t.Fatalf("checkpoint error mismatch: have %d/%v, want %d/%v", n, err, 2, errInvalidCheckpointSigners)
+	}
+}
+
+// Tests that a checkpoint signer list forged by an authorized signer to smuggle
+// in a new one passes header verification, which trusts the checkpoints, but is
+// rejected when the blocks are fully imported.
+func TestContractSignersForged(t *testing.T) {
+	var (
+		accounts = newTesterAccountPool()
+		contract = common.HexToAddress("0x0000000000000000000000000000000000001000")
+		db, _    = ethdb.NewMemDatabase()
+	)
+	config := *params.AllCliqueProtocolChanges
+	config.Clique = &params.CliqueConfig{Period: 0, Epoch: 3, SignerContract: &contract}
+
+	genesis := &core.Genesis{
+		Config:    &config,
+		ExtraData: make([]byte, extraVanity+common.AddressLength+extraSeal),
+		Alloc: core.GenesisAlloc{
+			contract: {
+				Balance: new(big.Int),
+				Code:    testSignerContractCode,
+				Storage: map[common.Hash]common.Hash{
+					common.BigToHash(common.Big0): common.BigToHash(common.Big1),
+					common.BigToHash(common.Big1): accounts.address("A").Hash(),
+				},
+			},
+		},
+	}
+	copy(genesis.ExtraData[extraVanity:], accounts.address("A").Bytes())
+	genesisBlock := genesis.MustCommit(db)
+
+	blocks, _ := core.GenerateChain(&config, genesisBlock, New(config.Clique, db), db, 5, func(i int, block *core.BlockGen) {
+		block.SetExtra(make([]byte, extraVanity+extraSeal))
+		block.SetDifficulty(diffInTurn)
+	})
+	// Forge the checkpoint to authorize D next to A, and let D sign afterwards
+	forged := []common.Address{accounts.address("A"), accounts.address("D")}
+	sort.Slice(forged, func(i, j int) bool { return bytes.Compare(forged[i][:], forged[j][:]) < 0 })
+
+	header := blocks[2].Header()
+	header.Extra = make([]byte, extraVanity+len(forged)*common.AddressLength+extraSeal)
+	for i, signer := range forged {
+		copy(header.Extra[extraVanity+i*common.AddressLength:], signer[:])
+	}
+	blocks[2] = blocks[2].WithSeal(header)
+
+	sealChain(accounts, genesisBlock, blocks, []string{"A", "A", "A", "D", "A"})
+
+	// Header verification can't check the list against the contract state
+	headers := make([]*types.Header, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.Header()
+	}
+	lightdb, _ := ethdb.NewMemDatabase()
+	genesis.MustCommit(lightdb)
+
+	light, _ := core.NewBlockChain(lightdb, nil, &config, New(config.Clique, lightdb), vm.Config{})
+	defer light.Stop()
+
+	if _, err := light.InsertHeaderChain(headers, 1); err != nil {
+		t.Fatalf("failed to insert forged header chain: %v", err)
+	}
+	// Full import must reject the forged checkpoint and anything signed by D
+	chain, _ := core.NewBlockChain(db, nil, &config, New(config.Clique, db), vm.Config{})
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks); err != errInvalidCheckpointSigners || n != 2 {
+		t.Fatalf("checkpoint error mismatch: have %d/%v, want %d/%v", n, err, 2, errInvalidCheckpointSigners)
+	}
+	if head := chain.CurrentBlock().NumberU64(); head != 2 {
+		t.Errorf("head mismatch: have %d, want %d", head, 2)
+	}
+}
+
+// sealChain relinks and signs a generated chain with the given signers, setting
+// the difficulties according to the turn-ness of the signers.
+func sealChain(accounts *testerAccountPool, genesis *types.Block, blocks []*types.Block, schedule []string) {
+	signers := checkpointSigners(genesis.Header())
+	for i, block := range blocks {
+		header := block.Header()
+		if i > 0 {
+			header.ParentHash = blocks[i-1].Hash()
+		} else {
+			header.ParentHash = genesis.Hash()
+		}
+		sort.Slice(signers, func(i, j int) bool { return bytes.Compare(signers[i][:], signers[j][:]) < 0 })
+		if signers[header.Number.Uint64()%uint64(len(signers))] == accounts.address(schedule[i]) {
+			header.Difficulty = diffInTurn
+		} else {
+			header.Difficulty = diffNoTurn
+		}
+		accounts.sign(header, schedule[i])
+		blocks[i] = block.WithSeal(header)
+
+		if len(header.Extra) > extraVanity+extraSeal {
+			signers = checkpointSigners(header)
+		}
+	}
+}
+
+// equalSigners reports whether two signer lists are identical.
+func equalSigners(a, b []common.Address) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,107 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package clique
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// signerContractABI is the interface a signer contract needs to implement to
+// define the authorized signers at epoch checkpoints.
+const signerContractABI = `[{"constant":true,"inputs":[],"name":"getSigners","outputs":[{"name":"","type":"address[]"}],"payable":false,"stateMutability":"view","type":"function"}]`
+
+// signerContractGas is the gas allowance for retrieving the signer list.
+const signerContractGas = uint64(50000000)
+
+// signerContract is the parsed signer contract interface.
+var signerContract, _ = abi.JSON(strings.NewReader(signerContractABI))
+
+// contractSigners retrieves the list of authorized signers in ascending order
+// from the configured signer contract, executing it on top of the given state.
+// The state itself is left untouched.
+func (c *Clique) contractSigners(chain consensus.ChainReader, header *types.Header, statedb *state.StateDB) ([]common.Address, error) {
+	input, err := signerContract.Pack("getSigners")
+	if err != nil {
+		return nil, err
+	}
+	context := vm.Context{
+		CanTransfer: func(db vm.StateDB, addr common.Address, amount *big.Int) bool {
+			return db.GetBalance(addr).Cmp(amount) >= 0
+		},
+		Transfer: func(db vm.StateDB, sender, recipient common.Address, amount *big.Int) {
+			db.SubBalance(sender, amount)
+			db.AddBalance(recipient, amount)
+		},
+		GetHash:     chainHashFn(chain, header),
+		GasPrice:    new(big.Int),
+		GasLimit:    header.GasLimit,
+		BlockNumber: new(big.Int).Set(header.Number),
+		Time:        new(big.Int).Set(header.Time),
+		Difficulty:  new(big.Int).Set(header.Difficulty),
+	}
+	evm := vm.NewEVM(context, statedb.Copy(), chain.Config(), vm.Config{})
+
+	output, _, err := evm.StaticCall(vm.AccountRef(common.Address{}), *c.config.SignerContract, input, signerContractGas)
+	if err != nil {
+		return nil, fmt.Errorf("signer contract call failed: %v", err)
+	}
+	var signers []common.Address
+	if err := signerContract.Unpack(&signers, "getSigners", output); err != nil {
+		return nil, fmt.Errorf("invalid signer contract output: %v", err)
+	}
+	// Sort the signers and drop any duplicates to match the checkpoint format
+	sort.Slice(signers, func(i, j int) bool {
+		return bytes.Compare(signers[i][:], signers[j][:]) < 0
+	})
+	var unique []common.Address
+	for _, signer := range signers {
+		if len(unique) == 0 || unique[len(unique)-1] != signer {
+			unique = append(unique, signer)
+		}
+	}
+	if len(unique) == 0 {
+		return nil, errNoContractSigners
+	}
+	return unique, nil
+}
+
+// chainHashFn returns a vm.GetHashFunc which retrieves the hashes of the
+// ancestors of the given header by number.
+func chainHashFn(chain consensus.ChainReader, ref *types.Header) vm.GetHashFunc {
+	return func(n uint64) common.Hash {
+		for header := chain.GetHeader(ref.ParentHash, ref.Number.Uint64()-1); header != nil; header = chain.GetHeader(header.ParentHash, header.Number.Uint64()-1) {
+			if header.Number.Uint64() == n {
+				return header.Hash()
+			}
+			if header.Number.Uint64() < n {
+				break
+			}
+		}
+		return common.Hash{}
+	}
+}
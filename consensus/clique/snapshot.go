@@ -215,6 +215,27 @@ func (s *Snapshot) apply(headers []*types.Header) (*Snapshot, error) {
 		}
 		snap.Recents[number] = signer
 
+		// If the signers are managed by a contract, switch over at checkpoints
+		if s.config.SignerContract != nil {
+			if number%s.config.Epoch == 0 {
+				signers := checkpointSigners(header)
+				if len(signers) == 0 {
+					return nil, errInvalidCheckpointSigners
+				}
+				snap.Signers = make(map[common.Address]struct{})
+				for _, signer := range signers {
+					snap.Signers[signer] = struct{}{}
+				}
+				// Signer list might have shrunk, delete any leftover recent caches
+				limit := uint64(len(snap.Signers)/2 + 1)
+				for seen := range snap.Recents {
+					if seen+limit <= number {
+						delete(snap.Recents, seen)
+					}
+				}
+			}
+			continue
+		}
 		// Header authorized, discard any previous votes from the signer
 		for i, vote := range snap.Votes {
 			if vote.Signer == signer && vote.Address == header.Coinbase {
@@ -21,6 +21,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rpc"
 	"math/big"
@@ -104,3 +105,35 @@ type PoW interface {
 	// Hashrate returns the current mining hashrate of a PoW consensus engine.
 	Hashrate() float64
 }
+
+// Handler is a consensus engine exchanging its own messages with the remote
+// peers, directly over the Ethereum wire protocol.
+type Handler interface {
+	// NewChainHead notifies the consensus engine that the canonical head of the
+	// local chain was updated.
+	NewChainHead() error
+
+	// HandleMsg processes a consensus message received from the remote peer with
+	// the given address.
+	HandleMsg(address common.Address, msg p2p.Msg) error
+
+	// SetBroadcaster sets the network interface used to deliver consensus messages
+	// to the remote peers.
+	SetBroadcaster(Broadcaster)
+}
+
+// Broadcaster defines the network interface needed by consensus engines with
+// their own messages to communicate with the remote peers.
+type Broadcaster interface {
+	// Enqueue schedules a block for import, as if it was received from a peer.
+	Enqueue(id string, block *types.Block)
+
+	// FindPeers retrieves the connected peers among the given addresses.
+	FindPeers(targets map[common.Address]bool) map[common.Address]Peer
+}
+
+// Peer defines a remote peer consensus messages can be sent to.
+type Peer interface {
+	// SendConsensus sends a consensus engine message to the remote peer.
+	SendConsensus(data interface{}) error
+}
@@ -0,0 +1,526 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package istanbul
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+const (
+	maxBacklog      = 64 // Maximum number of future messages to keep per validator
+	maxTimeoutShift = 10 // Maximum number of times the round timeout is doubled
+)
+
+// requestEvent is posted to the consensus loop when the local sealer requests a
+// block to be proposed.
+type requestEvent struct {
+	block *types.Block
+}
+
+// chainHeadEvent is posted to the consensus loop when the local chain head was
+// updated, starting the consensus on the next block.
+type chainHeadEvent struct{}
+
+// timeoutEvent is posted to the consensus loop when a round failed to complete
+// in time.
+type timeoutEvent struct {
+	sequence uint64
+	round    uint64
+}
+
+// roundState is the consensus state of the block currently being agreed upon.
+type roundState struct {
+	sequence   uint64        // Number of the block being agreed upon
+	round      uint64        // Current consensus round of the sequence
+	parent     *types.Header // Parent of the block being agreed upon
+	validators validatorSet  // Validators taking part in the consensus
+
+	waiting   bool         // Whether a quorum of round changes is awaited
+	proposal  *types.Block // Block proposed in the current round
+	digest    common.Hash  // Hash of the block proposed in the current round
+	prepared  bool         // Whether a quorum prepared the proposal
+	committed bool         // Whether a quorum committed to the proposal
+
+	prepares     map[common.Address]*message            // Prepare votes of the current round
+	commits      map[common.Address]*message            // Commit votes of the current round
+	roundChanges map[uint64]map[common.Address]*message // Round change votes of future rounds
+
+	locked  *types.Block // Block prepared by a quorum, the only one allowed to be proposed
+	request *types.Block // Block requested to be proposed by the local sealer
+}
+
+// roundManager runs the consensus rounds of the local validator. All its methods
+// are called from the single consensus loop.
+type roundManager struct {
+	istanbul *Istanbul
+	chain    Chain
+	events   chan interface{}
+	quit     chan struct{}
+
+	state   *roundState                   // State of the current sequence, nil if not validating
+	backlog map[common.Address][]*message // Messages of future sequences and rounds
+	timer   *time.Timer                   // Timer to trigger a round change
+}
+
+// loop is the consensus loop, processing the events of the consensus rounds one
+// by one until the engine is stopped.
+func (c *Istanbul) loop(chain Chain, events chan interface{}, quit chan struct{}) {
+	m := &roundManager{
+		istanbul: c,
+		chain:    chain,
+		events:   events,
+		quit:     quit,
+		backlog:  make(map[common.Address][]*message),
+	}
+	defer m.stopTimer()
+
+	for {
+		select {
+		case event := <-events:
+			switch event := event.(type) {
+			case chainHeadEvent:
+				m.newSequence()
+			case requestEvent:
+				m.handleRequest(event.block)
+			case *message:
+				m.handleMessage(event)
+			case timeoutEvent:
+				m.handleTimeout(event)
+			}
+		case <-quit:
+			return
+		}
+	}
+}
+
+// newSequence starts the consensus on the block following the chain head.
+func (m *roundManager) newSequence() {
+	parent := m.chain.CurrentHeader()
+	if m.state != nil && parent.Number.Uint64() < m.state.sequence {
+		return
+	}
+	validators, err := parentValidators(parent)
+	if err != nil {
+		log.Error("Failed to retrieve istanbul validators", "number", parent.Number, "err", err)
+		m.state = nil
+		return
+	}
+	if !validators.contains(m.istanbul.address) {
+		log.Warn("Local node is not an istanbul validator", "number", parent.Number.Uint64()+1)
+		m.state = nil
+		m.stopTimer()
+		return
+	}
+	m.state = &roundState{
+		sequence:     parent.Number.Uint64() + 1,
+		parent:       parent,
+		validators:   validators,
+		roundChanges: make(map[uint64]map[common.Address]*message),
+	}
+	m.startRound(0)
+}
+
+// startRound starts a new consensus round on the current sequence.
+func (m *roundManager) startRound(round uint64) {
+	s := m.state
+
+	s.round, s.waiting = round, false
+	s.proposal, s.digest, s.prepared, s.committed = nil, common.Hash{}, false, false
+	s.prepares = make(map[common.Address]*message)
+	s.commits = make(map[common.Address]*message)
+	for r := range s.roundChanges {
+		if r <= round {
+			delete(s.roundChanges, r)
+		}
+	}
+	m.resetTimer()
+
+	proposer := s.validators.proposer(s.sequence, round)
+	log.Debug("Starting istanbul round", "number", s.sequence, "round", round, "proposer", proposer)
+
+	if proposer == m.istanbul.address {
+		m.propose()
+	}
+	m.processBacklog()
+}
+
+// propose broadcasts the block to agree upon in the current round: the locked
+// block if any, or otherwise the one requested by the local sealer.
+func (m *roundManager) propose() {
+	s := m.state
+
+	block := s.locked
+	if block == nil {
+		block = s.request
+	}
+	if block == nil {
+		return
+	}
+	payload, err := rlp.EncodeToBytes(block)
+	if err != nil {
+		log.Error("Failed to encode istanbul proposal", "err", err)
+		return
+	}
+	m.broadcast(&message{
+		Code:     msgPreprepare,
+		Sequence: s.sequence,
+		Round:    s.round,
+		Digest:   block.Hash(),
+		Proposal: payload,
+	})
+}
+
+// handleRequest stores a block requested to be proposed by the local sealer, and
+// proposes it if the local validator is the proposer of the current round.
+func (m *roundManager) handleRequest(block *types.Block) {
+	s := m.state
+	if s == nil || block.NumberU64() != s.sequence || block.ParentHash() != s.parent.Hash() {
+		return
+	}
+	s.request = block
+	if !s.waiting && s.proposal == nil && s.validators.proposer(s.sequence, s.round) == m.istanbul.address {
+		m.propose()
+	}
+}
+
+// handleMessage processes a consensus message of a validator, storing it for
+// later if it belongs to a future sequence or round.
+func (m *roundManager) handleMessage(msg *message) {
+	s := m.state
+	if s == nil || !s.validators.contains(msg.sender) {
+		return
+	}
+	switch {
+	case msg.Sequence < s.sequence:
+		return
+	case msg.Sequence > s.sequence:
+		m.store(msg)
+		return
+	}
+	if msg.Code == msgRoundChange {
+		m.handleRoundChange(msg)
+		return
+	}
+	switch {
+	case msg.Round < s.round:
+		return
+	case msg.Round > s.round || s.waiting:
+		m.store(msg)
+		return
+	}
+	switch msg.Code {
+	case msgPreprepare:
+		m.handlePreprepare(msg)
+	case msgPrepare:
+		m.handlePrepare(msg)
+	case msgCommit:
+		m.handleCommit(msg)
+	}
+}
+
+// handlePreprepare validates the block proposed in the current round and, if
+// acceptable, votes to prepare it.
+func (m *roundManager) handlePreprepare(msg *message) {
+	s := m.state
+	if msg.sender != s.validators.proposer(s.sequence, s.round) || s.proposal != nil {
+		return
+	}
+	block, err := msg.proposal()
+	if err != nil {
+		log.Debug("Invalid istanbul proposal encoding", "msg", msg, "err", err)
+		return
+	}
+	if block.NumberU64() != s.sequence || block.ParentHash() != s.parent.Hash() || block.Hash() != msg.Digest {
+		log.Debug("Mismatching istanbul proposal", "msg", msg)
+		return
+	}
+	if s.locked != nil && s.locked.Hash() != msg.Digest {
+		log.Debug("Istanbul proposal conflicts with locked block", "msg", msg)
+		return
+	}
+	if err := m.istanbul.verifyProposal(m.chain, block); err != nil {
+		log.Warn("Invalid istanbul proposal", "msg", msg, "err", err)
+		return
+	}
+	s.proposal, s.digest = block, msg.Digest
+
+	m.broadcast(&message{
+		Code:     msgPrepare,
+		Sequence: s.sequence,
+		Round:    s.round,
+		Digest:   s.digest,
+	})
+	// Commits may have arrived before the proposal itself
+	m.checkCommitted()
+}
+
+// handlePrepare stores a prepare vote of the current round.
+func (m *roundManager) handlePrepare(msg *message) {
+	m.state.prepares[msg.sender] = msg
+	m.checkPrepared()
+}
+
+// checkPrepared locks the proposal and votes to commit it once a quorum of the
+// validators prepared it.
+func (m *roundManager) checkPrepared() {
+	s := m.state
+	if s.proposal == nil || s.prepared || s.committed {
+		return
+	}
+	votes := 0
+	for _, msg := range s.prepares {
+		if msg.Digest == s.digest {
+			votes++
+		}
+	}
+	if votes < s.validators.quorum() {
+		return
+	}
+	s.prepared, s.locked = true, s.proposal
+
+	seal, err := crypto.Sign(commitHash(s.digest), m.istanbul.key)
+	if err != nil {
+		log.Error("Failed to sign istanbul commit", "err", err)
+		return
+	}
+	m.broadcast(&message{
+		Code:          msgCommit,
+		Sequence:      s.sequence,
+		Round:         s.round,
+		Digest:        s.digest,
+		CommittedSeal: seal,
+	})
+}
+
+// handleCommit stores a commit vote of the current round.
+func (m *roundManager) handleCommit(msg *message) {
+	pubkey, err := crypto.SigToPub(commitHash(msg.Digest), msg.CommittedSeal)
+	if err != nil || crypto.PubkeyToAddress(*pubkey) != msg.sender {
+		log.Debug("Invalid istanbul committed seal", "msg", msg)
+		return
+	}
+	m.state.commits[msg.sender] = msg
+	m.checkCommitted()
+}
+
+// checkCommitted finalizes the proposal with the committed seals once a quorum
+// of the validators committed to it.
+func (m *roundManager) checkCommitted() {
+	s := m.state
+	if s.proposal == nil || s.committed {
+		return
+	}
+	var seals [][]byte
+	for _, validator := range s.validators {
+		if msg, ok := s.commits[validator]; ok && msg.Digest == s.digest {
+			seals = append(seals, msg.CommittedSeal)
+		}
+	}
+	if len(seals) < s.validators.quorum() {
+		return
+	}
+	s.committed, s.locked = true, s.proposal
+
+	header := s.proposal.Header()
+	if err := writeCommittedSeals(header, seals); err != nil {
+		log.Error("Failed to write istanbul committed seals", "err", err)
+		return
+	}
+	m.istanbul.commit(s.proposal.WithSeal(header), s.digest)
+}
+
+// handleRoundChange stores a round change vote, starting the new round once a
+// quorum of the validators voted for it. If enough validators voted for a
+// future round for at least one of them to be honest, the local validator joins
+// the vote instead of waiting for its own timeout.
+func (m *roundManager) handleRoundChange(msg *message) {
+	s := m.state
+	if msg.Round < s.round || (msg.Round == s.round && !s.waiting) {
+		return
+	}
+	if s.roundChanges[msg.Round] == nil {
+		s.roundChanges[msg.Round] = make(map[common.Address]*message)
+	}
+	s.roundChanges[msg.Round][msg.sender] = msg
+
+	switch votes := len(s.roundChanges[msg.Round]); {
+	case votes >= s.validators.quorum():
+		m.startRound(msg.Round)
+	case votes > s.validators.faulty() && msg.Round > s.round:
+		m.sendRoundChange(msg.Round)
+	}
+}
+
+// handleTimeout votes to move on to the next round if the current one failed to
+// complete in time.
+func (m *roundManager) handleTimeout(event timeoutEvent) {
+	s := m.state
+	if s == nil || event.sequence != s.sequence || event.round != s.round {
+		return
+	}
+	log.Debug("Istanbul round timed out", "number", s.sequence, "round", s.round)
+	m.sendRoundChange(s.round + 1)
+}
+
+// sendRoundChange abandons the current round and votes to change to the given one.
+func (m *roundManager) sendRoundChange(round uint64) {
+	s := m.state
+
+	s.round, s.waiting = round, true
+	s.proposal, s.digest, s.prepared, s.committed = nil, common.Hash{}, false, false
+	s.prepares = make(map[common.Address]*message)
+	s.commits = make(map[common.Address]*message)
+	m.resetTimer()
+
+	m.broadcast(&message{
+		Code:     msgRoundChange,
+		Sequence: s.sequence,
+		Round:    round,
+	})
+}
+
+// store adds a message of a future sequence or round to the backlog.
+func (m *roundManager) store(msg *message) {
+	backlog := m.backlog[msg.sender]
+	if len(backlog) >= maxBacklog {
+		backlog = backlog[1:]
+	}
+	m.backlog[msg.sender] = append(backlog, msg)
+}
+
+// processBacklog handles the messages of the backlog belonging to the current
+// sequence and round, dropping the stale ones.
+func (m *roundManager) processBacklog() {
+	s := m.state
+
+	var ready []*message
+	for sender, backlog := range m.backlog {
+		var keep []*message
+		for _, msg := range backlog {
+			switch {
+			case msg.Sequence < s.sequence:
+				continue
+			case msg.Sequence > s.sequence:
+				keep = append(keep, msg)
+			case msg.Code != msgRoundChange && (msg.Round > s.round || s.waiting):
+				keep = append(keep, msg)
+			default:
+				ready = append(ready, msg)
+			}
+		}
+		if len(keep) == 0 {
+			delete(m.backlog, sender)
+		} else {
+			m.backlog[sender] = keep
+		}
+	}
+	for _, msg := range ready {
+		m.handleMessage(msg)
+	}
+}
+
+// broadcast signs a consensus message, sends it to the other validators and
+// processes it locally.
+func (m *roundManager) broadcast(msg *message) {
+	if err := msg.sign(m.istanbul.key); err != nil {
+		log.Error("Failed to sign istanbul message", "err", err)
+		return
+	}
+	payload, err := rlp.EncodeToBytes(msg)
+	if err != nil {
+		log.Error("Failed to encode istanbul message", "err", err)
+		return
+	}
+	hash := crypto.Keccak256Hash(payload)
+	m.istanbul.messages.Add(hash, true)
+	m.istanbul.gossip(m.state.validators, payload, hash)
+
+	m.handleMessage(msg)
+}
+
+// resetTimer restarts the round change timer of the current round. The timeout
+// doubles with every round to allow the validators to eventually synchronise.
+func (m *roundManager) resetTimer() {
+	m.stopTimer()
+
+	s, shift := m.state, m.state.round
+	if shift > maxTimeoutShift {
+		shift = maxTimeoutShift
+	}
+	timeout := time.Duration(m.istanbul.config.BlockPeriod)*time.Second + time.Duration(m.istanbul.config.RequestTimeout<<shift)*time.Millisecond
+
+	event := timeoutEvent{sequence: s.sequence, round: s.round}
+	m.timer = time.AfterFunc(timeout, func() {
+		select {
+		case m.events <- event:
+		case <-m.quit:
+		}
+	})
+}
+
+// stopTimer stops the round change timer, if any.
+func (m *roundManager) stopTimer() {
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+}
+
+// verifyProposal checks whether a proposed block is valid to be agreed upon. The
+// block is executed, as the committed seals finalize it irrevocably: a block
+// failing to import afterwards would halt the chain.
+func (c *Istanbul) verifyProposal(chain Chain, block *types.Block) error {
+	if err := c.verifyHeader(chain, block.Header(), nil, false); err != nil {
+		return err
+	}
+	if hash := types.DeriveSha(block.Transactions()); hash != block.TxHash() {
+		return errors.New("transaction root hash mismatch")
+	}
+	if len(block.Uncles()) > 0 {
+		return errors.New("uncles not allowed")
+	}
+	return chain.ExecuteBlock(block)
+}
+
+// commit delivers a block committed by a quorum of the validators to the local
+// sealer if it proposed it, or schedules it for import otherwise.
+func (c *Istanbul) commit(block *types.Block, digest common.Hash) {
+	log.Info("Committed istanbul block", "number", block.Number(), "hash", block.Hash())
+
+	c.sealLock.Lock()
+	if c.sealHash == digest && c.sealCh != nil {
+		c.sealCh <- block
+		c.sealHash, c.sealCh = common.Hash{}, nil
+		c.sealLock.Unlock()
+		return
+	}
+	c.sealLock.Unlock()
+
+	c.lock.RLock()
+	broadcaster := c.broadcaster
+	c.lock.RUnlock()
+	if broadcaster != nil {
+		broadcaster.Enqueue(fetcherID, block)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package istanbul
+
+import (
+	"bytes"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// EncodeExtra assembles the extra-data section of a header (e.g. the genesis)
+// from a vanity prefix and the list of validators.
+func EncodeExtra(vanity []byte, validators []common.Address) ([]byte, error) {
+	extra := make([]byte, extraVanity)
+	copy(extra, vanity)
+
+	payload, err := rlp.EncodeToBytes(&types.IstanbulExtra{Validators: newValidatorSet(validators)})
+	if err != nil {
+		return nil, err
+	}
+	return append(extra, payload...), nil
+}
+
+// extractExtra decodes the consensus data from the extra-data section of a
+// header.
+func extractExtra(header *types.Header) (*types.IstanbulExtra, error) {
+	if len(header.Extra) < extraVanity {
+		return nil, errMissingVanity
+	}
+	extra, err := types.ExtractIstanbulExtra(header)
+	if err != nil {
+		return nil, errInvalidExtraData
+	}
+	return extra, nil
+}
+
+// sigHash returns the hash which is signed by the proposer of a block: the hash
+// of the entire header apart from the seals in the extra-data.
+func sigHash(header *types.Header) common.Hash {
+	if filtered := types.IstanbulFilteredHeader(header, false); filtered != nil {
+		return filtered.Hash()
+	}
+	return header.Hash()
+}
+
+// commitHash returns the hash which is signed by the validators committing to a
+// proposal. As the committed seals are not part of the block hash, the digest
+// is simply the hash of the proposed block.
+func commitHash(digest common.Hash) []byte {
+	return crypto.Keccak256(digest[:], []byte{byte(msgCommit)})
+}
+
+// writeSeal inserts the proposer seal into the extra-data section of a header.
+func writeSeal(header *types.Header, seal []byte) error {
+	extra, err := extractExtra(header)
+	if err != nil {
+		return err
+	}
+	extra.Seal = seal
+	return writeExtra(header, extra)
+}
+
+// writeCommittedSeals inserts the committed seals into the extra-data section of
+// a header.
+func writeCommittedSeals(header *types.Header, seals [][]byte) error {
+	extra, err := extractExtra(header)
+	if err != nil {
+		return err
+	}
+	extra.CommittedSeal = seals
+	return writeExtra(header, extra)
+}
+
+// writeExtra replaces the consensus data in the extra-data section of a header.
+func writeExtra(header *types.Header, extra *types.IstanbulExtra) error {
+	payload, err := rlp.EncodeToBytes(extra)
+	if err != nil {
+		return err
+	}
+	header.Extra = append(common.CopyBytes(header.Extra[:extraVanity]), payload...)
+	return nil
+}
+
+// validatorSet is a list of validators in ascending order.
+type validatorSet []common.Address
+
+// newValidatorSet creates a sorted validator set from a list of addresses.
+func newValidatorSet(addresses []common.Address) validatorSet {
+	set := make(validatorSet, len(addresses))
+	copy(set, addresses)
+
+	for i := 0; i < len(set); i++ {
+		for j := i + 1; j < len(set); j++ {
+			if bytes.Compare(set[i][:], set[j][:]) > 0 {
+				set[i], set[j] = set[j], set[i]
+			}
+		}
+	}
+	return set
+}
+
+// contains returns whether an address is part of the validator set.
+func (set validatorSet) contains(address common.Address) bool {
+	for _, validator := range set {
+		if validator == address {
+			return true
+		}
+	}
+	return false
+}
+
+// equal returns whether two validator sets are identical.
+func (set validatorSet) equal(other validatorSet) bool {
+	if len(set) != len(other) {
+		return false
+	}
+	for i := range set {
+		if set[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// proposer returns the validator responsible for proposing the block of a given
+// sequence (block number) in a given round.
+func (set validatorSet) proposer(sequence, round uint64) common.Address {
+	return set[(sequence+round)%uint64(len(set))]
+}
+
+// faulty returns the maximum number of faulty validators tolerated by the set.
+func (set validatorSet) faulty() int {
+	return (len(set) - 1) / 3
+}
+
+// quorum returns the number of validators needed to agree on a proposal, which
+// is large enough for any two quorums to share an honest validator.
+func (set validatorSet) quorum() int {
+	return (2*len(set) + 2) / 3
+}
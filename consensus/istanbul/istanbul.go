@@ -0,0 +1,636 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package istanbul implements a byzantine fault tolerant proof-of-authority
+// consensus engine with immediate finality.
+//
+// Each block is agreed upon by the validators in a three phase round: the
+// proposer of the round broadcasts the block (pre-prepare), the validators
+// accepting it broadcast their prepare votes, and after a quorum of prepares,
+// their commit votes. A block collecting a quorum of commit seals is final. If
+// a round fails to complete in time, the validators vote to change the round
+// and with it the proposer.
+package istanbul
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	inmemorySignatures = 4096 // Number of recent block signatures to keep in memory
+	inmemoryMessages   = 1024 // Number of recent consensus messages to keep in memory
+	inmemoryPeers      = 64   // Number of peers to track the known messages of
+
+	fetcherID = "istanbul" // Origin of the committed blocks scheduled for import
+)
+
+// Istanbul protocol constants.
+var (
+	requestTimeout = uint64(10000) // Default milliseconds to wait for a round to complete
+
+	extraVanity = types.IstanbulExtraVanity // Fixed number of extra-data prefix bytes reserved for validator vanity
+
+	istanbulDigest = types.IstanbulDigest // Fixed mix digest identifying istanbul blocks
+
+	uncleHash = types.CalcUncleHash(nil) // Always Keccak256(RLP([])) as uncles are meaningless outside of PoW.
+
+	defaultDifficulty = big.NewInt(1) // Block difficulty, the fork choice is irrelevant with finality
+)
+
+// Various error messages to mark blocks invalid. These should be private to
+// prevent engine specific errors from being referenced in the remainder of the
+// codebase, inherently breaking if the engine is swapped out. Please put common
+// error types into the consensus package.
+var (
+	// errUnknownBlock is returned when the list of validators is requested for a
+	// block that is not part of the local blockchain.
+	errUnknownBlock = errors.New("unknown block")
+
+	// errMissingVanity is returned if a block's extra-data section is shorter than
+	// 32 bytes, which is required to store the validator vanity.
+	errMissingVanity = errors.New("extra-data 32 byte vanity prefix missing")
+
+	// errInvalidExtraData is returned if a block's extra-data section after the
+	// vanity cannot be decoded as istanbul consensus data.
+	errInvalidExtraData = errors.New("invalid istanbul extra-data")
+
+	// errEmptyValidators is returned if a block doesn't contain any validators.
+	errEmptyValidators = errors.New("empty validator set")
+
+	// errInvalidValidators is returned if a block changes the validator set.
+	errInvalidValidators = errors.New("validator set mismatch")
+
+	// errInvalidNonce is returned if a block's nonce is non-zero.
+	errInvalidNonce = errors.New("non-zero nonce")
+
+	// errInvalidMixDigest is returned if a block's mix digest is not the istanbul
+	// digest.
+	errInvalidMixDigest = errors.New("invalid istanbul mix digest")
+
+	// errInvalidUncleHash is returned if a block contains an non-empty uncle list.
+	errInvalidUncleHash = errors.New("non empty uncle hash")
+
+	// errInvalidDifficulty is returned if the difficulty of a block is not 1.
+	errInvalidDifficulty = errors.New("invalid difficulty")
+
+	// errInvalidTimestamp is returned if the timestamp of a block is lower than
+	// the previous block's timestamp + the minimum block period.
+	errInvalidTimestamp = errors.New("invalid timestamp")
+
+	// errUnauthorized is returned if a header is signed by a non-validator.
+	errUnauthorized = errors.New("unauthorized")
+
+	// errInvalidCommittedSeals is returned if a committed seal is not signed by a
+	// validator, or a validator committed more than once.
+	errInvalidCommittedSeals = errors.New("invalid committed seals")
+
+	// errInsufficientCommittedSeals is returned if a block is not committed by a
+	// quorum of the validators.
+	errInsufficientCommittedSeals = errors.New("insufficient committed seals")
+
+	// errStopped is returned if a block is attempted to be sealed while the
+	// consensus engine is not running.
+	errStopped = errors.New("istanbul engine not started")
+)
+
+// Chain is the local chain the consensus rounds are run on top of. Next to the
+// chain data, the validators need to execute the proposed blocks, voting only
+// for the ones with a valid state transition.
+type Chain interface {
+	consensus.ChainReader
+
+	// ExecuteBlock processes a block on top of its parent state and validates
+	// the resulting state, without importing the block.
+	ExecuteBlock(block *types.Block) error
+}
+
+// Istanbul is the byzantine fault tolerant proof-of-authority consensus engine.
+type Istanbul struct {
+	config  *params.IstanbulConfig // Consensus engine configuration parameters
+	key     *ecdsa.PrivateKey      // Validator key to sign consensus messages and blocks with
+	address common.Address         // Ethereum address of the validator key
+
+	signatures *lru.ARCCache // Signatures of recent blocks to speed up mining
+	messages   *lru.ARCCache // Hashes of recent consensus messages to avoid duplicate processing
+	peerKnown  *lru.ARCCache // Hashes of recent consensus messages known by each peer
+
+	chain       Chain                 // Local chain the consensus rounds are run on top
+	broadcaster consensus.Broadcaster // Network interface to reach the other validators
+	events      chan interface{}      // Events for the consensus loop, nil if not running
+	quit        chan struct{}         // Quit channel of the consensus loop
+	lock        sync.RWMutex          // Protects the fields above
+
+	sealHash common.Hash       // Hash of the block being sealed locally
+	sealCh   chan *types.Block // Channel to deliver the locally sealed block when committed
+	sealLock sync.Mutex        // Protects the sealing fields
+}
+
+// New creates an Istanbul consensus engine, signing with the given validator key.
+func New(config *params.IstanbulConfig, key *ecdsa.PrivateKey) *Istanbul {
+	// Set any missing consensus parameters to their defaults
+	conf := *config
+	if conf.RequestTimeout == 0 {
+		conf.RequestTimeout = requestTimeout
+	}
+	signatures, _ := lru.NewARC(inmemorySignatures)
+	messages, _ := lru.NewARC(inmemoryMessages)
+	peerKnown, _ := lru.NewARC(inmemoryPeers)
+
+	return &Istanbul{
+		config:     &conf,
+		key:        key,
+		address:    crypto.PubkeyToAddress(key.PublicKey),
+		signatures: signatures,
+		messages:   messages,
+		peerKnown:  peerKnown,
+	}
+}
+
+// Address returns the address of the local validator key.
+func (c *Istanbul) Address() common.Address {
+	return c.address
+}
+
+// Author implements consensus.Engine, returning the Ethereum address recovered
+// from the proposer seal in the header's extra-data section.
+func (c *Istanbul) Author(header *types.Header) (common.Address, error) {
+	return ecrecover(header, c.signatures)
+}
+
+// ecrecover extracts the Ethereum account address of the proposer of a block.
+func ecrecover(header *types.Header, sigcache *lru.ARCCache) (common.Address, error) {
+	// If the signature's already cached, return that
+	hash := header.Hash()
+	if address, known := sigcache.Get(hash); known {
+		return address.(common.Address), nil
+	}
+	extra, err := extractExtra(header)
+	if err != nil {
+		return common.Address{}, err
+	}
+	pubkey, err := crypto.SigToPub(sigHash(header).Bytes(), extra.Seal)
+	if err != nil {
+		return common.Address{}, err
+	}
+	signer := crypto.PubkeyToAddress(*pubkey)
+
+	sigcache.Add(hash, signer)
+	return signer, nil
+}
+
+// VerifyHeader checks whether a header conforms to the consensus rules.
+func (c *Istanbul) VerifyHeader(chain consensus.ChainReader, header *types.Header, seal bool) error {
+	return c.verifyHeader(chain, header, nil, true)
+}
+
+// VerifyHeaders is similar to VerifyHeader, but verifies a batch of headers. The
+// method returns a quit channel to abort the operations and a results channel to
+// retrieve the async verifications (the order is that of the input slice).
+func (c *Istanbul) VerifyHeaders(chain consensus.ChainReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
+	abort := make(chan struct{})
+	results := make(chan error, len(headers))
+
+	go func() {
+		for i, header := range headers {
+			err := c.verifyHeader(chain, header, headers[:i], true)
+
+			select {
+			case <-abort:
+				return
+			case results <- err:
+			}
+		}
+	}()
+	return abort, results
+}
+
+// verifyHeader checks whether a header conforms to the consensus rules. The
+// caller may optionally pass in a batch of parents (ascending order) to avoid
+// looking those up from the database. Proposals being agreed upon are checked
+// without their committed seals.
+func (c *Istanbul) verifyHeader(chain consensus.ChainReader, header *types.Header, parents []*types.Header, committed bool) error {
+	if header.Number == nil {
+		return errUnknownBlock
+	}
+	number := header.Number.Uint64()
+
+	// Don't waste time checking blocks from the future
+	if header.Time.Cmp(big.NewInt(time.Now().Unix())) > 0 {
+		return consensus.ErrFutureBlock
+	}
+	extra, err := extractExtra(header)
+	if err != nil {
+		return err
+	}
+	if len(extra.Validators) == 0 {
+		return errEmptyValidators
+	}
+	// Ensure the fields without meaning in istanbul are empty
+	if header.Nonce != (types.BlockNonce{}) {
+		return errInvalidNonce
+	}
+	if header.MixDigest != istanbulDigest {
+		return errInvalidMixDigest
+	}
+	if header.UncleHash != uncleHash {
+		return errInvalidUncleHash
+	}
+	if header.Difficulty == nil || header.Difficulty.Cmp(defaultDifficulty) != 0 {
+		return errInvalidDifficulty
+	}
+	// The genesis block is the always valid dead-end
+	if number == 0 {
+		return nil
+	}
+	// Ensure that the block's timestamp isn't too close to it's parent
+	var parent *types.Header
+	if len(parents) > 0 {
+		parent = parents[len(parents)-1]
+	} else {
+		parent = chain.GetHeader(header.ParentHash, number-1)
+	}
+	if parent == nil || parent.Number.Uint64() != number-1 || parent.Hash() != header.ParentHash {
+		return consensus.ErrUnknownAncestor
+	}
+	if parent.Time.Uint64()+c.config.BlockPeriod > header.Time.Uint64() {
+		return errInvalidTimestamp
+	}
+	// Ensure the validator set is carried over and verify the seals against it
+	validators, err := parentValidators(parent)
+	if err != nil {
+		return err
+	}
+	if !validators.equal(extra.Validators) {
+		return errInvalidValidators
+	}
+	return c.verifySeals(header, validators, committed)
+}
+
+// verifySeals checks that a header was proposed by one of the validators and, if
+// requested, committed by a quorum of them.
+func (c *Istanbul) verifySeals(header *types.Header, validators validatorSet, committed bool) error {
+	signer, err := ecrecover(header, c.signatures)
+	if err != nil {
+		return err
+	}
+	if !validators.contains(signer) {
+		return errUnauthorized
+	}
+	if !committed {
+		return nil
+	}
+	extra, err := extractExtra(header)
+	if err != nil {
+		return err
+	}
+	hash := commitHash(header.Hash())
+
+	seen := make(map[common.Address]bool)
+	for _, seal := range extra.CommittedSeal {
+		pubkey, err := crypto.SigToPub(hash, seal)
+		if err != nil {
+			return errInvalidCommittedSeals
+		}
+		validator := crypto.PubkeyToAddress(*pubkey)
+		if !validators.contains(validator) || seen[validator] {
+			return errInvalidCommittedSeals
+		}
+		seen[validator] = true
+	}
+	if len(seen) < validators.quorum() {
+		return errInsufficientCommittedSeals
+	}
+	return nil
+}
+
+// parentValidators retrieves the validator set of the blocks built on top of the
+// given header.
+func parentValidators(parent *types.Header) (validatorSet, error) {
+	extra, err := extractExtra(parent)
+	if err != nil {
+		return nil, err
+	}
+	if len(extra.Validators) == 0 {
+		return nil, errEmptyValidators
+	}
+	return newValidatorSet(extra.Validators), nil
+}
+
+// VerifyUncles implements consensus.Engine, always returning an error for any
+// uncles as this consensus mechanism doesn't permit uncles.
+func (c *Istanbul) VerifyUncles(chain consensus.ChainReader, block *types.Block) error {
+	if len(block.Uncles()) > 0 {
+		return errors.New("uncles not allowed")
+	}
+	return nil
+}
+
+// VerifySeal implements consensus.Engine, checking whether the proposer seal and
+// the committed seals contained in the header satisfy the consensus rules.
+func (c *Istanbul) VerifySeal(chain consensus.ChainReader, header *types.Header) error {
+	// Verifying the genesis block is not supported
+	number := header.Number.Uint64()
+	if number == 0 {
+		return errUnknownBlock
+	}
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	validators, err := parentValidators(parent)
+	if err != nil {
+		return err
+	}
+	return c.verifySeals(header, validators, true)
+}
+
+// Prepare implements consensus.Engine, preparing all the consensus fields of the
+// header for running the transactions on top.
+func (c *Istanbul) Prepare(chain consensus.ChainReader, header *types.Header) error {
+	number := header.Number.Uint64()
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	validators, err := parentValidators(parent)
+	if err != nil {
+		return err
+	}
+	// Istanbul has no votes nor proof-of-work, set the unused fields to constants
+	header.Coinbase = common.Address{}
+	header.Nonce = types.BlockNonce{}
+	header.MixDigest = istanbulDigest
+	header.Difficulty = new(big.Int).Set(defaultDifficulty)
+
+	// Carry over the validators, leaving the seals empty until sealing
+	if len(header.Extra) < extraVanity {
+		header.Extra = append(header.Extra, bytes.Repeat([]byte{0x00}, extraVanity-len(header.Extra))...)
+	}
+	if header.Extra, err = EncodeExtra(header.Extra[:extraVanity], validators); err != nil {
+		return err
+	}
+	// Ensure the timestamp has the correct delay
+	header.Time = new(big.Int).Add(parent.Time, new(big.Int).SetUint64(c.config.BlockPeriod))
+	if header.Time.Int64() < time.Now().Unix() {
+		header.Time = big.NewInt(time.Now().Unix())
+	}
+	return nil
+}
+
+// Finalize implements consensus.Engine, ensuring no uncles are set, nor block
+// rewards given, and returns the final block.
+func (c *Istanbul) Finalize(chain consensus.ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
+	// No block rewards in istanbul, so the state remains as is and uncles are dropped
+	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
+	header.UncleHash = types.CalcUncleHash(nil)
+
+	// Assemble and return the final block for sealing
+	return types.NewBlock(header, txs, nil, receipts), nil
+}
+
+// Seal implements consensus.Engine, signing the block as its proposer and running
+// the consensus rounds until the block, or one proposed by another validator,
+// is committed. The block is returned only if it was committed itself.
+func (c *Istanbul) Seal(chain consensus.ChainReader, block *types.Block, stop <-chan struct{}) (*types.Block, error) {
+	header := block.Header()
+
+	// Sealing the genesis block is not supported
+	number := header.Number.Uint64()
+	if number == 0 {
+		return nil, errUnknownBlock
+	}
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return nil, consensus.ErrUnknownAncestor
+	}
+	validators, err := parentValidators(parent)
+	if err != nil {
+		return nil, err
+	}
+	if !validators.contains(c.address) {
+		return nil, errUnauthorized
+	}
+	// Wait until the block may be proposed
+	delay := time.Unix(header.Time.Int64(), 0).Sub(time.Now()) // nolint: gosimple
+	select {
+	case <-stop:
+		return nil, nil
+	case <-time.After(delay):
+	}
+	// Sign the block and hand it over to the consensus rounds
+	seal, err := crypto.Sign(sigHash(header).Bytes(), c.key)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeSeal(header, seal); err != nil {
+		return nil, err
+	}
+	block = block.WithSeal(header)
+
+	hash, result := header.Hash(), make(chan *types.Block, 1)
+	c.sealLock.Lock()
+	c.sealHash, c.sealCh = hash, result
+	c.sealLock.Unlock()
+
+	defer func() {
+		c.sealLock.Lock()
+		if c.sealHash == hash {
+			c.sealHash, c.sealCh = common.Hash{}, nil
+		}
+		c.sealLock.Unlock()
+	}()
+	if !c.post(requestEvent{block: block}, stop) {
+		return nil, errStopped
+	}
+	select {
+	case block := <-result:
+		return block, nil
+	case <-stop:
+		return nil, nil
+	}
+}
+
+// CalcDifficulty is the difficulty adjustment algorithm, always returning 1 as
+// the fork choice is meaningless with immediate finality.
+func (c *Istanbul) CalcDifficulty(chain consensus.ChainReader, time uint64, parent *types.Header) *big.Int {
+	return new(big.Int).Set(defaultDifficulty)
+}
+
+// APIs implements consensus.Engine, returning the user facing RPC API to allow
+// inspecting the validators.
+func (c *Istanbul) APIs(chain consensus.ChainReader) []rpc.API {
+	return []rpc.API{{
+		Namespace: "istanbul",
+		Version:   "1.0",
+		Service:   &API{chain: chain, istanbul: c},
+		Public:    true,
+	}}
+}
+
+// Start starts running the consensus rounds on top of the given chain, allowing
+// the local validator to propose and commit blocks.
+func (c *Istanbul) Start(chain Chain) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.events != nil {
+		return nil
+	}
+	c.chain = chain
+	c.events = make(chan interface{}, 256)
+	c.quit = make(chan struct{})
+	c.events <- chainHeadEvent{}
+
+	go c.loop(chain, c.events, c.quit)
+	return nil
+}
+
+// Stop terminates the consensus rounds.
+func (c *Istanbul) Stop() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.events == nil {
+		return nil
+	}
+	close(c.quit)
+	c.events, c.quit = nil, nil
+	return nil
+}
+
+// NewChainHead implements consensus.Handler, starting the consensus rounds of
+// the next block.
+func (c *Istanbul) NewChainHead() error {
+	c.post(chainHeadEvent{}, nil)
+	return nil
+}
+
+// SetBroadcaster implements consensus.Handler, setting the network interface to
+// reach the other validators with.
+func (c *Istanbul) SetBroadcaster(broadcaster consensus.Broadcaster) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.broadcaster = broadcaster
+}
+
+// HandleMsg implements consensus.Handler, relaying a consensus message received
+// from a remote validator to the other validators and into the consensus rounds.
+func (c *Istanbul) HandleMsg(address common.Address, msg p2p.Msg) error {
+	var payload []byte
+	if err := msg.Decode(&payload); err != nil {
+		return err
+	}
+	hash := crypto.Keccak256Hash(payload)
+	c.markKnown(address, hash)
+
+	// Skip messages already handled, and messages while not validating
+	if _, known := c.messages.Get(hash); known {
+		return nil
+	}
+	c.messages.Add(hash, true)
+
+	c.lock.RLock()
+	chain := c.chain
+	c.lock.RUnlock()
+	if chain == nil {
+		return nil
+	}
+	message, err := decodeMessage(payload)
+	if err != nil {
+		return err
+	}
+	// Gossip messages of the current validators to the ones not yet knowing them
+	validators, err := parentValidators(chain.CurrentHeader())
+	if err != nil || !validators.contains(message.sender) {
+		log.Debug("Dropping consensus message of non-validator", "msg", message)
+		return nil
+	}
+	c.gossip(validators, payload, hash)
+	c.post(message, nil)
+	return nil
+}
+
+// markKnown marks a consensus message as known by a peer.
+func (c *Istanbul) markKnown(address common.Address, hash common.Hash) {
+	known, ok := c.peerKnown.Get(address)
+	if !ok {
+		known, _ = lru.NewARC(inmemoryMessages)
+		c.peerKnown.Add(address, known)
+	}
+	known.(*lru.ARCCache).Add(hash, true)
+}
+
+// gossip sends a consensus message to the connected validators not known to
+// have it already.
+func (c *Istanbul) gossip(validators validatorSet, payload []byte, hash common.Hash) {
+	c.lock.RLock()
+	broadcaster := c.broadcaster
+	c.lock.RUnlock()
+	if broadcaster == nil {
+		return
+	}
+	targets := make(map[common.Address]bool)
+	for _, validator := range validators {
+		if validator == c.address {
+			continue
+		}
+		if known, ok := c.peerKnown.Get(validator); ok && known.(*lru.ARCCache).Contains(hash) {
+			continue
+		}
+		targets[validator] = true
+	}
+	for address, peer := range broadcaster.FindPeers(targets) {
+		c.markKnown(address, hash)
+		go peer.SendConsensus(payload)
+	}
+}
+
+// post delivers an event to the consensus loop, returning false if the loop is
+// not running.
+func (c *Istanbul) post(event interface{}, stop <-chan struct{}) bool {
+	c.lock.RLock()
+	events, quit := c.events, c.quit
+	c.lock.RUnlock()
+
+	if events == nil {
+		return false
+	}
+	select {
+	case events <- event:
+		return true
+	case <-quit:
+		return false
+	case <-stop:
+		return false
+	}
+}
@@ -0,0 +1,503 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package istanbul
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// testNode is an in-process validator with its own chain and consensus engine,
+// connected to the other nodes of its test network.
+type testNode struct {
+	key     *ecdsa.PrivateKey
+	address common.Address
+	engine  *Istanbul
+	chain   *core.BlockChain
+	network *testNetwork
+	faulty  bool // Whether the validator proposes blocks with an invalid state root
+
+	quit chan struct{}
+	done chan struct{}
+
+	inserts sync.WaitGroup // Block imports in progress
+	stopped bool           // Whether the node is shutting down, rejecting imports
+	lock    sync.Mutex     // Protects the import tracking
+}
+
+// testNetwork is a set of in-process validators exchanging consensus messages
+// and committed blocks directly with each other.
+type testNetwork struct {
+	nodes  []*testNode
+	online map[common.Address]bool
+	lock   sync.RWMutex
+}
+
+// newTestNetwork creates a network of validators sharing the same genesis block
+// with the given consensus configuration.
+func newTestNetwork(t *testing.T, validators int, config *params.IstanbulConfig) *testNetwork {
+	keys := make([]*ecdsa.PrivateKey, validators)
+	addresses := make([]common.Address, validators)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		addresses[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	genesis := newTestGenesis(addresses, config)
+
+	network := &testNetwork{online: make(map[common.Address]bool)}
+	for _, key := range keys {
+		db, _ := ethdb.NewMemDatabase()
+		genesis.MustCommit(db)
+
+		engine := New(config, key)
+		chain, err := core.NewBlockChain(db, nil, genesis.Config, engine, vm.Config{})
+		if err != nil {
+			t.Fatalf("failed to create blockchain: %v", err)
+		}
+		node := &testNode{
+			key:     key,
+			address: engine.Address(),
+			engine:  engine,
+			chain:   chain,
+			network: network,
+		}
+		engine.SetBroadcaster(node)
+		network.nodes = append(network.nodes, node)
+	}
+	return network
+}
+
+// newTestGenesis creates a genesis specification with the given validators.
+func newTestGenesis(validators []common.Address, config *params.IstanbulConfig) *core.Genesis {
+	chainConfig := *params.TestChainConfig
+	chainConfig.Ethash, chainConfig.Istanbul = nil, config
+
+	extra, _ := EncodeExtra(nil, validators)
+	return &core.Genesis{
+		Config:     &chainConfig,
+		ExtraData:  extra,
+		GasLimit:   params.GenesisGasLimit,
+		Difficulty: defaultDifficulty,
+		Mixhash:    types.IstanbulDigest,
+	}
+}
+
+// node retrieves the node of the given validator.
+func (n *testNetwork) node(address common.Address) *testNode {
+	for _, node := range n.nodes {
+		if node.address == address {
+			return node
+		}
+	}
+	return nil
+}
+
+// start brings the given validators online, running their consensus rounds.
+func (n *testNetwork) start(nodes ...*testNode) {
+	n.lock.Lock()
+	for _, node := range nodes {
+		n.online[node.address] = true
+	}
+	n.lock.Unlock()
+
+	for _, node := range nodes {
+		node.quit, node.done = make(chan struct{}), make(chan struct{})
+		go node.mine()
+	}
+}
+
+// stop takes all validators offline and releases their resources.
+func (n *testNetwork) stop() {
+	for _, node := range n.nodes {
+		if node.quit != nil {
+			close(node.quit)
+			<-node.done
+		}
+		node.engine.Stop()
+
+		node.lock.Lock()
+		node.stopped = true
+		node.lock.Unlock()
+
+		node.inserts.Wait()
+		node.chain.Stop()
+	}
+}
+
+// waitHeight waits until all the given validators imported the block with the
+// given number.
+func (n *testNetwork) waitHeight(nodes []*testNode, number uint64, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for _, node := range nodes {
+		for node.chain.CurrentBlock().NumberU64() < number {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("validator %x stuck at block %d, want %d", node.address[:4], node.chain.CurrentBlock().NumberU64(), number)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	return nil
+}
+
+// mine runs the consensus engine of the validator similarly to the miner: a new
+// block is requested to be sealed on top of every new chain head.
+func (node *testNode) mine() {
+	defer close(node.done)
+
+	heads := make(chan core.ChainHeadEvent, 16)
+	sub := node.chain.SubscribeChainHeadEvent(heads)
+	defer sub.Unsubscribe()
+
+	node.engine.Start(node.chain)
+
+	abort := node.seal()
+	for {
+		select {
+		case <-heads:
+			close(abort)
+			node.engine.NewChainHead()
+			abort = node.seal()
+
+		case <-node.quit:
+			close(abort)
+			return
+		}
+	}
+}
+
+// seal assembles a new empty block on top of the chain head and requests it to
+// be sealed, importing it if committed. The returned channel aborts sealing.
+func (node *testNode) seal() chan struct{} {
+	abort := make(chan struct{})
+
+	parent := node.chain.CurrentBlock()
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number(), common.Big1),
+		GasLimit:   core.CalcGasLimit(parent),
+	}
+	if err := node.engine.Prepare(node.chain, header); err != nil {
+		return abort
+	}
+	statedb, err := node.chain.StateAt(parent.Root())
+	if err != nil {
+		return abort
+	}
+	block, err := node.engine.Finalize(node.chain, header, statedb, nil, nil, nil)
+	if err != nil {
+		return abort
+	}
+	if node.faulty {
+		header = block.Header()
+		header.Root = common.Hash{0x01}
+		block = block.WithSeal(header)
+	}
+	go func() {
+		if result, err := node.engine.Seal(node.chain, block, abort); result != nil && err == nil {
+			node.insert(result)
+		}
+	}()
+	return abort
+}
+
+// Enqueue implements consensus.Broadcaster, importing a block committed by the
+// consensus rounds.
+func (node *testNode) Enqueue(id string, block *types.Block) {
+	go node.insert(block)
+}
+
+// insert imports a committed block into the local chain, unless the node is
+// shutting down.
+func (node *testNode) insert(block *types.Block) {
+	node.lock.Lock()
+	if node.stopped {
+		node.lock.Unlock()
+		return
+	}
+	node.inserts.Add(1)
+	node.lock.Unlock()
+
+	defer node.inserts.Done()
+	node.chain.InsertChain(types.Blocks{block})
+}
+
+// FindPeers implements consensus.Broadcaster, retrieving the online validators
+// among the given addresses.
+func (node *testNode) FindPeers(targets map[common.Address]bool) map[common.Address]consensus.Peer {
+	node.network.lock.RLock()
+	defer node.network.lock.RUnlock()
+
+	peers := make(map[common.Address]consensus.Peer)
+	for address := range targets {
+		if node.network.online[address] {
+			peers[address] = &testPeer{from: node, to: node.network.node(address)}
+		}
+	}
+	return peers
+}
+
+// testPeer is a direct connection between two validators.
+type testPeer struct {
+	from *testNode
+	to   *testNode
+}
+
+// SendConsensus implements consensus.Peer, delivering a consensus message to the
+// remote validator.
+func (p *testPeer) SendConsensus(data interface{}) error {
+	size, r, err := rlp.EncodeToReader(data)
+	if err != nil {
+		return err
+	}
+	return p.to.engine.HandleMsg(p.from.address, p2p.Msg{Size: uint32(size), Payload: r})
+}
+
+// Tests that a network of validators agrees on a chain of blocks, each of them
+// committed by a quorum of the validators.
+func TestConsensus(t *testing.T) {
+	network := newTestNetwork(t, 4, &params.IstanbulConfig{BlockPeriod: 0, RequestTimeout: 1000})
+	defer network.stop()
+
+	network.start(network.nodes...)
+	if err := network.waitHeight(network.nodes, 5, 20*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	checkChains(t, network.nodes, 5)
+}
+
+// Tests that the validators change the round if the proposer is offline, and
+// still agree on a chain of blocks as long as a quorum of them is online.
+func TestRoundChange(t *testing.T) {
+	network := newTestNetwork(t, 4, &params.IstanbulConfig{BlockPeriod: 0, RequestTimeout: 200})
+	defer network.stop()
+
+	// Take the proposer of the first block offline and start the others
+	validators, _ := parentValidators(network.nodes[0].chain.Genesis().Header())
+	offline := validators.proposer(1, 0)
+
+	var online []*testNode
+	for _, node := range network.nodes {
+		if node.address != offline {
+			online = append(online, node)
+		}
+	}
+	network.start(online...)
+	if err := network.waitHeight(online, 5, 30*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	checkChains(t, online, 5)
+
+	// Ensure the offline validator's turns were skipped
+	for _, number := range []uint64{1, 5} {
+		header := online[0].chain.GetHeaderByNumber(number)
+		if author, _ := online[0].engine.Author(header); author == offline {
+			t.Errorf("block %d: proposed by offline validator", number)
+		}
+	}
+}
+
+// Tests that the blocks of a proposer with an invalid state transition are not
+// agreed upon, the other validators changing the round and committing blocks of
+// the honest proposers instead.
+func TestFaultyProposer(t *testing.T) {
+	network := newTestNetwork(t, 4, &params.IstanbulConfig{BlockPeriod: 0, RequestTimeout: 200})
+	defer network.stop()
+
+	// Make the proposer of the first block faulty and start all validators
+	validators, _ := parentValidators(network.nodes[0].chain.Genesis().Header())
+	faulty := network.node(validators.proposer(1, 0))
+	faulty.faulty = true
+
+	network.start(network.nodes...)
+	if err := network.waitHeight(network.nodes, 5, 30*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	checkChains(t, network.nodes, 5)
+
+	// Ensure none of the faulty validator's proposals were committed
+	for number := uint64(1); number <= 5; number++ {
+		header := network.nodes[0].chain.GetHeaderByNumber(number)
+		if author, _ := network.nodes[0].engine.Author(header); author == faulty.address {
+			t.Errorf("block %d: proposed by faulty validator", number)
+		}
+	}
+}
+
+// checkChains ensures the validators agree on the first blocks of their chains,
+// and that all of them carry a valid proposer seal and enough committed seals.
+func checkChains(t *testing.T, nodes []*testNode, number uint64) {
+	for i := uint64(1); i <= number; i++ {
+		want := nodes[0].chain.GetHeaderByNumber(i)
+		for _, node := range nodes {
+			header := node.chain.GetHeaderByNumber(i)
+			if header.Hash() != want.Hash() {
+				t.Fatalf("validator %x: block %d hash mismatch: have %x, want %x", node.address[:4], i, header.Hash(), want.Hash())
+			}
+			if err := node.engine.VerifySeal(node.chain, header); err != nil {
+				t.Errorf("validator %x: block %d: seal verification failed: %v", node.address[:4], i, err)
+			}
+		}
+	}
+}
+
+// Tests that headers are only accepted if proposed by a validator, committed by
+// a quorum of them and keeping the validator set intact.
+func TestVerifyHeader(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 4)
+	addresses := make([]common.Address, len(keys))
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		addresses[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	outsider, _ := crypto.GenerateKey()
+
+	config := &params.IstanbulConfig{BlockPeriod: 1}
+	genesis := newTestGenesis(addresses, config)
+
+	db, _ := ethdb.NewMemDatabase()
+	genesisBlock := genesis.MustCommit(db)
+
+	engine := New(config, keys[0])
+	chain, _ := core.NewBlockChain(db, nil, genesis.Config, engine, vm.Config{})
+	defer chain.Stop()
+
+	// Assemble a block proposal on top of the genesis
+	header := &types.Header{
+		ParentHash: genesisBlock.Hash(),
+		Number:     common.Big1,
+		GasLimit:   genesisBlock.GasLimit(),
+		UncleHash:  uncleHash,
+	}
+	if err := engine.Prepare(chain, header); err != nil {
+		t.Fatalf("failed to prepare header: %v", err)
+	}
+	header.Time = new(big.Int).Add(genesisBlock.Time(), common.Big1)
+
+	// sealed signs the header by the given proposer and committers
+	sealed := func(header *types.Header, proposer *ecdsa.PrivateKey, committers ...*ecdsa.PrivateKey) *types.Header {
+		header = types.CopyHeader(header)
+
+		seal, _ := crypto.Sign(sigHash(header).Bytes(), proposer)
+		writeSeal(header, seal)
+
+		var seals [][]byte
+		for _, key := range committers {
+			seal, _ := crypto.Sign(commitHash(header.Hash()), key)
+			seals = append(seals, seal)
+		}
+		writeCommittedSeals(header, seals)
+		return header
+	}
+	tests := []struct {
+		header *types.Header
+		err    error
+	}{
+		// Proposed and committed by the validators
+		{sealed(header, keys[1], keys[0], keys[1], keys[2]), nil},
+		{sealed(header, keys[1], keys...), nil},
+
+		// Not committed by a quorum of the validators
+		{sealed(header, keys[1], keys[0], keys[1]), errInsufficientCommittedSeals},
+		{sealed(header, keys[1]), errInsufficientCommittedSeals},
+
+		// Committed by a non-validator or the same validator twice
+		{sealed(header, keys[1], keys[0], keys[1], outsider), errInvalidCommittedSeals},
+		{sealed(header, keys[1], keys[0], keys[1], keys[1]), errInvalidCommittedSeals},
+
+		// Proposed by a non-validator
+		{sealed(header, outsider, keys...), errUnauthorized},
+	}
+	for i, tt := range tests {
+		if err := engine.VerifyHeader(chain, tt.header, true); err != tt.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+	// Ensure the validator set cannot be changed
+	changed := types.CopyHeader(header)
+	changed.Extra, _ = EncodeExtra(nil, addresses[1:])
+	if err := engine.VerifyHeader(chain, sealed(changed, keys[1], keys...), true); err != errInvalidValidators {
+		t.Errorf("validator change error mismatch: have %v, want %v", err, errInvalidValidators)
+	}
+	// Ensure the fields unused by istanbul are rejected if set
+	nonce := types.CopyHeader(header)
+	nonce.Nonce = types.EncodeNonce(1)
+	if err := engine.VerifyHeader(chain, sealed(nonce, keys[1], keys...), true); err != errInvalidNonce {
+		t.Errorf("nonce error mismatch: have %v, want %v", err, errInvalidNonce)
+	}
+	early := types.CopyHeader(header)
+	early.Time = genesisBlock.Time()
+	if err := engine.VerifyHeader(chain, sealed(early, keys[1], keys...), true); err != errInvalidTimestamp {
+		t.Errorf("timestamp error mismatch: have %v, want %v", err, errInvalidTimestamp)
+	}
+}
+
+// Tests that the block hash is independent of the committed seals, allowing all
+// validators to agree on it regardless of which seals they collected.
+func TestCommittedSealsHash(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 3)
+	addresses := make([]common.Address, len(keys))
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		addresses[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	extra, _ := EncodeExtra(nil, addresses)
+	header := &types.Header{
+		Number:     common.Big1,
+		Difficulty: defaultDifficulty,
+		Time:       big.NewInt(1),
+		Extra:      extra,
+		MixDigest:  istanbulDigest,
+	}
+	seal, _ := crypto.Sign(sigHash(header).Bytes(), keys[0])
+	writeSeal(header, seal)
+	hash := header.Hash()
+
+	for i := range keys {
+		var seals [][]byte
+		for _, key := range keys[i:] {
+			seal, _ := crypto.Sign(commitHash(hash), key)
+			seals = append(seals, seal)
+		}
+		cpy := types.CopyHeader(header)
+		writeCommittedSeals(cpy, seals)
+		if cpy.Hash() != hash {
+			t.Errorf("seals %d: hash mismatch: have %x, want %x", len(seals), cpy.Hash(), hash)
+		}
+	}
+	// Ensure the proposer seal is part of the hash
+	cpy := types.CopyHeader(header)
+	seal, _ = crypto.Sign(sigHash(header).Bytes(), keys[1])
+	writeSeal(cpy, seal)
+	if cpy.Hash() == hash {
+		t.Errorf("proposer seal not part of the hash")
+	}
+}
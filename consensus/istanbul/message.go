@@ -0,0 +1,101 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package istanbul
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Consensus message codes of the three phases and round changes.
+const (
+	msgPreprepare uint64 = iota
+	msgPrepare
+	msgCommit
+	msgRoundChange
+)
+
+// message is a signed consensus message exchanged between the validators.
+type message struct {
+	Code          uint64      // Type of the consensus message
+	Sequence      uint64      // Block number the message is about
+	Round         uint64      // Consensus round the message belongs to
+	Digest        common.Hash // Hash of the proposal being voted on (empty for round changes)
+	Proposal      []byte      // RLP encoded block being proposed (pre-prepare only)
+	CommittedSeal []byte      // Validator signature over the proposal (commit only)
+	Signature     []byte      // Signature of the sender over the message
+
+	sender common.Address // Address of the validator that signed the message
+}
+
+// signHash returns the hash of the message signed by its sender.
+func (msg *message) signHash() common.Hash {
+	cpy := *msg
+	cpy.Signature = nil
+
+	blob, _ := rlp.EncodeToBytes(&cpy)
+	return crypto.Keccak256Hash(blob)
+}
+
+// sign signs the message with the given private key.
+func (msg *message) sign(key *ecdsa.PrivateKey) error {
+	sig, err := crypto.Sign(msg.signHash().Bytes(), key)
+	if err != nil {
+		return err
+	}
+	msg.Signature = sig
+	msg.sender = crypto.PubkeyToAddress(key.PublicKey)
+	return nil
+}
+
+// decodeMessage parses a consensus message and recovers its sender.
+func decodeMessage(payload []byte) (*message, error) {
+	msg := new(message)
+	if err := rlp.DecodeBytes(payload, msg); err != nil {
+		return nil, err
+	}
+	pubkey, err := crypto.SigToPub(msg.signHash().Bytes(), msg.Signature)
+	if err != nil {
+		return nil, err
+	}
+	msg.sender = crypto.PubkeyToAddress(*pubkey)
+
+	if msg.Code > msgRoundChange {
+		return nil, fmt.Errorf("unknown message code %d", msg.Code)
+	}
+	return msg, nil
+}
+
+// proposal decodes the block proposed by a pre-prepare message.
+func (msg *message) proposal() (*types.Block, error) {
+	block := new(types.Block)
+	if err := rlp.DecodeBytes(msg.Proposal, block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// String implements fmt.Stringer.
+func (msg *message) String() string {
+	names := []string{"PRE-PREPARE", "PREPARE", "COMMIT", "ROUND-CHANGE"}
+	return fmt.Sprintf("%s{seq: %d, round: %d, sender: %x}", names[msg.Code], msg.Sequence, msg.Round, msg.sender[:4])
+}
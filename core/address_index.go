@@ -0,0 +1,169 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// AddressIndexEntry is the location of a log or transaction in the canonical
+// chain, as stored by the address index.
+type AddressIndexEntry struct {
+	Number   uint64      // Number of the block containing the item
+	Hash     common.Hash // Hash of the block containing the item
+	TxIndex  uint        // Index of the transaction within the block
+	LogIndex uint        // Index of the log within the block, zero for transactions
+}
+
+// WriteAddressLogIndex stores the location of a log emitted by an address, with
+// the given first topic (zero if it has no topics at all).
+func WriteAddressLogIndex(db ethdb.Putter, address common.Address, topic common.Hash, number uint64, hash common.Hash, txIndex, logIndex uint) error {
+	key := make([]byte, 0, len(addressLogPrefix)+common.AddressLength+common.HashLength+16)
+	key = append(append(append(append(key, addressLogPrefix...), address[:]...), topic[:]...), encodeBlockNumber(number)...)
+	key = append(append(key, encodeIndex(txIndex)...), encodeIndex(logIndex)...)
+
+	return db.Put(key, hash[:])
+}
+
+// WriteAddressTxIndex stores the location of a transaction sent from or to an
+// address.
+func WriteAddressTxIndex(db ethdb.Putter, address common.Address, number uint64, hash common.Hash, txIndex uint) error {
+	key := make([]byte, 0, len(addressTxPrefix)+common.AddressLength+12)
+	key = append(append(append(append(key, addressTxPrefix...), address[:]...), encodeBlockNumber(number)...), encodeIndex(txIndex)...)
+
+	return db.Put(key, hash[:])
+}
+
+// FindAddressLogs retrieves the locations of the canonical logs emitted by an
+// address within the given block range, in chain order. If topic is nil, logs
+// with any first topic are returned.
+func FindAddressLogs(db ethdb.Database, address common.Address, topic *common.Hash, from, to uint64) ([]AddressIndexEntry, error) {
+	prefix := append(append([]byte{}, addressLogPrefix...), address[:]...)
+	if topic != nil {
+		return findAddressEntries(db, append(prefix, topic[:]...), from, to, true)
+	}
+	// Scan the block range of every first topic the address ever used, seeking to
+	// the next topic right after the range instead of iterating through it
+	var (
+		entries []AddressIndexEntry
+		next    common.Hash
+	)
+	for {
+		start := append(append(append([]byte{}, prefix...), next[:]...), encodeBlockNumber(from)...)
+
+		it := db.NewIteratorWithStart(start)
+		found := it.Next() && bytes.HasPrefix(it.Key(), prefix) && len(it.Key()) >= len(prefix)+common.HashLength
+		if found {
+			next = common.BytesToHash(it.Key()[len(prefix) : len(prefix)+common.HashLength])
+		}
+		it.Release()
+		if err := it.Error(); err != nil {
+			return nil, err
+		}
+		if !found {
+			break
+		}
+		matches, err := findAddressEntries(db, append(append([]byte{}, prefix...), next[:]...), from, to, true)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, matches...)
+
+		// Step over the topic just scanned, stopping after the last possible one
+		if next = incrementHash(next); next == (common.Hash{}) {
+			break
+		}
+	}
+	// Merge the entries of the distinct topics back into chain order
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Number != entries[j].Number {
+			return entries[i].Number < entries[j].Number
+		}
+		return entries[i].LogIndex < entries[j].LogIndex
+	})
+	return entries, nil
+}
+
+// FindAddressTransactions retrieves the locations of the canonical transactions
+// sent from or to an address within the given block range, in chain order.
+func FindAddressTransactions(db ethdb.Database, address common.Address, from, to uint64) ([]AddressIndexEntry, error) {
+	prefix := append(append([]byte{}, addressTxPrefix...), address[:]...)
+	return findAddressEntries(db, prefix, from, to, false)
+}
+
+// findAddressEntries iterates the address index entries with the given prefix
+// within a block range, skipping the ones of non-canonical blocks.
+func findAddressEntries(db ethdb.Database, prefix []byte, from, to uint64, logs bool) ([]AddressIndexEntry, error) {
+	size := len(prefix) + 12
+	if logs {
+		size += 4
+	}
+	it := db.NewIteratorWithStart(append(append([]byte{}, prefix...), encodeBlockNumber(from)...))
+	defer it.Release()
+
+	var entries []AddressIndexEntry
+	for it.Next() {
+		key := it.Key()
+		if !bytes.HasPrefix(key, prefix) {
+			break
+		}
+		if len(key) != size || len(it.Value()) != common.HashLength {
+			continue
+		}
+		entry := AddressIndexEntry{
+			Number:  binary.BigEndian.Uint64(key[len(prefix):]),
+			Hash:    common.BytesToHash(it.Value()),
+			TxIndex: uint(binary.BigEndian.Uint32(key[len(prefix)+8:])),
+		}
+		if entry.Number > to {
+			break
+		}
+		if logs {
+			entry.LogIndex = uint(binary.BigEndian.Uint32(key[len(prefix)+12:]))
+		}
+		// Entries of reorged blocks are left behind, skip them
+		if GetCanonicalHash(db, entry.Number) != entry.Hash {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, it.Error()
+}
+
+// encodeIndex encodes a transaction or log index as big endian uint32.
+func encodeIndex(index uint) []byte {
+	enc := make([]byte, 4)
+	binary.BigEndian.PutUint32(enc, uint32(index))
+	return enc
+}
+
+// incrementHash returns the hash following the given one, wrapping around to
+// zero after the maximum.
+func incrementHash(hash common.Hash) common.Hash {
+	for i := len(hash) - 1; i >= 0; i-- {
+		hash[i]++
+		if hash[i] != 0 {
+			break
+		}
+	}
+	return hash
+}
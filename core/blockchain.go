@@ -31,6 +31,7 @@ import (
 	"github.com/ethereum/go-ethereum/common/mclock"
 	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -69,6 +70,8 @@ type CacheConfig struct {
 	Disabled      bool          // Whether to disable trie write caching (archive node)
 	TrieNodeLimit int           // Memory limit (MB) at which to flush the current in-memory trie to disk
 	TrieTimeLimit time.Duration // Time limit after which to flush the current in-memory trie to disk
+	Snapshot      bool          // Whether to maintain a flat snapshot of the state for faster reads
+	StateHistory  bool          // Whether to store reverse state diffs to serve the pruned historic states
 }
 
 // BlockChain represents the canonical chain given a database with a genesis
@@ -111,6 +114,7 @@ type BlockChain struct {
 	currentFastBlock atomic.Value // Current head of the fast-sync chain (may be above the block chain!)
 
 	stateCache   state.Database // State database to reuse between imports (contains state cache)
+	snaps        *snapshot.Tree // Flat snapshot of the recent states, nil if disabled
 	bodyCache    *lru.Cache     // Cache for the most recent block bodies
 	bodyRLPCache *lru.Cache     // Cache for the most recent block bodies in RLP encoded format
 	blockCache   *lru.Cache     // Cache for the most recent entire blocks
@@ -176,6 +180,17 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 	if err := bc.loadLastState(); err != nil {
 		return nil, err
 	}
+	// Load any existing snapshot, regenerating it if loading failed
+	if bc.cacheConfig.Snapshot {
+		bc.snaps = snapshot.New(bc.db, bc.stateCache.TrieDB(), bc.CurrentBlock().Root())
+	}
+	// Start the state history from the current head if it's newly enabled, or
+	// drop it if disabled, since the diffs aren't stored any more
+	if _, ok := GetStateHistoryTail(bc.db); bc.cacheConfig.StateHistory && !ok {
+		bc.resetStateHistory(bc.CurrentBlock())
+	} else if !bc.cacheConfig.StateHistory && ok {
+		DeleteStateHistoryTail(bc.db)
+	}
 	// Check the current state of the block hashes and make sure that we do not have any of the bad blocks in our chain
 	for hash := range BadHashes {
 		if header := bc.GetHeaderByHash(hash); header != nil {
@@ -274,6 +289,13 @@ func (bc *BlockChain) SetHead(head uint64) error {
 	bc.hc.SetHead(head, delFn)
 	currentHeader := bc.hc.CurrentHeader()
 
+	// Discard any frozen chain segments above the new head too
+	if adb, ok := bc.db.(AncientWriter); ok {
+		if err := adb.TruncateAncients(head + 1); err != nil {
+			return err
+		}
+	}
+
 	// Clear out any stale content from the caches
 	bc.bodyCache.Purge()
 	bc.bodyRLPCache.Purge()
@@ -309,7 +331,18 @@ func (bc *BlockChain) SetHead(head uint64) error {
 	if err := WriteHeadFastBlockHash(bc.db, currentFastBlock.Hash()); err != nil {
 		log.Crit("Failed to reset head fast block", "err", err)
 	}
-	return bc.loadLastState()
+	if err := bc.loadLastState(); err != nil {
+		return err
+	}
+	// Regenerate the state snapshot if the new head is not covered by it
+	if bc.snaps != nil && bc.snaps.Snapshot(bc.CurrentBlock().Root()) == nil {
+		bc.snaps.Rebuild(bc.CurrentBlock().Root())
+	}
+	// The state history can't reach back before the head if that was reset
+	if tail, ok := GetStateHistoryTail(bc.db); ok && currentBlock.NumberU64()+1 < tail {
+		bc.resetStateHistory(currentBlock)
+	}
+	return nil
 }
 
 // FastSyncCommitHead sets the current head block to the one defined by the hash
@@ -328,6 +361,14 @@ func (bc *BlockChain) FastSyncCommitHead(hash common.Hash) error {
 	bc.currentBlock.Store(block)
 	bc.mu.Unlock()
 
+	// The state was downloaded, not processed, so the snapshot must be generated
+	if bc.snaps != nil {
+		bc.snaps.Rebuild(block.Root())
+	}
+	// Nor are there any diffs of the blocks before it
+	if bc.cacheConfig.StateHistory {
+		bc.resetStateHistory(block)
+	}
 	log.Info("Committed new head block", "number", block.Number(), "hash", hash)
 	return nil
 }
@@ -382,9 +423,56 @@ func (bc *BlockChain) State() (*state.StateDB, error) {
 	return bc.StateAt(bc.CurrentBlock().Root())
 }
 
-// StateAt returns a new mutable state based on a particular point in time.
+// StateAt returns a new mutable state based on a particular point in time. If
+// the state was already garbage collected, but the state history is maintained,
+// a read-only historic state is reconstructed instead.
 func (bc *BlockChain) StateAt(root common.Hash) (*state.StateDB, error) {
-	return state.New(root, bc.stateCache)
+	statedb, err := state.NewWithSnapshot(root, bc.stateCache, bc.snaps)
+	if err != nil {
+		if !bc.cacheConfig.StateHistory {
+			return nil, err
+		}
+		if historic, herr := bc.historicState(root); herr == nil {
+			return historic, nil
+		}
+		return nil, err
+	}
+	return statedb, nil
+}
+
+// ExecuteBlock processes a block on top of its parent state and validates the
+// resulting state, without writing anything into the chain. It allows consensus
+// engines to verify blocks proposed to them before agreeing on them.
+func (bc *BlockChain) ExecuteBlock(block *types.Block) error {
+	parent := bc.GetBlock(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	statedb, err := state.NewWithSnapshot(parent.Root(), bc.stateCache, bc.snaps)
+	if err != nil {
+		return err
+	}
+	receipts, _, usedGas, err := bc.Processor().Process(block, statedb, bc.vmConfig)
+	if err != nil {
+		return err
+	}
+	return bc.Validator().ValidateState(block, parent, statedb, receipts, usedGas)
+}
+
+// StateHistoryEnabled returns whether the reverse state diffs are stored for the
+// imported blocks. Block producers writing their states via WriteBlockWithState
+// must track the history of their states if so.
+func (bc *BlockChain) StateHistoryEnabled() bool {
+	return bc.cacheConfig.StateHistory
+}
+
+// resetStateHistory starts the state history from the given block, as the diffs
+// of all the earlier blocks are missing.
+func (bc *BlockChain) resetStateHistory(block *types.Block) {
+	if err := WriteStateHistoryRoot(bc.db, block.Root(), block.NumberU64(), block.Hash()); err != nil {
+		log.Crit("Failed to store state history root", "err", err)
+	}
+	WriteStateHistoryTail(bc.db, block.NumberU64()+1)
 }
 
 // Reset purges the entire blockchain, restoring it to its genesis state.
@@ -539,8 +627,10 @@ func (bc *BlockChain) HasBlock(hash common.Hash, number uint64) bool {
 	if bc.blockCache.Contains(hash) {
 		return true
 	}
-	ok, _ := bc.db.Has(blockBodyKey(hash, number))
-	return ok
+	if ok, _ := bc.db.Has(blockBodyKey(hash, number)); ok {
+		return true
+	}
+	return isAncient(bc.db, hash, number)
 }
 
 // HasState checks if state trie is fully present in the database or not.
@@ -629,6 +719,11 @@ func (bc *BlockChain) TrieNode(hash common.Hash) ([]byte, error) {
 	return bc.stateCache.TrieDB().Node(hash)
 }
 
+// StateCache returns the caching database underpinning the blockchain instance.
+func (bc *BlockChain) StateCache() state.Database {
+	return bc.stateCache
+}
+
 // Stop stops the blockchain service. If any imports are currently in progress
 // it will abort them using the procInterrupt.
 func (bc *BlockChain) Stop() {
@@ -642,6 +737,14 @@ func (bc *BlockChain) Stop() {
 
 	bc.wg.Wait()
 
+	// Flatten the snapshot into its disk layer at the head, so it can be loaded on
+	// the next startup, where the head state is also available.
+	if bc.snaps != nil {
+		if err := bc.snaps.Cap(bc.CurrentBlock().Root(), 0); err != nil {
+			log.Error("Failed to persist state snapshot", "err", err)
+		}
+		bc.snaps.Stop()
+	}
 	// Ensure the state of a recent block is also stored to disk before exiting.
 	// We're writing three different states to catch different restart scenarios:
 	//  - HEAD:     So we don't need to reprocess any blocks in the general case
@@ -901,6 +1004,27 @@ func (bc *BlockChain) WriteBlockWithState(block *types.Block, receipts []*types.
 	if err != nil {
 		return NonStatTy, err
 	}
+	// Store the reverse diff of the block, so its parent state can be served even
+	// after the tries are garbage collected
+	if bc.cacheConfig.StateHistory {
+		if history := state.History(); history != nil {
+			if err := WriteStateHistory(batch, block.NumberU64(), block.Hash(), root, history); err != nil {
+				return NonStatTy, err
+			}
+		} else {
+			log.Error("State history missing, truncating", "number", block.Number(), "hash", block.Hash())
+			WriteStateHistoryTail(batch, block.NumberU64()+1)
+			WriteStateHistoryRoot(batch, root, block.NumberU64(), block.Hash())
+		}
+	}
+	// Keep the snapshot diffs of the states held in memory, flatten the older ones.
+	// Do it before the trie garbage collection, the snapshot generator might still
+	// be iterating the state being dereferenced.
+	if bc.snaps != nil && bc.snaps.Snapshot(root) != nil {
+		if err := bc.snaps.Cap(root, triesInMemory-1); err != nil {
+			log.Warn("Failed to cap state snapshot", "root", root, "err", err)
+		}
+	}
 	triedb := bc.stateCache.TrieDB()
 
 	// If we're running an archive node, always flush
@@ -980,6 +1104,12 @@ func (bc *BlockChain) WriteBlockWithState(block *types.Block, receipts []*types.
 		if err := WritePreimages(bc.db, block.NumberU64(), state.Preimages()); err != nil {
 			return NonStatTy, err
 		}
+		// If the snapshot couldn't follow the chain (e.g. a reorg after restart),
+		// regenerate it for the new head
+		if bc.snaps != nil && bc.snaps.Snapshot(root) == nil {
+			log.Warn("State snapshot missing for new head, regenerating", "number", block.Number(), "hash", block.Hash())
+			bc.snaps.Rebuild(root)
+		}
 		status = CanonStatTy
 	} else {
 		status = SideStatTy
@@ -1139,10 +1269,13 @@ func (bc *BlockChain) insertChain(chain types.Blocks) (int, []interface{}, []*ty
 		} else {
 			parent = chain[i-1]
 		}
-		state, err := state.New(parent.Root(), bc.stateCache)
+		state, err := state.NewWithSnapshot(parent.Root(), bc.stateCache, bc.snaps)
 		if err != nil {
 			return i, events, coalescedLogs, err
 		}
+		if bc.cacheConfig.StateHistory {
+			state.TrackHistory()
+		}
 		// Process block using the parent state as reference point.
 		receipts, logs, usedGas, err := bc.processor.Process(block, state, bc.vmConfig)
 		if err != nil {
@@ -17,6 +17,7 @@
 package core
 
 import (
+	"bytes"
 	"fmt"
 	"math/big"
 	"math/rand"
@@ -27,6 +28,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -1338,3 +1340,206 @@ func TestLargeReorgTrieGC(t *testing.T) {
 		}
 	}
 }
+
+// Tests that the flat state snapshot follows the chain being imported, and that
+// it's persisted on shutdown and loaded back on restart.
+func TestSnapshotChain(t *testing.T) {
+	engine := ethash.NewFaker()
+
+	db, _ := ethdb.NewMemDatabase()
+	genesis := new(Genesis).MustCommit(db)
+	blocks, _ := GenerateChain(params.TestChainConfig, genesis, engine, db, 2*triesInMemory, func(i int, b *BlockGen) { b.SetCoinbase(common.Address{byte(i % 4)}) })
+
+	diskdb, _ := ethdb.NewMemDatabase()
+	new(Genesis).MustCommit(diskdb)
+
+	config := &CacheConfig{TrieNodeLimit: 256, TrieTimeLimit: 5 * time.Minute, Snapshot: true}
+	chain, err := NewBlockChain(diskdb, config, params.TestChainConfig, engine, vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	// checkSnapshot waits for the snapshot of the head to be generated and
+	// verifies the coinbase accounts against the state trie
+	checkSnapshot := func(chain *BlockChain) {
+		t.Helper()
+
+		root := chain.CurrentBlock().Root()
+		snap := chain.snaps.Snapshot(root)
+		if snap == nil {
+			t.Fatalf("no snapshot for head state %x", root)
+		}
+		triestate, _ := state.New(root, chain.stateCache)
+		for i := byte(0); i < 4; i++ {
+			addr := common.Address{i}
+
+			var (
+				account *snapshot.Account
+				err     error
+			)
+			for {
+				if account, err = snap.Account(crypto.Keccak256Hash(addr[:])); err != snapshot.ErrNotCoveredYet {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if err != nil || account == nil {
+				t.Fatalf("account %x: failed to retrieve from snapshot: %v", addr, err)
+			}
+			if want := triestate.GetBalance(addr); account.Balance.Cmp(want) != 0 {
+				t.Errorf("account %x: balance mismatch: have %v, want %v", addr, account.Balance, want)
+			}
+		}
+	}
+	checkSnapshot(chain)
+	chain.Stop()
+
+	// Reopen the chain and ensure the persisted snapshot is loaded
+	if root, _ := diskdb.Get([]byte("SnapshotRoot")); common.BytesToHash(root) != blocks[len(blocks)-1].Root() {
+		t.Fatalf("persisted snapshot root mismatch: have %x, want %x", root, blocks[len(blocks)-1].Root())
+	}
+	chain, err = NewBlockChain(diskdb, config, params.TestChainConfig, engine, vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to recreate tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	checkSnapshot(chain)
+}
+
+// Tests that the states garbage collected from a full node are still served from
+// the reverse state diffs, matching the ones of an archive node.
+func TestStateHistoryChain(t *testing.T) {
+	var (
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address  = crypto.PubkeyToAddress(key.PublicKey)
+		contract = common.Address{0xaa}
+		code     = []byte{0x43, 0x60, 0x04, 0x43, 0x06, 0x55, 0x00} // SSTORE(NUMBER % 4, NUMBER)
+		funds    = big.NewInt(1000000000)
+		gspec    = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc: GenesisAlloc{
+				address:  {Balance: funds},
+				contract: {Code: code, Balance: big.NewInt(0)},
+			},
+		}
+		gendb, _ = ethdb.NewMemDatabase()
+		genesis  = gspec.MustCommit(gendb)
+		engine   = ethash.NewFaker()
+		signer   = types.HomesteadSigner{}
+	)
+	blocks, _ := GenerateChain(gspec.Config, genesis, engine, gendb, 2*triesInMemory, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{byte(i % 4)})
+
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(address), contract, nil, 100000, nil, nil), signer, key)
+		b.AddTx(tx)
+		tx, _ = types.SignTx(types.NewTransaction(b.TxNonce(address), common.Address{0x10 + byte(i%8)}, big.NewInt(1000), params.TxGas, nil, nil), signer, key)
+		b.AddTx(tx)
+	})
+	// Import the chain into an archive node and a full node maintaining history
+	archivedb, _ := ethdb.NewMemDatabase()
+	gspec.MustCommit(archivedb)
+	archive, _ := NewBlockChain(archivedb, &CacheConfig{Disabled: true}, gspec.Config, engine, vm.Config{})
+	defer archive.Stop()
+
+	if _, err := archive.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert archive chain: %v", err)
+	}
+	diffdb, _ := ethdb.NewMemDatabase()
+	gspec.MustCommit(diffdb)
+
+	config := &CacheConfig{TrieNodeLimit: 256, TrieTimeLimit: 5 * time.Minute, StateHistory: true}
+	chain, err := NewBlockChain(diffdb, config, gspec.Config, engine, vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	checkHistory := func(chain *BlockChain) {
+		t.Helper()
+
+		for _, block := range []*types.Block{blocks[0], blocks[1], blocks[63], blocks[triesInMemory-2]} {
+			if _, err := state.New(block.Root(), chain.stateCache); err == nil {
+				t.Fatalf("block %d: state not garbage collected", block.NumberU64())
+			}
+			have, err := chain.StateAt(block.Root())
+			if err != nil {
+				t.Fatalf("block %d: failed to retrieve historic state: %v", block.NumberU64(), err)
+			}
+			want, _ := archive.StateAt(block.Root())
+
+			for _, addr := range []common.Address{address, contract, {0x00}, {0x01}, {0x02}, {0x03}, {0x10}, {0x17}, {0xff}} {
+				if have, want := have.GetBalance(addr), want.GetBalance(addr); have.Cmp(want) != 0 {
+					t.Errorf("block %d, account %x: balance mismatch: have %v, want %v", block.NumberU64(), addr, have, want)
+				}
+				if have, want := have.GetNonce(addr), want.GetNonce(addr); have != want {
+					t.Errorf("block %d, account %x: nonce mismatch: have %v, want %v", block.NumberU64(), addr, have, want)
+				}
+				if have, want := have.Exist(addr), want.Exist(addr); have != want {
+					t.Errorf("block %d, account %x: existence mismatch: have %v, want %v", block.NumberU64(), addr, have, want)
+				}
+			}
+			for i := byte(0); i < 4; i++ {
+				if have, want := have.GetState(contract, common.Hash{31: i}), want.GetState(contract, common.Hash{31: i}); have != want {
+					t.Errorf("block %d, slot %d: value mismatch: have %x, want %x", block.NumberU64(), i, have, want)
+				}
+			}
+			if have := have.GetCode(contract); !bytes.Equal(have, code) {
+				t.Errorf("block %d: code mismatch: have %x, want %x", block.NumberU64(), have, code)
+			}
+			if err := have.Error(); err != nil {
+				t.Errorf("block %d: historic state failed: %v", block.NumberU64(), err)
+			}
+			if _, err := have.Commit(true); err == nil {
+				t.Errorf("block %d: historic state committed", block.NumberU64())
+			}
+		}
+	}
+	checkHistory(chain)
+
+	// Ensure a historic state stays readable while the chain progresses past the
+	// head state it is reconstructed from, garbage collecting it otherwise
+	historic, err := chain.StateAt(blocks[0].Root())
+	if err != nil {
+		t.Fatalf("failed to retrieve historic state: %v", err)
+	}
+	head := chain.CurrentBlock()
+
+	more, _ := GenerateChain(gspec.Config, head, engine, gendb, triesInMemory+1, nil)
+	if _, err := chain.InsertChain(more); err != nil {
+		t.Fatalf("failed to extend chain: %v", err)
+	}
+	if _, err := chain.stateCache.TrieDB().Node(head.Root()); err != nil {
+		t.Fatalf("head state of historic reader garbage collected: %v", err)
+	}
+	want, _ := archive.StateAt(blocks[0].Root())
+	for _, addr := range []common.Address{address, contract, {0x03}, {0x17}, {0xff}} {
+		if have, want := historic.GetBalance(addr), want.GetBalance(addr); have.Cmp(want) != 0 {
+			t.Errorf("account %x: balance mismatch after progressing: have %v, want %v", addr, have, want)
+		}
+	}
+	if err := historic.Error(); err != nil {
+		t.Errorf("historic state failed after progressing: %v", err)
+	}
+	// Ensure releasing the historic state unpins its head state right away,
+	// without waiting for the garbage collector
+	historic.Release()
+	if _, err := chain.stateCache.TrieDB().Node(head.Root()); err == nil {
+		t.Errorf("head state of released historic reader still pinned")
+	}
+	historic.Release() // Releasing twice must not corrupt the reference counts
+
+	chain.Stop()
+
+	// Reopen the chain and ensure the history is still served
+	chain, err = NewBlockChain(diffdb, config, gspec.Config, engine, vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to recreate tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	checkHistory(chain)
+}
@@ -43,11 +43,37 @@ type DatabaseDeleter interface {
 	Delete(key []byte) error
 }
 
+// AncientReader wraps the retrieval methods of an immutable ancient chain data
+// store, such as the freezer.
+type AncientReader interface {
+	// HasAncient returns an indicator whether the specified data exists in the
+	// ancient store.
+	HasAncient(kind string, number uint64) (bool, error)
+
+	// Ancient retrieves an ancient binary blob from the append-only immutable files.
+	Ancient(kind string, number uint64) ([]byte, error)
+
+	// Ancients returns the number of blocks stored in the ancient store.
+	Ancients() (uint64, error)
+}
+
+// AncientWriter wraps the modification methods of an immutable ancient chain
+// data store.
+type AncientWriter interface {
+	// TruncateAncients discards all but the first n ancient data from the store.
+	TruncateAncients(n uint64) error
+}
+
 var (
 	headHeaderKey = []byte("LastHeader")
 	headBlockKey  = []byte("LastBlock")
 	headFastKey   = []byte("LastFast")
 	trieSyncKey   = []byte("TrieSync")
+	snapSyncKey   = []byte("SnapSync")
+
+	// freezerMarkerKey tracks the genesis hash of the chain whose segments were
+	// moved into the ancient store, to detect mismatching freezers.
+	freezerMarkerKey = []byte("AncientFreezer")
 
 	// Data item prefixes (use single byte to avoid mixing data types, avoid `i`).
 	headerPrefix        = []byte("h") // headerPrefix + num (uint64 big endian) + hash -> header
@@ -59,11 +85,25 @@ var (
 	lookupPrefix        = []byte("l") // lookupPrefix + hash -> transaction/receipt lookup metadata
 	bloomBitsPrefix     = []byte("B") // bloomBitsPrefix + bit (uint16 big endian) + section (uint64 big endian) + hash -> bloom bits
 
+	// State history prefixes, the reverse diffs of the blocks keyed by the items
+	// they modified, so the value at any block is a single seek away.
+	stateHistoryAccountPrefix = []byte("X") // stateHistoryAccountPrefix + account hash + num (uint64 big endian) + hash -> account before the block
+	stateHistoryStoragePrefix = []byte("Y") // stateHistoryStoragePrefix + account hash + slot hash + num (uint64 big endian) + hash -> slot before the block
+	stateHistoryCodePrefix    = []byte("C") // stateHistoryCodePrefix + code hash -> contract code
+	stateHistoryRootPrefix    = []byte("R") // stateHistoryRootPrefix + state root + num (uint64 big endian) + hash -> nothing
+	stateHistoryTailKey       = []byte("StateHistoryTail")
+
+	// Address index prefixes, the canonical logs and transactions of an address
+	// in chain order, so a block range is a single seek away.
+	addressLogPrefix = []byte("L") // addressLogPrefix + address + topic0 + num (uint64 big endian) + tx index (uint32 big endian) + log index (uint32 big endian) -> hash
+	addressTxPrefix  = []byte("T") // addressTxPrefix + address + num (uint64 big endian) + tx index (uint32 big endian) -> hash
+
 	preimagePrefix = "secure-key-"              // preimagePrefix + hash -> preimage
 	configPrefix   = []byte("ethereum-config-") // config prefix for the db
 
 	// Chain index prefixes (use `i` + single byte to avoid mixing data types).
 	BloomBitsIndexPrefix = []byte("iB") // BloomBitsIndexPrefix is the data table of a chain indexer to track its progress
+	AddressIndexPrefix   = []byte("iA") // AddressIndexPrefix is the data table of the address indexer to track its progress
 
 	// used by old db, now only used for conversion
 	oldReceiptsPrefix = []byte("receipts-")
@@ -93,12 +133,44 @@ func encodeBlockNumber(number uint64) []byte {
 // GetCanonicalHash retrieves a hash assigned to a canonical block number.
 func GetCanonicalHash(db DatabaseReader, number uint64) common.Hash {
 	data, _ := db.Get(append(append(headerPrefix, encodeBlockNumber(number)...), numSuffix...))
+	if len(data) == 0 {
+		data = getAncient(db, freezerHashTable, number)
+	}
 	if len(data) == 0 {
 		return common.Hash{}
 	}
 	return common.BytesToHash(data)
 }
 
+// getAncient retrieves an item from the ancient store backing the database, if
+// there is any. Nil is returned if the item is unavailable.
+func getAncient(db DatabaseReader, kind string, number uint64) []byte {
+	if adb, ok := db.(AncientReader); ok {
+		data, _ := adb.Ancient(kind, number)
+		return data
+	}
+	return nil
+}
+
+// getAncientByHash retrieves an item from the ancient store backing the database,
+// if there is any and if the canonical block at the given number has a matching
+// hash. Nil is returned otherwise.
+func getAncientByHash(db DatabaseReader, kind string, hash common.Hash, number uint64) []byte {
+	if !isAncient(db, hash, number) {
+		return nil
+	}
+	return getAncient(db, kind, number)
+}
+
+// isAncient checks whether the block with the given hash and number has been
+// moved into the ancient store backing the database.
+func isAncient(db DatabaseReader, hash common.Hash, number uint64) bool {
+	if _, ok := db.(AncientReader); !ok {
+		return false
+	}
+	return bytes.Equal(getAncient(db, freezerHashTable, number), hash[:])
+}
+
 // missingNumber is returned by GetBlockNumber if no header with the
 // given block hash has been stored in the database
 const missingNumber = uint64(0xffffffffffffffff)
@@ -157,10 +229,20 @@ func GetTrieSyncProgress(db DatabaseReader) uint64 {
 	return new(big.Int).SetBytes(data).Uint64()
 }
 
+// GetSnapSyncProgress retrieves the serialized progress of an interrupted snap
+// sync state download, or nil if there is none.
+func GetSnapSyncProgress(db DatabaseReader) []byte {
+	data, _ := db.Get(snapSyncKey)
+	return data
+}
+
 // GetHeaderRLP retrieves a block header in its raw RLP database encoding, or nil
 // if the header's not found.
 func GetHeaderRLP(db DatabaseReader, hash common.Hash, number uint64) rlp.RawValue {
 	data, _ := db.Get(headerKey(hash, number))
+	if len(data) == 0 {
+		data = getAncientByHash(db, freezerHeaderTable, hash, number)
+	}
 	return data
 }
 
@@ -182,6 +264,9 @@ func GetHeader(db DatabaseReader, hash common.Hash, number uint64) *types.Header
 // GetBodyRLP retrieves the block body (transactions and uncles) in RLP encoding.
 func GetBodyRLP(db DatabaseReader, hash common.Hash, number uint64) rlp.RawValue {
 	data, _ := db.Get(blockBodyKey(hash, number))
+	if len(data) == 0 {
+		data = getAncientByHash(db, freezerBodiesTable, hash, number)
+	}
 	return data
 }
 
@@ -189,10 +274,18 @@ func headerKey(hash common.Hash, number uint64) []byte {
 	return append(append(headerPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
 }
 
+func headerTdKey(hash common.Hash, number uint64) []byte {
+	return append(headerKey(hash, number), tdSuffix...)
+}
+
 func blockBodyKey(hash common.Hash, number uint64) []byte {
 	return append(append(bodyPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
 }
 
+func blockReceiptsKey(hash common.Hash, number uint64) []byte {
+	return append(append(blockReceiptsPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
+}
+
 // GetBody retrieves the block body (transactons, uncles) corresponding to the
 // hash, nil if none found.
 func GetBody(db DatabaseReader, hash common.Hash, number uint64) *types.Body {
@@ -211,7 +304,10 @@ func GetBody(db DatabaseReader, hash common.Hash, number uint64) *types.Body {
 // GetTd retrieves a block's total difficulty corresponding to the hash, nil if
 // none found.
 func GetTd(db DatabaseReader, hash common.Hash, number uint64) *big.Int {
-	data, _ := db.Get(append(append(append(headerPrefix, encodeBlockNumber(number)...), hash[:]...), tdSuffix...))
+	data, _ := db.Get(headerTdKey(hash, number))
+	if len(data) == 0 {
+		data = getAncientByHash(db, freezerDifficultyTable, hash, number)
+	}
 	if len(data) == 0 {
 		return nil
 	}
@@ -246,7 +342,10 @@ func GetBlock(db DatabaseReader, hash common.Hash, number uint64) *types.Block {
 // GetBlockReceipts retrieves the receipts generated by the transactions included
 // in a block given by its hash.
 func GetBlockReceipts(db DatabaseReader, hash common.Hash, number uint64) types.Receipts {
-	data, _ := db.Get(append(append(blockReceiptsPrefix, encodeBlockNumber(number)...), hash[:]...))
+	data, _ := db.Get(blockReceiptsKey(hash, number))
+	if len(data) == 0 {
+		data = getAncientByHash(db, freezerReceiptTable, hash, number)
+	}
 	if len(data) == 0 {
 		return nil
 	}
@@ -394,6 +493,15 @@ func WriteTrieSyncProgress(db ethdb.Putter, count uint64) error {
 	return nil
 }
 
+// WriteSnapSyncProgress stores the serialized progress of the snap sync state
+// download to support resuming it across restarts.
+func WriteSnapSyncProgress(db ethdb.Putter, progress []byte) error {
+	if err := db.Put(snapSyncKey, progress); err != nil {
+		log.Crit("Failed to store snap sync progress", "err", err)
+	}
+	return nil
+}
+
 // WriteHeader serializes a block header into the database.
 func WriteHeader(db ethdb.Putter, header *types.Header) error {
 	data, err := rlp.EncodeToBytes(header)
@@ -438,7 +546,7 @@ func WriteTd(db ethdb.Putter, hash common.Hash, number uint64, td *big.Int) erro
 	if err != nil {
 		return err
 	}
-	key := append(append(append(headerPrefix, encodeBlockNumber(number)...), hash.Bytes()...), tdSuffix...)
+	key := headerTdKey(hash, number)
 	if err := db.Put(key, data); err != nil {
 		log.Crit("Failed to store block total difficulty", "err", err)
 	}
@@ -472,7 +580,7 @@ func WriteBlockReceipts(db ethdb.Putter, hash common.Hash, number uint64, receip
 		return err
 	}
 	// Store the flattened receipt slice
-	key := append(append(blockReceiptsPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
+	key := blockReceiptsKey(hash, number)
 	if err := db.Put(key, bytes); err != nil {
 		log.Crit("Failed to store block receipts", "err", err)
 	}
@@ -518,6 +626,11 @@ func DeleteCanonicalHash(db DatabaseDeleter, number uint64) {
 	db.Delete(append(append(headerPrefix, encodeBlockNumber(number)...), numSuffix...))
 }
 
+// DeleteSnapSyncProgress removes the progress of a finished snap sync.
+func DeleteSnapSyncProgress(db DatabaseDeleter) {
+	db.Delete(snapSyncKey)
+}
+
 // DeleteHeader removes all block header data associated with a hash.
 func DeleteHeader(db DatabaseDeleter, hash common.Hash, number uint64) {
 	db.Delete(append(blockHashPrefix, hash.Bytes()...))
@@ -526,12 +639,12 @@ func DeleteHeader(db DatabaseDeleter, hash common.Hash, number uint64) {
 
 // DeleteBody removes all block body data associated with a hash.
 func DeleteBody(db DatabaseDeleter, hash common.Hash, number uint64) {
-	db.Delete(append(append(bodyPrefix, encodeBlockNumber(number)...), hash.Bytes()...))
+	db.Delete(blockBodyKey(hash, number))
 }
 
 // DeleteTd removes all block total difficulty data associated with a hash.
 func DeleteTd(db DatabaseDeleter, hash common.Hash, number uint64) {
-	db.Delete(append(append(append(headerPrefix, encodeBlockNumber(number)...), hash.Bytes()...), tdSuffix...))
+	db.Delete(headerTdKey(hash, number))
 }
 
 // DeleteBlock removes all block data associated with a hash.
@@ -544,7 +657,7 @@ func DeleteBlock(db DatabaseDeleter, hash common.Hash, number uint64) {
 
 // DeleteBlockReceipts removes all receipt data associated with a block hash.
 func DeleteBlockReceipts(db DatabaseDeleter, hash common.Hash, number uint64) {
-	db.Delete(append(append(blockReceiptsPrefix, encodeBlockNumber(number)...), hash.Bytes()...))
+	db.Delete(blockReceiptsKey(hash, number))
 }
 
 // DeleteTxLookupEntry removes all transaction data associated with a hash.
@@ -0,0 +1,440 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+const (
+	// freezerHashTable indicates the name of the freezer canonical hash table.
+	freezerHashTable = "hashes"
+
+	// freezerHeaderTable indicates the name of the freezer header table.
+	freezerHeaderTable = "headers"
+
+	// freezerBodiesTable indicates the name of the freezer block body table.
+	freezerBodiesTable = "bodies"
+
+	// freezerReceiptTable indicates the name of the freezer receipts table.
+	freezerReceiptTable = "receipts"
+
+	// freezerDifficultyTable indicates the name of the freezer total difficulty table.
+	freezerDifficultyTable = "diffs"
+)
+
+// freezerNoSnappy configures whether compression is disabled for the ancient
+// tables. Hashes and total difficulties are incompressible, the rest is snappy
+// encoded unless the freezer is created with compression disabled altogether.
+var freezerNoSnappy = map[string]bool{
+	freezerHashTable:       true,
+	freezerHeaderTable:     false,
+	freezerBodiesTable:     false,
+	freezerReceiptTable:    false,
+	freezerDifficultyTable: true,
+}
+
+var (
+	// errUnknownTable is returned if the user attempts to read from a table that is
+	// not tracked by the freezer.
+	errUnknownTable = errors.New("unknown table")
+
+	// errFrozenSegments is returned if a key-value database containing frozen
+	// chain segments is opened without its matching freezer.
+	errFrozenSegments = errors.New("database contains frozen chain segments, ancient directory required")
+)
+
+const (
+	// freezerRecheckInterval is the frequency to check the key-value database for
+	// chain progression that might permit new blocks to be frozen into immutable
+	// storage.
+	freezerRecheckInterval = time.Minute
+
+	// freezerBatchLimit is the maximum number of blocks to freeze in one batch
+	// before doing an fsync and deleting it from the key-value store.
+	freezerBatchLimit = 30000
+)
+
+// freezer is an append-only database to store immutable chain data into flat
+// files:
+//
+// - The append only nature ensures that disk writes are minimized.
+// - The indexed flat files allow reads to be served without touching the key-value
+//   store, which keeps the LevelDB small and compactions cheap.
+type freezer struct {
+	frozen uint64 // Number of blocks already frozen (atomic)
+
+	tables map[string]*freezerTable // Data tables for storing everything
+	lock   sync.Mutex               // Lock serializing appends and truncations
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newFreezer creates a chain freezer that moves ancient chain data into
+// append-only flat file containers. If noSnappy is set, newly created tables are
+// not compressed; existing tables always keep the format they were created with.
+func newFreezer(datadir string, namespace string, noSnappy bool) (*freezer, error) {
+	// Create the initial freezer object
+	var (
+		readMeter  = metrics.NewRegisteredMeter(namespace+"ancient/read", nil)
+		writeMeter = metrics.NewRegisteredMeter(namespace+"ancient/write", nil)
+	)
+	freezer := &freezer{
+		tables: make(map[string]*freezerTable),
+		quit:   make(chan struct{}),
+	}
+	for name, disableSnappy := range freezerNoSnappy {
+		// Switching the compression of an existing table would orphan its data
+		disableSnappy = tableNoSnappy(datadir, name, disableSnappy || noSnappy)
+
+		table, err := newTable(datadir, name, readMeter, writeMeter, disableSnappy)
+		if err != nil {
+			for _, table := range freezer.tables {
+				table.Close()
+			}
+			return nil, err
+		}
+		freezer.tables[name] = table
+	}
+	if err := freezer.repair(); err != nil {
+		for _, table := range freezer.tables {
+			table.Close()
+		}
+		return nil, err
+	}
+	log.Info("Opened ancient database", "database", datadir, "frozen", freezer.frozen)
+	return freezer, nil
+}
+
+// Close terminates the chain freezer, closing all the data files.
+func (f *freezer) Close() error {
+	select {
+	case <-f.quit:
+	default:
+		close(f.quit)
+	}
+	f.wg.Wait()
+
+	var errs []error
+	for _, table := range f.tables {
+		if err := table.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if errs != nil {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// HasAncient returns an indicator whether the specified ancient data exists
+// in the freezer.
+func (f *freezer) HasAncient(kind string, number uint64) (bool, error) {
+	if table := f.tables[kind]; table != nil {
+		return table.has(number), nil
+	}
+	return false, nil
+}
+
+// Ancient retrieves an ancient binary blob from the append-only immutable files.
+func (f *freezer) Ancient(kind string, number uint64) ([]byte, error) {
+	if table := f.tables[kind]; table != nil {
+		return table.Retrieve(number)
+	}
+	return nil, errUnknownTable
+}
+
+// Ancients returns the length of the frozen items.
+func (f *freezer) Ancients() (uint64, error) {
+	return atomic.LoadUint64(&f.frozen), nil
+}
+
+// AppendAncient injects all binary blobs belong to block at the end of the
+// append-only immutable table files. Any out-of-order injection is rejected.
+func (f *freezer) AppendAncient(number uint64, hash, header, body, receipts, td []byte) (err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	// Rollback all inserted data if any insertion below failed to ensure
+	// the tables won't out of sync.
+	defer func() {
+		if err != nil {
+			f.truncate(number)
+		}
+	}()
+	if err := f.tables[freezerHashTable].Append(number, hash); err != nil {
+		log.Error("Failed to append ancient hash", "number", number, "hash", common.BytesToHash(hash), "err", err)
+		return err
+	}
+	if err := f.tables[freezerHeaderTable].Append(number, header); err != nil {
+		log.Error("Failed to append ancient header", "number", number, "hash", common.BytesToHash(hash), "err", err)
+		return err
+	}
+	if err := f.tables[freezerBodiesTable].Append(number, body); err != nil {
+		log.Error("Failed to append ancient body", "number", number, "hash", common.BytesToHash(hash), "err", err)
+		return err
+	}
+	if err := f.tables[freezerReceiptTable].Append(number, receipts); err != nil {
+		log.Error("Failed to append ancient receipts", "number", number, "hash", common.BytesToHash(hash), "err", err)
+		return err
+	}
+	if err := f.tables[freezerDifficultyTable].Append(number, td); err != nil {
+		log.Error("Failed to append ancient difficulty", "number", number, "hash", common.BytesToHash(hash), "err", err)
+		return err
+	}
+	atomic.AddUint64(&f.frozen, 1) // Only modify atomically
+	return nil
+}
+
+// TruncateAncients discards any recent data above the provided threshold number.
+func (f *freezer) TruncateAncients(items uint64) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if atomic.LoadUint64(&f.frozen) <= items {
+		return nil
+	}
+	return f.truncate(items)
+}
+
+// truncate cuts all the tables down to the given number of items. The caller
+// must hold the freezer lock.
+func (f *freezer) truncate(items uint64) error {
+	for _, table := range f.tables {
+		if err := table.truncate(items); err != nil {
+			return err
+		}
+	}
+	atomic.StoreUint64(&f.frozen, items)
+	return nil
+}
+
+// Sync flushes all data tables to disk.
+func (f *freezer) Sync() error {
+	var errs []error
+	for _, table := range f.tables {
+		if err := table.Sync(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if errs != nil {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// repair truncates all data tables to the same length.
+func (f *freezer) repair() error {
+	min := uint64(1<<64 - 1)
+	for _, table := range f.tables {
+		if items := table.Items(); min > items {
+			min = items
+		}
+	}
+	return f.truncate(min)
+}
+
+// freeze is a background thread that periodically checks the blockchain for any
+// import progress and moves ancient data from the fast database into the freezer.
+//
+// This functionality is deliberately broken off from block importing to avoid
+// incurring additional data shuffling delays on block propagation.
+func (f *freezer) freeze(db ethdb.Database) {
+	defer f.wg.Done()
+
+	for {
+		if limit, ok := f.freezable(db); ok {
+			if err := f.freezeRange(db, limit); err != nil {
+				log.Error("Failed to freeze ancient chain segment", "err", err)
+			}
+		}
+		select {
+		case <-f.quit:
+			return
+		case <-time.After(freezerRecheckInterval):
+		}
+	}
+}
+
+// freezable returns the block number up to which (exclusive) chain data can be
+// moved into the freezer, based on the current head of the key-value database.
+func (f *freezer) freezable(db ethdb.Database) (uint64, bool) {
+	// Retrieve the freezing threshold. The fast block is used as reference, as
+	// it's guaranteed to have receipts for everything below it.
+	hash := GetHeadFastBlockHash(db)
+	if hash == (common.Hash{}) {
+		return 0, false
+	}
+	number := GetBlockNumber(db, hash)
+	if number == missingNumber || number < params.ImmutabilityThreshold {
+		return 0, false
+	}
+	limit := number - params.ImmutabilityThreshold
+	if frozen := atomic.LoadUint64(&f.frozen); limit <= frozen {
+		return 0, false
+	} else if limit-frozen > freezerBatchLimit {
+		limit = frozen + freezerBatchLimit
+	}
+	return limit, true
+}
+
+// freezeRange moves all the canonical chain data from the current freezer tip up
+// to limit (exclusive) out of the key-value store and into the freezer.
+func (f *freezer) freezeRange(db ethdb.Database, limit uint64) error {
+	var (
+		start  = time.Now()
+		first  = atomic.LoadUint64(&f.frozen)
+		frozen = first
+	)
+	for ; frozen < limit; frozen++ {
+		// Retrieves all the components of the canonical block
+		hash := GetCanonicalHash(db, frozen)
+		if hash == (common.Hash{}) {
+			return fmt.Errorf("canonical hash missing, can't freeze block %d", frozen)
+		}
+		header, _ := db.Get(headerKey(hash, frozen))
+		if len(header) == 0 {
+			return fmt.Errorf("block header missing, can't freeze block %d (%x)", frozen, hash)
+		}
+		body, _ := db.Get(blockBodyKey(hash, frozen))
+		if len(body) == 0 {
+			return fmt.Errorf("block body missing, can't freeze block %d (%x)", frozen, hash)
+		}
+		receipts, _ := db.Get(blockReceiptsKey(hash, frozen))
+		if len(receipts) == 0 {
+			return fmt.Errorf("block receipts missing, can't freeze block %d (%x)", frozen, hash)
+		}
+		td, _ := db.Get(headerTdKey(hash, frozen))
+		if len(td) == 0 {
+			return fmt.Errorf("total difficulty missing, can't freeze block %d (%x)", frozen, hash)
+		}
+		// Inject all the components into the relevant data tables
+		if err := f.AppendAncient(frozen, hash[:], header, body, receipts, td); err != nil {
+			return err
+		}
+		select {
+		case <-f.quit:
+			limit = frozen + 1 // Finish up the current block and abort
+		default:
+		}
+	}
+	// Batch of blocks have been frozen, flush them before wiping from the database
+	if err := f.Sync(); err != nil {
+		log.Crit("Failed to flush frozen tables", "err", err)
+	}
+	if first == 0 && frozen > 0 {
+		genesis, _ := f.Ancient(freezerHashTable, 0)
+		if err := db.Put(freezerMarkerKey, genesis); err != nil {
+			return err
+		}
+	}
+	batch := db.NewBatch()
+	for number := first; number < frozen; number++ {
+		// Wipe out all data associated with the block height, including any side
+		// chains that can't ever become canonical any more.
+		for _, prefix := range [][]byte{headerPrefix, bodyPrefix, blockReceiptsPrefix} {
+			it := db.NewIteratorWithPrefix(append(append([]byte{}, prefix...), encodeBlockNumber(number)...))
+			for it.Next() {
+				if err := batch.Delete(common.CopyBytes(it.Key())); err != nil {
+					it.Release()
+					return err
+				}
+			}
+			it.Release()
+		}
+		if batch.ValueSize() > ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	log.Info("Deep froze chain segment", "blocks", frozen-first, "elapsed", common.PrettyDuration(time.Since(start)), "number", frozen-1)
+	return nil
+}
+
+// freezerdb is a database wrapper that enables freezer data retrievals.
+type freezerdb struct {
+	ethdb.Database
+	*freezer
+}
+
+// Close implements ethdb.Database, closing both the fast key-value store as well
+// as the slow ancient tables.
+func (frdb *freezerdb) Close() {
+	if err := frdb.freezer.Close(); err != nil {
+		log.Error("Failed to close ancient database", "err", err)
+	}
+	frdb.Database.Close()
+}
+
+// NewDatabaseWithFreezer creates a high level database on top of a given key-value
+// data store with a freezer moving immutable chain segments into flat files in
+// the given directory.
+//
+// If no freezer directory is given, the key-value store is returned unmodified,
+// but only after ensuring that it doesn't depend on a previously used freezer.
+// The noSnappy flag disables the compression of newly created freezer tables.
+func NewDatabaseWithFreezer(db ethdb.Database, freezer string, namespace string, noSnappy bool) (ethdb.Database, error) {
+	marker, _ := db.Get(freezerMarkerKey)
+	if freezer == "" {
+		if len(marker) > 0 && GetCanonicalHash(db, 0) == (common.Hash{}) {
+			return nil, errFrozenSegments
+		}
+		return db, nil
+	}
+	frdb, err := newFreezer(freezer, namespace, noSnappy)
+	if err != nil {
+		return nil, err
+	}
+	// Ensure the freezer and the key-value store belong to the same chain
+	if frozen, _ := frdb.Ancients(); frozen == 0 {
+		if len(marker) > 0 && GetCanonicalHash(db, 0) == (common.Hash{}) {
+			frdb.Close()
+			return nil, fmt.Errorf("frozen chain segments missing from ancient directory %s", freezer)
+		}
+	} else {
+		genesis, err := frdb.Ancient(freezerHashTable, 0)
+		if err != nil {
+			frdb.Close()
+			return nil, err
+		}
+		if !bytes.Equal(marker, genesis) {
+			frdb.Close()
+			return nil, fmt.Errorf("ancient chain segments in %s don't match the key-value database", freezer)
+		}
+	}
+	frdb.wg.Add(1)
+	go frdb.freeze(db)
+
+	return &freezerdb{Database: db, freezer: frdb}, nil
+}
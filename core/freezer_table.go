@@ -0,0 +1,351 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/golang/snappy"
+)
+
+var (
+	// errClosed is returned if an operation attempts to read from or write to the
+	// freezer table after it has already been closed.
+	errClosed = errors.New("closed")
+
+	// errOutOfBounds is returned if the item requested is not contained within the
+	// freezer table.
+	errOutOfBounds = errors.New("out of bounds")
+
+	// errOutOrderInsertion is returned if the user attempts to inject out-of-order
+	// binary blobs into the freezer.
+	errOutOrderInsertion = errors.New("the append operation is out-order")
+)
+
+// indexEntrySize is the size of a single entry in a freezer table index file:
+// the big endian end offset of the item within the data file.
+const indexEntrySize = 8
+
+// freezerTable represents a single chained data table within the freezer (e.g.
+// blocks). It consists of a data file (snappy encoded arbitrary data blobs) and
+// an index file (uint64 end offsets into the data file).
+//
+// Items are only ever appended to the end of a table, or removed from its end
+// via truncation, never modified in place.
+type freezerTable struct {
+	items   uint64   // Number of items stored in the table
+	head    uint64   // Number of bytes stored in the data file
+	noSnap  bool     // if true, disables snappy compression
+	index   *os.File // File descriptor for the index of the table
+	data    *os.File // File descriptor for the data of the table
+	discard []byte   // Scratch space for reading index entries
+
+	readMeter  metrics.Meter // Meter for measuring the effective amount of data read
+	writeMeter metrics.Meter // Meter for measuring the effective amount of data written
+
+	logger log.Logger   // Logger with database path and table name embedded
+	lock   sync.RWMutex // Mutex protecting the data file descriptors
+}
+
+// tableNoSnappy reports whether the freezer table of the given name in path was
+// created without compression, or returns the given default if it doesn't exist.
+func tableNoSnappy(path string, name string, def bool) bool {
+	switch {
+	case common.FileExist(filepath.Join(path, fmt.Sprintf("%s.ridx", name))):
+		return true
+	case common.FileExist(filepath.Join(path, fmt.Sprintf("%s.cidx", name))):
+		return false
+	default:
+		return def
+	}
+}
+
+// newTable opens a freezer table, creating the data and index files if they are
+// non existent. Both files are truncated to the shortest common length to ensure
+// they don't go out of sync.
+func newTable(path string, name string, readMeter metrics.Meter, writeMeter metrics.Meter, disableSnappy bool) (*freezerTable, error) {
+	// Ensure the containing directory exists and open the indexEntry file
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	var idxName, datName string
+	if disableSnappy {
+		idxName, datName = fmt.Sprintf("%s.ridx", name), fmt.Sprintf("%s.rdat", name)
+	} else {
+		idxName, datName = fmt.Sprintf("%s.cidx", name), fmt.Sprintf("%s.cdat", name)
+	}
+	index, err := os.OpenFile(filepath.Join(path, idxName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.OpenFile(filepath.Join(path, datName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		index.Close()
+		return nil, err
+	}
+	tab := &freezerTable{
+		index:      index,
+		data:       data,
+		noSnap:     disableSnappy,
+		discard:    make([]byte, indexEntrySize),
+		readMeter:  readMeter,
+		writeMeter: writeMeter,
+		logger:     log.New("database", path, "table", name),
+	}
+	if err := tab.repair(); err != nil {
+		tab.Close()
+		return nil, err
+	}
+	return tab, nil
+}
+
+// repair cross checks the head and the index file and truncates them to be in
+// sync with each other after a potential crash / data loss.
+func (t *freezerTable) repair() error {
+	// Retrieve the file sizes and prepare for truncation
+	stat, err := t.index.Stat()
+	if err != nil {
+		return err
+	}
+	// Ensure the index is a multiple of indexEntrySize bytes
+	if overflow := stat.Size() % indexEntrySize; overflow != 0 {
+		t.logger.Warn("Truncating dangling index bytes", "bytes", overflow)
+		if err := t.index.Truncate(stat.Size() - overflow); err != nil {
+			return err
+		}
+	}
+	if stat, err = t.index.Stat(); err != nil {
+		return err
+	}
+	items := uint64(stat.Size() / indexEntrySize)
+
+	if stat, err = t.data.Stat(); err != nil {
+		return err
+	}
+	size := uint64(stat.Size())
+
+	// Drop any index entries pointing past the end of the data file, then drop
+	// any data that isn't referenced by the index.
+	var offset uint64
+	for items > 0 {
+		if offset, err = t.offset(items); err != nil {
+			return err
+		}
+		if offset <= size {
+			break
+		}
+		items--
+	}
+	if err := t.index.Truncate(int64(items * indexEntrySize)); err != nil {
+		return err
+	}
+	if size > offset {
+		t.logger.Warn("Truncating dangling data bytes", "bytes", size-offset)
+	}
+	if err := t.data.Truncate(int64(offset)); err != nil {
+		return err
+	}
+	t.items, t.head = items, offset
+
+	t.logger.Debug("Chain freezer table opened", "items", t.items, "size", common.StorageSize(t.head))
+	return nil
+}
+
+// offset returns the end offset of the item-th entry (counting from 1) in the
+// data file. The end offset of the zeroth entry is by definition zero.
+func (t *freezerTable) offset(item uint64) (uint64, error) {
+	if item == 0 {
+		return 0, nil
+	}
+	if _, err := t.index.ReadAt(t.discard, int64((item-1)*indexEntrySize)); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(t.discard), nil
+}
+
+// truncate discards any recent data above the provided threshold number.
+func (t *freezerTable) truncate(items uint64) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// If our item count is correct, don't do anything
+	if t.items <= items {
+		return nil
+	}
+	if t.index == nil || t.data == nil {
+		return errClosed
+	}
+	// Something's out of sync, truncate the table's offset index
+	t.logger.Warn("Truncating freezer table", "items", t.items, "limit", items)
+	offset, err := t.offset(items)
+	if err != nil {
+		return err
+	}
+	if err := t.index.Truncate(int64(items * indexEntrySize)); err != nil {
+		return err
+	}
+	if err := t.data.Truncate(int64(offset)); err != nil {
+		return err
+	}
+	t.items, t.head = items, offset
+	return nil
+}
+
+// Close closes all opened files.
+func (t *freezerTable) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var errs []error
+	for _, f := range []*os.File{t.index, t.data} {
+		if f == nil {
+			continue
+		}
+		if err := f.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	t.index, t.data = nil, nil
+
+	if errs != nil {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// Append injects a binary blob at the end of the freezer table. The item number
+// is a precautionary parameter to ensure data correctness, but the table will
+// reject already existing data.
+//
+// Note, this method will *not* flush any data to disk so be sure to explicitly
+// fsync before irreversibly deleting data from the database.
+func (t *freezerTable) Append(item uint64, blob []byte) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// Ensure the table is still accessible
+	if t.index == nil || t.data == nil {
+		return errClosed
+	}
+	// Ensure only the next item can be written, nothing else
+	if t.items != item {
+		return errOutOrderInsertion
+	}
+	// Encode the blob and write it into the data file
+	if !t.noSnap {
+		blob = snappy.Encode(nil, blob)
+	}
+	if _, err := t.data.WriteAt(blob, int64(t.head)); err != nil {
+		return err
+	}
+	// Write the new end offset into the index, data first for crash consistency
+	binary.BigEndian.PutUint64(t.discard, t.head+uint64(len(blob)))
+	if _, err := t.index.WriteAt(t.discard, int64(t.items*indexEntrySize)); err != nil {
+		return err
+	}
+	t.head += uint64(len(blob))
+	t.items++
+
+	if t.writeMeter != nil {
+		t.writeMeter.Mark(int64(len(blob) + indexEntrySize))
+	}
+	return nil
+}
+
+// Retrieve looks up the data offset of an item with the given number and retrieves
+// the raw binary blob from the data file.
+func (t *freezerTable) Retrieve(item uint64) ([]byte, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	// Ensure the table and the item is accessible
+	if t.index == nil || t.data == nil {
+		return nil, errClosed
+	}
+	if t.items <= item {
+		return nil, errOutOfBounds
+	}
+	// Retrieve the start and end offsets of the item, and read the data
+	var buf [2 * indexEntrySize]byte
+
+	start, end := uint64(0), uint64(0)
+	if item == 0 {
+		if _, err := t.index.ReadAt(buf[indexEntrySize:], 0); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := t.index.ReadAt(buf[:], int64((item-1)*indexEntrySize)); err != nil {
+			return nil, err
+		}
+		start = binary.BigEndian.Uint64(buf[:indexEntrySize])
+	}
+	end = binary.BigEndian.Uint64(buf[indexEntrySize:])
+	if end < start {
+		return nil, fmt.Errorf("corrupt index entry %d: end offset %d below start %d", item, end, start)
+	}
+	blob := make([]byte, end-start)
+	if _, err := t.data.ReadAt(blob, int64(start)); err != nil {
+		return nil, err
+	}
+	if t.readMeter != nil {
+		t.readMeter.Mark(int64(len(blob) + 2*indexEntrySize))
+	}
+	if t.noSnap {
+		return blob, nil
+	}
+	return snappy.Decode(nil, blob)
+}
+
+// has returns an indicator whether the specified number data exists in the
+// freezer table.
+func (t *freezerTable) has(number uint64) bool {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return number < t.items
+}
+
+// Items returns the number of items stored in the table.
+func (t *freezerTable) Items() uint64 {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.items
+}
+
+// Sync pushes any pending data from memory out to disk. This is an expensive
+// operation, so use it with care.
+func (t *freezerTable) Sync() error {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if t.index == nil || t.data == nil {
+		return errClosed
+	}
+	if err := t.data.Sync(); err != nil {
+		return err
+	}
+	return t.index.Sync()
+}
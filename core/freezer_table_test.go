@@ -0,0 +1,173 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// getChunk returns a chunk of data, filled with the given byte.
+func getChunk(size int, b int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(b)
+	}
+	return data
+}
+
+// Tests that items can be appended to and retrieved from a freezer table, both
+// with and without compression, and that they survive a restart.
+func TestFreezerTableBasics(t *testing.T)         { testFreezerTableBasics(t, false) }
+func TestFreezerTableBasicsNoSnappy(t *testing.T) { testFreezerTableBasics(t, true) }
+
+func testFreezerTableBasics(t *testing.T, noSnappy bool) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	table, err := newTable(dir, "test", nil, nil, noSnappy)
+	if err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	for i := 0; i < 255; i++ {
+		if err := table.Append(uint64(i), getChunk(15, i)); err != nil {
+			t.Fatalf("failed to append item %d: %v", i, err)
+		}
+	}
+	if err := table.Append(300, getChunk(15, 0)); err != errOutOrderInsertion {
+		t.Fatalf("out of order append error mismatch: have %v, want %v", err, errOutOrderInsertion)
+	}
+	table.Close()
+
+	// Reopen the table and check all the items are still there
+	if table, err = newTable(dir, "test", nil, nil, noSnappy); err != nil {
+		t.Fatalf("failed to reopen table: %v", err)
+	}
+	defer table.Close()
+
+	if items := table.Items(); items != 255 {
+		t.Fatalf("item count mismatch: have %d, want %d", items, 255)
+	}
+	for i := 0; i < 255; i++ {
+		blob, err := table.Retrieve(uint64(i))
+		if err != nil {
+			t.Fatalf("failed to retrieve item %d: %v", i, err)
+		}
+		if want := getChunk(15, i); !bytes.Equal(blob, want) {
+			t.Fatalf("item %d mismatch: have %x, want %x", i, blob, want)
+		}
+	}
+	if _, err := table.Retrieve(255); err != errOutOfBounds {
+		t.Fatalf("out of bounds retrieval error mismatch: have %v, want %v", err, errOutOfBounds)
+	}
+}
+
+// Tests that a freezer table with a data file shorter than its index is repaired
+// on startup by dropping the dangling index entries.
+func TestFreezerTableRepairDanglingIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	table, err := newTable(dir, "test", nil, nil, true)
+	if err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := table.Append(uint64(i), getChunk(20, i)); err != nil {
+			t.Fatalf("failed to append item %d: %v", i, err)
+		}
+	}
+	table.Close()
+
+	// Chop off the last item and a half, and leave some garbage in the index
+	if err := os.Truncate(filepath.Join(dir, "test.rdat"), 8*20+10); err != nil {
+		t.Fatalf("failed to truncate data file: %v", err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "test.ridx"), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("failed to open index file: %v", err)
+	}
+	f.Write([]byte{0x01, 0x02, 0x03})
+	f.Close()
+
+	if table, err = newTable(dir, "test", nil, nil, true); err != nil {
+		t.Fatalf("failed to reopen table: %v", err)
+	}
+	defer table.Close()
+
+	if items := table.Items(); items != 8 {
+		t.Fatalf("item count mismatch: have %d, want %d", items, 8)
+	}
+	for i := 0; i < 8; i++ {
+		if blob, err := table.Retrieve(uint64(i)); err != nil || !bytes.Equal(blob, getChunk(20, i)) {
+			t.Fatalf("item %d mismatch: have %x, %v; want %x", i, blob, err, getChunk(20, i))
+		}
+	}
+	// Ensure the table can be appended to after the repair
+	if err := table.Append(8, getChunk(20, 0xff)); err != nil {
+		t.Fatalf("failed to append after repair: %v", err)
+	}
+	if blob, err := table.Retrieve(8); err != nil || !bytes.Equal(blob, getChunk(20, 0xff)) {
+		t.Fatalf("appended item mismatch: have %x, %v; want %x", blob, err, getChunk(20, 0xff))
+	}
+}
+
+// Tests that truncating a freezer table drops all items above the limit and
+// that new items can be appended in their place.
+func TestFreezerTableTruncate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	table, err := newTable(dir, "test", nil, nil, false)
+	if err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	defer table.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := table.Append(uint64(i), getChunk(20, i)); err != nil {
+			t.Fatalf("failed to append item %d: %v", i, err)
+		}
+	}
+	if err := table.truncate(5); err != nil {
+		t.Fatalf("failed to truncate table: %v", err)
+	}
+	if items := table.Items(); items != 5 {
+		t.Fatalf("item count mismatch: have %d, want %d", items, 5)
+	}
+	if _, err := table.Retrieve(5); err != errOutOfBounds {
+		t.Fatalf("truncated item retrievable: %v", err)
+	}
+	if err := table.Append(5, getChunk(30, 0xaa)); err != nil {
+		t.Fatalf("failed to append after truncation: %v", err)
+	}
+	if blob, err := table.Retrieve(5); err != nil || !bytes.Equal(blob, getChunk(30, 0xaa)) {
+		t.Fatalf("appended item mismatch: have %x, %v; want %x", blob, err, getChunk(30, 0xaa))
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Tests that canonical chain data moved into the freezer is still accessible
+// through the usual database accessors, and that it's gone from the key-value
+// store.
+func TestFreezerChainRetrieval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Assemble a short canonical chain directly in the database
+	db, _ := ethdb.NewMemDatabase()
+
+	var blocks []*types.Block
+	parent := common.Hash{}
+	for i := 0; i < 10; i++ {
+		header := &types.Header{
+			ParentHash: parent,
+			Number:     big.NewInt(int64(i)),
+			Difficulty: big.NewInt(int64(i + 1)),
+			Extra:      []byte(fmt.Sprintf("block %d", i)),
+		}
+		block := types.NewBlockWithHeader(header)
+		blocks = append(blocks, block)
+		parent = block.Hash()
+
+		if err := WriteBlock(db, block); err != nil {
+			t.Fatalf("failed to write block %d: %v", i, err)
+		}
+		if err := WriteTd(db, block.Hash(), block.NumberU64(), big.NewInt(int64(i+1))); err != nil {
+			t.Fatalf("failed to write td %d: %v", i, err)
+		}
+		if err := WriteBlockReceipts(db, block.Hash(), block.NumberU64(), types.Receipts{}); err != nil {
+			t.Fatalf("failed to write receipts %d: %v", i, err)
+		}
+		if err := WriteCanonicalHash(db, block.Hash(), block.NumberU64()); err != nil {
+			t.Fatalf("failed to write canonical hash %d: %v", i, err)
+		}
+	}
+	f, err := newFreezer(dir, "", false)
+	if err != nil {
+		t.Fatalf("failed to create freezer: %v", err)
+	}
+	defer f.Close()
+
+	if err := f.freezeRange(db, 6); err != nil {
+		t.Fatalf("failed to freeze chain segment: %v", err)
+	}
+	if frozen, _ := f.Ancients(); frozen != 6 {
+		t.Fatalf("frozen block count mismatch: have %d, want %d", frozen, 6)
+	}
+	fdb := &freezerdb{Database: db, freezer: f}
+	for i, block := range blocks {
+		frozen := i < 6
+		if has, _ := db.Has(blockBodyKey(block.Hash(), block.NumberU64())); has == frozen {
+			t.Errorf("block %d: key-value presence mismatch: have %v, want %v", i, has, !frozen)
+		}
+		if hash := GetCanonicalHash(fdb, block.NumberU64()); hash != block.Hash() {
+			t.Errorf("block %d: canonical hash mismatch: have %x, want %x", i, hash, block.Hash())
+		}
+		if entry := GetBlock(fdb, block.Hash(), block.NumberU64()); entry == nil || entry.Hash() != block.Hash() {
+			t.Errorf("block %d: retrieved block mismatch: have %v, want %v", i, entry, block)
+		}
+		if td := GetTd(fdb, block.Hash(), block.NumberU64()); td == nil || td.Int64() != int64(i+1) {
+			t.Errorf("block %d: total difficulty mismatch: have %v, want %d", i, td, i+1)
+		}
+		// Side chain lookups must not be served from the freezer
+		if entry := GetHeader(fdb, common.Hash{0x01}, block.NumberU64()); entry != nil {
+			t.Errorf("block %d: non-canonical header returned: %v", i, entry)
+		}
+	}
+	// Rewinding the freezer should drop the frozen blocks too
+	if err := f.TruncateAncients(3); err != nil {
+		t.Fatalf("failed to truncate freezer: %v", err)
+	}
+	if entry := GetBlock(fdb, blocks[4].Hash(), 4); entry != nil {
+		t.Fatalf("truncated block returned: %v", entry)
+	}
+}
+
+// Tests that a key-value database whose chain segments were moved into a freezer
+// is refused if opened without the matching ancient directory.
+func TestFreezerMissingAncients(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, _ := ethdb.NewMemDatabase()
+	genesis := new(Genesis).MustCommit(db)
+
+	chain, _ := GenerateChain(params.TestChainConfig, genesis, ethash.NewFaker(), db, 4, nil)
+	blockchain, _ := NewBlockChain(db, nil, params.TestChainConfig, ethash.NewFaker(), vm.Config{})
+	if _, err := blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	blockchain.Stop()
+
+	// Without anything frozen, the database can be opened with or without a freezer
+	if _, err := NewDatabaseWithFreezer(db, "", "", false); err != nil {
+		t.Fatalf("failed to open unfrozen database: %v", err)
+	}
+	frdb, err := NewDatabaseWithFreezer(db, dir, "", false)
+	if err != nil {
+		t.Fatalf("failed to open database with freezer: %v", err)
+	}
+	if err := frdb.(*freezerdb).freezeRange(db, 3); err != nil {
+		t.Fatalf("failed to freeze chain segment: %v", err)
+	}
+	frdb.Close()
+
+	// Once frozen, the ancient directory is required
+	if _, err := NewDatabaseWithFreezer(db, "", "", false); err != errFrozenSegments {
+		t.Fatalf("missing freezer error mismatch: have %v, want %v", err, errFrozenSegments)
+	}
+	empty, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(empty)
+
+	if _, err := NewDatabaseWithFreezer(db, empty, "", false); err == nil {
+		t.Fatalf("empty ancient directory accepted")
+	}
+	frdb, err = NewDatabaseWithFreezer(db, dir, "", false)
+	if err != nil {
+		t.Fatalf("failed to reopen database with freezer: %v", err)
+	}
+	defer frdb.Close()
+
+	if hash := GetCanonicalHash(frdb, 0); hash != genesis.Hash() {
+		t.Errorf("frozen genesis mismatch: have %x, want %x", hash, genesis.Hash())
+	}
+}
+
+// Tests that rewinding the chain below the frozen segments truncates the freezer
+// too, and that the chain can be extended again afterwards.
+func TestFreezerSetHead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	kvdb, _ := ethdb.NewMemDatabase()
+	db, err := NewDatabaseWithFreezer(kvdb, dir, "", false)
+	if err != nil {
+		t.Fatalf("failed to open database with freezer: %v", err)
+	}
+	defer db.Close()
+
+	genesis := new(Genesis).MustCommit(db)
+	blocks, _ := GenerateChain(params.TestChainConfig, genesis, ethash.NewFaker(), db, 10, nil)
+
+	chain, _ := NewBlockChain(db, &CacheConfig{Disabled: true}, params.TestChainConfig, ethash.NewFaker(), vm.Config{})
+	defer chain.Stop()
+
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	if err := db.(*freezerdb).freezeRange(kvdb, 8); err != nil {
+		t.Fatalf("failed to freeze chain segment: %v", err)
+	}
+	// Rewind into the frozen segment and ensure everything above is gone
+	if err := chain.SetHead(5); err != nil {
+		t.Fatalf("failed to rewind chain: %v", err)
+	}
+	if frozen, _ := db.(*freezerdb).Ancients(); frozen != 6 {
+		t.Errorf("frozen block count mismatch: have %d, want %d", frozen, 6)
+	}
+	if head := chain.CurrentBlock().NumberU64(); head != 5 {
+		t.Errorf("head block mismatch: have %d, want %d", head, 5)
+	}
+	for number := uint64(0); number <= 10; number++ {
+		block := chain.GetBlockByNumber(number)
+		if number <= 5 && (block == nil || block.Hash() != chain.GetHeaderByNumber(number).Hash()) {
+			t.Errorf("block %d: missing after rewind", number)
+		}
+		if number > 5 && block != nil {
+			t.Errorf("block %d: present after rewind", number)
+		}
+	}
+	// Ensure the chain can progress over the truncated segment again
+	if _, err := chain.InsertChain(blocks[5:]); err != nil {
+		t.Fatalf("failed to reimport chain: %v", err)
+	}
+	if head := chain.CurrentBlock().Hash(); head != blocks[9].Hash() {
+		t.Errorf("reimported head mismatch: have %x, want %x", head, blocks[9].Hash())
+	}
+	if block := chain.GetBlockByNumber(7); block == nil || block.Hash() != blocks[6].Hash() {
+		t.Errorf("reimported block 7 mismatch: have %v, want %x", block, blocks[6].Hash())
+	}
+}
+
+// Tests that the compression of the freezer tables can be disabled, and that
+// existing tables keep their format if the setting is changed later.
+func TestFreezerNoSnappy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	kvdb, _ := ethdb.NewMemDatabase()
+	db, err := NewDatabaseWithFreezer(kvdb, dir, "", true)
+	if err != nil {
+		t.Fatalf("failed to open database with freezer: %v", err)
+	}
+	genesis := new(Genesis).MustCommit(db)
+	blocks, _ := GenerateChain(params.TestChainConfig, genesis, ethash.NewFaker(), db, 10, nil)
+
+	chain, _ := NewBlockChain(db, &CacheConfig{Disabled: true}, params.TestChainConfig, ethash.NewFaker(), vm.Config{})
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	chain.Stop()
+
+	if err := db.(*freezerdb).freezeRange(kvdb, 8); err != nil {
+		t.Fatalf("failed to freeze chain segment: %v", err)
+	}
+	db.Close()
+
+	for name := range freezerNoSnappy {
+		if !common.FileExist(filepath.Join(dir, name+".ridx")) || common.FileExist(filepath.Join(dir, name+".cidx")) {
+			t.Errorf("table %s: not stored uncompressed", name)
+		}
+	}
+	// Reopen the freezer with compression enabled and ensure the data is intact
+	if db, err = NewDatabaseWithFreezer(kvdb, dir, "", false); err != nil {
+		t.Fatalf("failed to reopen database with freezer: %v", err)
+	}
+	defer db.Close()
+
+	if frozen, _ := db.(*freezerdb).Ancients(); frozen != 8 {
+		t.Fatalf("frozen block count mismatch: have %d, want %d", frozen, 8)
+	}
+	for i, block := range blocks[:7] {
+		if entry := GetBlock(db, block.Hash(), block.NumberU64()); entry == nil || entry.Hash() != block.Hash() {
+			t.Errorf("block %d: retrieved block mismatch: have %v, want %v", i+1, entry, block)
+		}
+	}
+	for name := range freezerNoSnappy {
+		if common.FileExist(filepath.Join(dir, name+".cidx")) {
+			t.Errorf("table %s: compressed table created next to the uncompressed one", name)
+		}
+	}
+}
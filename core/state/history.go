@@ -0,0 +1,155 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"errors"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// errHistoricState is returned when trying to commit a historic state.
+var errHistoricState = errors.New("historic state is read-only")
+
+// StateHistory is the reverse diff of a state transition: the values of all the
+// accounts and storage slots it modified, from before the transition. Applying
+// it on top of the post state restores the pre state.
+type StateHistory struct {
+	Accounts map[common.Hash][]byte                 // Slim RLP of the modified accounts, nil if they didn't exist
+	Storage  map[common.Hash]map[common.Hash][]byte // Trie encoded modified slots, nil if they were empty
+	Codes    map[common.Hash][]byte                 // Contract codes deployed by the transition
+}
+
+// releaser is implemented by the readers backing historic states which pin
+// resources until they are released.
+type releaser interface {
+	Release()
+}
+
+// Release frees the resources pinned by a historic state, such as the head state
+// its accounts and storage slots are reconstructed from. It is a no-op for any
+// other state. Copies of a historic state share its reader, so the state may
+// only be released once none of the copies are used any more.
+func (self *StateDB) Release() {
+	if r, ok := self.snap.(releaser); ok && self.historic {
+		r.Release()
+	}
+}
+
+// TrackHistory starts to collect the reverse diff of all the changes committed
+// to the tries from now on, retrievable with History.
+func (self *StateDB) TrackHistory() {
+	self.history = &StateHistory{
+		Accounts: make(map[common.Hash][]byte),
+		Storage:  make(map[common.Hash]map[common.Hash][]byte),
+		Codes:    make(map[common.Hash][]byte),
+	}
+}
+
+// History returns the reverse diff collected since TrackHistory was called, or
+// nil if the history is not tracked.
+func (self *StateDB) History() *StateHistory {
+	return self.history
+}
+
+// recordAccount remembers the value of an account from before it's modified in
+// the account trie. Only the first modification is recorded, since the trie was
+// untouched before.
+func (self *StateDB) recordAccount(addr common.Address, addrHash common.Hash) {
+	if _, ok := self.history.Accounts[addrHash]; ok {
+		return
+	}
+	enc, err := self.trie.TryGet(addr[:])
+	if len(enc) == 0 {
+		self.setError(err)
+		self.history.Accounts[addrHash] = nil
+		return
+	}
+	var data Account
+	if err := rlp.DecodeBytes(enc, &data); err != nil {
+		self.setError(err)
+		return
+	}
+	self.history.Accounts[addrHash] = snapshot.AccountRLP(data.Nonce, data.Balance, data.Root, data.CodeHash)
+}
+
+// recordSlot remembers the value of a storage slot from before it's modified in
+// the storage trie of an account.
+func (self *StateDB) recordSlot(addrHash, slotHash common.Hash, value []byte) {
+	storage := self.history.Storage[addrHash]
+	if storage == nil {
+		storage = make(map[common.Hash][]byte)
+		self.history.Storage[addrHash] = storage
+	}
+	if _, ok := storage[slotHash]; !ok {
+		storage[slotHash] = common.CopyBytes(value)
+	}
+}
+
+// recordWipe remembers all the storage slots of an account which is about to be
+// deleted or overwritten, losing its entire storage.
+func (self *StateDB) recordWipe(addr common.Address, addrHash common.Hash) {
+	enc, err := self.trie.TryGet(addr[:])
+	if len(enc) == 0 {
+		self.setError(err)
+		return
+	}
+	var data Account
+	if err := rlp.DecodeBytes(enc, &data); err != nil {
+		self.setError(err)
+		return
+	}
+	if data.Root == types.EmptyRootHash {
+		return
+	}
+	tr, err := self.db.OpenStorageTrie(addrHash, data.Root)
+	if err != nil {
+		self.setError(err)
+		return
+	}
+	it := trie.NewIterator(tr.NodeIterator(nil))
+	for it.Next() {
+		self.recordSlot(addrHash, common.BytesToHash(it.Key), it.Value)
+	}
+	self.setError(it.Err)
+}
+
+// copy creates a deep copy of the state history.
+func (h *StateHistory) copy() *StateHistory {
+	cpy := &StateHistory{
+		Accounts: make(map[common.Hash][]byte, len(h.Accounts)),
+		Storage:  make(map[common.Hash]map[common.Hash][]byte, len(h.Storage)),
+		Codes:    make(map[common.Hash][]byte, len(h.Codes)),
+	}
+	for hash, data := range h.Accounts {
+		cpy.Accounts[hash] = data
+	}
+	for hash, storage := range h.Storage {
+		cpy.Storage[hash] = make(map[common.Hash][]byte, len(storage))
+		for slot, data := range storage {
+			cpy.Storage[hash][slot] = data
+		}
+	}
+	for hash, code := range h.Codes {
+		cpy.Codes[hash] = code
+	}
+	return cpy
+}
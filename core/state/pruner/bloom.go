@@ -0,0 +1,129 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package pruner
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// stateBloomHashes is the number of bits set in the filter for every key.
+const stateBloomHashes = 4
+
+// stateBloom is a bloom filter of the trie nodes and contract codes to retain.
+// All the keys are hashes already, so the bit positions are taken from the key
+// itself instead of hashing it again.
+//
+// False positives only result in some garbage surviving the pruning, but a key
+// that was added is always found.
+type stateBloom struct {
+	bits []byte
+}
+
+// newStateBloom creates an empty bloom filter of the given size in bytes.
+func newStateBloom(size uint64) *stateBloom {
+	if size < 1 {
+		size = 1
+	}
+	return &stateBloom{bits: make([]byte, size)}
+}
+
+// loadStateBloom loads a bloom filter persisted by commit.
+func loadStateBloom(filename string) (*stateBloom, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	bits, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(bits) == 0 {
+		return nil, errors.New("empty state bloom")
+	}
+	return &stateBloom{bits: bits}, nil
+}
+
+// commit persists the bloom filter into the given file. The content is written
+// into a temporary file first and moved in place afterwards, so the file only
+// ever exists in complete form.
+func (b *stateBloom) commit(filename string) error {
+	tmp := filename + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := gzip.NewWriter(f)
+	if _, err := writer.Write(b.bits); err != nil {
+		f.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// positions returns the bit positions of a key in the filter.
+func (b *stateBloom) positions(key []byte) [stateBloomHashes]uint64 {
+	var (
+		hash  = common.BytesToHash(key)
+		total = uint64(len(b.bits)) * 8
+		pos   [stateBloomHashes]uint64
+	)
+	for i := range pos {
+		pos[i] = binary.BigEndian.Uint64(hash[i*8:]) % total
+	}
+	return pos
+}
+
+// put adds a key to the filter.
+func (b *stateBloom) put(key []byte) {
+	for _, pos := range b.positions(key) {
+		b.bits[pos/8] |= 1 << (pos % 8)
+	}
+}
+
+// contain reports whether a key might have been added to the filter.
+func (b *stateBloom) contain(key []byte) bool {
+	for _, pos := range b.positions(key) {
+		if b.bits[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,295 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package pruner implements the offline deletion of stale state data.
+//
+// The in-memory garbage collection of trie.Database only covers the recent tries
+// not yet flushed to disk. Everything persisted stays forever, so the state of a
+// long running node grows unbounded. The pruner marks the trie nodes and contract
+// codes reachable from a few retained state roots in a bloom filter, and deletes
+// every other content addressed entry from the database.
+package pruner
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// stateBloomFileName is the name of the file the bloom filter of the retained
+// state is persisted into, until the pruning is finished.
+const stateBloomFileName = "statebloom.bf.gz"
+
+var (
+	// emptyRoot is the known root hash of an empty trie.
+	emptyRoot = common.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+
+	// emptyCode is the known hash of the empty EVM bytecode.
+	emptyCode = crypto.Keccak256(nil)
+)
+
+// Pruner is an offline tool to delete the state data not reachable from a set
+// of retained state roots. It must not be used while the database is open by
+// a running node.
+//
+// The pruning happens in two phases. First all the trie nodes and contract codes
+// of the retained states are marked in a bloom filter, which is persisted into
+// the data directory. Then all other trie nodes and codes are swept from the
+// database. If the sweeping is interrupted, it's resumed from the persisted
+// filter, since the pruned states can't be iterated any more.
+type Pruner struct {
+	db        ethdb.Database
+	bloomPath string
+	bloomSize uint64
+}
+
+// NewPruner creates a state pruner for the given database. The bloom filter is
+// persisted into the given data directory and has a size of bloomSize megabytes.
+// Larger filters let less garbage survive.
+func NewPruner(db ethdb.Database, datadir string, bloomSize uint64) *Pruner {
+	return &Pruner{
+		db:        db,
+		bloomPath: filepath.Join(datadir, stateBloomFileName),
+		bloomSize: bloomSize,
+	}
+}
+
+// Prune deletes all the trie nodes and contract codes not reachable from any of
+// the given state roots, then compacts the database. If an earlier pruning was
+// interrupted, that one is finished instead and the roots are ignored.
+func (p *Pruner) Prune(roots []common.Hash) error {
+	if common.FileExist(p.bloomPath) {
+		log.Warn("Resuming interrupted state pruning, ignoring requested roots")
+		return RecoverPruning(filepath.Dir(p.bloomPath), p.db)
+	}
+	if len(roots) == 0 {
+		return errors.New("no state to retain")
+	}
+	bloom := newStateBloom(p.bloomSize * 1024 * 1024)
+
+	start := time.Now()
+	for _, root := range roots {
+		if err := markState(p.db, bloom, root); err != nil {
+			return fmt.Errorf("failed to mark state %x: %v", root, err)
+		}
+	}
+	log.Info("Marked retained state", "roots", len(roots), "elapsed", common.PrettyDuration(time.Since(start)))
+
+	// Persist the filter before deleting anything, a resumed sweep needs it
+	if err := bloom.commit(p.bloomPath); err != nil {
+		return err
+	}
+	return sweepState(p.db, bloom, p.bloomPath)
+}
+
+// RecoverPruning finishes an interrupted pruning from the bloom filter persisted
+// in the given data directory, if there's any. It must be called before the
+// database is used by a node, otherwise the state written in the meantime would
+// be swept by a later resumption.
+func RecoverPruning(datadir string, db ethdb.Database) error {
+	if datadir == "" {
+		return nil
+	}
+	path := filepath.Join(datadir, stateBloomFileName)
+	if !common.FileExist(path) {
+		return nil
+	}
+	bloom, err := loadStateBloom(path)
+	if err != nil {
+		return err
+	}
+	log.Info("Resuming interrupted state pruning")
+	return sweepState(db, bloom, path)
+}
+
+// RetainedRoots returns the state roots to keep when pruning: the ones of the
+// given number of most recent blocks and the genesis, as far as their state is
+// present in the database.
+func RetainedRoots(db ethdb.Database, recent uint64) ([]common.Hash, error) {
+	headHash := core.GetHeadBlockHash(db)
+	if headHash == (common.Hash{}) {
+		return nil, errors.New("head block missing")
+	}
+	head := core.GetBlockNumber(db, headHash)
+	if fastHash := core.GetHeadFastBlockHash(db); fastHash != (common.Hash{}) && core.GetBlockNumber(db, fastHash) > head {
+		return nil, errors.New("state sync in progress")
+	}
+	var (
+		roots []common.Hash
+		seen  = make(map[common.Hash]bool)
+	)
+	add := func(number uint64) {
+		header := core.GetHeader(db, core.GetCanonicalHash(db, number), number)
+		if header == nil || seen[header.Root] {
+			return
+		}
+		if ok, _ := db.Has(header.Root[:]); ok {
+			roots = append(roots, header.Root)
+			seen[header.Root] = true
+		}
+	}
+	for i := uint64(0); i < recent && i <= head; i++ {
+		add(head - i)
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no state available in the last %d blocks", recent)
+	}
+	add(0)
+	return roots, nil
+}
+
+// markState adds all the trie nodes and contract codes of a state to the bloom
+// filter. Any missing data is an error, as pruning around it is not safe.
+func markState(db ethdb.Database, bloom *stateBloom, root common.Hash) error {
+	var (
+		triedb = trie.NewDatabase(db)
+		start  = time.Now()
+		logged = time.Now()
+		nodes  uint64
+	)
+	accTrie, err := trie.New(root, triedb)
+	if err != nil {
+		return err
+	}
+	accIt := accTrie.NodeIterator(nil)
+	for accIt.Next(true) {
+		if hash := accIt.Hash(); hash != (common.Hash{}) {
+			bloom.put(hash[:])
+			nodes++
+		}
+		if !accIt.Leaf() {
+			continue
+		}
+		var account state.Account
+		if err := rlp.DecodeBytes(accIt.LeafBlob(), &account); err != nil {
+			return err
+		}
+		if !bytes.Equal(account.CodeHash, emptyCode) {
+			bloom.put(account.CodeHash)
+		}
+		if account.Root != emptyRoot {
+			storeTrie, err := trie.New(account.Root, triedb)
+			if err != nil {
+				return err
+			}
+			storeIt := storeTrie.NodeIterator(nil)
+			for storeIt.Next(true) {
+				if hash := storeIt.Hash(); hash != (common.Hash{}) {
+					bloom.put(hash[:])
+					nodes++
+				}
+			}
+			if storeIt.Error() != nil {
+				return storeIt.Error()
+			}
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Marking retained state", "root", root, "at", common.BytesToHash(accIt.LeafKey()), "nodes", nodes, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if accIt.Error() != nil {
+		return accIt.Error()
+	}
+	log.Debug("Marked retained state", "root", root, "nodes", nodes, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// sweepState deletes all the trie nodes and contract codes not in the bloom
+// filter, removes the persisted filter and compacts the database.
+func sweepState(db ethdb.Database, bloom *stateBloom, bloomPath string) error {
+	var (
+		start   = time.Now()
+		logged  = time.Now()
+		batch   = db.NewBatch()
+		deleted uint64
+		size    common.StorageSize
+	)
+	it := db.NewIterator()
+	for it.Next() {
+		key := it.Key()
+		if len(key) != common.HashLength || bloom.contain(key) {
+			continue
+		}
+		// Only trie nodes and contract codes are keyed by the hash of their content,
+		// leave any other entry alone which happens to have a hash sized key
+		if !bytes.Equal(crypto.Keccak256(it.Value()), key) {
+			continue
+		}
+		batch.Delete(common.CopyBytes(key))
+		deleted++
+		size += common.StorageSize(len(key) + len(it.Value()))
+
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				it.Release()
+				return err
+			}
+			batch.Reset()
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Pruning state data", "at", common.BytesToHash(key), "nodes", deleted, "size", size, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	log.Info("Pruned state data", "nodes", deleted, "size", size, "elapsed", common.PrettyDuration(time.Since(start)))
+
+	// All stale data is gone, a restart doesn't need the filter any more
+	if err := os.Remove(bloomPath); err != nil {
+		return err
+	}
+	return compactDatabase(db)
+}
+
+// compactDatabase compacts the entire database in a number of ranges, so that
+// the progress can be reported.
+func compactDatabase(db ethdb.Database) error {
+	start := time.Now()
+	for b := 0x00; b <= 0xf0; b += 0x10 {
+		var (
+			from  = []byte{byte(b)}
+			until = []byte{byte(b + 0x10)}
+		)
+		if b == 0xf0 {
+			until = nil
+		}
+		log.Info("Compacting database", "range", fmt.Sprintf("%#x-%#x", from, until), "elapsed", common.PrettyDuration(time.Since(start)))
+		if err := db.Compact(from, until); err != nil {
+			return err
+		}
+	}
+	log.Info("Compacted database", "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
@@ -0,0 +1,234 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package pruner
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// makeTestStates creates two consecutive states on disk, the second one changing
+// some of the balances, storage slots and contract codes of the first.
+func makeTestStates(t *testing.T, db ethdb.Database) (common.Hash, common.Hash) {
+	sdb := state.NewDatabase(db)
+
+	statedb, _ := state.New(common.Hash{}, sdb)
+	for i := byte(0); i < 100; i++ {
+		addr := common.BytesToAddress([]byte{i})
+		statedb.AddBalance(addr, big.NewInt(int64(i)+1))
+		if i%10 == 0 {
+			statedb.SetCode(addr, []byte{i, 0x01})
+			statedb.SetState(addr, common.Hash{i}, common.Hash{i})
+		}
+	}
+	old, _ := statedb.Commit(false)
+	if err := sdb.TrieDB().Commit(old, false); err != nil {
+		t.Fatalf("failed to commit old state: %v", err)
+	}
+	statedb, _ = state.New(old, sdb)
+	for i := byte(0); i < 10; i++ {
+		addr := common.BytesToAddress([]byte{i * 10})
+		statedb.AddBalance(addr, big.NewInt(1))
+		statedb.SetState(addr, common.Hash{i * 10}, common.Hash{0xff})
+		statedb.SetCode(addr, []byte{i, 0x02})
+	}
+	recent, _ := statedb.Commit(false)
+	if err := sdb.TrieDB().Commit(recent, false); err != nil {
+		t.Fatalf("failed to commit recent state: %v", err)
+	}
+	return old, recent
+}
+
+// checkPruned verifies that the retained state is complete and the pruned one
+// is gone, along with its contract codes.
+func checkPruned(t *testing.T, db ethdb.Database, retained, pruned common.Hash) {
+	t.Helper()
+
+	statedb, err := state.New(retained, state.NewDatabase(db))
+	if err != nil {
+		t.Fatalf("failed to open retained state: %v", err)
+	}
+	it := state.NewNodeIterator(statedb)
+	for it.Next() {
+	}
+	if it.Error != nil {
+		t.Fatalf("retained state incomplete: %v", it.Error)
+	}
+	if ok, _ := db.Has(pruned[:]); ok {
+		t.Errorf("pruned state root still present")
+	}
+	if ok, _ := db.Has(crypto.Keccak256([]byte{0x00, 0x01})); ok {
+		t.Errorf("pruned contract code still present")
+	}
+	if ok, _ := db.Has(crypto.Keccak256([]byte{0x00, 0x02})); !ok {
+		t.Errorf("retained contract code missing")
+	}
+}
+
+// Tests that pruning deletes the unreachable state data but nothing else.
+func TestPruneState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pruner-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, _ := ethdb.NewMemDatabase()
+	old, recent := makeTestStates(t, db)
+
+	// Insert an entry with a hash sized key, which is not content addressed
+	junk := common.HexToHash("0x01")
+	db.Put(junk[:], []byte("junk"))
+
+	if err := NewPruner(db, dir, 1).Prune([]common.Hash{recent}); err != nil {
+		t.Fatalf("failed to prune state: %v", err)
+	}
+	checkPruned(t, db, recent, old)
+
+	if ok, _ := db.Has(junk[:]); !ok {
+		t.Errorf("unrelated entry deleted")
+	}
+	if common.FileExist(filepath.Join(dir, stateBloomFileName)) {
+		t.Errorf("state bloom not removed after pruning")
+	}
+}
+
+// Tests that an interrupted pruning is resumed from the persisted bloom filter,
+// regardless of the roots requested later.
+func TestPruneResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pruner-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, _ := ethdb.NewMemDatabase()
+	old, recent := makeTestStates(t, db)
+
+	// Nothing to recover without a persisted filter
+	if err := RecoverPruning(dir, db); err != nil {
+		t.Fatalf("failed to recover without pruning: %v", err)
+	}
+	if ok, _ := db.Has(old[:]); !ok {
+		t.Fatalf("state deleted without pruning")
+	}
+	// Simulate a pruning interrupted right after marking
+	bloom := newStateBloom(1024 * 1024)
+	if err := markState(db, bloom, recent); err != nil {
+		t.Fatalf("failed to mark state: %v", err)
+	}
+	if err := bloom.commit(filepath.Join(dir, stateBloomFileName)); err != nil {
+		t.Fatalf("failed to persist state bloom: %v", err)
+	}
+	if err := NewPruner(db, dir, 1).Prune([]common.Hash{old}); err != nil {
+		t.Fatalf("failed to resume pruning: %v", err)
+	}
+	checkPruned(t, db, recent, old)
+
+	if common.FileExist(filepath.Join(dir, stateBloomFileName)) {
+		t.Errorf("state bloom not removed after pruning")
+	}
+}
+
+// Tests that marking a state with missing data fails, instead of pruning around
+// the hole.
+func TestPruneIncompleteState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pruner-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, _ := ethdb.NewMemDatabase()
+	old, recent := makeTestStates(t, db)
+
+	// Delete a random trie node of the recent state
+	statedb, _ := state.New(recent, state.NewDatabase(db))
+	it := state.NewNodeIterator(statedb)
+	for i := 0; it.Next(); i++ {
+		if i >= 10 && it.Hash != (common.Hash{}) {
+			break
+		}
+	}
+	db.Delete(it.Hash[:])
+
+	if err := NewPruner(db, dir, 1).Prune([]common.Hash{recent}); err == nil {
+		t.Fatalf("pruning succeeded with incomplete state")
+	}
+	if ok, _ := db.Has(old[:]); !ok {
+		t.Errorf("state deleted by failed pruning")
+	}
+}
+
+// Tests that the states of the recent blocks and the genesis are retained, as
+// far as they are available.
+func TestRetainedRoots(t *testing.T) {
+	var (
+		gendb, _ = ethdb.NewMemDatabase()
+		engine   = ethash.NewFaker()
+		gspec    = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  core.GenesisAlloc{common.Address{0xff}: {Balance: big.NewInt(1)}},
+		}
+		genesis = gspec.MustCommit(gendb)
+	)
+	blocks, _ := core.GenerateChain(params.TestChainConfig, genesis, engine, gendb, 10, func(i int, b *core.BlockGen) {
+		b.SetCoinbase(common.Address{byte(i)})
+	})
+	db, _ := ethdb.NewMemDatabase()
+	gspec.MustCommit(db)
+
+	chain, err := core.NewBlockChain(db, &core.CacheConfig{Disabled: true}, params.TestChainConfig, engine, vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	chain.Stop()
+
+	roots, err := RetainedRoots(db, 3)
+	if err != nil {
+		t.Fatalf("failed to retrieve retained roots: %v", err)
+	}
+	want := []common.Hash{blocks[9].Root(), blocks[8].Root(), blocks[7].Root(), genesis.Root()}
+	if len(roots) != len(want) {
+		t.Fatalf("root count mismatch: have %d, want %d", len(roots), len(want))
+	}
+	for i := range want {
+		if roots[i] != want[i] {
+			t.Errorf("root %d mismatch: have %x, want %x", i, roots[i], want[i])
+		}
+	}
+	// Unavailable states are skipped
+	db.Delete(blocks[8].Root().Bytes())
+	if roots, _ = RetainedRoots(db, 3); len(roots) != 3 {
+		t.Errorf("root count mismatch: have %d, want %d", len(roots), 3)
+	}
+}
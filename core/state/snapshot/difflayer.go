@@ -0,0 +1,152 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// diffLayer represents the state changes of a single block on top of a parent
+// snapshot. Destructed accounts have their entire storage wiped before any of
+// the account or storage changes of the same layer are applied.
+//
+// Nil values in the account or storage maps denote deleted entries.
+type diffLayer struct {
+	parent snapshot    // Parent snapshot modified by this one, never nil
+	root   common.Hash // Root hash to which this snapshot diff belongs to
+	stale  bool        // Signals that the layer became stale (state progressed)
+
+	destructSet map[common.Hash]struct{}               // Accounts deleted in this layer
+	accountData map[common.Hash][]byte                 // Slim RLP encoded accounts changed in this layer
+	storageData map[common.Hash]map[common.Hash][]byte // Storage slots changed in this layer
+
+	lock sync.RWMutex
+}
+
+// newDiffLayer creates a new diff on top of an existing snapshot.
+func newDiffLayer(parent snapshot, root common.Hash, destructs map[common.Hash]struct{}, accounts map[common.Hash][]byte, storage map[common.Hash]map[common.Hash][]byte) *diffLayer {
+	return &diffLayer{
+		parent:      parent,
+		root:        root,
+		destructSet: destructs,
+		accountData: accounts,
+		storageData: storage,
+	}
+}
+
+// Root returns the root hash for which this snapshot was made.
+func (dl *diffLayer) Root() common.Hash {
+	return dl.root
+}
+
+// Parent returns the subsequent layer of a diff layer.
+func (dl *diffLayer) Parent() snapshot {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	return dl.parent
+}
+
+// Stale returns whether this layer has become stale (was flattened across) or
+// if it's still live.
+func (dl *diffLayer) Stale() bool {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	return dl.stale
+}
+
+// markStale flags the layer as stale, failing all subsequent reads.
+func (dl *diffLayer) markStale() {
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	dl.stale = true
+}
+
+// setParent replaces the parent of the layer after its old parent was persisted.
+func (dl *diffLayer) setParent(parent snapshot) {
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	dl.parent = parent
+}
+
+// Account directly retrieves the account associated with a particular hash in
+// the snapshot slim data format.
+func (dl *diffLayer) Account(hash common.Hash) (*Account, error) {
+	data, err := dl.AccountRLP(hash)
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	account := new(Account)
+	if err := rlp.DecodeBytes(data, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// AccountRLP directly retrieves the account RLP associated with a particular
+// hash in the snapshot slim data format. If the account is not changed in this
+// layer, the lookup is delegated to the parent.
+func (dl *diffLayer) AccountRLP(hash common.Hash) ([]byte, error) {
+	dl.lock.RLock()
+	if dl.stale {
+		dl.lock.RUnlock()
+		return nil, ErrSnapshotStale
+	}
+	if data, ok := dl.accountData[hash]; ok {
+		dl.lock.RUnlock()
+		return data, nil
+	}
+	if _, ok := dl.destructSet[hash]; ok {
+		dl.lock.RUnlock()
+		return nil, nil
+	}
+	parent := dl.parent
+	dl.lock.RUnlock()
+
+	return parent.AccountRLP(hash)
+}
+
+// Storage directly retrieves the storage data associated with a particular hash,
+// within a particular account. If the slot is not changed in this layer, the
+// lookup is delegated to the parent.
+func (dl *diffLayer) Storage(accountHash, storageHash common.Hash) ([]byte, error) {
+	dl.lock.RLock()
+	if dl.stale {
+		dl.lock.RUnlock()
+		return nil, ErrSnapshotStale
+	}
+	if storage, ok := dl.storageData[accountHash]; ok {
+		if data, ok := storage[storageHash]; ok {
+			dl.lock.RUnlock()
+			return data, nil
+		}
+	}
+	if _, ok := dl.destructSet[accountHash]; ok {
+		dl.lock.RUnlock()
+		return nil, nil
+	}
+	parent := dl.parent
+	dl.lock.RUnlock()
+
+	return parent.Storage(accountHash, storageHash)
+}
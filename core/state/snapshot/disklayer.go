@@ -0,0 +1,244 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// Schema layout of the persistent snapshot.
+var (
+	snapshotRootKey      = []byte("SnapshotRoot")      // State root the persisted snapshot belongs to
+	snapshotGeneratorKey = []byte("SnapshotGenerator") // Progress of the snapshot generation, absent if done
+
+	accountSnapshotPrefix = []byte("a") // accountSnapshotPrefix + account hash -> slim account RLP
+	storageSnapshotPrefix = []byte("o") // storageSnapshotPrefix + account hash + storage hash -> slot RLP
+)
+
+// accountSnapshotKey = accountSnapshotPrefix + hash
+func accountSnapshotKey(hash common.Hash) []byte {
+	return append(append([]byte{}, accountSnapshotPrefix...), hash[:]...)
+}
+
+// storageSnapshotKey = storageSnapshotPrefix + account hash + storage hash
+func storageSnapshotKey(accountHash, storageHash common.Hash) []byte {
+	return append(append(append([]byte{}, storageSnapshotPrefix...), accountHash[:]...), storageHash[:]...)
+}
+
+// diskLayer is the persistent snapshot at the bottom of the layer tree. While the
+// snapshot is being generated, only the accounts up to (and including) the
+// generation marker are available, along with their storage.
+type diskLayer struct {
+	diskdb ethdb.Database // Key-value store containing the flat snapshot
+	triedb *trie.Database // Trie node database to generate the snapshot from
+	root   common.Hash    // Root hash of the base snapshot
+	stale  bool           // Signals that the layer became stale (state progressed)
+
+	genMarker  []byte             // Last generated account hash, empty if none yet, nil if done
+	genAbort   chan chan struct{} // Notification channel to abort the running generator
+	genPending chan struct{}      // Notification channel closed when the generator terminates
+
+	lock sync.RWMutex
+}
+
+// Root returns the root hash for which this snapshot was made.
+func (dl *diskLayer) Root() common.Hash {
+	return dl.root
+}
+
+// Parent always returns nil as there's no layer below the disk.
+func (dl *diskLayer) Parent() snapshot {
+	return nil
+}
+
+// Stale returns whether this layer has become stale (was flattened across) or
+// if it's still live.
+func (dl *diskLayer) Stale() bool {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	return dl.stale
+}
+
+// markStale flags the layer as stale, failing all subsequent reads.
+func (dl *diskLayer) markStale() {
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	dl.stale = true
+}
+
+// covered reports whether the data of an account is already generated. The lock
+// must be held.
+func (dl *diskLayer) covered(hash common.Hash) bool {
+	return dl.genMarker == nil || bytes.Compare(hash[:], dl.genMarker) <= 0
+}
+
+// Account directly retrieves the account associated with a particular hash in
+// the snapshot slim data format.
+func (dl *diskLayer) Account(hash common.Hash) (*Account, error) {
+	data, err := dl.AccountRLP(hash)
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	account := new(Account)
+	if err := rlp.DecodeBytes(data, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// AccountRLP directly retrieves the account RLP associated with a particular
+// hash in the snapshot slim data format.
+func (dl *diskLayer) AccountRLP(hash common.Hash) ([]byte, error) {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	if dl.stale {
+		return nil, ErrSnapshotStale
+	}
+	if !dl.covered(hash) {
+		return nil, ErrNotCoveredYet
+	}
+	data, _ := dl.diskdb.Get(accountSnapshotKey(hash))
+	return data, nil
+}
+
+// Storage directly retrieves the storage data associated with a particular hash,
+// within a particular account.
+func (dl *diskLayer) Storage(accountHash, storageHash common.Hash) ([]byte, error) {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	if dl.stale {
+		return nil, ErrSnapshotStale
+	}
+	if !dl.covered(accountHash) {
+		return nil, ErrNotCoveredYet
+	}
+	data, _ := dl.diskdb.Get(storageSnapshotKey(accountHash, storageHash))
+	return data, nil
+}
+
+// flatten writes the changes of a diff layer on top of the disk layer into the
+// database, returning a new disk layer for the diff's root. Changes of accounts
+// not generated yet are skipped, the resumed generator picks them up from the
+// new root instead.
+func (dl *diskLayer) flatten(diff *diffLayer) (*diskLayer, error) {
+	dl.stopGeneration()
+
+	dl.lock.Lock()
+	dl.stale = true
+	marker := dl.genMarker
+	dl.lock.Unlock()
+
+	covered := func(hash common.Hash) bool {
+		return marker == nil || bytes.Compare(hash[:], marker) <= 0
+	}
+	// Invalidate the persisted snapshot until all the changes are written, so a
+	// crash mid-way is detected on startup
+	if err := dl.diskdb.Delete(snapshotRootKey); err != nil {
+		return nil, err
+	}
+	batch := dl.diskdb.NewBatch()
+	flush := func() error {
+		if batch.ValueSize() < ethdb.IdealBatchSize {
+			return nil
+		}
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		batch.Reset()
+		return nil
+	}
+	for hash := range diff.destructSet {
+		if !covered(hash) {
+			continue
+		}
+		batch.Delete(accountSnapshotKey(hash))
+		if err := wipeStorage(dl.diskdb, batch, hash); err != nil {
+			return nil, err
+		}
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+	for hash, data := range diff.accountData {
+		if !covered(hash) {
+			continue
+		}
+		if len(data) == 0 {
+			batch.Delete(accountSnapshotKey(hash))
+		} else {
+			batch.Put(accountSnapshotKey(hash), data)
+		}
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+	for accountHash, storage := range diff.storageData {
+		if !covered(accountHash) {
+			continue
+		}
+		for storageHash, data := range storage {
+			if len(data) == 0 {
+				batch.Delete(storageSnapshotKey(accountHash, storageHash))
+			} else {
+				batch.Put(storageSnapshotKey(accountHash, storageHash), data)
+			}
+		}
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+	batch.Put(snapshotRootKey, diff.root[:])
+	if err := batch.Write(); err != nil {
+		return nil, err
+	}
+	base := &diskLayer{
+		diskdb:    dl.diskdb,
+		triedb:    dl.triedb,
+		root:      diff.root,
+		genMarker: marker,
+	}
+	if marker != nil {
+		base.startGeneration()
+	}
+	return base, nil
+}
+
+// wipeStorage adds the deletion of all the snapshot storage slots of an account
+// to a batch.
+func wipeStorage(db ethdb.Database, batch ethdb.Batch, accountHash common.Hash) error {
+	prefix := append(append([]byte{}, storageSnapshotPrefix...), accountHash[:]...)
+
+	it := db.NewIteratorWithPrefix(prefix)
+	defer it.Release()
+
+	for it.Next() {
+		if key := it.Key(); len(key) == len(prefix)+common.HashLength {
+			batch.Delete(common.CopyBytes(key))
+		}
+	}
+	return it.Error()
+}
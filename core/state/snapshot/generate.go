@@ -0,0 +1,244 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// errGenerationAborted is returned internally if the generator was interrupted.
+var errGenerationAborted = errors.New("generation aborted")
+
+// generatorStats is a collection of statistics gathered by the snapshot generator
+// for logging purposes.
+type generatorStats struct {
+	accounts uint64    // Number of accounts generated
+	slots    uint64    // Number of storage slots generated
+	start    time.Time // Timestamp when generation started
+	logged   time.Time // Timestamp when the last progress was reported
+}
+
+// startGeneration launches the background generation of the disk layer from the
+// state trie, resuming after the current marker.
+func (dl *diskLayer) startGeneration() {
+	dl.genAbort = make(chan chan struct{})
+	dl.genPending = make(chan struct{})
+
+	go dl.generate()
+}
+
+// stopGeneration aborts the background generation if it's still running and
+// waits for it to terminate. The progress made so far is persisted.
+func (dl *diskLayer) stopGeneration() {
+	if dl.genAbort == nil {
+		return
+	}
+	abort := make(chan struct{})
+	select {
+	case dl.genAbort <- abort:
+		<-abort
+	case <-dl.genPending:
+	}
+}
+
+// generate iterates the account trie of the disk layer's root, writing every
+// account and storage slot into the flat snapshot. Stale snapshot entries which
+// are not in the trie any more (e.g. from an earlier, interrupted generation at
+// a different root) are deleted along the way.
+func (dl *diskLayer) generate() {
+	defer close(dl.genPending)
+
+	dl.lock.RLock()
+	start := common.CopyBytes(dl.genMarker)
+	dl.lock.RUnlock()
+
+	stats := &generatorStats{start: time.Now(), logged: time.Now()}
+	if len(start) == 0 {
+		log.Info("Generating state snapshot", "root", dl.root)
+	} else {
+		log.Info("Resuming state snapshot generation", "root", dl.root, "at", common.BytesToHash(start))
+	}
+	err := dl.generateRange(start, stats)
+	switch err {
+	case nil:
+		log.Info("Generated state snapshot", "accounts", stats.accounts, "slots", stats.slots, "elapsed", common.PrettyDuration(time.Since(stats.start)))
+	case errGenerationAborted:
+		log.Debug("Aborted state snapshot generation", "root", dl.root)
+	default:
+		// The state might be garbage collected from under the generator if the
+		// chain progressed, in which case the next disk layer resumes.
+		log.Warn("Failed to generate state snapshot", "root", dl.root, "err", err)
+	}
+}
+
+// generateRange generates the snapshot of all the accounts after start.
+func (dl *diskLayer) generateRange(start []byte, stats *generatorStats) error {
+	accTrie, err := trie.NewSecure(dl.root, dl.triedb, 0)
+	if err != nil {
+		return err
+	}
+	var (
+		batch  = dl.diskdb.NewBatch()
+		accIt  = trie.NewIterator(accTrie.NodeIterator(start))
+		snapIt = dl.diskdb.NewIteratorWithStart(append(append([]byte{}, accountSnapshotPrefix...), start...))
+	)
+	defer snapIt.Release()
+
+	// nextStale moves the snapshot iterator to the next account entry
+	nextStale := func() []byte {
+		for snapIt.Next() {
+			key := snapIt.Key()
+			if !bytes.HasPrefix(key, accountSnapshotPrefix) {
+				return nil
+			}
+			if len(key) == len(accountSnapshotPrefix)+common.HashLength {
+				return common.CopyBytes(key[len(accountSnapshotPrefix):])
+			}
+		}
+		return nil
+	}
+	// wipeStale deletes all the snapshot accounts preceding the given one
+	stale := nextStale()
+	if stale != nil && bytes.Equal(stale, start) {
+		stale = nextStale() // Marker is inclusive, already generated
+	}
+	wipeStale := func(until []byte) error {
+		for stale != nil && (until == nil || bytes.Compare(stale, until) < 0) {
+			hash := common.BytesToHash(stale)
+			batch.Delete(accountSnapshotKey(hash))
+			if err := wipeStorage(dl.diskdb, batch, hash); err != nil {
+				return err
+			}
+			stale = nextStale()
+		}
+		if stale != nil && bytes.Equal(stale, until) {
+			stale = nextStale()
+		}
+		return nil
+	}
+	for accIt.Next() {
+		if bytes.Equal(accIt.Key, start) {
+			continue // Marker is inclusive, already generated
+		}
+		accountHash := common.BytesToHash(accIt.Key)
+		if err := wipeStale(accIt.Key); err != nil {
+			return err
+		}
+		var account struct {
+			Nonce    uint64
+			Balance  *big.Int
+			Root     common.Hash
+			CodeHash []byte
+		}
+		if err := rlp.DecodeBytes(accIt.Value, &account); err != nil {
+			return err
+		}
+		batch.Put(accountSnapshotKey(accountHash), AccountRLP(account.Nonce, account.Balance, account.Root, account.CodeHash))
+		stats.accounts++
+
+		// Regenerate the storage of the account from scratch, even if some of it
+		// was already generated before an interruption
+		if err := wipeStorage(dl.diskdb, batch, accountHash); err != nil {
+			return err
+		}
+		if account.Root != emptyRoot {
+			storeTrie, err := trie.NewSecure(account.Root, dl.triedb, 0)
+			if err != nil {
+				return err
+			}
+			storeIt := trie.NewIterator(storeTrie.NodeIterator(nil))
+			for storeIt.Next() {
+				batch.Put(storageSnapshotKey(accountHash, common.BytesToHash(storeIt.Key)), common.CopyBytes(storeIt.Value))
+				stats.slots++
+
+				// Flush huge storage tries without moving the marker
+				if batch.ValueSize() > ethdb.IdealBatchSize {
+					if err := dl.checkAndFlush(batch, nil, stats); err != nil {
+						return err
+					}
+				}
+			}
+			if storeIt.Err != nil {
+				return storeIt.Err
+			}
+		}
+		if batch.ValueSize() > ethdb.IdealBatchSize {
+			if err := dl.checkAndFlush(batch, accountHash[:], stats); err != nil {
+				return err
+			}
+		}
+	}
+	if accIt.Err != nil {
+		return accIt.Err
+	}
+	if err := wipeStale(nil); err != nil {
+		return err
+	}
+	// Generation finished, persist the last batch and drop the marker
+	batch.Delete(snapshotGeneratorKey)
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	dl.lock.Lock()
+	dl.genMarker = nil
+	dl.lock.Unlock()
+
+	return nil
+}
+
+// checkAndFlush writes the accumulated batch to disk, also moving the generation
+// marker forward if a new one is given. Before doing so, it checks whether the
+// generator was requested to stop.
+func (dl *diskLayer) checkAndFlush(batch ethdb.Batch, marker []byte, stats *generatorStats) error {
+	var abort chan struct{}
+	select {
+	case abort = <-dl.genAbort:
+	default:
+	}
+	if marker != nil {
+		enc, _ := rlp.EncodeToBytes(marker)
+		batch.Put(snapshotGeneratorKey, enc)
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	batch.Reset()
+
+	if marker != nil {
+		dl.lock.Lock()
+		dl.genMarker = common.CopyBytes(marker)
+		dl.lock.Unlock()
+	}
+	if abort != nil {
+		close(abort)
+		return errGenerationAborted
+	}
+	if time.Since(stats.logged) > 8*time.Second {
+		log.Info("Generating state snapshot", "at", common.BytesToHash(marker), "accounts", stats.accounts, "slots", stats.slots, "elapsed", common.PrettyDuration(time.Since(stats.start)))
+		stats.logged = time.Now()
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// testState is a state trie committed to a database, along with the snapshot
+// entries expected to be generated from it.
+type testState struct {
+	root     common.Hash
+	accounts map[common.Hash][]byte
+	storage  map[common.Hash]map[common.Hash][]byte
+}
+
+// makeTestState creates a state of the given number of accounts, every third
+// having a few storage slots, and commits it to the database.
+func makeTestState(t *testing.T, db ethdb.Database, accounts int) *testState {
+	triedb := trie.NewDatabase(db)
+	accTrie, _ := trie.NewSecure(common.Hash{}, triedb, 0)
+
+	state := &testState{
+		accounts: make(map[common.Hash][]byte),
+		storage:  make(map[common.Hash]map[common.Hash][]byte),
+	}
+	for i := 0; i < accounts; i++ {
+		var (
+			key  = common.BigToHash(big.NewInt(int64(i)))
+			hash = crypto.Keccak256Hash(key[:])
+			root = emptyRoot
+		)
+		if i%3 == 0 {
+			storeTrie, _ := trie.NewSecure(common.Hash{}, triedb, 0)
+			state.storage[hash] = make(map[common.Hash][]byte)
+			for j := 0; j < 10; j++ {
+				slot := common.BigToHash(big.NewInt(int64(j)))
+				value, _ := rlp.EncodeToBytes(big.NewInt(int64(i*j + 1)))
+				storeTrie.Update(slot[:], value)
+				state.storage[hash][crypto.Keccak256Hash(slot[:])] = value
+			}
+			root, _ = storeTrie.Commit(nil)
+			if err := triedb.Commit(root, false); err != nil {
+				t.Fatalf("failed to commit storage: %v", err)
+			}
+		}
+		account, _ := rlp.EncodeToBytes([]interface{}{uint64(i), big.NewInt(int64(i)), root, emptyCode[:]})
+		accTrie.Update(key[:], account)
+		state.accounts[hash] = AccountRLP(uint64(i), big.NewInt(int64(i)), root, emptyCode[:])
+	}
+	state.root, _ = accTrie.Commit(nil)
+	if err := triedb.Commit(state.root, false); err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	return state
+}
+
+// checkSnapshot waits for the generation of a disk layer and verifies that it
+// contains exactly the given state.
+func checkSnapshot(t *testing.T, db ethdb.Database, tree *Tree, state *testState) {
+	t.Helper()
+
+	disk, ok := tree.Snapshot(state.root).(*diskLayer)
+	if !ok {
+		t.Fatalf("no disk layer for root %x", state.root)
+	}
+	<-disk.genPending
+	if disk.genMarker != nil {
+		t.Fatalf("generation not finished, marker %x", disk.genMarker)
+	}
+	if _, err := db.Get(snapshotGeneratorKey); err == nil {
+		t.Errorf("generator marker persisted after generation")
+	}
+	accounts, slots := 0, 0
+	for it := db.NewIteratorWithPrefix(accountSnapshotPrefix); it.Next(); {
+		if len(it.Key()) == len(accountSnapshotPrefix)+common.HashLength {
+			accounts++
+		}
+	}
+	for it := db.NewIteratorWithPrefix(storageSnapshotPrefix); it.Next(); {
+		if len(it.Key()) == len(storageSnapshotPrefix)+2*common.HashLength {
+			slots++
+		}
+	}
+	want := 0
+	for hash, data := range state.accounts {
+		checkAccount(t, disk, hash, data)
+		for slot, value := range state.storage[hash] {
+			checkStorage(t, disk, hash, slot, value)
+		}
+		want += len(state.storage[hash])
+	}
+	if accounts != len(state.accounts) {
+		t.Errorf("account count mismatch: have %d, want %d", accounts, len(state.accounts))
+	}
+	if slots != want {
+		t.Errorf("slot count mismatch: have %d, want %d", slots, want)
+	}
+}
+
+// Tests that a snapshot is generated from scratch if there's none, and that the
+// leftovers of an older snapshot are wiped in the process.
+func TestGeneration(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	state := makeTestState(t, db, 1000)
+
+	// Insert some junk of a previous snapshot at a different root
+	stale := common.HexToHash("0xdead")
+	db.Put(snapshotRootKey, common.HexToHash("0x01").Bytes())
+	db.Put(accountSnapshotKey(stale), testAccount(1))
+	db.Put(storageSnapshotKey(stale, stale), []byte{0x01})
+	for hash := range state.storage {
+		db.Put(storageSnapshotKey(hash, stale), []byte{0x01})
+		break
+	}
+	tree := New(db, trie.NewDatabase(db), state.root)
+	checkSnapshot(t, db, tree, state)
+}
+
+// Tests that an interrupted generation is resumed after its persisted marker,
+// regenerating all the accounts not covered yet.
+func TestGenerationResume(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	state := makeTestState(t, db, 1000)
+
+	// Generate the snapshot completely, then rewind the marker to the middle,
+	// corrupting the data of the uncovered accounts
+	tree := New(db, trie.NewDatabase(db), state.root)
+	checkSnapshot(t, db, tree, state)
+
+	marker := common.HexToHash("0x8000000000000000000000000000000000000000000000000000000000000000")
+	enc, _ := rlp.EncodeToBytes(marker[:])
+	db.Put(snapshotGeneratorKey, enc)
+	for hash := range state.accounts {
+		if hash.Big().Cmp(marker.Big()) > 0 {
+			db.Put(accountSnapshotKey(hash), testAccount(12345))
+		}
+	}
+	tree = New(db, trie.NewDatabase(db), state.root)
+	checkSnapshot(t, db, tree, state)
+}
@@ -0,0 +1,319 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package snapshot implements a flat, hash-keyed view of the Ethereum state.
+//
+// The snapshot consists of a persistent disk layer at some older state root and
+// a tree of in-memory diff layers on top of it, one per block. Accounts and
+// storage slots can be retrieved with a single database lookup, instead of a walk
+// through the state trie.
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+var (
+	// emptyRoot is the known root hash of an empty trie.
+	emptyRoot = common.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+
+	// emptyCode is the known hash of the empty EVM bytecode.
+	emptyCode = crypto.Keccak256Hash(nil)
+)
+
+var (
+	// ErrSnapshotStale is returned from data accessors if the underlying snapshot
+	// layer had been invalidated due to the chain progressing forward far enough
+	// to not maintain the layer's original state.
+	ErrSnapshotStale = errors.New("snapshot stale")
+
+	// ErrNotCoveredYet is returned from data accessors if the underlying snapshot
+	// is being generated currently and the requested data item is not yet in the
+	// range of accounts covered.
+	ErrNotCoveredYet = errors.New("not covered yet")
+
+	// errSnapshotCycle is returned if a snapshot is attempted to be inserted
+	// that forms a cycle in the snapshot tree.
+	errSnapshotCycle = errors.New("snapshot cycle")
+)
+
+// Snapshot represents the functionality supported by a snapshot storage layer.
+type Snapshot interface {
+	// Root returns the root hash for which this snapshot was made.
+	Root() common.Hash
+
+	// Account directly retrieves the account associated with a particular hash in
+	// the snapshot slim data format. A nil account means it doesn't exist.
+	Account(hash common.Hash) (*Account, error)
+
+	// AccountRLP directly retrieves the account RLP associated with a particular
+	// hash in the snapshot slim data format.
+	AccountRLP(hash common.Hash) ([]byte, error)
+
+	// Storage directly retrieves the storage data associated with a particular hash,
+	// within a particular account. The data is RLP encoded, the same as in the trie.
+	Storage(accountHash, storageHash common.Hash) ([]byte, error)
+}
+
+// snapshot is the internal version of the snapshot data layer that supports some
+// additional methods compared to the public API.
+type snapshot interface {
+	Snapshot
+
+	// Parent returns the subsequent layer of a snapshot, or nil if the base was
+	// reached.
+	Parent() snapshot
+
+	// Stale returns whether this layer has become stale (was flattened across) or
+	// if it's still live.
+	Stale() bool
+
+	// markStale flags the layer as stale, failing all subsequent reads.
+	markStale()
+}
+
+// Tree is an Ethereum state snapshot tree. It consists of one persistent base
+// layer backed by a key-value store, on top of which arbitrarily many in-memory
+// diff layers are topped. The memory diffs can form a tree with branching, but
+// the disk layer is singleton and common to all. If a reorg goes deeper than the
+// disk layer, everything needs to be regenerated.
+//
+// The goal of the state snapshot is to allow direct access to account and storage
+// data, avoiding expensive multi-level trie lookups.
+type Tree struct {
+	diskdb ethdb.Database           // Persistent database to store the snapshot
+	triedb *trie.Database           // In-memory cache to access the trie through
+	layers map[common.Hash]snapshot // Collection of all known layers
+	lock   sync.RWMutex
+}
+
+// New attempts to load an already existing snapshot from a persistent key-value
+// store, ensuring that the head of the snapshot matches the expected one.
+//
+// If the snapshot is missing or inconsistent, the entirety is deleted and will
+// be reconstructed from scratch based on the tries in the key-value store, on a
+// background thread.
+func New(diskdb ethdb.Database, triedb *trie.Database, root common.Hash) *Tree {
+	base, err := loadSnapshot(diskdb, triedb, root)
+	if err != nil {
+		log.Warn("Failed to load state snapshot, regenerating", "err", err)
+		base = generateSnapshot(diskdb, triedb, root)
+	}
+	return &Tree{
+		diskdb: diskdb,
+		triedb: triedb,
+		layers: map[common.Hash]snapshot{root: base},
+	}
+}
+
+// loadSnapshot loads the persisted disk layer, resuming its generation if it
+// was interrupted.
+func loadSnapshot(diskdb ethdb.Database, triedb *trie.Database, root common.Hash) (*diskLayer, error) {
+	blob, err := diskdb.Get(snapshotRootKey)
+	if err != nil || len(blob) != common.HashLength {
+		return nil, errors.New("missing or corrupted snapshot")
+	}
+	if have := common.BytesToHash(blob); have != root {
+		return nil, fmt.Errorf("head doesn't match snapshot: have %#x, want %#x", have, root)
+	}
+	base := &diskLayer{
+		diskdb: diskdb,
+		triedb: triedb,
+		root:   root,
+	}
+	if enc, err := diskdb.Get(snapshotGeneratorKey); err == nil && len(enc) > 0 {
+		var marker []byte
+		if err := rlp.DecodeBytes(enc, &marker); err != nil {
+			return nil, fmt.Errorf("corrupted snapshot generator marker: %v", err)
+		}
+		if marker == nil {
+			marker = []byte{}
+		}
+		base.genMarker = marker
+		base.startGeneration()
+	}
+	return base, nil
+}
+
+// generateSnapshot creates a new disk layer for the given root and starts to
+// generate it from the state trie in the background.
+func generateSnapshot(diskdb ethdb.Database, triedb *trie.Database, root common.Hash) *diskLayer {
+	// Mark the generation as started at the very beginning, any data already in
+	// the database is overwritten or wiped by the generator
+	enc, _ := rlp.EncodeToBytes([]byte{})
+
+	batch := diskdb.NewBatch()
+	batch.Put(snapshotRootKey, root[:])
+	batch.Put(snapshotGeneratorKey, enc)
+	if err := batch.Write(); err != nil {
+		log.Error("Failed to reset state snapshot", "err", err)
+	}
+	base := &diskLayer{
+		diskdb:    diskdb,
+		triedb:    triedb,
+		root:      root,
+		genMarker: []byte{},
+	}
+	base.startGeneration()
+	return base
+}
+
+// Snapshot retrieves a snapshot belonging to the given state root, or nil if no
+// snapshot is maintained for that root.
+func (t *Tree) Snapshot(root common.Hash) Snapshot {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if snap, ok := t.layers[root]; ok {
+		return snap
+	}
+	return nil
+}
+
+// Update adds a new snapshot into the tree, if that can be linked to an existing
+// old parent. It is disallowed to insert a disk layer (the origin of all).
+func (t *Tree) Update(blockRoot common.Hash, parentRoot common.Hash, destructs map[common.Hash]struct{}, accounts map[common.Hash][]byte, storage map[common.Hash]map[common.Hash][]byte) error {
+	// Reject noop updates to avoid self-loops in the snapshot tree
+	if blockRoot == parentRoot {
+		return errSnapshotCycle
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// The same state might be reached through different blocks, keep the first
+	if _, ok := t.layers[blockRoot]; ok {
+		return nil
+	}
+	parent, ok := t.layers[parentRoot]
+	if !ok {
+		return fmt.Errorf("parent [%#x] snapshot missing", parentRoot)
+	}
+	t.layers[blockRoot] = newDiffLayer(parent, blockRoot, destructs, accounts, storage)
+	return nil
+}
+
+// Cap traverses downwards the snapshot tree from a head block hash until the
+// number of allowed layers are crossed. All layers beyond the permitted number
+// are flattened downwards into the disk layer. Layers of other branches, which
+// are not built on top of the new disk layer any more, are dropped.
+func (t *Tree) Cap(root common.Hash, layers int) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	snap, ok := t.layers[root]
+	if !ok {
+		return fmt.Errorf("snapshot [%#x] missing", root)
+	}
+	// Collect the diff layers from the head down to the disk layer
+	var diffs []*diffLayer
+	for layer := snap; ; {
+		diff, ok := layer.(*diffLayer)
+		if !ok {
+			break
+		}
+		diffs = append(diffs, diff)
+		layer = diff.Parent()
+	}
+	if len(diffs) <= layers {
+		return nil
+	}
+	for len(diffs) > layers {
+		if err := t.persist(diffs[len(diffs)-1]); err != nil {
+			return err
+		}
+		diffs = diffs[:len(diffs)-1]
+	}
+	t.prune()
+	return nil
+}
+
+// persist flattens the bottom-most diff layer into the disk layer, replacing
+// both of them with a new disk layer. The lock must be held.
+func (t *Tree) persist(bottom *diffLayer) error {
+	disk := bottom.Parent().(*diskLayer)
+
+	base, err := disk.flatten(bottom)
+	if err != nil {
+		return err
+	}
+	bottom.markStale()
+
+	delete(t.layers, disk.root)
+	t.layers[base.root] = base
+
+	// Relink the children of the persisted layer to the new disk layer
+	for _, layer := range t.layers {
+		if diff, ok := layer.(*diffLayer); ok && diff.Parent() == snapshot(bottom) {
+			diff.setParent(base)
+		}
+	}
+	return nil
+}
+
+// prune drops all the layers which are not built on top of the current disk
+// layer any more. The lock must be held.
+func (t *Tree) prune() {
+	for root, layer := range t.layers {
+		base := layer
+		for base.Parent() != nil {
+			base = base.Parent()
+		}
+		if base.Stale() {
+			layer.markStale()
+			delete(t.layers, root)
+		}
+	}
+}
+
+// Rebuild wipes all available snapshot data from memory and regenerates the
+// disk layer from the state trie of the given root in the background.
+func (t *Tree) Rebuild(root common.Hash) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, layer := range t.layers {
+		if disk, ok := layer.(*diskLayer); ok {
+			disk.stopGeneration()
+		}
+		layer.markStale()
+	}
+	log.Info("Rebuilding state snapshot", "root", root)
+	t.layers = map[common.Hash]snapshot{
+		root: generateSnapshot(t.diskdb, t.triedb, root),
+	}
+}
+
+// Stop aborts the background generation of the disk layer, if running. Its
+// progress is persisted and resumed on the next startup.
+func (t *Tree) Stop() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, layer := range t.layers {
+		if disk, ok := layer.(*diskLayer); ok {
+			disk.stopGeneration()
+		}
+	}
+}
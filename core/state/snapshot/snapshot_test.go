@@ -0,0 +1,183 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// testAccount generates a slim account RLP with the given nonce.
+func testAccount(nonce uint64) []byte {
+	return AccountRLP(nonce, big.NewInt(int64(nonce)), emptyRoot, nil)
+}
+
+// newTestTree creates a snapshot tree with a fully generated, empty disk layer.
+func newTestTree(root common.Hash) (*Tree, ethdb.Database) {
+	db, _ := ethdb.NewMemDatabase()
+	db.Put(snapshotRootKey, root[:])
+
+	return New(db, trie.NewDatabase(db), root), db
+}
+
+// checkAccount verifies the account of a snapshot layer.
+func checkAccount(t *testing.T, snap Snapshot, hash common.Hash, want []byte) {
+	t.Helper()
+
+	have, err := snap.AccountRLP(hash)
+	if err != nil {
+		t.Fatalf("layer %x: failed to retrieve account %x: %v", snap.Root(), hash, err)
+	}
+	if !bytes.Equal(have, want) {
+		t.Errorf("layer %x: account %x mismatch: have %x, want %x", snap.Root(), hash, have, want)
+	}
+}
+
+// checkStorage verifies a storage slot of a snapshot layer.
+func checkStorage(t *testing.T, snap Snapshot, account, slot common.Hash, want []byte) {
+	t.Helper()
+
+	have, err := snap.Storage(account, slot)
+	if err != nil {
+		t.Fatalf("layer %x: failed to retrieve slot %x/%x: %v", snap.Root(), account, slot, err)
+	}
+	if !bytes.Equal(have, want) {
+		t.Errorf("layer %x: slot %x/%x mismatch: have %x, want %x", snap.Root(), account, slot, have, want)
+	}
+}
+
+// Tests that diff layers shadow their parents, including destructed accounts
+// whose storage is wiped.
+func TestDiffLayerLookups(t *testing.T) {
+	var (
+		base   = common.HexToHash("0x01")
+		first  = common.HexToHash("0x02")
+		second = common.HexToHash("0x03")
+
+		acc1 = common.HexToHash("0xa1")
+		acc2 = common.HexToHash("0xa2")
+		slot = common.HexToHash("0xb1")
+	)
+	tree, db := newTestTree(base)
+	db.Put(accountSnapshotKey(acc1), testAccount(1))
+	db.Put(storageSnapshotKey(acc1, slot), []byte{0x01})
+
+	// Modify the storage of acc1 in the first layer, create acc2
+	if err := tree.Update(first, base, nil, map[common.Hash][]byte{acc2: testAccount(2)}, map[common.Hash]map[common.Hash][]byte{acc1: {slot: []byte{0x02}}}); err != nil {
+		t.Fatalf("failed to create first layer: %v", err)
+	}
+	// Destruct acc1 in the second layer
+	if err := tree.Update(second, first, map[common.Hash]struct{}{acc1: {}}, nil, nil); err != nil {
+		t.Fatalf("failed to create second layer: %v", err)
+	}
+	checkAccount(t, tree.Snapshot(base), acc1, testAccount(1))
+	checkAccount(t, tree.Snapshot(base), acc2, nil)
+	checkStorage(t, tree.Snapshot(base), acc1, slot, []byte{0x01})
+
+	checkAccount(t, tree.Snapshot(first), acc1, testAccount(1))
+	checkAccount(t, tree.Snapshot(first), acc2, testAccount(2))
+	checkStorage(t, tree.Snapshot(first), acc1, slot, []byte{0x02})
+
+	checkAccount(t, tree.Snapshot(second), acc1, nil)
+	checkAccount(t, tree.Snapshot(second), acc2, testAccount(2))
+	checkStorage(t, tree.Snapshot(second), acc1, slot, nil)
+
+	// Noop and unlinked updates must be rejected
+	if err := tree.Update(second, second, nil, nil, nil); err != errSnapshotCycle {
+		t.Errorf("cyclic update error mismatch: have %v, want %v", err, errSnapshotCycle)
+	}
+	if err := tree.Update(common.HexToHash("0x04"), common.HexToHash("0x05"), nil, nil, nil); err == nil {
+		t.Errorf("unlinked update accepted")
+	}
+}
+
+// Tests that capping the tree flattens the bottom layers into the disk, marks
+// them stale and drops the layers of other branches.
+func TestTreeCap(t *testing.T) {
+	var (
+		base  = common.HexToHash("0x01")
+		a1    = common.HexToHash("0x02")
+		a2    = common.HexToHash("0x03")
+		a3    = common.HexToHash("0x04")
+		b1    = common.HexToHash("0x05")
+		acc   = common.HexToHash("0xa1")
+		other = common.HexToHash("0xa2")
+		slot  = common.HexToHash("0xb1")
+	)
+	tree, db := newTestTree(base)
+	db.Put(accountSnapshotKey(other), testAccount(100))
+	db.Put(storageSnapshotKey(other, slot), []byte{0x01})
+
+	tree.Update(a1, base, nil, map[common.Hash][]byte{acc: testAccount(1)}, map[common.Hash]map[common.Hash][]byte{acc: {slot: []byte{0x01}}})
+	tree.Update(a2, a1, map[common.Hash]struct{}{other: {}}, map[common.Hash][]byte{acc: testAccount(2)}, nil)
+	tree.Update(a3, a2, nil, map[common.Hash][]byte{acc: testAccount(3)}, map[common.Hash]map[common.Hash][]byte{acc: {slot: nil}})
+	tree.Update(b1, base, nil, map[common.Hash][]byte{acc: testAccount(10)}, nil)
+
+	// Capping within the allowance should be a noop
+	if err := tree.Cap(a3, 3); err != nil {
+		t.Fatalf("failed to cap tree: %v", err)
+	}
+	if snap := tree.Snapshot(b1); snap == nil {
+		t.Fatalf("side branch dropped by noop cap")
+	}
+	// Flatten two layers into the disk
+	a1snap, b1snap := tree.Snapshot(a1), tree.Snapshot(b1)
+	if err := tree.Cap(a3, 1); err != nil {
+		t.Fatalf("failed to cap tree: %v", err)
+	}
+	if len(tree.layers) != 2 {
+		t.Errorf("layer count mismatch: have %d, want %d", len(tree.layers), 2)
+	}
+	for _, root := range []common.Hash{base, a1, b1} {
+		if tree.Snapshot(root) != nil {
+			t.Errorf("layer %x not dropped", root)
+		}
+	}
+	if _, err := a1snap.AccountRLP(acc); err != ErrSnapshotStale {
+		t.Errorf("flattened layer error mismatch: have %v, want %v", err, ErrSnapshotStale)
+	}
+	if _, err := b1snap.AccountRLP(acc); err != ErrSnapshotStale {
+		t.Errorf("side branch error mismatch: have %v, want %v", err, ErrSnapshotStale)
+	}
+	disk, ok := tree.Snapshot(a2).(*diskLayer)
+	if !ok {
+		t.Fatalf("flattened layer is not a disk layer: %T", tree.Snapshot(a2))
+	}
+	if enc, _ := db.Get(snapshotRootKey); common.BytesToHash(enc) != a2 {
+		t.Errorf("persisted root mismatch: have %x, want %x", enc, a2)
+	}
+	checkAccount(t, disk, acc, testAccount(2))
+	checkAccount(t, disk, other, nil)
+	checkStorage(t, disk, acc, slot, []byte{0x01})
+	checkStorage(t, disk, other, slot, nil)
+
+	checkAccount(t, tree.Snapshot(a3), acc, testAccount(3))
+	checkStorage(t, tree.Snapshot(a3), acc, slot, nil)
+
+	// Flatten everything and reload the tree from disk
+	if err := tree.Cap(a3, 0); err != nil {
+		t.Fatalf("failed to flatten tree: %v", err)
+	}
+	tree = New(db, trie.NewDatabase(db), a3)
+	checkAccount(t, tree.Snapshot(a3), acc, testAccount(3))
+	checkStorage(t, tree.Snapshot(a3), acc, slot, nil)
+}
@@ -77,8 +77,9 @@ type stateObject struct {
 	trie Trie // storage trie, which becomes non-nil on first access
 	code Code // contract bytecode, which gets set when code is loaded
 
-	cachedStorage Storage // Storage entry cache to avoid duplicate reads
-	dirtyStorage  Storage // Storage entries that need to be flushed to disk
+	originStorage Storage // Storage entries as of the last finalised transaction, cached to avoid duplicate reads
+	dirtyStorage  Storage // Storage entries modified since, that need to be flushed to disk
+	fakeStorage   Storage // Fake storage constructed by the caller for debugging purposes
 
 	// Cache flags.
 	// When an object is marked suicided it will be delete from the trie
@@ -117,7 +118,7 @@ func newObject(db *StateDB, address common.Address, data Account, onDirty func(a
 		address:       address,
 		addrHash:      crypto.Keccak256Hash(address[:]),
 		data:          data,
-		cachedStorage: make(Storage),
+		originStorage: make(Storage),
 		dirtyStorage:  make(Storage),
 		onDirty:       onDirty,
 	}
@@ -170,15 +171,53 @@ func (c *stateObject) getTrie(db Database) Trie {
 
 // GetState returns a value in account storage.
 func (self *stateObject) GetState(db Database, key common.Hash) common.Hash {
-	value, exists := self.cachedStorage[key]
+	// If the fake storage is set, only lookup the state there (debugging mode)
+	if self.fakeStorage != nil {
+		return self.fakeStorage[key]
+	}
+	// If the entry was modified in the current transaction, return that
+	if value, dirty := self.dirtyStorage[key]; dirty {
+		return value
+	}
+	// Otherwise return the entry's original value
+	return self.GetCommittedState(db, key)
+}
+
+// GetCommittedState returns a value in account storage as of the last finalised
+// transaction, ignoring the modifications of the current one.
+func (self *stateObject) GetCommittedState(db Database, key common.Hash) common.Hash {
+	// If the fake storage is set, only lookup the state there (debugging mode)
+	if self.fakeStorage != nil {
+		return self.fakeStorage[key]
+	}
+	value, exists := self.originStorage[key]
 	if exists {
 		return value
 	}
-	// Load from DB in case it is missing.
-	enc, err := self.getTrie(db).TryGet(key[:])
-	if err != nil {
-		self.setError(err)
-		return common.Hash{}
+	// Load from the snapshot if available, unless the account was overwritten
+	// in the meantime, falling back to the trie.
+	var (
+		enc []byte
+		err error
+	)
+	snap := self.db.snap
+	if snap != nil {
+		if _, destructed := self.db.snapDestructs[self.addrHash]; destructed {
+			snap = nil
+		} else if enc, err = snap.Storage(self.addrHash, crypto.Keccak256Hash(key[:])); err != nil {
+			if self.db.historic {
+				// There are no tries to fall back to
+				self.setError(err)
+				return common.Hash{}
+			}
+			snap = nil
+		}
+	}
+	if snap == nil {
+		if enc, err = self.getTrie(db).TryGet(key[:]); err != nil {
+			self.setError(err)
+			return common.Hash{}
+		}
 	}
 	if len(enc) > 0 {
 		_, content, _, err := rlp.Split(enc)
@@ -187,9 +226,7 @@ func (self *stateObject) GetState(db Database, key common.Hash) common.Hash {
 		}
 		value.SetBytes(content)
 	}
-	if (value != common.Hash{}) {
-		self.cachedStorage[key] = value
-	}
+	self.originStorage[key] = value
 	return value
 }
 
@@ -203,8 +240,30 @@ func (self *stateObject) SetState(db Database, key, value common.Hash) {
 	self.setState(key, value)
 }
 
+// SetStorage replaces the entire state storage with the given one.
+//
+// After this function is called, all original state will be ignored and state
+// lookup only happens in the fake state storage.
+//
+// Note this function should only be used for debugging purpose.
+func (self *stateObject) SetStorage(storage map[common.Hash]common.Hash) {
+	// Allocate fake storage if it's nil.
+	if self.fakeStorage == nil {
+		self.fakeStorage = make(Storage)
+	}
+	for key, value := range storage {
+		self.fakeStorage[key] = value
+	}
+	// Don't bother journal since this function should only be used for
+	// debugging and the `fake` storage won't be committed to database.
+}
+
 func (self *stateObject) setState(key, value common.Hash) {
-	self.cachedStorage[key] = value
+	// If the fake storage is set, put the temporary state update there
+	if self.fakeStorage != nil {
+		self.fakeStorage[key] = value
+		return
+	}
 	self.dirtyStorage[key] = value
 
 	if self.onDirty != nil {
@@ -216,15 +275,42 @@ func (self *stateObject) setState(key, value common.Hash) {
 // updateTrie writes cached storage modifications into the object's storage trie.
 func (self *stateObject) updateTrie(db Database) Trie {
 	tr := self.getTrie(db)
+
+	// Collect the storage changes for the snapshot too, deletions as nil values
+	var storage map[common.Hash][]byte
+	if self.db.snap != nil && len(self.dirtyStorage) > 0 {
+		if storage = self.db.snapStorage[self.addrHash]; storage == nil {
+			storage = make(map[common.Hash][]byte)
+			self.db.snapStorage[self.addrHash] = storage
+		}
+	}
 	for key, value := range self.dirtyStorage {
 		delete(self.dirtyStorage, key)
+
+		// Skip noop changes, persist actual changes
+		if value == self.originStorage[key] {
+			continue
+		}
+		self.originStorage[key] = value
+
+		if self.db.history != nil {
+			prev, err := tr.TryGet(key[:])
+			self.setError(err)
+			self.db.recordSlot(self.addrHash, crypto.Keccak256Hash(key[:]), prev)
+		}
 		if (value == common.Hash{}) {
 			self.setError(tr.TryDelete(key[:]))
+			if storage != nil {
+				storage[crypto.Keccak256Hash(key[:])] = nil
+			}
 			continue
 		}
 		// Encoding []byte cannot fail, ok to ignore the error.
 		v, _ := rlp.EncodeToBytes(bytes.TrimLeft(value[:], "\x00"))
 		self.setError(tr.TryUpdate(key[:], v))
+		if storage != nil {
+			storage[crypto.Keccak256Hash(key[:])] = v
+		}
 	}
 	return tr
 }
@@ -299,7 +385,10 @@ func (self *stateObject) deepCopy(db *StateDB, onDirty func(addr common.Address)
 	}
 	stateObject.code = self.code
 	stateObject.dirtyStorage = self.dirtyStorage.Copy()
-	stateObject.cachedStorage = self.dirtyStorage.Copy()
+	stateObject.originStorage = self.originStorage.Copy()
+	if self.fakeStorage != nil {
+		stateObject.fakeStorage = self.fakeStorage.Copy()
+	}
 	stateObject.suicided = self.suicided
 	stateObject.dirtyCode = self.dirtyCode
 	stateObject.deleted = self.deleted
@@ -18,12 +18,14 @@
 package state
 
 import (
+	"errors"
 	"fmt"
 	"math/big"
 	"sort"
 	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
@@ -31,6 +33,14 @@ import (
 	"github.com/ethereum/go-ethereum/trie"
 )
 
+// proofList collects the encoded trie nodes of a Merkle proof in path order.
+type proofList [][]byte
+
+func (n *proofList) Put(key []byte, value []byte) error {
+	*n = append(*n, value)
+	return nil
+}
+
 type revision struct {
 	id           int
 	journalIndex int
@@ -53,6 +63,19 @@ type StateDB struct {
 	db   Database
 	trie Trie
 
+	// Flat state snapshot to read accounts and storage from before falling back
+	// to the tries, along with the changes to push into it on commit.
+	snaps         *snapshot.Tree
+	snap          snapshot.Snapshot
+	snapDestructs map[common.Hash]struct{}
+	snapAccounts  map[common.Hash][]byte
+	snapStorage   map[common.Hash]map[common.Hash][]byte
+
+	// Reverse diff of the changes committed to the tries, nil if not tracked, and
+	// whether the state is a read-only historic one without tries.
+	history  *StateHistory
+	historic bool
+
 	// This map holds 'live' objects, which will get modified while processing a state transition.
 	stateObjects      map[common.Address]*stateObject
 	stateObjectsDirty map[common.Address]struct{}
@@ -85,13 +108,46 @@ type StateDB struct {
 
 // Create a new state from a given trie.
 func New(root common.Hash, db Database) (*StateDB, error) {
+	return NewWithSnapshot(root, db, nil)
+}
+
+// NewWithSnapshot creates a new state from a given trie, reading accounts and
+// storage from the snapshot tree if it has a snapshot of the root. The state
+// changes are pushed into the snapshot tree on commit.
+func NewWithSnapshot(root common.Hash, db Database, snaps *snapshot.Tree) (*StateDB, error) {
 	tr, err := db.OpenTrie(root)
 	if err != nil {
 		return nil, err
 	}
+	sdb := &StateDB{
+		db:                db,
+		trie:              tr,
+		snaps:             snaps,
+		stateObjects:      make(map[common.Address]*stateObject),
+		stateObjectsDirty: make(map[common.Address]struct{}),
+		logs:              make(map[common.Hash][]*types.Log),
+		preimages:         make(map[common.Hash][]byte),
+	}
+	sdb.resetSnapshot(root)
+	return sdb, nil
+}
+
+// NewHistoric creates a read-only state whose tries might not exist any more.
+// All accounts and storage slots are retrieved from the given reader, tries are
+// never consulted and the state can't be committed.
+func NewHistoric(db Database, reader snapshot.Snapshot) (*StateDB, error) {
+	tr, err := db.OpenTrie(common.Hash{})
+	if err != nil {
+		return nil, err
+	}
 	return &StateDB{
 		db:                db,
 		trie:              tr,
+		snap:              reader,
+		snapDestructs:     make(map[common.Hash]struct{}),
+		snapAccounts:      make(map[common.Hash][]byte),
+		snapStorage:       make(map[common.Hash]map[common.Hash][]byte),
+		historic:          true,
 		stateObjects:      make(map[common.Address]*stateObject),
 		stateObjectsDirty: make(map[common.Address]struct{}),
 		logs:              make(map[common.Hash][]*types.Log),
@@ -99,6 +155,20 @@ func New(root common.Hash, db Database) (*StateDB, error) {
 	}, nil
 }
 
+// resetSnapshot retrieves the snapshot of the given root, if any, and clears the
+// collected snapshot changes.
+func (self *StateDB) resetSnapshot(root common.Hash) {
+	self.snap, self.snapDestructs, self.snapAccounts, self.snapStorage = nil, nil, nil, nil
+	if self.snaps == nil {
+		return
+	}
+	if self.snap = self.snaps.Snapshot(root); self.snap != nil {
+		self.snapDestructs = make(map[common.Hash]struct{})
+		self.snapAccounts = make(map[common.Hash][]byte)
+		self.snapStorage = make(map[common.Hash]map[common.Hash][]byte)
+	}
+}
+
 // setError remembers the first non-nil error it is called with.
 func (self *StateDB) setError(err error) {
 	if self.dbErr == nil {
@@ -126,6 +196,7 @@ func (self *StateDB) Reset(root common.Hash) error {
 	self.logs = make(map[common.Hash][]*types.Log)
 	self.logSize = 0
 	self.preimages = make(map[common.Hash][]byte)
+	self.resetSnapshot(root)
 	self.clearJournalAndRefund()
 	return nil
 }
@@ -173,6 +244,16 @@ func (self *StateDB) AddRefund(gas uint64) {
 	self.refund += gas
 }
 
+// SubRefund removes gas from the refund counter.
+// This method will panic if the refund counter goes below zero
+func (self *StateDB) SubRefund(gas uint64) {
+	self.journal = append(self.journal, refundChange{prev: self.refund})
+	if gas > self.refund {
+		panic("Refund counter below zero")
+	}
+	self.refund -= gas
+}
+
 // Exist reports whether the given account address exists in the state.
 // Notably this also returns true for suicided accounts.
 func (self *StateDB) Exist(addr common.Address) bool {
@@ -243,6 +324,34 @@ func (self *StateDB) GetState(a common.Address, b common.Hash) common.Hash {
 	return common.Hash{}
 }
 
+// GetCommittedState retrieves a value from the given account's storage as of
+// the start of the current transaction.
+func (self *StateDB) GetCommittedState(a common.Address, b common.Hash) common.Hash {
+	stateObject := self.getStateObject(a)
+	if stateObject != nil {
+		return stateObject.GetCommittedState(self.db, b)
+	}
+	return common.Hash{}
+}
+
+// GetProof returns the Merkle proof for a given account.
+func (self *StateDB) GetProof(a common.Address) ([][]byte, error) {
+	var proof proofList
+	err := self.trie.Prove(crypto.Keccak256(a.Bytes()), 0, &proof)
+	return [][]byte(proof), err
+}
+
+// GetStorageProof returns the Merkle proof for the given storage slot of an account.
+func (self *StateDB) GetStorageProof(a common.Address, key common.Hash) ([][]byte, error) {
+	var proof proofList
+	trie := self.StorageTrie(a)
+	if trie == nil {
+		return proof, errors.New("storage trie for requested address does not exist")
+	}
+	err := trie.Prove(crypto.Keccak256(key.Bytes()), 0, &proof)
+	return [][]byte(proof), err
+}
+
 // Database retrieves the low level database supporting the lower level trie ops.
 func (self *StateDB) Database() Database {
 	return self.db
@@ -315,6 +424,15 @@ func (self *StateDB) SetState(addr common.Address, key common.Hash, value common
 	}
 }
 
+// SetStorage replaces the entire storage for the specified account with given
+// storage. This function should only be used for debugging.
+func (self *StateDB) SetStorage(addr common.Address, storage map[common.Hash]common.Hash) {
+	stateObject := self.GetOrNewStateObject(addr)
+	if stateObject != nil {
+		stateObject.SetStorage(storage)
+	}
+}
+
 // Suicide marks the given account as suicided.
 // This clears the account balance.
 //
@@ -347,14 +465,31 @@ func (self *StateDB) updateStateObject(stateObject *stateObject) {
 	if err != nil {
 		panic(fmt.Errorf("can't encode object at %x: %v", addr[:], err))
 	}
+	if self.history != nil {
+		self.recordAccount(addr, stateObject.addrHash)
+	}
 	self.setError(self.trie.TryUpdate(addr[:], data))
+
+	if self.snap != nil {
+		self.snapAccounts[stateObject.addrHash] = snapshot.AccountRLP(stateObject.data.Nonce, stateObject.data.Balance, stateObject.data.Root, stateObject.data.CodeHash)
+	}
 }
 
 // deleteStateObject removes the given object from the state trie.
 func (self *StateDB) deleteStateObject(stateObject *stateObject) {
 	stateObject.deleted = true
 	addr := stateObject.Address()
+	if self.history != nil {
+		self.recordAccount(addr, stateObject.addrHash)
+		self.recordWipe(addr, stateObject.addrHash)
+	}
 	self.setError(self.trie.TryDelete(addr[:]))
+
+	if self.snap != nil {
+		self.snapDestructs[stateObject.addrHash] = struct{}{}
+		delete(self.snapAccounts, stateObject.addrHash)
+		delete(self.snapStorage, stateObject.addrHash)
+	}
 }
 
 // Retrieve a state object given my the address. Returns nil if not found.
@@ -367,19 +502,46 @@ func (self *StateDB) getStateObject(addr common.Address) (stateObject *stateObje
 		return obj
 	}
 
-	// Load the object from the database.
-	enc, err := self.trie.TryGet(addr[:])
-	if len(enc) == 0 {
-		self.setError(err)
-		return nil
+	// Load the object from the snapshot if available, falling back to the trie
+	var data *Account
+	if self.snap != nil {
+		acc, err := self.snap.Account(crypto.Keccak256Hash(addr[:]))
+		if err == nil {
+			if acc == nil {
+				return nil
+			}
+			data = &Account{
+				Nonce:    acc.Nonce,
+				Balance:  acc.Balance,
+				Root:     types.EmptyRootHash,
+				CodeHash: emptyCodeHash,
+			}
+			if len(acc.Root) > 0 {
+				data.Root = common.BytesToHash(acc.Root)
+			}
+			if len(acc.CodeHash) > 0 {
+				data.CodeHash = acc.CodeHash
+			}
+		} else if self.historic {
+			// There are no tries to fall back to
+			self.setError(err)
+			return nil
+		}
 	}
-	var data Account
-	if err := rlp.DecodeBytes(enc, &data); err != nil {
-		log.Error("Failed to decode state object", "addr", addr, "err", err)
-		return nil
+	if data == nil {
+		enc, err := self.trie.TryGet(addr[:])
+		if len(enc) == 0 {
+			self.setError(err)
+			return nil
+		}
+		data = new(Account)
+		if err := rlp.DecodeBytes(enc, data); err != nil {
+			log.Error("Failed to decode state object", "addr", addr, "err", err)
+			return nil
+		}
 	}
 	// Insert into the live set.
-	obj := newObject(self, addr, data, self.MarkStateObjectDirty)
+	obj := newObject(self, addr, *data, self.MarkStateObjectDirty)
 	self.setStateObject(obj)
 	return obj
 }
@@ -407,12 +569,24 @@ func (self *StateDB) MarkStateObjectDirty(addr common.Address) {
 // the given address, it is overwritten and returned as the second return value.
 func (self *StateDB) createObject(addr common.Address) (newobj, prev *stateObject) {
 	prev = self.getStateObject(addr)
+
+	// An overwritten account loses its storage, the snapshot must not serve it
+	var prevdestruct bool
+	if self.snap != nil && prev != nil {
+		_, prevdestruct = self.snapDestructs[prev.addrHash]
+		if !prevdestruct {
+			self.snapDestructs[prev.addrHash] = struct{}{}
+		}
+	}
+	if self.history != nil && prev != nil {
+		self.recordWipe(addr, prev.addrHash)
+	}
 	newobj = newObject(self, addr, Account{}, self.MarkStateObjectDirty)
 	newobj.setNonce(0) // sets the object to dirty
 	if prev == nil {
 		self.journal = append(self.journal, createObjectChange{account: &addr})
 	} else {
-		self.journal = append(self.journal, resetObjectChange{prev: prev})
+		self.journal = append(self.journal, resetObjectChange{prev: prev, prevdestruct: prevdestruct})
 	}
 	self.setStateObject(newobj)
 	return newobj, prev
@@ -441,16 +615,16 @@ func (db *StateDB) ForEachStorage(addr common.Address, cb func(key, value common
 		return
 	}
 
-	// When iterating over the storage check the cache first
-	for h, value := range so.cachedStorage {
+	// When iterating over the storage check the modifications first
+	for h, value := range so.dirtyStorage {
 		cb(h, value)
 	}
 
 	it := trie.NewIterator(so.getTrie(db.db).NodeIterator(nil))
 	for it.Next() {
-		// ignore cached values
+		// ignore modified values
 		key := common.BytesToHash(db.trie.GetKey(it.Key))
-		if _, ok := so.cachedStorage[key]; !ok {
+		if _, ok := so.dirtyStorage[key]; !ok {
 			cb(key, common.BytesToHash(it.Value))
 		}
 	}
@@ -472,6 +646,29 @@ func (self *StateDB) Copy() *StateDB {
 		logs:              make(map[common.Hash][]*types.Log, len(self.logs)),
 		logSize:           self.logSize,
 		preimages:         make(map[common.Hash][]byte),
+		snaps:             self.snaps,
+		snap:              self.snap,
+		historic:          self.historic,
+	}
+	if self.history != nil {
+		state.history = self.history.copy()
+	}
+	if self.snap != nil {
+		state.snapDestructs = make(map[common.Hash]struct{}, len(self.snapDestructs))
+		for hash := range self.snapDestructs {
+			state.snapDestructs[hash] = struct{}{}
+		}
+		state.snapAccounts = make(map[common.Hash][]byte, len(self.snapAccounts))
+		for hash, data := range self.snapAccounts {
+			state.snapAccounts[hash] = data
+		}
+		state.snapStorage = make(map[common.Hash]map[common.Hash][]byte, len(self.snapStorage))
+		for hash, storage := range self.snapStorage {
+			state.snapStorage[hash] = make(map[common.Hash][]byte, len(storage))
+			for key, data := range storage {
+				state.snapStorage[hash][key] = data
+			}
+		}
 	}
 	// Copy the dirty states, logs, and preimages
 	for addr := range self.stateObjectsDirty {
@@ -583,6 +780,9 @@ func (s *StateDB) clearJournalAndRefund() {
 
 // Commit writes the state to the underlying in-memory trie database.
 func (s *StateDB) Commit(deleteEmptyObjects bool) (root common.Hash, err error) {
+	if s.historic {
+		return common.Hash{}, errHistoricState
+	}
 	defer s.clearJournalAndRefund()
 
 	// Commit objects to the trie.
@@ -597,6 +797,9 @@ func (s *StateDB) Commit(deleteEmptyObjects bool) (root common.Hash, err error)
 			// Write any contract code associated with the state object
 			if stateObject.code != nil && stateObject.dirtyCode {
 				s.db.TrieDB().Insert(common.BytesToHash(stateObject.CodeHash()), stateObject.code)
+				if s.history != nil {
+					s.history.Codes[common.BytesToHash(stateObject.CodeHash())] = stateObject.code
+				}
 				stateObject.dirtyCode = false
 			}
 			// Write any storage changes in the state object to its storage trie.
@@ -624,5 +827,15 @@ func (s *StateDB) Commit(deleteEmptyObjects bool) (root common.Hash, err error)
 		return nil
 	})
 	log.Debug("Trie cache stats after commit", "misses", trie.CacheMisses(), "unloads", trie.CacheUnloads())
+
+	// Push the changes into the snapshot tree as a new layer on top of the old one
+	if err == nil && s.snap != nil {
+		if parent := s.snap.Root(); parent != root {
+			if err := s.snaps.Update(root, parent, s.snapDestructs, s.snapAccounts, s.snapStorage); err != nil {
+				log.Warn("Failed to update state snapshot", "from", parent, "to", root, "err", err)
+			}
+		}
+		s.resetSnapshot(root)
+	}
 	return root, err
 }
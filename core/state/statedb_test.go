@@ -27,12 +27,17 @@ import (
 	"strings"
 	"testing"
 	"testing/quick"
+	"time"
 
 	check "gopkg.in/check.v1"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
 )
 
 // Tests that updating a state trie does not leak any database writes prior to
@@ -168,6 +173,319 @@ func TestCopy(t *testing.T) {
 	}
 }
 
+// Tests that account and storage proofs generated from a state can be verified
+// against the state root and the account's storage root.
+func TestProofs(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	state, _ := New(common.Hash{}, NewDatabase(db))
+
+	addr := common.BytesToAddress([]byte{0x01})
+	state.SetBalance(addr, big.NewInt(42))
+	state.SetState(addr, common.Hash{0x02}, common.Hash{0x03})
+	root, _ := state.Commit(false)
+	state, _ = New(root, state.Database())
+
+	// Verify the account proof against the state root
+	proof, err := state.GetProof(addr)
+	if err != nil {
+		t.Fatalf("failed to prove account: %v", err)
+	}
+	if _, err, _ := trie.VerifyProof(root, crypto.Keccak256(addr.Bytes()), proofDatabase(proof)); err != nil {
+		t.Fatalf("failed to verify account proof: %v", err)
+	}
+	// Verify the storage proof against the storage root
+	proof, err = state.GetStorageProof(addr, common.Hash{0x02})
+	if err != nil {
+		t.Fatalf("failed to prove storage slot: %v", err)
+	}
+	storageRoot := state.StorageTrie(addr).Hash()
+	val, err, _ := trie.VerifyProof(storageRoot, crypto.Keccak256(common.Hash{0x02}.Bytes()), proofDatabase(proof))
+	if err != nil {
+		t.Fatalf("failed to verify storage proof: %v", err)
+	}
+	var content []byte
+	if err := rlp.DecodeBytes(val, &content); err != nil {
+		t.Fatalf("failed to decode storage value: %v", err)
+	}
+	if have, want := common.BytesToHash(content), (common.Hash{0x03}); have != want {
+		t.Errorf("storage value mismatch: have %x, want %x", have, want)
+	}
+	// Non-existent accounts have no storage trie to prove against
+	if _, err := state.GetStorageProof(common.Address{0xff}, common.Hash{}); err == nil {
+		t.Errorf("storage proof for missing account succeeded")
+	}
+}
+
+// Tests that replacing the storage of an account hides all its original slots,
+// and that writes on top of the replacement can still be reverted.
+func TestSetStorage(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	state, _ := New(common.Hash{}, NewDatabase(db))
+
+	addr := common.BytesToAddress([]byte{0x01})
+	state.SetState(addr, common.Hash{0x01}, common.Hash{0x01})
+	state.SetStorage(addr, map[common.Hash]common.Hash{{0x02}: {0x02}})
+
+	if val := state.GetState(addr, common.Hash{0x01}); val != (common.Hash{}) {
+		t.Errorf("original slot visible after replacement: have %x", val)
+	}
+	if val := state.GetState(addr, common.Hash{0x02}); val != (common.Hash{0x02}) {
+		t.Errorf("replaced slot mismatch: have %x, want %x", val, common.Hash{0x02})
+	}
+	snapshot := state.Snapshot()
+	state.SetState(addr, common.Hash{0x02}, common.Hash{0x03})
+	state.RevertToSnapshot(snapshot)
+
+	if val := state.GetState(addr, common.Hash{0x02}); val != (common.Hash{0x02}) {
+		t.Errorf("reverted slot mismatch: have %x, want %x", val, common.Hash{0x02})
+	}
+	if val := state.Copy().GetState(addr, common.Hash{0x02}); val != (common.Hash{0x02}) {
+		t.Errorf("copied slot mismatch: have %x, want %x", val, common.Hash{0x02})
+	}
+}
+
+// Tests that the committed storage values track the state as of the last
+// finalised transaction, unaffected by the modifications of the current one.
+func TestCommittedState(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	state, _ := New(common.Hash{}, NewDatabase(db))
+
+	addr := common.BytesToAddress([]byte{0x01})
+	state.SetState(addr, common.Hash{0x01}, common.Hash{0x01})
+	if val := state.GetCommittedState(addr, common.Hash{0x01}); val != (common.Hash{}) {
+		t.Errorf("unfinalised write visible as committed: have %x", val)
+	}
+	state.Finalise(false)
+	if val := state.GetCommittedState(addr, common.Hash{0x01}); val != (common.Hash{0x01}) {
+		t.Errorf("committed value mismatch: have %x, want %x", val, common.Hash{0x01})
+	}
+	// Modify the slot repeatedly and ensure the original value stays put
+	state.SetState(addr, common.Hash{0x01}, common.Hash{0x02})
+	state.SetState(addr, common.Hash{0x01}, common.Hash{0x03})
+	if val := state.GetState(addr, common.Hash{0x01}); val != (common.Hash{0x03}) {
+		t.Errorf("current value mismatch: have %x, want %x", val, common.Hash{0x03})
+	}
+	if val := state.GetCommittedState(addr, common.Hash{0x01}); val != (common.Hash{0x01}) {
+		t.Errorf("committed value changed by dirty write: have %x, want %x", val, common.Hash{0x01})
+	}
+	// Resetting the slot to its original value must not touch the storage trie
+	root := state.IntermediateRoot(false)
+	state.SetState(addr, common.Hash{0x01}, common.Hash{0x04})
+	state.SetState(addr, common.Hash{0x01}, common.Hash{0x03})
+	if have := state.IntermediateRoot(false); have != root {
+		t.Errorf("root mismatch after noop write: have %x, want %x", have, root)
+	}
+	if val := state.Copy().GetCommittedState(addr, common.Hash{0x01}); val != (common.Hash{0x03}) {
+		t.Errorf("copied committed value mismatch: have %x, want %x", val, common.Hash{0x03})
+	}
+}
+
+// Tests that state reads served from the flat snapshot match the tries, both
+// before and after modifying, deleting and recreating accounts.
+func TestFlatSnapshot(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	sdb := NewDatabase(db)
+	state, _ := New(common.Hash{}, sdb)
+
+	var addrs []common.Address
+	for i := byte(1); i <= 20; i++ {
+		addr := common.BytesToAddress([]byte{i})
+		state.AddBalance(addr, big.NewInt(int64(i)))
+		state.SetNonce(addr, uint64(i))
+		state.SetState(addr, common.Hash{i}, common.Hash{i})
+		state.SetState(addr, common.Hash{i, i}, common.Hash{i, i})
+		addrs = append(addrs, addr)
+	}
+	root, _ := state.Commit(false)
+	sdb.TrieDB().Commit(root, false)
+
+	// Generate the snapshot of the committed state and wait for it to finish
+	snaps := snapshot.New(db, sdb.TrieDB(), root)
+	for {
+		if _, err := snaps.Snapshot(root).AccountRLP(common.Hash{0xff}); err != snapshot.ErrNotCoveredYet {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	checkSnap := func(root common.Hash) {
+		t.Helper()
+
+		snapstate, _ := NewWithSnapshot(root, sdb, snaps)
+		triestate, _ := New(root, sdb)
+		if snapstate.snap == nil {
+			t.Fatalf("no snapshot for root %x", root)
+		}
+		for _, addr := range addrs {
+			if have, want := snapstate.Exist(addr), triestate.Exist(addr); have != want {
+				t.Errorf("account %x: existence mismatch: have %v, want %v", addr, have, want)
+			}
+			if have, want := snapstate.GetBalance(addr), triestate.GetBalance(addr); have.Cmp(want) != 0 {
+				t.Errorf("account %x: balance mismatch: have %v, want %v", addr, have, want)
+			}
+			if have, want := snapstate.GetNonce(addr), triestate.GetNonce(addr); have != want {
+				t.Errorf("account %x: nonce mismatch: have %v, want %v", addr, have, want)
+			}
+			for _, key := range []common.Hash{{addr[19]}, {addr[19], addr[19]}, {0xff}} {
+				if have, want := snapstate.GetState(addr, key), triestate.GetState(addr, key); have != want {
+					t.Errorf("account %x: slot %x mismatch: have %x, want %x", addr, key, have, want)
+				}
+			}
+		}
+	}
+	checkSnap(root)
+
+	// Modify the state both through the snapshot and the tries, ensuring that the
+	// resulting roots match and the new snapshot layer is correct
+	modify := func(state *StateDB) common.Hash {
+		state.SetState(addrs[0], common.Hash{0x01}, common.Hash{})
+		state.SetState(addrs[0], common.Hash{0xff}, common.Hash{0xff})
+		state.AddBalance(addrs[1], big.NewInt(100))
+		state.Suicide(addrs[2])
+		state.CreateAccount(addrs[3])
+		state.SetState(addrs[3], common.Hash{0xff}, common.Hash{0xff})
+		state.Finalise(true)
+
+		// Recreate a deleted account in the same block, its old storage must be gone
+		state.Suicide(addrs[4])
+		state.Finalise(true)
+		state.AddBalance(addrs[4], big.NewInt(1))
+
+		root, _ := state.Commit(true)
+		return root
+	}
+	snapstate, _ := NewWithSnapshot(root, sdb, snaps)
+	triestate, _ := New(root, sdb)
+
+	root, want := modify(snapstate), modify(triestate)
+	if root != want {
+		t.Fatalf("state root mismatch: have %x, want %x", root, want)
+	}
+	checkSnap(root)
+}
+
+// flattenState collects all the accounts of a state in the snapshot slim format
+// and all the storage slots, keyed by their hashes.
+func flattenState(t *testing.T, db Database, root common.Hash) (map[common.Hash][]byte, map[common.Hash]map[common.Hash][]byte) {
+	t.Helper()
+
+	accounts := make(map[common.Hash][]byte)
+	storage := make(map[common.Hash]map[common.Hash][]byte)
+
+	tr, err := db.OpenTrie(root)
+	if err != nil {
+		t.Fatalf("failed to open state %x: %v", root, err)
+	}
+	it := trie.NewIterator(tr.NodeIterator(nil))
+	for it.Next() {
+		var data Account
+		if err := rlp.DecodeBytes(it.Value, &data); err != nil {
+			t.Fatalf("failed to decode account: %v", err)
+		}
+		hash := common.BytesToHash(it.Key)
+		accounts[hash] = snapshot.AccountRLP(data.Nonce, data.Balance, data.Root, data.CodeHash)
+
+		st, err := db.OpenStorageTrie(hash, data.Root)
+		if err != nil {
+			t.Fatalf("failed to open storage of %x: %v", hash, err)
+		}
+		sit := trie.NewIterator(st.NodeIterator(nil))
+		for sit.Next() {
+			if storage[hash] == nil {
+				storage[hash] = make(map[common.Hash][]byte)
+			}
+			storage[hash][common.BytesToHash(sit.Key)] = common.CopyBytes(sit.Value)
+		}
+	}
+	return accounts, storage
+}
+
+// Tests that the tracked state history is the exact reverse diff of the changes,
+// restoring the parent state when applied on top of the committed one.
+func TestStateHistory(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	sdb := NewDatabase(db)
+	state, _ := New(common.Hash{}, sdb)
+
+	var addrs []common.Address
+	for i := byte(1); i <= 10; i++ {
+		addr := common.BytesToAddress([]byte{i})
+		state.AddBalance(addr, big.NewInt(int64(i)))
+		state.SetState(addr, common.Hash{i}, common.Hash{i})
+		state.SetState(addr, common.Hash{i, i}, common.Hash{i, i})
+		addrs = append(addrs, addr)
+	}
+	parent, _ := state.Commit(false)
+
+	state, _ = New(parent, sdb)
+	state.TrackHistory()
+
+	state.SetState(addrs[0], common.Hash{0x01}, common.Hash{})
+	state.SetState(addrs[0], common.Hash{0x01, 0x01}, common.Hash{0xff})
+	state.SetState(addrs[0], common.Hash{0xff}, common.Hash{0xff})
+	state.AddBalance(addrs[1], big.NewInt(100))
+	state.Suicide(addrs[2])
+	state.CreateAccount(addrs[3])
+	state.SetState(addrs[3], common.Hash{0xff}, common.Hash{0xff})
+	state.SetCode(common.Address{0xff}, []byte{0x01, 0x02})
+	state.IntermediateRoot(true)
+
+	// Values changed more than once must be recorded from before the first change
+	state.SetState(addrs[0], common.Hash{0x01, 0x01}, common.Hash{0xfe})
+	state.AddBalance(addrs[1], big.NewInt(100))
+	state.Suicide(addrs[4])
+	state.Finalise(true)
+	state.AddBalance(addrs[4], big.NewInt(1))
+
+	root, err := state.Commit(true)
+	if err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	history := state.History()
+	if code := history.Codes[crypto.Keccak256Hash([]byte{0x01, 0x02})]; !bytes.Equal(code, []byte{0x01, 0x02}) {
+		t.Errorf("deployed code mismatch: have %x, want %x", code, []byte{0x01, 0x02})
+	}
+	// Apply the reverse diff and compare with the parent state
+	accounts, storage := flattenState(t, sdb, root)
+	for hash, data := range history.Accounts {
+		if data == nil {
+			delete(accounts, hash)
+		} else {
+			accounts[hash] = data
+		}
+	}
+	for hash, slots := range history.Storage {
+		for slot, data := range slots {
+			if data == nil {
+				delete(storage[hash], slot)
+				continue
+			}
+			if storage[hash] == nil {
+				storage[hash] = make(map[common.Hash][]byte)
+			}
+			storage[hash][slot] = data
+		}
+		if len(storage[hash]) == 0 {
+			delete(storage, hash)
+		}
+	}
+	wantAccounts, wantStorage := flattenState(t, sdb, parent)
+	if !reflect.DeepEqual(accounts, wantAccounts) {
+		t.Errorf("reverted accounts mismatch:\nhave %x\nwant %x", accounts, wantAccounts)
+	}
+	if !reflect.DeepEqual(storage, wantStorage) {
+		t.Errorf("reverted storage mismatch:\nhave %x\nwant %x", storage, wantStorage)
+	}
+}
+
+// proofDatabase inserts the nodes of a proof into a memory database keyed by hash.
+func proofDatabase(proof [][]byte) *ethdb.MemDatabase {
+	db, _ := ethdb.NewMemDatabase()
+	for _, node := range proof {
+		db.Put(crypto.Keccak256(node), node)
+	}
+	return db
+}
+
 func TestSnapshotRandom(t *testing.T) {
 	config := &quick.Config{MaxCount: 1000}
 	err := quick.Check((*snapshotTest).run, config)
@@ -0,0 +1,312 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"runtime"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// errHistoryUnavailable is returned if a historic state can't be reconstructed
+// from the state history.
+var errHistoryUnavailable = errors.New("state history unavailable")
+
+// GetStateHistoryTail retrieves the number of the first block whose reverse
+// state diff is stored, along with all the later ones. The second return value
+// is false if no state history is maintained.
+func GetStateHistoryTail(db DatabaseReader) (uint64, bool) {
+	data, _ := db.Get(stateHistoryTailKey)
+	if len(data) != 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(data), true
+}
+
+// WriteStateHistoryTail stores the number of the first block whose reverse
+// state diff is stored.
+func WriteStateHistoryTail(db ethdb.Putter, number uint64) {
+	if err := db.Put(stateHistoryTailKey, encodeBlockNumber(number)); err != nil {
+		log.Crit("Failed to store state history tail", "err", err)
+	}
+}
+
+// DeleteStateHistoryTail removes the state history tail, marking the history
+// as not maintained.
+func DeleteStateHistoryTail(db DatabaseDeleter) {
+	db.Delete(stateHistoryTailKey)
+}
+
+// WriteStateHistoryRoot indexes the state root of a block, so the historic state
+// can be looked up by root.
+func WriteStateHistoryRoot(db ethdb.Putter, root common.Hash, number uint64, hash common.Hash) error {
+	key := append(append(append(append([]byte{}, stateHistoryRootPrefix...), root[:]...), encodeBlockNumber(number)...), hash[:]...)
+	return db.Put(key, []byte{})
+}
+
+// WriteStateHistory stores the reverse state diff of a block, along with the
+// index of its state root.
+func WriteStateHistory(db ethdb.Putter, number uint64, hash common.Hash, root common.Hash, history *state.StateHistory) error {
+	suffix := append(encodeBlockNumber(number), hash[:]...)
+
+	for accountHash, data := range history.Accounts {
+		key := append(append(append([]byte{}, stateHistoryAccountPrefix...), accountHash[:]...), suffix...)
+		if err := db.Put(key, data); err != nil {
+			return err
+		}
+	}
+	for accountHash, storage := range history.Storage {
+		for slotHash, data := range storage {
+			key := append(append(append(append([]byte{}, stateHistoryStoragePrefix...), accountHash[:]...), slotHash[:]...), suffix...)
+			if err := db.Put(key, data); err != nil {
+				return err
+			}
+		}
+	}
+	for codeHash, code := range history.Codes {
+		if err := db.Put(append(append([]byte{}, stateHistoryCodePrefix...), codeHash[:]...), code); err != nil {
+			return err
+		}
+	}
+	return WriteStateHistoryRoot(db, root, number, hash)
+}
+
+// GetStateHistoryCode retrieves a contract code stored by the state history.
+func GetStateHistoryCode(db DatabaseReader, codeHash common.Hash) ([]byte, error) {
+	return db.Get(append(append([]byte{}, stateHistoryCodePrefix...), codeHash[:]...))
+}
+
+// historicState creates a read-only state for a root whose tries are not
+// available any more, by reconstructing its accounts and storage slots from the
+// reverse state diffs of the canonical blocks since.
+func (bc *BlockChain) historicState(root common.Hash) (*state.StateDB, error) {
+	tail, ok := GetStateHistoryTail(bc.db)
+	if !ok {
+		return nil, errHistoryUnavailable
+	}
+	head := bc.CurrentBlock()
+
+	// Find a canonical block with the requested state, the diffs of all the blocks
+	// after it must be available
+	prefix := append(append([]byte{}, stateHistoryRootPrefix...), root[:]...)
+
+	it := bc.db.NewIteratorWithPrefix(prefix)
+	defer it.Release()
+
+	for it.Next() {
+		key := it.Key()
+		if len(key) != len(prefix)+8+common.HashLength {
+			continue
+		}
+		number := binary.BigEndian.Uint64(key[len(prefix):])
+		if number+1 < tail || number > head.NumberU64() {
+			continue
+		}
+		if GetCanonicalHash(bc.db, number) != common.BytesToHash(key[len(prefix)+8:]) {
+			continue
+		}
+		// Keep the head state referenced as long as the reader is alive, otherwise
+		// the garbage collector drops it from memory as the chain progresses
+		triedb := bc.stateCache.TrieDB()
+		triedb.Reference(head.Root(), common.Hash{})
+
+		headTrie, err := trie.New(head.Root(), triedb)
+		if err != nil {
+			triedb.Dereference(head.Root(), common.Hash{})
+			return nil, err
+		}
+		reader := &historicReader{
+			db:       bc.db,
+			triedb:   triedb,
+			root:     root,
+			number:   number,
+			head:     head.NumberU64(),
+			headRoot: head.Root(),
+			headTrie: headTrie,
+			storage:  make(map[common.Hash]*trie.Trie),
+		}
+		// Users release the reader explicitly once done, the finalizer is only a
+		// backstop for the ones that don't
+		runtime.SetFinalizer(reader, (*historicReader).Release)
+
+		return state.NewHistoric(&historicDatabase{Database: bc.stateCache, db: bc.db}, reader)
+	}
+	return nil, errHistoryUnavailable
+}
+
+// historicReader retrieves the accounts and storage slots of a historic state.
+// The value of an item is the one stored in the reverse diff of the first block
+// modifying it after the historic state, or the current one if it wasn't since.
+//
+// The reader implements the snapshot.Snapshot interface, so that it can back a
+// StateDB instead of the tries.
+type historicReader struct {
+	db     ethdb.Database
+	triedb *trie.Database
+	root   common.Hash // Root hash of the historic state
+	number uint64      // Number of the block with the historic state
+
+	head     uint64                     // Number of the head block the diffs are applied to
+	headRoot common.Hash                // Root hash of the head state, referenced in the trie database
+	headTrie *trie.Trie                 // Account trie of the head state
+	storage  map[common.Hash]*trie.Trie // Storage tries of the head state, opened so far
+	lock     sync.Mutex
+
+	releaseOnce sync.Once // Ensures the head state is only dereferenced once
+}
+
+// Release drops the reference of the head state held by the reader, allowing it
+// to be garbage collected. The reader must not be used afterwards.
+func (r *historicReader) Release() {
+	r.releaseOnce.Do(func() {
+		r.triedb.Dereference(r.headRoot, common.Hash{})
+	})
+}
+
+// Root returns the root hash of the historic state.
+func (r *historicReader) Root() common.Hash {
+	return r.root
+}
+
+// Account retrieves the historic account associated with a particular hash in
+// the snapshot slim data format.
+func (r *historicReader) Account(hash common.Hash) (*snapshot.Account, error) {
+	data, err := r.AccountRLP(hash)
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	account := new(snapshot.Account)
+	if err := rlp.DecodeBytes(data, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// AccountRLP retrieves the historic account RLP associated with a particular
+// hash in the snapshot slim data format.
+func (r *historicReader) AccountRLP(hash common.Hash) ([]byte, error) {
+	prefix := append(append([]byte{}, stateHistoryAccountPrefix...), hash[:]...)
+	if data, ok, err := r.lookup(prefix); ok || err != nil {
+		return data, err
+	}
+	account, err := r.headAccount(hash)
+	if account == nil || err != nil {
+		return nil, err
+	}
+	return snapshot.AccountRLP(account.Nonce, account.Balance, account.Root, account.CodeHash), nil
+}
+
+// Storage retrieves the historic storage data associated with a particular hash,
+// within a particular account.
+func (r *historicReader) Storage(accountHash, storageHash common.Hash) ([]byte, error) {
+	prefix := append(append(append([]byte{}, stateHistoryStoragePrefix...), accountHash[:]...), storageHash[:]...)
+	if data, ok, err := r.lookup(prefix); ok || err != nil {
+		return data, err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	tr, ok := r.storage[accountHash]
+	if !ok {
+		account, err := r.headAccount(accountHash)
+		if err != nil {
+			return nil, err
+		}
+		if account != nil {
+			if tr, err = trie.New(account.Root, r.triedb); err != nil {
+				return nil, err
+			}
+		}
+		r.storage[accountHash] = tr
+	}
+	if tr == nil {
+		return nil, nil
+	}
+	return tr.TryGet(storageHash[:])
+}
+
+// lookup searches the reverse diffs stored under the given item prefix for the
+// first canonical block after the historic one, up to the head.
+func (r *historicReader) lookup(prefix []byte) ([]byte, bool, error) {
+	it := r.db.NewIteratorWithStart(append(append([]byte{}, prefix...), encodeBlockNumber(r.number+1)...))
+	defer it.Release()
+
+	for it.Next() {
+		key := it.Key()
+		if !bytes.HasPrefix(key, prefix) {
+			break
+		}
+		if len(key) != len(prefix)+8+common.HashLength {
+			continue
+		}
+		number := binary.BigEndian.Uint64(key[len(prefix):])
+		if number > r.head {
+			break
+		}
+		// Diffs of blocks not on the canonical chain (any more) are irrelevant
+		if GetCanonicalHash(r.db, number) == common.BytesToHash(key[len(prefix)+8:]) {
+			return common.CopyBytes(it.Value()), true, nil
+		}
+	}
+	return nil, false, it.Error()
+}
+
+// headAccount retrieves an account from the head state, or nil if it doesn't
+// exist.
+func (r *historicReader) headAccount(hash common.Hash) (*state.Account, error) {
+	enc, err := r.headTrie.TryGet(hash[:])
+	if len(enc) == 0 || err != nil {
+		return nil, err
+	}
+	account := new(state.Account)
+	if err := rlp.DecodeBytes(enc, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// historicDatabase is a state database which also retrieves the contract codes
+// stored by the state history, in case they were garbage collected with their
+// tries.
+type historicDatabase struct {
+	state.Database
+	db ethdb.Database
+}
+
+// ContractCode retrieves a particular contract's code.
+func (db *historicDatabase) ContractCode(addrHash, codeHash common.Hash) ([]byte, error) {
+	if code, err := db.Database.ContractCode(addrHash, codeHash); err == nil {
+		return code, nil
+	}
+	return GetStateHistoryCode(db.db, codeHash)
+}
+
+// ContractCodeSize retrieves a particular contracts code's size.
+func (db *historicDatabase) ContractCodeSize(addrHash, codeHash common.Hash) (int, error) {
+	code, err := db.ContractCode(addrHash, codeHash)
+	return len(code), err
+}
@@ -243,6 +243,16 @@ func (l *txList) Overlaps(tx *types.Transaction) bool {
 	return l.txs.Get(tx.Nonce()) != nil
 }
 
+// replaceable checks whether a transaction is priced high enough to replace an
+// older one with the same nonce, given the minimum price bump percentage.
+func replaceable(old, tx *types.Transaction, priceBump uint64) bool {
+	threshold := new(big.Int).Div(new(big.Int).Mul(old.GasPrice(), new(big.Int).SetUint64(100+priceBump)), big.NewInt(100))
+	// Have to ensure that the new gas price is higher than the old gas
+	// price as well as checking the percentage threshold to ensure that
+	// this is accurate for low (Wei-level) gas price replacements
+	return old.GasPrice().Cmp(tx.GasPrice()) < 0 && threshold.Cmp(tx.GasPrice()) <= 0
+}
+
 // Add tries to insert a new transaction into the list, returning whether the
 // transaction was accepted, and if yes, any previous transaction it replaced.
 //
@@ -251,14 +261,8 @@ func (l *txList) Overlaps(tx *types.Transaction) bool {
 func (l *txList) Add(tx *types.Transaction, priceBump uint64) (bool, *types.Transaction) {
 	// If there's an older better transaction, abort
 	old := l.txs.Get(tx.Nonce())
-	if old != nil {
-		threshold := new(big.Int).Div(new(big.Int).Mul(old.GasPrice(), big.NewInt(100+int64(priceBump))), big.NewInt(100))
-		// Have to ensure that the new gas price is higher than the old gas
-		// price as well as checking the percentage threshold to ensure that
-		// this is accurate for low (Wei-level) gas price replacements
-		if old.GasPrice().Cmp(tx.GasPrice()) >= 0 || threshold.Cmp(tx.GasPrice()) > 0 {
-			return false, nil
-		}
+	if old != nil && !replaceable(old, tx, priceBump) {
+		return false, nil
 	}
 	// Otherwise overwrite the old transaction with the current one
 	l.txs.Put(tx)
@@ -138,6 +138,12 @@ type TxPoolConfig struct {
 	GlobalQueue  uint64 // Maximum number of non-executable transaction slots for all accounts
 
 	Lifetime time.Duration // Maximum amount of time non-executable transaction are queued
+
+	SenderCap       uint64        // Maximum number of transactions permitted per remote account (0 = unlimited)
+	PriceBumpGrowth uint64        // Percentage the price bump grows by with each replacement of a remote transaction (0 = flat)
+	MaxAge          time.Duration // Maximum amount of time remote transactions are pooled (0 = unlimited)
+
+	Policies []TxPoolPolicy `toml:"-"` // Custom admission and eviction policies for remote transactions
 }
 
 // DefaultTxPoolConfig contains the default configurations for the transaction
@@ -176,6 +182,23 @@ func (config *TxPoolConfig) sanitize() TxPoolConfig {
 	return conf
 }
 
+// policies assembles the admission and eviction policies configured for the
+// pool: the built in ones enabled by the config fields, followed by any custom
+// ones.
+func (config *TxPoolConfig) policies() []TxPoolPolicy {
+	var policies []TxPoolPolicy
+	if config.SenderCap > 0 {
+		policies = append(policies, &SenderCapPolicy{Max: config.SenderCap})
+	}
+	if config.PriceBumpGrowth > 0 {
+		policies = append(policies, NewPriceBumpCurvePolicy(config.PriceBump, config.PriceBumpGrowth))
+	}
+	if config.MaxAge > 0 {
+		policies = append(policies, &AgingPolicy{MaxAge: config.MaxAge})
+	}
+	return append(policies, config.Policies...)
+}
+
 // TxPool contains all currently known transactions. Transactions
 // enter the pool when they are received from the network or submitted
 // locally. They exit the pool when they are included in the blockchain.
@@ -208,6 +231,10 @@ type TxPool struct {
 	all     map[common.Hash]*types.Transaction // All transactions to allow lookups
 	priced  *txPricedList                      // All transactions sorted by price
 
+	policies      []TxPoolPolicy            // Admission and eviction policies for remote transactions
+	policyMetrics []policyMetrics           // Rejection and eviction metrics of the policies
+	arrivals      map[common.Hash]time.Time // Time the transactions entered the pool
+
 	wg sync.WaitGroup // for shutdown sync
 
 	homestead bool
@@ -229,9 +256,14 @@ func NewTxPool(config TxPoolConfig, chainconfig *params.ChainConfig, chain block
 		queue:       make(map[common.Address]*txList),
 		beats:       make(map[common.Address]time.Time),
 		all:         make(map[common.Hash]*types.Transaction),
+		arrivals:    make(map[common.Hash]time.Time),
 		chainHeadCh: make(chan ChainHeadEvent, chainHeadChanSize),
 		gasPrice:    new(big.Int).SetUint64(config.PriceLimit),
 	}
+	pool.policies = config.policies()
+	for _, policy := range pool.policies {
+		pool.policyMetrics = append(pool.policyMetrics, newPolicyMetrics(policy))
+	}
 	pool.locals = newAccountSet(pool.signer)
 	pool.priced = newTxPricedList(&pool.all)
 	pool.reset(nil, chain.CurrentBlock().Header())
@@ -324,6 +356,8 @@ func (pool *TxPool) loop() {
 					}
 				}
 			}
+			// Run the eviction policies too
+			pool.evictPolicies()
 			pool.mu.Unlock()
 
 		// Handle local transaction journal rotation
@@ -618,13 +652,26 @@ func (pool *TxPool) add(tx *types.Transaction, local bool) (bool, error) {
 		return false, err
 	}
 	// If the transaction pool is full, discard underpriced transactions
-	if uint64(len(pool.all)) >= pool.config.GlobalSlots+pool.config.GlobalQueue {
-		// If the new transaction is underpriced, don't accept it
-		if pool.priced.Underpriced(tx, pool.locals) {
-			log.Trace("Discarding underpriced transaction", "hash", hash, "price", tx.GasPrice())
-			underpricedTxCounter.Inc(1)
-			return false, ErrUnderpriced
+	full := uint64(len(pool.all)) >= pool.config.GlobalSlots+pool.config.GlobalQueue
+
+	// If the new transaction is underpriced, don't accept it
+	if full && pool.priced.Underpriced(tx, pool.locals) {
+		log.Trace("Discarding underpriced transaction", "hash", hash, "price", tx.GasPrice())
+		underpricedTxCounter.Inc(1)
+		return false, ErrUnderpriced
+	}
+	// If the transaction is rejected by any of the remote policies, discard it
+	var (
+		from, _ = types.Sender(pool.signer, tx) // already validated
+		remote  = !local && !pool.locals.contains(from)
+	)
+	if remote {
+		if err := pool.admit(from, tx); err != nil {
+			log.Trace("Discarding policy rejected transaction", "hash", hash, "err", err)
+			return false, err
 		}
+	}
+	if full {
 		// New transaction is better than our worse ones, make room for it
 		drop := pool.priced.Discard(len(pool.all)-int(pool.config.GlobalSlots+pool.config.GlobalQueue-1), pool.locals)
 		for _, tx := range drop {
@@ -634,7 +681,6 @@ func (pool *TxPool) add(tx *types.Transaction, local bool) (bool, error) {
 		}
 	}
 	// If the transaction is replacing an already pending one, do directly
-	from, _ := types.Sender(pool.signer, tx) // already validated
 	if list := pool.pending[from]; list != nil && list.Overlaps(tx) {
 		// Nonce already pending, check if required price bump is met
 		inserted, old := list.Add(tx, pool.config.PriceBump)
@@ -647,8 +693,13 @@ func (pool *TxPool) add(tx *types.Transaction, local bool) (bool, error) {
 			delete(pool.all, old.Hash())
 			pool.priced.Removed()
 			pendingReplaceCounter.Inc(1)
+
+			if remote {
+				pool.replaced(from, old, tx)
+			}
 		}
 		pool.all[tx.Hash()] = tx
+		pool.arrivals[tx.Hash()] = time.Now()
 		pool.priced.Put(tx)
 		pool.journalTx(from, tx)
 
@@ -660,10 +711,15 @@ func (pool *TxPool) add(tx *types.Transaction, local bool) (bool, error) {
 		return old != nil, nil
 	}
 	// New transaction isn't replacing a pending one, push into queue
+	old := pool.lookup(from, tx.Nonce())
 	replace, err := pool.enqueueTx(hash, tx)
 	if err != nil {
 		return false, err
 	}
+	if replace && remote {
+		pool.replaced(from, old, tx)
+	}
+	pool.arrivals[hash] = time.Now()
 	// Mark local addresses and journal local transactions
 	if local {
 		pool.locals.add(from)
@@ -674,6 +730,89 @@ func (pool *TxPool) add(tx *types.Transaction, local bool) (bool, error) {
 	return replace, nil
 }
 
+// lookup returns the pending or queued transaction of an account with the given
+// nonce, or nil if there is none.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) lookup(addr common.Address, nonce uint64) *types.Transaction {
+	if list := pool.pending[addr]; list != nil {
+		if tx := list.txs.Get(nonce); tx != nil {
+			return tx
+		}
+	}
+	if list := pool.queue[addr]; list != nil {
+		return list.txs.Get(nonce)
+	}
+	return nil
+}
+
+// admit runs a remote transaction through the admission policies of the pool.
+// Replacements not meeting the pool's own price bump are rejected upfront, so
+// the policies only see replacements that will actually be carried out.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) admit(from common.Address, tx *types.Transaction) error {
+	if len(pool.policies) == 0 {
+		return nil
+	}
+	old := pool.lookup(from, tx.Nonce())
+	if old != nil && !replaceable(old, tx, pool.config.PriceBump) {
+		return ErrReplaceUnderpriced
+	}
+	view := txPoolView{pool}
+	for i, policy := range pool.policies {
+		var err error
+		if old != nil {
+			err = policy.Replace(view, from, old, tx)
+		} else {
+			err = policy.Admit(view, from, tx)
+		}
+		if err != nil {
+			pool.policyMetrics[i].rejected.Inc(1)
+			return err
+		}
+	}
+	return nil
+}
+
+// replaced notifies the policies of the pool that a remote transaction accepted
+// by all of them replaced an older one.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) replaced(from common.Address, old, tx *types.Transaction) {
+	view := txPoolView{pool}
+	for _, policy := range pool.policies {
+		policy.Replaced(view, from, old, tx)
+	}
+}
+
+// evictPolicies drops all the remote transactions deemed evictable by any of the
+// policies of the pool, and forgets the arrival times of transactions no longer
+// in the pool.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) evictPolicies() {
+	view := txPoolView{pool}
+	for i, policy := range pool.policies {
+		for _, tx := range policy.Evict(view) {
+			if pool.all[tx.Hash()] == nil {
+				continue
+			}
+			if from, _ := types.Sender(pool.signer, tx); pool.locals.contains(from) {
+				continue
+			}
+			log.Trace("Evicting policy dropped transaction", "hash", tx.Hash(), "policy", policy.Name())
+			pool.policyMetrics[i].evicted.Inc(1)
+			pool.removeTx(tx.Hash())
+		}
+	}
+	for hash := range pool.arrivals {
+		if pool.all[hash] == nil {
+			delete(pool.arrivals, hash)
+		}
+	}
+}
+
 // enqueueTx inserts a new transaction into the non-executable transaction queue.
 //
 // Note, this method assumes the pool lock is held!
@@ -695,8 +834,11 @@ func (pool *TxPool) enqueueTx(hash common.Hash, tx *types.Transaction) (bool, er
 		pool.priced.Removed()
 		queuedReplaceCounter.Inc(1)
 	}
-	pool.all[hash] = tx
-	pool.priced.Put(tx)
+	// Demoted transactions are already tracked, don't track them twice
+	if pool.all[hash] == nil {
+		pool.all[hash] = tx
+		pool.priced.Put(tx)
+	}
 	return old != nil, nil
 }
 
@@ -0,0 +1,302 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// ErrSenderCapped is returned if a remote account already has the maximum number
+// of transactions permitted per account in the pool.
+var ErrSenderCapped = errors.New("sender transaction cap exceeded")
+
+// maxPolicyPriceBump is the highest price bump percentage the replacement curve
+// will ever demand, to avoid overflows on runaway curves.
+const maxPolicyPriceBump = 1000000
+
+// TxPoolView is a read only view into the transaction pool, handed to the pool
+// policies so they can base their decisions on its contents. Local accounts are
+// not visible through the view.
+type TxPoolView interface {
+	// Capacity returns the total number of transaction slots of the pool.
+	Capacity() uint64
+
+	// Size returns the number of transactions currently in the pool.
+	Size() int
+
+	// Count returns the number of pending and queued transactions of an account.
+	Count(addr common.Address) int
+
+	// Get returns the pending or queued transaction of an account with the given
+	// nonce, or nil if there is none.
+	Get(addr common.Address, nonce uint64) *types.Transaction
+
+	// Arrival returns the time a transaction entered the pool, or the zero time
+	// if it is unknown.
+	Arrival(hash common.Hash) time.Time
+
+	// Remotes returns all the pending and queued transactions of remote accounts,
+	// grouped by account and sorted by nonce.
+	Remotes() map[common.Address]types.Transactions
+}
+
+// TxPoolPolicy is an admission and eviction policy of the transaction pool. The
+// policies apply to transactions of remote accounts only, local accounts are
+// exempt from them.
+//
+// The methods of a policy are invoked with the pool lock held, so they must not
+// call back into the pool itself.
+type TxPoolPolicy interface {
+	// Name returns the name of the policy, used in logs and metrics.
+	Name() string
+
+	// Admit checks whether a transaction with a new nonce may enter the pool.
+	Admit(view TxPoolView, from common.Address, tx *types.Transaction) error
+
+	// Replace checks whether a transaction may replace an existing one with the
+	// same nonce. It is only invoked if the replacement meets the price bump of
+	// the pool itself, and the replacement is carried out if all policies accept.
+	Replace(view TxPoolView, from common.Address, old, tx *types.Transaction) error
+
+	// Replaced is invoked after a replacement accepted by all the policies was
+	// carried out by the pool.
+	Replaced(view TxPoolView, from common.Address, old, tx *types.Transaction)
+
+	// Evict returns the transactions that should be dropped from the pool. It is
+	// invoked periodically.
+	Evict(view TxPoolView) []*types.Transaction
+}
+
+// txPoolView is the TxPoolView implementation of the pool.
+type txPoolView struct {
+	pool *TxPool
+}
+
+// Capacity implements TxPoolView, returning the total number of pool slots.
+func (v txPoolView) Capacity() uint64 {
+	return v.pool.config.GlobalSlots + v.pool.config.GlobalQueue
+}
+
+// Size implements TxPoolView, returning the number of pooled transactions.
+func (v txPoolView) Size() int {
+	return len(v.pool.all)
+}
+
+// Count implements TxPoolView, returning the number of transactions of an account.
+func (v txPoolView) Count(addr common.Address) int {
+	if v.pool.locals.contains(addr) {
+		return 0
+	}
+	count := 0
+	if list := v.pool.pending[addr]; list != nil {
+		count += list.Len()
+	}
+	if list := v.pool.queue[addr]; list != nil {
+		count += list.Len()
+	}
+	return count
+}
+
+// Get implements TxPoolView, returning the transaction of an account with a nonce.
+func (v txPoolView) Get(addr common.Address, nonce uint64) *types.Transaction {
+	if v.pool.locals.contains(addr) {
+		return nil
+	}
+	return v.pool.lookup(addr, nonce)
+}
+
+// Arrival implements TxPoolView, returning the time a transaction was pooled.
+func (v txPoolView) Arrival(hash common.Hash) time.Time {
+	return v.pool.arrivals[hash]
+}
+
+// Remotes implements TxPoolView, returning all transactions of remote accounts.
+func (v txPoolView) Remotes() map[common.Address]types.Transactions {
+	remotes := make(map[common.Address]types.Transactions)
+	for _, lists := range []map[common.Address]*txList{v.pool.pending, v.pool.queue} {
+		for addr, list := range lists {
+			if !v.pool.locals.contains(addr) {
+				remotes[addr] = append(remotes[addr], list.Flatten()...)
+			}
+		}
+	}
+	// Pending nonces always precede the queued ones, so the nonce order is retained
+	return remotes
+}
+
+// policyMetrics are the metrics tracked for a single pool policy.
+type policyMetrics struct {
+	rejected metrics.Counter // Transactions rejected by the policy
+	evicted  metrics.Counter // Transactions evicted by the policy
+}
+
+// newPolicyMetrics retrieves the metrics of the given policy.
+func newPolicyMetrics(policy TxPoolPolicy) policyMetrics {
+	return policyMetrics{
+		rejected: metrics.GetOrRegisterCounter("txpool/policy/"+policy.Name()+"/rejected", nil),
+		evicted:  metrics.GetOrRegisterCounter("txpool/policy/"+policy.Name()+"/evicted", nil),
+	}
+}
+
+// SenderCapPolicy is a fairness policy limiting the number of transactions a
+// single remote account may have in the pool, so a single spammer can't crowd
+// out everyone else.
+type SenderCapPolicy struct {
+	Max uint64 // Maximum number of pending and queued transactions per account
+}
+
+// Name implements TxPoolPolicy.
+func (p *SenderCapPolicy) Name() string { return "sendercap" }
+
+// Admit implements TxPoolPolicy, rejecting transactions of capped accounts.
+func (p *SenderCapPolicy) Admit(view TxPoolView, from common.Address, tx *types.Transaction) error {
+	if uint64(view.Count(from)) >= p.Max {
+		return ErrSenderCapped
+	}
+	return nil
+}
+
+// Replace implements TxPoolPolicy. Replacements don't change the number of
+// transactions of an account, so they are always accepted.
+func (p *SenderCapPolicy) Replace(view TxPoolView, from common.Address, old, tx *types.Transaction) error {
+	return nil
+}
+
+// Replaced implements TxPoolPolicy.
+func (p *SenderCapPolicy) Replaced(view TxPoolView, from common.Address, old, tx *types.Transaction) {
+}
+
+// Evict implements TxPoolPolicy. Admission control suffices, nothing is evicted.
+func (p *SenderCapPolicy) Evict(view TxPoolView) []*types.Transaction {
+	return nil
+}
+
+// txSlot identifies a transaction position within the pool.
+type txSlot struct {
+	from  common.Address
+	nonce uint64
+}
+
+// PriceBumpCurvePolicy is a replacement policy raising the price bump required
+// to replace a transaction with each successive replacement of the same nonce.
+// This makes repeatedly replacing a transaction (and thus flooding the network
+// with it) exponentially more expensive.
+type PriceBumpCurvePolicy struct {
+	Base   uint64 // Price bump percentage required for the first replacement
+	Growth uint64 // Percentage the required price bump grows by with each replacement
+
+	replaced map[txSlot]int // Number of times the transactions were replaced
+}
+
+// NewPriceBumpCurvePolicy creates a replacement policy requiring a price bump of
+// base percent for the first replacement, growing by growth percent for every
+// subsequent one.
+func NewPriceBumpCurvePolicy(base, growth uint64) *PriceBumpCurvePolicy {
+	return &PriceBumpCurvePolicy{
+		Base:     base,
+		Growth:   growth,
+		replaced: make(map[txSlot]int),
+	}
+}
+
+// Name implements TxPoolPolicy.
+func (p *PriceBumpCurvePolicy) Name() string { return "pricebumpcurve" }
+
+// Admit implements TxPoolPolicy. New transactions are not concerned.
+func (p *PriceBumpCurvePolicy) Admit(view TxPoolView, from common.Address, tx *types.Transaction) error {
+	return nil
+}
+
+// bump returns the price bump percentage required for the next replacement of
+// a transaction that was already replaced the given number of times.
+func (p *PriceBumpCurvePolicy) bump(replaced int) uint64 {
+	bump := float64(p.Base) * math.Pow(1+float64(p.Growth)/100, float64(replaced))
+	if bump > maxPolicyPriceBump {
+		return maxPolicyPriceBump
+	}
+	return uint64(bump)
+}
+
+// Replace implements TxPoolPolicy, enforcing the price bump of the curve.
+func (p *PriceBumpCurvePolicy) Replace(view TxPoolView, from common.Address, old, tx *types.Transaction) error {
+	slot := txSlot{from: from, nonce: tx.Nonce()}
+	if !replaceable(old, tx, p.bump(p.replaced[slot])) {
+		return ErrReplaceUnderpriced
+	}
+	return nil
+}
+
+// Replaced implements TxPoolPolicy, raising the price bump required for the next
+// replacement. Replacements rejected by other policies don't count.
+func (p *PriceBumpCurvePolicy) Replaced(view TxPoolView, from common.Address, old, tx *types.Transaction) {
+	p.replaced[txSlot{from: from, nonce: tx.Nonce()}]++
+}
+
+// Evict implements TxPoolPolicy. Nothing is evicted, but the replacement counts
+// of transactions no longer in the pool are forgotten.
+func (p *PriceBumpCurvePolicy) Evict(view TxPoolView) []*types.Transaction {
+	for slot := range p.replaced {
+		if view.Get(slot.from, slot.nonce) == nil {
+			delete(p.replaced, slot)
+		}
+	}
+	return nil
+}
+
+// AgingPolicy is an eviction policy dropping remote transactions which have
+// been sitting in the pool for too long, whether executable or not.
+type AgingPolicy struct {
+	MaxAge time.Duration // Maximum amount of time a transaction may stay in the pool
+}
+
+// Name implements TxPoolPolicy.
+func (p *AgingPolicy) Name() string { return "aging" }
+
+// Admit implements TxPoolPolicy. New transactions are not concerned.
+func (p *AgingPolicy) Admit(view TxPoolView, from common.Address, tx *types.Transaction) error {
+	return nil
+}
+
+// Replace implements TxPoolPolicy. Replacements are not concerned.
+func (p *AgingPolicy) Replace(view TxPoolView, from common.Address, old, tx *types.Transaction) error {
+	return nil
+}
+
+// Replaced implements TxPoolPolicy.
+func (p *AgingPolicy) Replaced(view TxPoolView, from common.Address, old, tx *types.Transaction) {
+}
+
+// Evict implements TxPoolPolicy, returning all transactions exceeding the age
+// limit. Transactions of the same account are returned highest nonce first, so
+// dropping them doesn't needlessly shuffle the rest between pending and queued.
+func (p *AgingPolicy) Evict(view TxPoolView) []*types.Transaction {
+	var drops []*types.Transaction
+	for _, txs := range view.Remotes() {
+		for i := len(txs) - 1; i >= 0; i-- {
+			if arrival := view.Arrival(txs[i].Hash()); !arrival.IsZero() && time.Since(arrival) > p.MaxAge {
+				drops = append(drops, txs[i])
+			}
+		}
+	}
+	return drops
+}
@@ -0,0 +1,256 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// setupPolicyTxPool creates a transaction pool with the given config tweaks.
+func setupPolicyTxPool(tweak func(*TxPoolConfig)) *TxPool {
+	db, _ := ethdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+	blockchain := &testBlockChain{statedb, 1000000, new(event.Feed)}
+
+	config := testTxPoolConfig
+	tweak(&config)
+
+	return NewTxPool(config, params.TestChainConfig, blockchain)
+}
+
+// Tests that the sender cap policy limits the number of transactions a remote
+// account may have in the pool, but doesn't interfere with replacements or with
+// local accounts.
+func TestTransactionPolicySenderCap(t *testing.T) {
+	t.Parallel()
+
+	pool := setupPolicyTxPool(func(config *TxPoolConfig) { config.SenderCap = 2 })
+	defer pool.Stop()
+
+	remote, _ := crypto.GenerateKey()
+	local, _ := crypto.GenerateKey()
+	pool.currentState.AddBalance(crypto.PubkeyToAddress(remote.PublicKey), big.NewInt(1000000000))
+	pool.currentState.AddBalance(crypto.PubkeyToAddress(local.PublicKey), big.NewInt(1000000000))
+
+	// Fill the remote account up to its cap (one pending, one queued)
+	if err := pool.AddRemote(pricedTransaction(0, 100000, big.NewInt(1), remote)); err != nil {
+		t.Fatalf("failed to add first remote transaction: %v", err)
+	}
+	if err := pool.AddRemote(pricedTransaction(2, 100000, big.NewInt(1), remote)); err != nil {
+		t.Fatalf("failed to add second remote transaction: %v", err)
+	}
+	if err := pool.AddRemote(pricedTransaction(1, 100000, big.NewInt(1), remote)); err != ErrSenderCapped {
+		t.Fatalf("capped remote transaction error mismatch: have %v, want %v", err, ErrSenderCapped)
+	}
+	// Replacements don't grow the account, they should be accepted
+	if err := pool.AddRemote(pricedTransaction(2, 100000, big.NewInt(2), remote)); err != nil {
+		t.Fatalf("failed to replace remote transaction: %v", err)
+	}
+	// Local accounts are exempt from the cap
+	for i := uint64(0); i < 4; i++ {
+		if err := pool.AddLocal(pricedTransaction(i, 100000, big.NewInt(1), local)); err != nil {
+			t.Fatalf("failed to add local transaction %d: %v", i, err)
+		}
+	}
+	pending, queued := pool.Stats()
+	if pending != 5 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 5)
+	}
+	if queued != 1 {
+		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 1)
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that the price bump curve policy demands increasingly higher price bumps
+// for successive replacements of the same transaction.
+func TestTransactionPolicyPriceBumpCurve(t *testing.T) {
+	t.Parallel()
+
+	pool := setupPolicyTxPool(func(config *TxPoolConfig) {
+		config.PriceBump = 10
+		config.PriceBumpGrowth = 100
+	})
+	defer pool.Stop()
+
+	key, _ := crypto.GenerateKey()
+	pool.currentState.AddBalance(crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000000))
+
+	if err := pool.AddRemote(pricedTransaction(0, 100000, big.NewInt(100), key)); err != nil {
+		t.Fatalf("failed to add original transaction: %v", err)
+	}
+	// The first replacement needs a 10% bump, the second 20%, the third 40%
+	steps := []struct {
+		price int64
+		err   error
+	}{
+		{109, ErrReplaceUnderpriced},
+		{110, nil},
+		{131, ErrReplaceUnderpriced},
+		{132, nil},
+		{183, ErrReplaceUnderpriced},
+		{184, nil},
+	}
+	for i, step := range steps {
+		if err := pool.AddRemote(pricedTransaction(0, 100000, big.NewInt(step.price), key)); err != step.err {
+			t.Fatalf("step %d: replacement error mismatch: have %v, want %v", i, err, step.err)
+		}
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// errReplaceVetoed is returned by the vetoPolicy for rejected replacements.
+var errReplaceVetoed = errors.New("replacement vetoed")
+
+// vetoPolicy is a test policy rejecting all replacements while vetoing.
+type vetoPolicy struct {
+	veto bool
+}
+
+func (p *vetoPolicy) Name() string { return "veto" }
+
+func (p *vetoPolicy) Admit(view TxPoolView, from common.Address, tx *types.Transaction) error {
+	return nil
+}
+
+func (p *vetoPolicy) Replace(view TxPoolView, from common.Address, old, tx *types.Transaction) error {
+	if p.veto {
+		return errReplaceVetoed
+	}
+	return nil
+}
+
+func (p *vetoPolicy) Replaced(view TxPoolView, from common.Address, old, tx *types.Transaction) {}
+
+func (p *vetoPolicy) Evict(view TxPoolView) []*types.Transaction { return nil }
+
+// Tests that replacements accepted by the price bump curve but rejected by a
+// later policy don't raise the price bump required for the next replacement.
+func TestTransactionPolicyPriceBumpCurveVetoed(t *testing.T) {
+	t.Parallel()
+
+	veto := &vetoPolicy{veto: true}
+	pool := setupPolicyTxPool(func(config *TxPoolConfig) {
+		config.PriceBump = 10
+		config.PriceBumpGrowth = 100
+		config.Policies = []TxPoolPolicy{veto}
+	})
+	defer pool.Stop()
+
+	key, _ := crypto.GenerateKey()
+	pool.currentState.AddBalance(crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000000))
+
+	// Add a pending and a queued transaction, and try to replace both while vetoed
+	for _, nonce := range []uint64{0, 2} {
+		if err := pool.AddRemote(pricedTransaction(nonce, 100000, big.NewInt(100), key)); err != nil {
+			t.Fatalf("nonce %d: failed to add original transaction: %v", nonce, err)
+		}
+		for i := 0; i < 3; i++ {
+			if err := pool.AddRemote(pricedTransaction(nonce, 100000, big.NewInt(110), key)); err != errReplaceVetoed {
+				t.Fatalf("nonce %d: vetoed replacement error mismatch: have %v, want %v", nonce, err, errReplaceVetoed)
+			}
+		}
+	}
+	// Lift the veto, the first replacements should still need a 10% bump only
+	veto.veto = false
+	for _, nonce := range []uint64{0, 2} {
+		steps := []struct {
+			price int64
+			err   error
+		}{
+			{110, nil},
+			{131, ErrReplaceUnderpriced},
+			{132, nil},
+		}
+		for i, step := range steps {
+			if err := pool.AddRemote(pricedTransaction(nonce, 100000, big.NewInt(step.price), key)); err != step.err {
+				t.Fatalf("nonce %d, step %d: replacement error mismatch: have %v, want %v", nonce, i, err, step.err)
+			}
+		}
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that the aging policy evicts remote transactions which stayed in the pool
+// for too long, but leaves fresh ones and local ones alone.
+func TestTransactionPolicyAging(t *testing.T) {
+	t.Parallel()
+
+	pool := setupPolicyTxPool(func(config *TxPoolConfig) { config.MaxAge = time.Minute })
+	defer pool.Stop()
+
+	remote, _ := crypto.GenerateKey()
+	local, _ := crypto.GenerateKey()
+	pool.currentState.AddBalance(crypto.PubkeyToAddress(remote.PublicKey), big.NewInt(1000000000))
+	pool.currentState.AddBalance(crypto.PubkeyToAddress(local.PublicKey), big.NewInt(1000000000))
+
+	stale := pricedTransaction(0, 100000, big.NewInt(1), remote)
+	fresh := pricedTransaction(1, 100000, big.NewInt(1), remote)
+	if err := pool.AddRemotes([]*types.Transaction{stale, fresh}); err[0] != nil || err[1] != nil {
+		t.Fatalf("failed to add remote transactions: %v", err)
+	}
+	if err := pool.AddLocal(pricedTransaction(0, 100000, big.NewInt(1), local)); err != nil {
+		t.Fatalf("failed to add local transaction: %v", err)
+	}
+	// Age all but one of the remote transactions and run the eviction
+	pool.mu.Lock()
+	for hash := range pool.arrivals {
+		if hash != fresh.Hash() {
+			pool.arrivals[hash] = time.Now().Add(-2 * time.Minute)
+		}
+	}
+	pool.evictPolicies()
+	pool.mu.Unlock()
+
+	// The stale transaction is gone, the fresh one is demoted into the queue
+	if pool.Get(stale.Hash()) != nil {
+		t.Fatalf("stale remote transaction not evicted")
+	}
+	if pool.Get(fresh.Hash()) == nil {
+		t.Fatalf("fresh remote transaction evicted")
+	}
+	pending, queued := pool.Stats()
+	if pending != 1 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 1)
+	}
+	if queued != 1 {
+		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 1)
+	}
+	if len(pool.arrivals) != 2 {
+		t.Fatalf("arrival times not pruned: have %d, want %d", len(pool.arrivals), 2)
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
@@ -666,6 +666,40 @@ func TestTransactionPostponing(t *testing.T) {
 	}
 }
 
+// Tests that transactions demoted from the pending to the queued set are not
+// tracked twice in the price list of the pool.
+func TestTransactionDemotionTracking(t *testing.T) {
+	t.Parallel()
+
+	// Create a test account and fund it
+	pool, key := setupTxPool()
+	defer pool.Stop()
+
+	account, _ := deriveSender(transaction(0, 0, key))
+	pool.currentState.AddBalance(account, big.NewInt(1000))
+
+	// Add three pending transactions, the middle one being the most expensive
+	var (
+		tx0 = transaction(0, 100, key)
+		tx1 = transaction(1, 300, key)
+		tx2 = transaction(2, 100, key)
+	)
+	pool.promoteTx(account, tx0.Hash(), tx0)
+	pool.promoteTx(account, tx1.Hash(), tx1)
+	pool.promoteTx(account, tx2.Hash(), tx2)
+
+	// Reduce the balance so the middle one is dropped and the last one demoted
+	pool.currentState.AddBalance(account, big.NewInt(-750))
+	pool.lockedReset(nil, nil)
+
+	if pending, queued := pool.Stats(); pending != 1 || queued != 1 {
+		t.Fatalf("pool stats mismatch: have %d pending, %d queued, want 1 pending, 1 queued", pending, queued)
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
 // Tests that if the transaction pool has both executable and non-executable
 // transactions from an origin account, filling the nonce gap moves all queued
 // ones into the pending pool.
@@ -98,8 +98,18 @@ type headerMarshaling struct {
 }
 
 // Hash returns the block hash of the header, which is simply the keccak256 hash of its
-// RLP encoding.
+// RLP encoding. Istanbul headers are hashed without their committed seals.
+//
+// The exception doesn't alter the hashes of other chains: clique enforces a zero
+// mix digest, and an ethash mix digest is the output of the proof-of-work, which
+// can't be steered into the istanbul one. Headers with undecodable istanbul
+// extra-data are hashed in full too.
 func (h *Header) Hash() common.Hash {
+	if h.MixDigest == IstanbulDigest {
+		if filtered := IstanbulFilteredHeader(h, true); filtered != nil {
+			return rlpHash(filtered)
+		}
+	}
 	return rlpHash(h)
 }
 
@@ -0,0 +1,85 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"errors"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+var (
+	// IstanbulDigest is the fixed mix digest of the blocks sealed by the istanbul
+	// byzantine fault tolerant consensus engine, used to identify them (i.e.
+	// "Istanbul practical byzantine fault tolerance").
+	IstanbulDigest = common.HexToHash("0x63746963616c2062797a616e74696e65206661756c7420746f6c6572616e6365")
+
+	// IstanbulExtraVanity is the fixed number of extra-data prefix bytes of the
+	// istanbul headers reserved for validator vanity.
+	IstanbulExtraVanity = 32
+
+	// ErrInvalidIstanbulHeaderExtra is returned if the extra-data section of an
+	// istanbul header cannot be decoded.
+	ErrInvalidIstanbulHeaderExtra = errors.New("invalid istanbul header extra-data")
+)
+
+// IstanbulExtra is the consensus data stored in the extra-data section of the
+// istanbul headers, following the fixed size vanity prefix.
+type IstanbulExtra struct {
+	Validators    []common.Address // Validators of the next block, in ascending order
+	Seal          []byte           // Signature of the proposer over the header
+	CommittedSeal [][]byte         // Signatures of the validators committing to the header
+}
+
+// ExtractIstanbulExtra decodes the consensus data from the extra-data section of
+// an istanbul header.
+func ExtractIstanbulExtra(h *Header) (*IstanbulExtra, error) {
+	if len(h.Extra) < IstanbulExtraVanity {
+		return nil, ErrInvalidIstanbulHeaderExtra
+	}
+	extra := new(IstanbulExtra)
+	if err := rlp.DecodeBytes(h.Extra[IstanbulExtraVanity:], extra); err != nil {
+		return nil, ErrInvalidIstanbulHeaderExtra
+	}
+	return extra, nil
+}
+
+// IstanbulFilteredHeader returns a copy of an istanbul header with the committed
+// seals and, if requested, the proposer seal removed from the extra-data. Nil is
+// returned if the extra-data cannot be decoded.
+//
+// The committed seals are collected independently by every validator, so they
+// are left out of the block hash for all validators to agree on it.
+func IstanbulFilteredHeader(h *Header, keepSeal bool) *Header {
+	extra, err := ExtractIstanbulExtra(h)
+	if err != nil {
+		return nil
+	}
+	if !keepSeal {
+		extra.Seal = []byte{}
+	}
+	extra.CommittedSeal = [][]byte{}
+
+	payload, err := rlp.EncodeToBytes(extra)
+	if err != nil {
+		return nil
+	}
+	cpy := CopyHeader(h)
+	cpy.Extra = append(cpy.Extra[:IstanbulExtraVanity:IstanbulExtraVanity], payload...)
+	return cpy
+}
@@ -0,0 +1,71 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// istanbulTestHeader creates a header carrying the given istanbul consensus data.
+func istanbulTestHeader(t *testing.T, extra *IstanbulExtra) *Header {
+	payload, err := rlp.EncodeToBytes(extra)
+	if err != nil {
+		t.Fatalf("failed to encode istanbul extra: %v", err)
+	}
+	return &Header{
+		Number:     big.NewInt(1),
+		Difficulty: big.NewInt(1),
+		MixDigest:  IstanbulDigest,
+		Extra:      append(make([]byte, IstanbulExtraVanity), payload...),
+	}
+}
+
+// Tests that istanbul headers are hashed without their committed seals, while
+// the hashes of all other headers remain the plain hash of their encoding.
+func TestIstanbulHeaderHash(t *testing.T) {
+	validators := []common.Address{{0x01}, {0x02}}
+
+	// Committed seals must not affect the hash, the proposer seal must
+	header := istanbulTestHeader(t, &IstanbulExtra{Validators: validators, Seal: []byte{0x01}})
+	committed := istanbulTestHeader(t, &IstanbulExtra{Validators: validators, Seal: []byte{0x01}, CommittedSeal: [][]byte{{0x02}, {0x03}}})
+	resealed := istanbulTestHeader(t, &IstanbulExtra{Validators: validators, Seal: []byte{0x04}})
+
+	if header.Hash() != committed.Hash() {
+		t.Errorf("committed seals changed the hash: have %x, want %x", committed.Hash(), header.Hash())
+	}
+	if header.Hash() == resealed.Hash() {
+		t.Errorf("proposer seal didn't change the hash")
+	}
+	if committed.Hash() == rlpHash(committed) {
+		t.Errorf("istanbul header hashed in full")
+	}
+	// Headers of other engines, and istanbul ones with invalid consensus data, must
+	// be hashed in full
+	for i, header := range []*Header{
+		{Number: big.NewInt(1), Difficulty: big.NewInt(2), Extra: make([]byte, 32+65)},                                             // clique
+		{Number: big.NewInt(1), Difficulty: big.NewInt(131072), MixDigest: common.HexToHash("0xbd4472abb6659ebe3ee06ee4d7b72a00")}, // ethash
+		{Number: big.NewInt(1), Difficulty: big.NewInt(1), MixDigest: IstanbulDigest, Extra: []byte{0x01, 0x02}},                   // invalid istanbul
+	} {
+		if have, want := header.Hash(), rlpHash(header); have != want {
+			t.Errorf("header %d: hash mismatch: have %x, want %x", i, have, want)
+		}
+	}
+}
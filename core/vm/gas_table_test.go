@@ -16,7 +16,16 @@
 
 package vm
 
-import "testing"
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+)
 
 func TestMemoryGasCost(t *testing.T) {
 	//size := uint64(math.MaxUint64 - 64)
@@ -34,3 +43,74 @@ func TestMemoryGasCost(t *testing.T) {
 		t.Error("expected error")
 	}
 }
+
+var eip1283Tests = []struct {
+	original byte
+	code     string
+	used     uint64
+	refund   uint64
+}{
+	{0, "0x60006000556000600055", 412, 0},
+	{0, "0x60006000556001600055", 20212, 0},
+	{0, "0x60016000556000600055", 20212, 19800},
+	{0, "0x60016000556002600055", 20212, 0},
+	{0, "0x60016000556001600055", 20212, 0},
+	{1, "0x60006000556000600055", 5212, 15000},
+	{1, "0x60006000556001600055", 5212, 4800},
+	{1, "0x60006000556002600055", 5212, 0},
+	{1, "0x60026000556000600055", 5212, 15000},
+	{1, "0x60026000556003600055", 5212, 0},
+	{1, "0x60026000556001600055", 5212, 4800},
+	{1, "0x60026000556002600055", 5212, 0},
+	{1, "0x60016000556000600055", 5212, 15000},
+	{1, "0x60016000556002600055", 5212, 0},
+	{1, "0x60016000556001600055", 412, 0},
+	{0, "0x600160005560006000556001600055", 40218, 19800},
+	{1, "0x600060005560016000556000600055", 10218, 19800},
+}
+
+// Tests the net gas metering of SSTORE against the test cases of EIP-1283.
+func TestEIP1283(t *testing.T) {
+	config := *params.AllEthashProtocolChanges
+	config.EIP1283Block = big.NewInt(1)
+
+	for i, tt := range eip1283Tests {
+		// Run the test case both before and after the fork
+		used, refund := testSStore(t, &config, big.NewInt(1), tt.original, tt.code)
+		if used != tt.used {
+			t.Errorf("test %d: gas used mismatch: have %v, want %v", i, used, tt.used)
+		}
+		if refund != tt.refund {
+			t.Errorf("test %d: gas refund mismatch: have %v, want %v", i, refund, tt.refund)
+		}
+		if used, _ := testSStore(t, &config, big.NewInt(0), tt.original, tt.code); used == tt.used {
+			t.Errorf("test %d: net gas metering active before the fork", i)
+		}
+	}
+}
+
+// testSStore runs the given code on top of a storage slot with the given
+// original value, returning the gas used and refunded.
+func testSStore(t *testing.T, config *params.ChainConfig, number *big.Int, original byte, code string) (uint64, uint64) {
+	address := common.BytesToAddress([]byte("contract"))
+	db, _ := ethdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+	statedb.CreateAccount(address)
+	statedb.SetCode(address, hexutil.MustDecode(code))
+	statedb.SetState(address, common.Hash{}, common.BytesToHash([]byte{original}))
+	statedb.Finalise(true) // Push the state into the "original" slot
+
+	vmctx := Context{
+		CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+		Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+		BlockNumber: number,
+	}
+	vmenv := NewEVM(vmctx, statedb, config, Config{})
+
+	gas := uint64(100000)
+	_, left, err := vmenv.Call(AccountRef(common.Address{}), address, nil, gas, new(big.Int))
+	if err != nil {
+		t.Fatalf("failed to execute code %s: %v", code, err)
+	}
+	return gas - left, statedb.GetRefund()
+}
@@ -17,6 +17,7 @@
 package runtime
 
 import (
+	"bytes"
 	"math/big"
 	"strings"
 	"testing"
@@ -25,7 +26,9 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
 )
 
 func TestDefaults(t *testing.T) {
@@ -117,6 +120,109 @@ func TestCall(t *testing.T) {
 	}
 }
 
+// constantinopleConfig returns a chain configuration scheduling Constantinople
+// at the given block.
+func constantinopleConfig(block int64) *params.ChainConfig {
+	return &params.ChainConfig{
+		ChainId:             big.NewInt(1),
+		HomesteadBlock:      new(big.Int),
+		EIP150Block:         new(big.Int),
+		EIP155Block:         new(big.Int),
+		EIP158Block:         new(big.Int),
+		ByzantiumBlock:      new(big.Int),
+		ConstantinopleBlock: big.NewInt(block),
+	}
+}
+
+// Tests that EXTCODEHASH returns the code hash of existing accounts and zero for
+// non-existent ones, and that it's only available after Constantinople.
+func TestExtCodeHash(t *testing.T) {
+	code := []byte{
+		byte(vm.ADDRESS),
+		byte(vm.EXTCODEHASH),
+		byte(vm.PUSH1), 0,
+		byte(vm.MSTORE),
+		byte(vm.PUSH1), 0xff,
+		byte(vm.EXTCODEHASH),
+		byte(vm.PUSH1), 32,
+		byte(vm.MSTORE),
+		byte(vm.PUSH1), 64,
+		byte(vm.PUSH1), 0,
+		byte(vm.RETURN),
+	}
+	config := constantinopleConfig(5)
+
+	if _, _, err := Execute(code, nil, &Config{ChainConfig: config, BlockNumber: big.NewInt(4)}); err == nil {
+		t.Fatalf("EXTCODEHASH executed before Constantinople")
+	}
+	ret, _, err := Execute(code, nil, &Config{ChainConfig: config, BlockNumber: big.NewInt(5)})
+	if err != nil {
+		t.Fatalf("failed to execute EXTCODEHASH: %v", err)
+	}
+	if hash := common.BytesToHash(ret[:32]); hash != crypto.Keccak256Hash(code) {
+		t.Errorf("code hash mismatch: have %x, want %x", hash, crypto.Keccak256Hash(code))
+	}
+	if hash := common.BytesToHash(ret[32:]); hash != (common.Hash{}) {
+		t.Errorf("non-existent account hash mismatch: have %x, want zero", hash)
+	}
+}
+
+// Tests that CREATE2 deploys contracts to the salted address derived from the
+// init code, fails on address collisions, and is only available after
+// Constantinople.
+func TestCreate2(t *testing.T) {
+	// Init code deploying a single 0x2a byte
+	initCode := []byte{
+		byte(vm.PUSH1), 0x2a,
+		byte(vm.PUSH1), 0,
+		byte(vm.MSTORE8),
+		byte(vm.PUSH1), 1,
+		byte(vm.PUSH1), 0,
+		byte(vm.RETURN),
+	}
+	create2 := []byte{
+		byte(vm.PUSH1), 1, // salt
+		byte(vm.PUSH1), byte(len(initCode)), // size
+		byte(vm.PUSH1), byte(32 - len(initCode)), // offset
+		byte(vm.PUSH1), 0, // value
+		byte(vm.CREATE2),
+	}
+	// Store the init code in memory, create the contract twice with the same
+	// salt and return both addresses
+	code := append([]byte{byte(vm.PUSH10)}, initCode...)
+	code = append(code, byte(vm.PUSH1), 0, byte(vm.MSTORE))
+	code = append(code, create2...)
+	code = append(code, create2...)
+	code = append(code,
+		byte(vm.PUSH1), 64,
+		byte(vm.MSTORE),
+		byte(vm.PUSH1), 32,
+		byte(vm.MSTORE),
+		byte(vm.PUSH1), 64,
+		byte(vm.PUSH1), 32,
+		byte(vm.RETURN),
+	)
+	config := constantinopleConfig(5)
+
+	if _, _, err := Execute(code, nil, &Config{ChainConfig: config, BlockNumber: big.NewInt(4)}); err == nil {
+		t.Fatalf("CREATE2 executed before Constantinople")
+	}
+	ret, statedb, err := Execute(code, nil, &Config{ChainConfig: config, BlockNumber: big.NewInt(5)})
+	if err != nil {
+		t.Fatalf("failed to execute CREATE2: %v", err)
+	}
+	want := crypto.CreateAddress2(common.StringToAddress("contract"), common.BigToHash(big.NewInt(1)), crypto.Keccak256(initCode))
+	if have := common.BytesToAddress(ret[:32]); have != want {
+		t.Errorf("created address mismatch: have %x, want %x", have, want)
+	}
+	if have := common.BytesToAddress(ret[32:]); have != (common.Address{}) {
+		t.Errorf("colliding creation succeeded: have %x", have)
+	}
+	if code := statedb.GetCode(want); !bytes.Equal(code, []byte{0x2a}) {
+		t.Errorf("deployed code mismatch: have %x, want 2a", code)
+	}
+}
+
 func BenchmarkCall(b *testing.B) {
 	var definition = `[{"constant":true,"inputs":[],"name":"seller","outputs":[{"name":"","type":"address"}],"type":"function"},{"constant":false,"inputs":[],"name":"abort","outputs":[],"type":"function"},{"constant":true,"inputs":[],"name":"value","outputs":[{"name":"","type":"uint256"}],"type":"function"},{"constant":false,"inputs":[],"name":"refund","outputs":[],"type":"function"},{"constant":true,"inputs":[],"name":"buyer","outputs":[{"name":"","type":"address"}],"type":"function"},{"constant":false,"inputs":[],"name":"confirmReceived","outputs":[],"type":"function"},{"constant":true,"inputs":[],"name":"state","outputs":[{"name":"","type":"uint8"}],"type":"function"},{"constant":false,"inputs":[],"name":"confirmPurchase","outputs":[],"type":"function"},{"inputs":[],"type":"constructor"},{"anonymous":false,"inputs":[],"name":"Aborted","type":"event"},{"anonymous":false,"inputs":[],"name":"PurchaseConfirmed","type":"event"},{"anonymous":false,"inputs":[],"name":"ItemReceived","type":"event"},{"anonymous":false,"inputs":[],"name":"Refunded","type":"event"}]`
 
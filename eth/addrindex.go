@@ -0,0 +1,129 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// AddressIndexer implements a core.ChainIndexer, building up an exact index of
+// the logs and transactions of the canonical chain by address, permitting fast
+// log filtering even for addresses present in most bloom filters.
+type AddressIndexer struct {
+	config *params.ChainConfig // Chain configuration to derive the transaction senders with
+
+	db    ethdb.Database // database instance to write index data into
+	batch ethdb.Batch    // batch collecting the index entries of the current section
+	err   error          // first failure while processing the current section
+}
+
+// NewAddressIndexer returns a chain indexer that generates the address index of
+// the canonical chain for fast logs filtering and transaction lookups.
+func NewAddressIndexer(db ethdb.Database, config *params.ChainConfig, size uint64) *core.ChainIndexer {
+	backend := &AddressIndexer{
+		config: config,
+		db:     db,
+	}
+	table := ethdb.NewTable(db, string(core.AddressIndexPrefix))
+
+	return core.NewChainIndexer(db, table, backend, size, bloomConfirms, bloomThrottling, "addrindex")
+}
+
+// Reset implements core.ChainIndexerBackend, starting a new address index
+// section. Entries left over from a reorged section are not deleted, they are
+// skipped during lookups by being non-canonical.
+func (b *AddressIndexer) Reset(section uint64, lastSectionHead common.Hash) error {
+	b.batch, b.err = b.db.NewBatch(), nil
+	return nil
+}
+
+// Process implements core.ChainIndexerBackend, adding the logs and transactions
+// of a new block into the index.
+func (b *AddressIndexer) Process(header *types.Header) {
+	if b.err != nil {
+		return
+	}
+	var (
+		number = header.Number.Uint64()
+		hash   = header.Hash()
+	)
+	body := core.GetBody(b.db, hash, number)
+	if body == nil {
+		b.err = fmt.Errorf("block body #%d [%x…] missing", number, hash[:4])
+		return
+	}
+	receipts := core.GetBlockReceipts(b.db, hash, number)
+	if len(receipts) != len(body.Transactions) {
+		b.err = fmt.Errorf("block receipts #%d [%x…] missing", number, hash[:4])
+		return
+	}
+	signer := types.MakeSigner(b.config, header.Number)
+
+	var logIndex uint
+	for i, tx := range body.Transactions {
+		from, to, err := txParticipants(signer, tx, receipts[i])
+		if err != nil {
+			b.err = err
+			return
+		}
+		core.WriteAddressTxIndex(b.batch, from, number, hash, uint(i))
+		if to != from {
+			core.WriteAddressTxIndex(b.batch, to, number, hash, uint(i))
+		}
+		for _, log := range receipts[i].Logs {
+			var topic common.Hash
+			if len(log.Topics) > 0 {
+				topic = log.Topics[0]
+			}
+			core.WriteAddressLogIndex(b.batch, log.Address, topic, number, hash, uint(i), logIndex)
+			logIndex++
+		}
+	}
+	if b.batch.ValueSize() >= ethdb.IdealBatchSize {
+		if b.err = b.batch.Write(); b.err == nil {
+			b.batch.Reset()
+		}
+	}
+}
+
+// Commit implements core.ChainIndexerBackend, writing out the remaining index
+// entries of the section into the database.
+func (b *AddressIndexer) Commit() error {
+	if b.err != nil {
+		return b.err
+	}
+	return b.batch.Write()
+}
+
+// txParticipants returns the sender and the recipient of a transaction, the
+// latter being the created contract in case of contract creations.
+func txParticipants(signer types.Signer, tx *types.Transaction, receipt *types.Receipt) (common.Address, common.Address, error) {
+	from, err := types.Sender(signer, tx)
+	if err != nil {
+		return common.Address{}, common.Address{}, err
+	}
+	if to := tx.To(); to != nil {
+		return from, *to, nil
+	}
+	return from, receipt.ContractAddress, nil
+}
@@ -0,0 +1,192 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Tests that the address indexer records the transactions of both the senders
+// and recipients, and the logs by emitter and first topic.
+func TestAddressIndexer(t *testing.T) {
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		gspec   = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  core.GenesisAlloc{address: {Balance: big.NewInt(1000000000)}},
+		}
+		db, _   = ethdb.NewMemDatabase()
+		genesis = gspec.MustCommit(db)
+		signer  = types.HomesteadSigner{}
+
+		recipient = common.Address{0xaa}
+		contract  = crypto.CreateAddress(address, 1)
+		initcode  = []byte{0x60, 0x42, 0x60, 0x00, 0x60, 0x00, 0xa1, 0x00} // LOG1(0, 0, 0x42)
+	)
+	blocks, _ := core.GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 4, func(i int, b *core.BlockGen) {
+		switch i {
+		case 0:
+			tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(address), recipient, big.NewInt(1), params.TxGas, nil, nil), signer, key)
+			b.AddTx(tx)
+		case 2:
+			tx, _ := types.SignTx(types.NewContractCreation(b.TxNonce(address), nil, 100000, nil, initcode), signer, key)
+			b.AddTx(tx)
+		}
+	})
+	chain, _ := core.NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{})
+	defer chain.Stop()
+
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	indexer := &AddressIndexer{config: gspec.Config, db: db}
+	indexer.Reset(0, common.Hash{})
+	for i := uint64(0); i <= 4; i++ {
+		indexer.Process(chain.GetHeaderByNumber(i))
+	}
+	if err := indexer.Commit(); err != nil {
+		t.Fatalf("failed to commit index: %v", err)
+	}
+	// Check the transaction entries of the sender and the recipients
+	tests := []struct {
+		address common.Address
+		blocks  []uint64
+	}{
+		{address, []uint64{1, 3}},
+		{recipient, []uint64{1}},
+		{contract, []uint64{3}},
+		{common.Address{0xbb}, nil},
+	}
+	for i, tt := range tests {
+		entries, err := core.FindAddressTransactions(db, tt.address, 0, 4)
+		if err != nil {
+			t.Fatalf("test %d: failed to find transactions: %v", i, err)
+		}
+		if len(entries) != len(tt.blocks) {
+			t.Fatalf("test %d: transaction count mismatch: have %d, want %d", i, len(entries), len(tt.blocks))
+		}
+		for j, entry := range entries {
+			if entry.Number != tt.blocks[j] || entry.Hash != blocks[entry.Number-1].Hash() || entry.TxIndex != 0 {
+				t.Errorf("test %d, entry %d: location mismatch: have %+v", i, j, entry)
+			}
+		}
+	}
+	// Check the log entries of the created contract
+	topic := common.Hash{31: 0x42}
+	for i, topic := range []*common.Hash{&topic, nil} {
+		entries, err := core.FindAddressLogs(db, contract, topic, 0, 4)
+		if err != nil {
+			t.Fatalf("test %d: failed to find logs: %v", i, err)
+		}
+		if len(entries) != 1 || entries[0].Number != 3 {
+			t.Errorf("test %d: log entries mismatch: have %+v", i, entries)
+		}
+	}
+	if entries, _ := core.FindAddressLogs(db, contract, &common.Hash{}, 0, 4); len(entries) != 0 {
+		t.Errorf("log found with mismatching topic: %+v", entries)
+	}
+	if entries, _ := core.FindAddressLogs(db, contract, nil, 0, 2); len(entries) != 0 {
+		t.Errorf("log found out of range: %+v", entries)
+	}
+}
+
+// Tests that transactions are looked up by address via the RPC API, and that
+// lookups scanning too many blocks not yet covered by the index are refused.
+func TestGetTransactionsByAddress(t *testing.T) {
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		gspec   = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  core.GenesisAlloc{address: {Balance: big.NewInt(1000000000)}},
+		}
+		db, _   = ethdb.NewMemDatabase()
+		genesis = gspec.MustCommit(db)
+		signer  = types.HomesteadSigner{}
+
+		recipient = common.Address{0xaa}
+		contract  = crypto.CreateAddress(address, 1)
+	)
+	blocks, _ := core.GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, maxUnindexedBlocks+1, func(i int, b *core.BlockGen) {
+		switch i {
+		case 0:
+			tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(address), recipient, big.NewInt(1), params.TxGas, nil, nil), signer, key)
+			b.AddTx(tx)
+		case 2:
+			tx, _ := types.SignTx(types.NewContractCreation(b.TxNonce(address), nil, 100000, nil, nil), signer, key)
+			b.AddTx(tx)
+		}
+	})
+	chain, _ := core.NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{})
+	defer chain.Stop()
+
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	eth := &Ethereum{chainDb: db, blockchain: chain, chainConfig: gspec.Config}
+	api := NewPublicEthereumAPI(eth)
+
+	if _, err := api.GetTransactionsByAddress(address, 0, rpc.LatestBlockNumber); err == nil {
+		t.Fatalf("lookup succeeded with the address index disabled")
+	}
+	eth.addrIndexer = NewAddressIndexer(db, gspec.Config, params.BloomBitsBlocks)
+	defer eth.addrIndexer.Close()
+
+	// Lookups within the scan limit are served from the unindexed blocks
+	tests := []struct {
+		address  common.Address
+		from, to rpc.BlockNumber
+		blocks   []uint64
+		tos      []common.Address
+	}{
+		{address, 0, 3, []uint64{1, 3}, []common.Address{recipient, contract}},
+		{recipient, 0, 3, []uint64{1}, []common.Address{recipient}},
+		{contract, 2, rpc.LatestBlockNumber, []uint64{3}, []common.Address{contract}},
+		{address, 2, 2, nil, nil},
+	}
+	for i, tt := range tests {
+		txs, err := api.GetTransactionsByAddress(tt.address, tt.from, tt.to)
+		if err != nil {
+			t.Fatalf("test %d: failed to look up transactions: %v", i, err)
+		}
+		if len(txs) != len(tt.blocks) {
+			t.Fatalf("test %d: transaction count mismatch: have %d, want %d", i, len(txs), len(tt.blocks))
+		}
+		for j, tx := range txs {
+			if uint64(tx.BlockNumber) != tt.blocks[j] || tx.BlockHash != blocks[tt.blocks[j]-1].Hash() || tx.From != address || tx.To != tt.tos[j] {
+				t.Errorf("test %d, tx %d: location mismatch: have %+v", i, j, tx)
+			}
+		}
+	}
+	// Lookups scanning more blocks than allowed are refused while the index lags
+	if _, err := api.GetTransactionsByAddress(address, 0, rpc.LatestBlockNumber); err == nil || !strings.Contains(err.Error(), "lagging") {
+		t.Errorf("unindexed range error mismatch: have %v, want lagging index", err)
+	}
+}
@@ -19,6 +19,7 @@ package eth
 import (
 	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
@@ -64,6 +65,124 @@ func (api *PublicEthereumAPI) Hashrate() hexutil.Uint64 {
 	return hexutil.Uint64(api.e.Miner().HashRate())
 }
 
+// maxUnindexedBlocks is the maximum number of blocks not yet covered by the
+// address index that GetTransactionsByAddress is willing to scan one by one.
+const maxUnindexedBlocks = 1024
+
+// AddressTransaction is the location of a transaction sent from or to an
+// address in the canonical chain.
+type AddressTransaction struct {
+	BlockHash        common.Hash    `json:"blockHash"`
+	BlockNumber      hexutil.Uint64 `json:"blockNumber"`
+	Hash             common.Hash    `json:"hash"`
+	TransactionIndex hexutil.Uint   `json:"transactionIndex"`
+	From             common.Address `json:"from"`
+	To               common.Address `json:"to"`
+}
+
+// GetTransactionsByAddress returns the canonical transactions sent from or to an
+// address within the given block range, including contract creations. It needs
+// the address index to be enabled, only the blocks not yet indexed are scanned.
+// Queries reaching too far beyond the indexed sections are refused until the
+// indexer catches up.
+func (api *PublicEthereumAPI) GetTransactionsByAddress(address common.Address, fromBlock, toBlock rpc.BlockNumber) ([]*AddressTransaction, error) {
+	if api.e.addrIndexer == nil {
+		return nil, errors.New("address index disabled")
+	}
+	head := api.e.BlockChain().CurrentBlock().NumberU64()
+
+	from, to := uint64(fromBlock), uint64(toBlock)
+	if fromBlock < 0 {
+		from = head
+	}
+	if toBlock < 0 || to > head {
+		to = head
+	}
+	if from > to {
+		return nil, fmt.Errorf("invalid block range %d-%d", from, to)
+	}
+	var (
+		db      = api.e.ChainDb()
+		results []*AddressTransaction
+	)
+	sections, _, _ := api.e.addrIndexer.Sections()
+	indexed := sections * params.BloomBitsBlocks
+
+	if start := indexed; to >= start {
+		if start < from {
+			start = from
+		}
+		if unindexed := to - start + 1; unindexed > maxUnindexedBlocks {
+			return nil, fmt.Errorf("address index lagging: %d blocks unindexed, max %d", unindexed, maxUnindexedBlocks)
+		}
+	}
+	if indexed > from {
+		end := to
+		if indexed <= end {
+			end = indexed - 1
+		}
+		entries, err := core.FindAddressTransactions(db, address, from, end)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			block := core.GetBlock(db, entry.Hash, entry.Number)
+			if block == nil || int(entry.TxIndex) >= len(block.Transactions()) {
+				return nil, fmt.Errorf("block #%d [%x…] missing", entry.Number, entry.Hash[:4])
+			}
+			receipts := core.GetBlockReceipts(db, entry.Hash, entry.Number)
+			if int(entry.TxIndex) >= len(receipts) {
+				return nil, fmt.Errorf("block receipts #%d [%x…] missing", entry.Number, entry.Hash[:4])
+			}
+			result, err := api.addressTransaction(block, receipts, entry.TxIndex)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, result)
+		}
+		from = end + 1
+	}
+	// Scan the remaining blocks not covered by the index yet
+	for number := from; number <= to; number++ {
+		block := api.e.BlockChain().GetBlockByNumber(number)
+		if block == nil {
+			return nil, fmt.Errorf("block #%d missing", number)
+		}
+		receipts := core.GetBlockReceipts(db, block.Hash(), number)
+		if len(receipts) != len(block.Transactions()) {
+			return nil, fmt.Errorf("block receipts #%d [%x…] missing", number, block.Hash().Bytes()[:4])
+		}
+		for i := range block.Transactions() {
+			result, err := api.addressTransaction(block, receipts, uint(i))
+			if err != nil {
+				return nil, err
+			}
+			if result.From == address || result.To == address {
+				results = append(results, result)
+			}
+		}
+	}
+	return results, nil
+}
+
+// addressTransaction assembles the location of a transaction in a block.
+func (api *PublicEthereumAPI) addressTransaction(block *types.Block, receipts types.Receipts, index uint) (*AddressTransaction, error) {
+	tx := block.Transactions()[index]
+
+	from, to, err := txParticipants(types.MakeSigner(api.e.chainConfig, block.Number()), tx, receipts[index])
+	if err != nil {
+		return nil, err
+	}
+	return &AddressTransaction{
+		BlockHash:        block.Hash(),
+		BlockNumber:      hexutil.Uint64(block.NumberU64()),
+		Hash:             tx.Hash(),
+		TransactionIndex: hexutil.Uint(index),
+		From:             from,
+		To:               to,
+	}, nil
+}
+
 // PublicMinerAPI provides an API to control the miner.
 // It offers only methods that operate on data that pose no security risk when it is publicly accessible.
 type PublicMinerAPI struct {
@@ -325,6 +444,8 @@ func (api *PublicDebugAPI) DumpBlock(blockNr rpc.BlockNumber) (state.Dump, error
 	if err != nil {
 		return state.Dump{}, err
 	}
+	defer stateDb.Release()
+
 	return stateDb.RawDump(), nil
 }
 
@@ -372,6 +493,8 @@ func (api *PrivateDebugAPI) StorageRangeAt(ctx context.Context, blockHash common
 	if err != nil {
 		return StorageRangeResult{}, err
 	}
+	defer statedb.Release()
+
 	st := statedb.StorageTrie(contractAddress)
 	if st == nil {
 		return StorageRangeResult{}, fmt.Errorf("account %x doesn't exist", contractAddress)
@@ -213,6 +213,14 @@ func (b *EthApiBackend) BloomStatus() (uint64, uint64) {
 	return params.BloomBitsBlocks, sections
 }
 
+func (b *EthApiBackend) AddressIndexStatus() (uint64, uint64) {
+	if b.eth.addrIndexer == nil {
+		return 0, 0
+	}
+	sections, _, _ := b.eth.addrIndexer.Sections()
+	return params.BloomBitsBlocks, sections
+}
+
 func (b *EthApiBackend) ServiceFilter(ctx context.Context, session *bloombits.MatcherSession) {
 	for i := 0; i < bloomFilterThreads; i++ {
 		go session.Multiplex(bloomRetrievalBatch, bloomRetrievalWait, b.eth.bloomRequests)
@@ -0,0 +1,404 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// flatTraceTracer is the name of the tracer used to gather the call trees which
+// are flattened into the trace namespace's output format.
+const flatTraceTracer = "flatCallTracer"
+
+// maxTraceFilterBlocks is the maximum number of blocks a single trace filter
+// query may span, as every block in the range needs to be re-executed.
+const maxTraceFilterBlocks = 1000
+
+// flatTraceErrors maps the error messages reported by the EVM to the ones used
+// by the flat trace format.
+var flatTraceErrors = map[string]string{
+	"execution reverted":      "Reverted",
+	"evm: execution reverted": "Reverted",
+	vm.ErrOutOfGas.Error():    "Out of gas",
+}
+
+// FlatTraceAction is the input of a single flat trace. Depending on the type of
+// the trace, only a subset of the fields are populated.
+type FlatTraceAction struct {
+	CallType      string          `json:"callType,omitempty"`      // Call opcode for call traces
+	From          *common.Address `json:"from,omitempty"`          // Caller for call and create traces
+	To            *common.Address `json:"to,omitempty"`            // Callee for call traces
+	Address       *common.Address `json:"address,omitempty"`       // Destructed contract for suicide traces
+	RefundAddress *common.Address `json:"refundAddress,omitempty"` // Beneficiary for suicide traces
+	Balance       string          `json:"balance,omitempty"`       // Ether refunded to the beneficiary for suicide traces
+	Gas           string          `json:"gas,omitempty"`           // Gas allowance of the call or create
+	Value         string          `json:"value,omitempty"`         // Ether transferred along the call or create
+	Input         string          `json:"input,omitempty"`         // Call data for call traces
+	Init          string          `json:"init,omitempty"`          // Init code for create traces
+}
+
+// FlatTraceResult is the output of a single successful flat trace.
+type FlatTraceResult struct {
+	GasUsed string          `json:"gasUsed,omitempty"` // Gas consumed by the call or create
+	Output  string          `json:"output,omitempty"`  // Return data for call traces
+	Address *common.Address `json:"address,omitempty"` // Deployed contract for create traces
+	Code    string          `json:"code,omitempty"`    // Deployed code for create traces
+}
+
+// FlatTrace is a single call, create or suicide action of a transaction, with
+// its position in the call tree encoded in its trace address.
+type FlatTrace struct {
+	Action              FlatTraceAction  `json:"action"`
+	BlockHash           common.Hash      `json:"blockHash"`
+	BlockNumber         uint64           `json:"blockNumber"`
+	Error               string           `json:"error,omitempty"`
+	Result              *FlatTraceResult `json:"result,omitempty"`
+	Subtraces           int              `json:"subtraces"`
+	TraceAddress        []int            `json:"traceAddress"`
+	TransactionHash     common.Hash      `json:"transactionHash"`
+	TransactionPosition uint64           `json:"transactionPosition"`
+	Type                string           `json:"type"`
+}
+
+// endpoints returns the sender and recipient of a trace, used for filtering.
+func (t *FlatTrace) endpoints() (from, to *common.Address) {
+	switch t.Type {
+	case "create":
+		if t.Result != nil {
+			return t.Action.From, t.Result.Address
+		}
+		return t.Action.From, nil
+	case "suicide":
+		return t.Action.Address, t.Action.RefundAddress
+	default:
+		return t.Action.From, t.Action.To
+	}
+}
+
+// callTraceFrame is a single call as reported by the call tracer.
+type callTraceFrame struct {
+	Type    string            `json:"type"`
+	From    *common.Address   `json:"from"`
+	To      *common.Address   `json:"to"`
+	Value   string            `json:"value"`
+	Gas     string            `json:"gas"`
+	GasUsed string            `json:"gasUsed"`
+	Input   string            `json:"input"`
+	Output  string            `json:"output"`
+	Error   string            `json:"error"`
+	Calls   []*callTraceFrame `json:"calls"`
+}
+
+// flattenCallTrace converts a call tree produced by the call tracer into a list
+// of flat traces, ordered depth first.
+func flattenCallTrace(frame *callTraceFrame, address []int, traces []*FlatTrace) []*FlatTrace {
+	trace := &FlatTrace{
+		Subtraces:    len(frame.Calls),
+		TraceAddress: address,
+	}
+	if err, ok := flatTraceErrors[frame.Error]; ok {
+		trace.Error = err
+	} else {
+		trace.Error = frame.Error
+	}
+	value := frame.Value
+	if value == "" {
+		value = "0x0"
+	}
+	switch frame.Type {
+	case vm.CREATE.String(), vm.CREATE2.String():
+		trace.Type = "create"
+		trace.Action = FlatTraceAction{
+			From:  frame.From,
+			Gas:   frame.Gas,
+			Value: value,
+			Init:  frame.Input,
+		}
+		if trace.Error == "" {
+			trace.Result = &FlatTraceResult{
+				GasUsed: frame.GasUsed,
+				Address: frame.To,
+				Code:    frame.Output,
+			}
+		}
+	case vm.OpCode(vm.SELFDESTRUCT).String():
+		// The flat call tracer reports the destructed contract as the sender, the
+		// beneficiary as the recipient and the refunded balance as the value
+		trace.Type = "suicide"
+		trace.Action = FlatTraceAction{
+			Address:       frame.From,
+			RefundAddress: frame.To,
+			Balance:       value,
+		}
+	default:
+		trace.Type = "call"
+		trace.Action = FlatTraceAction{
+			CallType: strings.ToLower(frame.Type),
+			From:     frame.From,
+			To:       frame.To,
+			Gas:      frame.Gas,
+			Value:    value,
+			Input:    frame.Input,
+		}
+		if trace.Error == "" {
+			output := frame.Output
+			if output == "" {
+				output = "0x"
+			}
+			trace.Result = &FlatTraceResult{
+				GasUsed: frame.GasUsed,
+				Output:  output,
+			}
+		}
+	}
+	traces = append(traces, trace)
+
+	for i, call := range frame.Calls {
+		// Copy the parent address so siblings don't share the backing array
+		child := make([]int, len(address)+1)
+		copy(child, address)
+		child[len(address)] = i
+
+		traces = flattenCallTrace(call, child, traces)
+	}
+	return traces
+}
+
+// flattenTxTrace decodes the result of a call tracer run on a transaction and
+// flattens it into a list of traces, annotated with the transaction's position.
+func flattenTxTrace(result interface{}, block *types.Block, tx common.Hash, index uint64) ([]*FlatTrace, error) {
+	blob, ok := result.(json.RawMessage)
+	if !ok {
+		return nil, fmt.Errorf("unexpected trace result type %T", result)
+	}
+	frame := new(callTraceFrame)
+	if err := json.Unmarshal(blob, frame); err != nil {
+		return nil, fmt.Errorf("failed to decode call trace: %v", err)
+	}
+	traces := flattenCallTrace(frame, []int{}, nil)
+	for _, trace := range traces {
+		trace.BlockHash = block.Hash()
+		trace.BlockNumber = block.NumberU64()
+		trace.TransactionHash = tx
+		trace.TransactionPosition = index
+	}
+	return traces, nil
+}
+
+// PrivateTraceAPI is the collection of Ethereum full node APIs exposing flat
+// call traces of transactions, the format popularized by Parity's trace module.
+type PrivateTraceAPI struct {
+	debug *PrivateDebugAPI
+}
+
+// NewPrivateTraceAPI creates a new API definition for the full node-related
+// private trace methods of the Ethereum service.
+func NewPrivateTraceAPI(config *params.ChainConfig, eth *Ethereum) *PrivateTraceAPI {
+	return &PrivateTraceAPI{debug: NewPrivateDebugAPI(config, eth)}
+}
+
+// flatTraceConfig returns the trace configuration used to gather call trees.
+func flatTraceConfig() *TraceConfig {
+	tracer := flatTraceTracer
+	return &TraceConfig{Tracer: &tracer}
+}
+
+// blockByNumber retrieves a block by number, resolving the special pending and
+// latest block tags.
+func (api *PrivateTraceAPI) blockByNumber(number rpc.BlockNumber) (*types.Block, error) {
+	var block *types.Block
+
+	switch number {
+	case rpc.PendingBlockNumber:
+		block = api.debug.eth.miner.PendingBlock()
+	case rpc.LatestBlockNumber:
+		block = api.debug.eth.blockchain.CurrentBlock()
+	default:
+		block = api.debug.eth.blockchain.GetBlockByNumber(uint64(number))
+	}
+	if block == nil {
+		return nil, fmt.Errorf("block #%d not found", number)
+	}
+	return block, nil
+}
+
+// traceBlock executes all the transactions of a block and returns their flat
+// call traces in execution order.
+func (api *PrivateTraceAPI) traceBlock(ctx context.Context, block *types.Block) ([]*FlatTrace, error) {
+	// Genesis has no parent state to execute on top of, nor any transactions
+	if block.NumberU64() == 0 {
+		return []*FlatTrace{}, nil
+	}
+	results, err := api.debug.traceBlock(ctx, block, flatTraceConfig())
+	if err != nil {
+		return nil, err
+	}
+	traces := []*FlatTrace{}
+	for i, tx := range block.Transactions() {
+		if results[i].Error != "" {
+			return nil, fmt.Errorf("tx %x trace failed: %s", tx.Hash(), results[i].Error)
+		}
+		txtraces, err := flattenTxTrace(results[i].Result, block, tx.Hash(), uint64(i))
+		if err != nil {
+			return nil, err
+		}
+		traces = append(traces, txtraces...)
+	}
+	return traces, nil
+}
+
+// Block returns the flat call traces of all the transactions in the block.
+func (api *PrivateTraceAPI) Block(ctx context.Context, number rpc.BlockNumber) ([]*FlatTrace, error) {
+	block, err := api.blockByNumber(number)
+	if err != nil {
+		return nil, err
+	}
+	return api.traceBlock(ctx, block)
+}
+
+// Transaction returns the flat call traces of a single transaction.
+func (api *PrivateTraceAPI) Transaction(ctx context.Context, hash common.Hash) ([]*FlatTrace, error) {
+	tx, blockHash, _, index := core.GetTransaction(api.debug.eth.ChainDb(), hash)
+	if tx == nil {
+		return nil, fmt.Errorf("transaction %x not found", hash)
+	}
+	block := api.debug.eth.blockchain.GetBlockByHash(blockHash)
+	if block == nil {
+		return nil, fmt.Errorf("block %x not found", blockHash)
+	}
+	msg, vmctx, statedb, err := api.debug.computeTxEnv(blockHash, int(index), defaultTraceReexec)
+	if err != nil {
+		return nil, err
+	}
+	defer statedb.Release()
+
+	result, err := api.debug.traceTx(ctx, msg, vmctx, statedb, flatTraceConfig())
+	if err != nil {
+		return nil, err
+	}
+	return flattenTxTrace(result, block, hash, index)
+}
+
+// TraceFilterArgs are the arguments of a trace filter query. A trace matches the
+// filter if its sender is within FromAddress and its recipient within ToAddress,
+// an empty address list matching anything.
+type TraceFilterArgs struct {
+	FromBlock   *rpc.BlockNumber `json:"fromBlock"`   // First block to trace (default latest)
+	ToBlock     *rpc.BlockNumber `json:"toBlock"`     // Last block to trace (default latest)
+	FromAddress []common.Address `json:"fromAddress"` // Senders to filter for
+	ToAddress   []common.Address `json:"toAddress"`   // Recipients to filter for
+	After       *uint64          `json:"after"`       // Number of matching traces to skip
+	Count       *uint64          `json:"count"`       // Maximum number of matching traces to return
+}
+
+// matches checks whether a flat trace passes the address filters.
+func (args *TraceFilterArgs) matches(trace *FlatTrace) bool {
+	from, to := trace.endpoints()
+	return containsAddress(args.FromAddress, from) && containsAddress(args.ToAddress, to)
+}
+
+// containsAddress checks whether an address is contained within a filter list,
+// an empty list matching any address.
+func containsAddress(list []common.Address, addr *common.Address) bool {
+	if len(list) == 0 {
+		return true
+	}
+	if addr == nil {
+		return false
+	}
+	for _, item := range list {
+		if item == *addr {
+			return true
+		}
+	}
+	return false
+}
+
+// blockRange resolves the block range of the filter against the current head,
+// rejecting invalid ranges and ones too long to trace in a single query.
+func (args *TraceFilterArgs) blockRange(head uint64) (uint64, uint64, error) {
+	resolve := func(number *rpc.BlockNumber) uint64 {
+		if number == nil || *number < 0 {
+			return head
+		}
+		return uint64(*number)
+	}
+	from, to := resolve(args.FromBlock), resolve(args.ToBlock)
+	if from > to {
+		return 0, 0, fmt.Errorf("invalid block range #%d-#%d", from, to)
+	}
+	if to > head {
+		return 0, 0, fmt.Errorf("block #%d not found", to)
+	}
+	if to-from >= maxTraceFilterBlocks {
+		return 0, 0, fmt.Errorf("block range #%d-#%d exceeds the maximum of %d blocks", from, to, maxTraceFilterBlocks)
+	}
+	return from, to, nil
+}
+
+// Filter returns the flat call traces within a range of blocks matching the
+// given sender and recipient filters.
+func (api *PrivateTraceAPI) Filter(ctx context.Context, args TraceFilterArgs) ([]*FlatTrace, error) {
+	from, to, err := args.blockRange(api.debug.eth.blockchain.CurrentBlock().NumberU64())
+	if err != nil {
+		return nil, err
+	}
+	// Trace the blocks one by one, collecting all matching traces
+	var (
+		traces  = []*FlatTrace{}
+		skipped uint64
+	)
+	for number := from; number <= to; number++ {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		block := api.debug.eth.blockchain.GetBlockByNumber(number)
+		if block == nil {
+			return nil, fmt.Errorf("block #%d not found", number)
+		}
+		blocktraces, err := api.traceBlock(ctx, block)
+		if err != nil {
+			return nil, err
+		}
+		for _, trace := range blocktraces {
+			if !args.matches(trace) {
+				continue
+			}
+			if args.After != nil && skipped < *args.After {
+				skipped++
+				continue
+			}
+			if args.Count != nil && uint64(len(traces)) >= *args.Count {
+				return traces, nil
+			}
+			traces = append(traces, trace)
+		}
+	}
+	return traces, nil
+}
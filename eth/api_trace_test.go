@@ -0,0 +1,167 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// callTraceBlob is a call tracer result with a nested call, a reverted call, a
+// contract creation and a self destruct.
+const callTraceBlob = `{
+	"type": "CALL", "from": "0x00000000000000000000000000000000000000aa", "to": "0x00000000000000000000000000000000000000bb",
+	"value": "0x7", "gas": "0x1000", "gasUsed": "0x100", "input": "0xdeadbeef", "output": "0x01",
+	"calls": [
+		{
+			"type": "DELEGATECALL", "from": "0x00000000000000000000000000000000000000bb", "to": "0x00000000000000000000000000000000000000cc",
+			"gas": "0x200", "gasUsed": "0x20", "input": "0x",
+			"calls": [{"type": "SELFDESTRUCT", "from": "0x00000000000000000000000000000000000000cc", "to": "0x00000000000000000000000000000000000000ff", "value": "0x5"}]
+		},
+		{
+			"type": "CALL", "from": "0x00000000000000000000000000000000000000bb", "to": "0x00000000000000000000000000000000000000dd",
+			"value": "0x0", "gas": "0x300", "gasUsed": "0x300", "input": "0x01", "error": "execution reverted"
+		},
+		{
+			"type": "CREATE", "from": "0x00000000000000000000000000000000000000bb", "to": "0x00000000000000000000000000000000000000ee",
+			"value": "0x1", "gas": "0x400", "gasUsed": "0x40", "input": "0x6000", "output": "0x00"
+		}
+	]
+}`
+
+// Tests that call tracer results are correctly flattened into traces.
+func TestFlattenCallTrace(t *testing.T) {
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(3)})
+	hash := common.HexToHash("0x01")
+
+	traces, err := flattenTxTrace(json.RawMessage(callTraceBlob), block, hash, 2)
+	if err != nil {
+		t.Fatalf("failed to flatten call trace: %v", err)
+	}
+	var (
+		addrB = common.HexToAddress("0xbb")
+		addrC = common.HexToAddress("0xcc")
+		addrD = common.HexToAddress("0xdd")
+		addrE = common.HexToAddress("0xee")
+		addrF = common.HexToAddress("0xff")
+	)
+	tests := []struct {
+		typ       string
+		address   []int
+		subtraces int
+		err       string
+		action    FlatTraceAction
+		result    *FlatTraceResult
+	}{
+		{"call", []int{}, 3, "", FlatTraceAction{}, &FlatTraceResult{GasUsed: "0x100", Output: "0x01"}},
+		{"call", []int{0}, 1, "", FlatTraceAction{CallType: "delegatecall", From: &addrB, To: &addrC, Gas: "0x200", Value: "0x0", Input: "0x"}, &FlatTraceResult{GasUsed: "0x20", Output: "0x"}},
+		{"suicide", []int{0, 0}, 0, "", FlatTraceAction{Address: &addrC, RefundAddress: &addrF, Balance: "0x5"}, nil},
+		{"call", []int{1}, 0, "Reverted", FlatTraceAction{CallType: "call", From: &addrB, To: &addrD, Gas: "0x300", Value: "0x0", Input: "0x01"}, nil},
+		{"create", []int{2}, 0, "", FlatTraceAction{From: &addrB, Gas: "0x400", Value: "0x1", Init: "0x6000"}, &FlatTraceResult{GasUsed: "0x40", Address: &addrE, Code: "0x00"}},
+	}
+	if len(traces) != len(tests) {
+		t.Fatalf("trace count mismatch: have %d, want %d", len(traces), len(tests))
+	}
+	for i, tt := range tests {
+		trace := traces[i]
+		if trace.Type != tt.typ || trace.Subtraces != tt.subtraces || trace.Error != tt.err {
+			t.Errorf("trace %d: type/subtraces/error mismatch: have %s/%d/%q, want %s/%d/%q", i, trace.Type, trace.Subtraces, trace.Error, tt.typ, tt.subtraces, tt.err)
+		}
+		if !reflect.DeepEqual(trace.TraceAddress, tt.address) {
+			t.Errorf("trace %d: address mismatch: have %v, want %v", i, trace.TraceAddress, tt.address)
+		}
+		if i > 0 && !reflect.DeepEqual(trace.Action, tt.action) {
+			t.Errorf("trace %d: action mismatch: have %+v, want %+v", i, trace.Action, tt.action)
+		}
+		if !reflect.DeepEqual(trace.Result, tt.result) {
+			t.Errorf("trace %d: result mismatch: have %+v, want %+v", i, trace.Result, tt.result)
+		}
+		if trace.BlockHash != block.Hash() || trace.BlockNumber != 3 || trace.TransactionHash != hash || trace.TransactionPosition != 2 {
+			t.Errorf("trace %d: position mismatch: have %x/%d/%x/%d", i, trace.BlockHash, trace.BlockNumber, trace.TransactionHash, trace.TransactionPosition)
+		}
+	}
+	// Ensure the filters match on the correct endpoints
+	filters := []struct {
+		args TraceFilterArgs
+		want int
+	}{
+		{TraceFilterArgs{}, 5},
+		{TraceFilterArgs{FromAddress: []common.Address{addrB}}, 3},
+		{TraceFilterArgs{ToAddress: []common.Address{addrE}}, 1},
+		{TraceFilterArgs{FromAddress: []common.Address{addrC}}, 1},
+		{TraceFilterArgs{FromAddress: []common.Address{addrB}, ToAddress: []common.Address{addrC, addrD}}, 2},
+		{TraceFilterArgs{FromAddress: []common.Address{addrC}, ToAddress: []common.Address{addrF}}, 1},
+	}
+	for i, tt := range filters {
+		matches := 0
+		for _, trace := range traces {
+			if tt.args.matches(trace) {
+				matches++
+			}
+		}
+		if matches != tt.want {
+			t.Errorf("filter %d: match count mismatch: have %d, want %d", i, matches, tt.want)
+		}
+	}
+}
+
+// Tests that the block range of trace filters is resolved against the head and
+// that invalid or overly long ranges are rejected.
+func TestTraceFilterBlockRange(t *testing.T) {
+	number := func(n int64) *rpc.BlockNumber {
+		num := rpc.BlockNumber(n)
+		return &num
+	}
+	tests := []struct {
+		from, to *rpc.BlockNumber
+		head     uint64
+		start    uint64
+		end      uint64
+		fail     bool
+	}{
+		{nil, nil, 5000, 5000, 5000, false},
+		{number(10), nil, 500, 10, 500, false},
+		{number(0), number(maxTraceFilterBlocks - 1), 5000, 0, maxTraceFilterBlocks - 1, false},
+		{number(0), number(maxTraceFilterBlocks), 5000, 0, 0, true},
+		{number(0), nil, 5000, 0, 0, true},
+		{number(20), number(10), 5000, 0, 0, true},
+		{number(10), number(20), 15, 0, 0, true},
+	}
+	for i, tt := range tests {
+		args := TraceFilterArgs{FromBlock: tt.from, ToBlock: tt.to}
+		start, end, err := args.blockRange(tt.head)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("test %d: expected failure, got range #%d-#%d", i, start, end)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: failed to resolve range: %v", i, err)
+			continue
+		}
+		if start != tt.start || end != tt.end {
+			t.Errorf("test %d: range mismatch: have #%d-#%d, want #%d-#%d", i, start, end, tt.start, tt.end)
+		}
+	}
+}
@@ -28,6 +28,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -402,6 +403,8 @@ func (api *PrivateDebugAPI) traceBlock(ctx context.Context, block *types.Block,
 	if err != nil {
 		return nil, err
 	}
+	defer statedb.Release()
+
 	// Execute all the transaction contained within the block concurrently
 	var (
 		signer = types.MakeSigner(api.config, block.Number())
@@ -545,10 +548,53 @@ func (api *PrivateDebugAPI) TraceTransaction(ctx context.Context, hash common.Ha
 	if err != nil {
 		return nil, err
 	}
+	defer statedb.Release()
+
 	// Trace the transaction and return
 	return api.traceTx(ctx, msg, vmctx, statedb, config)
 }
 
+// TraceCall returns the structured logs created during the execution of an
+// arbitrary call message on top of the state of the given block, without it
+// having to be signed or included in the chain. The return value is the same as
+// the one of TraceTransaction.
+func (api *PrivateDebugAPI) TraceCall(ctx context.Context, args ethapi.CallArgs, number rpc.BlockNumber, config *TraceConfig) (interface{}, error) {
+	// Fetch the block (and state) that we want to trace the call on top of
+	var (
+		block   *types.Block
+		statedb *state.StateDB
+		err     error
+	)
+	switch number {
+	case rpc.PendingBlockNumber:
+		block, statedb = api.eth.miner.Pending()
+	case rpc.LatestBlockNumber:
+		block = api.eth.blockchain.CurrentBlock()
+	default:
+		block = api.eth.blockchain.GetBlockByNumber(uint64(number))
+	}
+	if block == nil {
+		return nil, fmt.Errorf("block #%d not found", number)
+	}
+	if statedb == nil {
+		reexec := defaultTraceReexec
+		if config != nil && config.Reexec != nil {
+			reexec = *config.Reexec
+		}
+		if statedb, err = api.computeStateDB(block, reexec); err != nil {
+			return nil, err
+		}
+		defer statedb.Release()
+	}
+	// Assemble the call message the same way eth_call does, funding the sender so
+	// the default gas allowance can be paid for
+	msg := args.ToMessage(api.eth.AccountManager())
+	statedb.SetBalance(msg.From(), math.MaxBig256)
+
+	vmctx := core.NewEVMContext(msg, block.Header(), api.eth.blockchain, nil)
+	return api.traceTx(ctx, msg, vmctx, statedb, config)
+}
+
 // traceTx configures a new tracer according to the provided configuration, and
 // executes the given message in the provided environment. The return value will
 // be tracer dependent.
@@ -567,15 +613,20 @@ func (api *PrivateDebugAPI) traceTx(ctx context.Context, message core.Message, v
 				return nil, err
 			}
 		}
-		// Constuct the JavaScript tracer to execute with
-		if tracer, err = tracers.New(*config.Tracer); err != nil {
-			return nil, err
+		// Constuct the native or JavaScript tracer to execute with
+		rtracer, ok := tracers.NewNative(*config.Tracer)
+		if !ok {
+			if rtracer, err = tracers.New(*config.Tracer); err != nil {
+				return nil, err
+			}
 		}
+		tracer = rtracer
+
 		// Handle timeouts and RPC cancellations
 		deadlineCtx, cancel := context.WithTimeout(ctx, timeout)
 		go func() {
 			<-deadlineCtx.Done()
-			tracer.(*tracers.Tracer).Stop(errors.New("execution timeout"))
+			rtracer.Stop(errors.New("execution timeout"))
 		}()
 		defer cancel()
 
@@ -602,7 +653,7 @@ func (api *PrivateDebugAPI) traceTx(ctx context.Context, message core.Message, v
 			StructLogs:  ethapi.FormatLogs(tracer.StructLogs()),
 		}, nil
 
-	case *tracers.Tracer:
+	case tracers.ResultTracer:
 		return tracer.GetResult()
 
 	default:
@@ -638,9 +689,11 @@ func (api *PrivateDebugAPI) computeTxEnv(blockHash common.Hash, txIndex int, ree
 		// Not yet the searched for transaction, execute on top of the current state
 		vmenv := vm.NewEVM(context, statedb, api.config, vm.Config{})
 		if _, _, _, err := core.ApplyMessage(vmenv, msg, new(core.GasPool).AddGas(tx.Gas())); err != nil {
+			statedb.Release()
 			return nil, vm.Context{}, nil, fmt.Errorf("tx %x failed: %v", tx.Hash(), err)
 		}
 		statedb.DeleteSuicides()
 	}
+	statedb.Release()
 	return nil, vm.Context{}, nil, fmt.Errorf("tx index %d out of range for block %x", txIndex, blockHash)
 }
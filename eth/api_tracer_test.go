@@ -0,0 +1,145 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/internal/ethapi"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var (
+	// tracerTestRecipient receives a wei in every block of the test chain.
+	tracerTestRecipient = common.HexToAddress("0xaa")
+
+	// tracerTestContract is a contract returning the balance of the recipient:
+	// PUSH1 0xaa BALANCE PUSH1 0 MSTORE PUSH1 32 PUSH1 0 RETURN
+	tracerTestContract = common.HexToAddress("0xcc")
+	tracerTestCode     = common.Hex2Bytes("60aa3160005260206000f3")
+)
+
+// newTestDebugAPI creates a debug API on top of a chain of the given length, in
+// which every block transfers a wei to the test recipient.
+func newTestDebugAPI(t *testing.T, blocks int) *PrivateDebugAPI {
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		gspec   = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc: core.GenesisAlloc{
+				address:            {Balance: big.NewInt(1000000000)},
+				tracerTestContract: {Balance: new(big.Int), Code: tracerTestCode},
+			},
+		}
+		db, _   = ethdb.NewMemDatabase()
+		genesis = gspec.MustCommit(db)
+		signer  = types.HomesteadSigner{}
+	)
+	chain, _ := core.GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, blocks, func(i int, b *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(address), tracerTestRecipient, big.NewInt(1), params.TxGas, nil, nil), signer, key)
+		b.AddTx(tx)
+	})
+	blockchain, _ := core.NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{})
+	if _, err := blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	eth := &Ethereum{
+		chainDb:        db,
+		blockchain:     blockchain,
+		accountManager: accounts.NewManager(),
+	}
+	return NewPrivateDebugAPI(gspec.Config, eth)
+}
+
+// Tests that calls can be traced on top of the state of arbitrary blocks, both
+// with the default struct logger and with named tracers.
+func TestTraceCall(t *testing.T) {
+	api := newTestDebugAPI(t, 3)
+	defer api.eth.blockchain.Stop()
+
+	args := ethapi.CallArgs{From: common.Address{0x01}, To: &tracerTestContract}
+
+	// Trace the call with the default struct logger on various blocks
+	tests := []struct {
+		number  rpc.BlockNumber
+		balance int64
+	}{
+		{rpc.LatestBlockNumber, 3},
+		{3, 3},
+		{1, 1},
+		{0, 0},
+	}
+	for i, tt := range tests {
+		res, err := api.TraceCall(context.Background(), args, tt.number, nil)
+		if err != nil {
+			t.Fatalf("test %d: failed to trace call: %v", i, err)
+		}
+		result, ok := res.(*ethapi.ExecutionResult)
+		if !ok {
+			t.Fatalf("test %d: result type mismatch: have %T, want %T", i, res, new(ethapi.ExecutionResult))
+		}
+		if result.Failed || result.Gas == 0 {
+			t.Errorf("test %d: execution failed: %+v", i, result)
+		}
+		if want := common.BigToHash(big.NewInt(tt.balance)).Hex()[2:]; result.ReturnValue != want {
+			t.Errorf("test %d: return value mismatch: have %s, want %s", i, result.ReturnValue, want)
+		}
+		if len(result.StructLogs) != 7 {
+			t.Errorf("test %d: struct log count mismatch: have %d, want %d", i, len(result.StructLogs), 7)
+		} else if op := result.StructLogs[1].Op; op != "BALANCE" {
+			t.Errorf("test %d: second opcode mismatch: have %s, want %s", i, op, "BALANCE")
+		}
+	}
+	// Trace the call with a named tracer
+	tracer := "callTracer"
+	res, err := api.TraceCall(context.Background(), args, rpc.LatestBlockNumber, &TraceConfig{Tracer: &tracer})
+	if err != nil {
+		t.Fatalf("failed to trace call with %s: %v", tracer, err)
+	}
+	blob, ok := res.(json.RawMessage)
+	if !ok {
+		t.Fatalf("%s result type mismatch: have %T, want %T", tracer, res, json.RawMessage{})
+	}
+	frame := new(callTraceFrame)
+	if err := json.Unmarshal(blob, frame); err != nil {
+		t.Fatalf("failed to decode %s result: %v", tracer, err)
+	}
+	if frame.Type != "CALL" || frame.To == nil || *frame.To != tracerTestContract {
+		t.Errorf("%s frame mismatch: have %+v", tracer, frame)
+	}
+	if want := common.BigToHash(big.NewInt(3)).Hex(); frame.Output != want {
+		t.Errorf("%s output mismatch: have %s, want %s", tracer, frame.Output, want)
+	}
+	// Tracing on top of an unknown block should fail
+	if _, err := api.TraceCall(context.Background(), args, 10, nil); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("unknown block error mismatch: have %v, want block not found", err)
+	}
+}
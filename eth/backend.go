@@ -31,8 +31,10 @@ import (
 	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/consensus/clique"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/bloombits"
+	"github.com/ethereum/go-ethereum/core/state/pruner"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/eth/downloader"
@@ -81,6 +83,7 @@ type Ethereum struct {
 
 	bloomRequests chan chan *bloombits.Retrieval // Channel receiving bloom data retrieval requests
 	bloomIndexer  *core.ChainIndexer             // Bloom indexer operating during block imports
+	addrIndexer   *core.ChainIndexer             // Address indexer operating during block imports, nil if disabled
 
 	ApiBackend *EthApiBackend
 
@@ -112,6 +115,10 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Finish any interrupted offline state pruning before new state is written
+	if err := pruner.RecoverPruning(ctx.ResolvePath(""), chainDb); err != nil {
+		return nil, err
+	}
 	stopDbUpgrade := upgradeDeduplicateData(chainDb)
 	chainConfig, genesisHash, genesisErr := core.SetupGenesisBlock(chainDb, config.Genesis)
 	if _, ok := genesisErr.(*params.ConfigCompatError); genesisErr != nil && !ok {
@@ -146,7 +153,7 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 	}
 	var (
 		vmConfig    = vm.Config{EnablePreimageRecording: config.EnablePreimageRecording}
-		cacheConfig = &core.CacheConfig{Disabled: config.NoPruning, TrieNodeLimit: config.TrieCache, TrieTimeLimit: config.TrieTimeout}
+		cacheConfig = &core.CacheConfig{Disabled: config.NoPruning, TrieNodeLimit: config.TrieCache, TrieTimeLimit: config.TrieTimeout, Snapshot: config.Snapshot, StateHistory: config.StateHistory}
 	)
 	eth.blockchain, err = core.NewBlockChain(chainDb, cacheConfig, eth.chainConfig, eth.engine, vmConfig)
 	if err != nil {
@@ -160,6 +167,11 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 	}
 	eth.bloomIndexer.Start(eth.blockchain)
 
+	if config.AddressIndex {
+		eth.addrIndexer = NewAddressIndexer(chainDb, eth.chainConfig, params.BloomBitsBlocks)
+		eth.addrIndexer.Start(eth.blockchain)
+	}
+
 	if config.TxPool.Journal != "" {
 		config.TxPool.Journal = ctx.ResolvePath(config.TxPool.Journal)
 	}
@@ -207,7 +219,18 @@ func CreateDB(ctx *node.ServiceContext, config *Config, name string) (ethdb.Data
 	if db, ok := db.(*ethdb.LDBDatabase); ok {
 		db.Meter("eth/db/chaindata/")
 	}
-	return db, nil
+	// Light clients don't store full chain segments, nothing to freeze. The
+	// database is still checked not to depend on a previously used freezer.
+	var freezer string
+	if config.DatabaseFreezer != "" && config.SyncMode != downloader.LightSync {
+		freezer = ctx.ResolvePath(config.DatabaseFreezer)
+	}
+	frdb, err := core.NewDatabaseWithFreezer(db, freezer, "eth/db/ancient/", config.DatabaseNoSnappy)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return frdb, nil
 }
 
 // CreateConsensusEngine creates the required type of consensus engine instance for an Ethereum service
@@ -216,6 +239,10 @@ func CreateConsensusEngine(ctx *node.ServiceContext, config *ethash.Config, chai
 	if chainConfig.Clique != nil {
 		return clique.New(chainConfig.Clique, db)
 	}
+	// If byzantine fault tolerance is requested, validate with the node key
+	if chainConfig.Istanbul != nil {
+		return istanbul.New(chainConfig.Istanbul, ctx.NodeKey())
+	}
 	// Otherwise assume proof-of-work
 	switch {
 	case config.PowMode == ethash.ModeFake:
@@ -289,6 +316,10 @@ func (s *Ethereum) APIs() []rpc.API {
 			Namespace: "debug",
 			Version:   "1.0",
 			Service:   NewPrivateDebugAPI(s.chainConfig, s),
+		}, {
+			Namespace: "trace",
+			Version:   "1.0",
+			Service:   NewPrivateTraceAPI(s.chainConfig, s),
 		}, {
 			Namespace: "net",
 			Version:   "1.0",
@@ -336,6 +367,13 @@ func (self *Ethereum) SetEtherbase(etherbase common.Address) {
 
 func (s *Ethereum) StartMining(local bool) error {
 	eb, err := s.Etherbase()
+	if istanbul, ok := s.engine.(*istanbul.Istanbul); ok {
+		// Istanbul validates with the node key, the etherbase is irrelevant
+		if err != nil {
+			eb, err = istanbul.Address(), nil
+		}
+		istanbul.Start(s.blockchain)
+	}
 	if err != nil {
 		log.Error("Cannot start mining without etherbase", "err", err)
 		return fmt.Errorf("etherbase missing: %v", err)
@@ -359,7 +397,13 @@ func (s *Ethereum) StartMining(local bool) error {
 	return nil
 }
 
-func (s *Ethereum) StopMining()         { s.miner.Stop() }
+func (s *Ethereum) StopMining() {
+	s.miner.Stop()
+	if istanbul, ok := s.engine.(*istanbul.Istanbul); ok {
+		istanbul.Stop()
+	}
+}
+
 func (s *Ethereum) IsMining() bool      { return s.miner.Mining() }
 func (s *Ethereum) Miner() *miner.Miner { return s.miner }
 
@@ -415,13 +459,16 @@ func (s *Ethereum) Stop() error {
 		s.stopDbUpgrade()
 	}
 	s.bloomIndexer.Close()
+	if s.addrIndexer != nil {
+		s.addrIndexer.Close()
+	}
 	s.blockchain.Stop()
 	s.protocolManager.Stop()
 	if s.lesServer != nil {
 		s.lesServer.Stop()
 	}
 	s.txPool.Stop()
-	s.miner.Stop()
+	s.StopMining()
 	s.eventMux.Stop()
 
 	s.chainDb.Close()
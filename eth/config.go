@@ -91,8 +91,13 @@ type Config struct {
 	SkipBcVersionCheck bool `toml:"-"`
 	DatabaseHandles    int  `toml:"-"`
 	DatabaseCache      int
+	DatabaseFreezer    string `toml:",omitempty"` // Directory for the ancient chain segments (disabled if empty)
+	DatabaseNoSnappy   bool   `toml:",omitempty"` // Whether to store newly created ancient tables uncompressed
 	TrieCache          int
 	TrieTimeout        time.Duration
+	Snapshot           bool `toml:",omitempty"` // Whether to maintain a flat state snapshot for faster reads
+	StateHistory       bool `toml:",omitempty"` // Whether to store reverse state diffs to serve the pruned historic states
+	AddressIndex       bool `toml:",omitempty"` // Whether to index the logs and transactions by address
 
 	// Mining-related options
 	Etherbase    common.Address `toml:",omitempty"`
@@ -50,7 +50,7 @@ func upgradeDeduplicateData(db ethdb.Database) func() error {
 
 	go func() {
 		// Create an iterator to read the entire database and covert old lookup entires
-		it := db.(*ethdb.LDBDatabase).NewIterator()
+		it := db.NewIterator()
 		defer func() {
 			if it != nil {
 				it.Release()
@@ -100,8 +100,7 @@ func upgradeDeduplicateData(db ethdb.Database) func() error {
 			converted++
 			if converted%100000 == 0 {
 				it.Release()
-				it = db.(*ethdb.LDBDatabase).NewIterator()
-				it.Seek(key)
+				it = db.NewIteratorWithStart(key)
 
 				log.Info("Deduplicating database entries", "deduped", converted)
 			}
@@ -133,6 +133,8 @@ type Downloader struct {
 	stateSyncStart chan *stateSync
 	trackStateReq  chan *stateReq
 	stateCh        chan dataPack // [eth/63] Channel receiving inbound node state data
+	snapCh         chan dataPack // [range/1] Channel receiving inbound account, storage and code ranges
+	snapState      *snapState    // Range download progress of snap sync, kept across pivot moves
 
 	// Cancellation and termination
 	cancelPeer string        // Identifier of the peer currently being used as the master (cancel on drop)
@@ -221,6 +223,7 @@ func New(mode SyncMode, stateDb ethdb.Database, mux *event.TypeMux, chain BlockC
 		headerProcCh:   make(chan []*types.Header, 1),
 		quitCh:         make(chan struct{}),
 		stateCh:        make(chan dataPack),
+		snapCh:         make(chan dataPack),
 		stateSyncStart: make(chan *stateSync),
 		syncStatsState: stateSyncStats{
 			processed: core.GetTrieSyncProgress(stateDb),
@@ -248,7 +251,7 @@ func (d *Downloader) Progress() ethereum.SyncProgress {
 	switch d.mode {
 	case FullSync:
 		current = d.blockchain.CurrentBlock().NumberU64()
-	case FastSync:
+	case FastSync, SnapSync:
 		current = d.blockchain.CurrentFastBlock().NumberU64()
 	case LightSync:
 		current = d.lightchain.CurrentHeader().Number.Uint64()
@@ -286,6 +289,18 @@ func (d *Downloader) RegisterLightPeer(id string, version int, peer LightPeer) e
 	return d.RegisterPeer(id, version, &lightPeerWrapper{peer})
 }
 
+// RegisterSnapPeer attaches the range protocol connection of a registered peer,
+// allowing the state to be retrieved from it in ranges during snap sync.
+func (d *Downloader) RegisterSnapPeer(id string, peer SnapPeer) error {
+	logger := log.New("peer", id)
+	logger.Trace("Registering snap sync peer")
+	if err := d.peers.RegisterSnap(id, peer); err != nil {
+		logger.Debug("Failed to register snap sync peer", "err", err)
+		return err
+	}
+	return nil
+}
+
 // UnregisterPeer remove a peer from the known list, preventing any action from
 // the specified peer. An effort is also made to return any pending fetches into
 // the queue.
@@ -439,7 +454,7 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td *big.I
 
 	// Ensure our origin point is below any fast sync pivot point
 	pivot := uint64(0)
-	if d.mode == FastSync {
+	if d.mode == FastSync || d.mode == SnapSync {
 		if height <= uint64(fsMinFullBlocks) {
 			origin = 0
 		} else {
@@ -450,7 +465,7 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td *big.I
 		}
 	}
 	d.committed = 1
-	if d.mode == FastSync && pivot != 0 {
+	if (d.mode == FastSync || d.mode == SnapSync) && pivot != 0 {
 		d.committed = 0
 	}
 	// Initiate the sync using a concurrent header and content retrieval algorithm
@@ -465,7 +480,7 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td *big.I
 		func() error { return d.fetchReceipts(origin + 1) },        // Receipts are retrieved during fast sync
 		func() error { return d.processHeaders(origin+1, pivot, td) },
 	}
-	if d.mode == FastSync {
+	if d.mode == FastSync || d.mode == SnapSync {
 		fetchers = append(fetchers, func() error { return d.processFastSyncContent(latest) })
 	} else if d.mode == FullSync {
 		fetchers = append(fetchers, d.processFullSyncContent)
@@ -588,7 +603,7 @@ func (d *Downloader) findAncestor(p *peerConnection, height uint64) (uint64, err
 
 	if d.mode == FullSync {
 		ceil = d.blockchain.CurrentBlock().NumberU64()
-	} else if d.mode == FastSync {
+	} else if d.mode == FastSync || d.mode == SnapSync {
 		ceil = d.blockchain.CurrentFastBlock().NumberU64()
 	}
 	if ceil >= MaxForkAncestry {
@@ -1221,7 +1236,7 @@ func (d *Downloader) processHeaders(origin uint64, pivot uint64, td *big.Int) er
 				// This check cannot be executed "as is" for full imports, since blocks may still be
 				// queued for processing when the header download completes. However, as long as the
 				// peer gave us something useful, we're already happy/progressed (above check).
-				if d.mode == FastSync || d.mode == LightSync {
+				if d.mode != FullSync {
 					head := d.lightchain.CurrentHeader()
 					if td.Cmp(d.lightchain.GetTd(head.Hash(), head.Number.Uint64())) > 0 {
 						return errStallingPeer
@@ -1249,7 +1264,7 @@ func (d *Downloader) processHeaders(origin uint64, pivot uint64, td *big.Int) er
 				chunk := headers[:limit]
 
 				// In case of header only syncing, validate the chunk immediately
-				if d.mode == FastSync || d.mode == LightSync {
+				if d.mode != FullSync {
 					// Collect the yet unknown headers to mark them as uncertain
 					unknown := make([]*types.Header, 0, len(headers))
 					for _, header := range chunk {
@@ -1277,7 +1292,7 @@ func (d *Downloader) processHeaders(origin uint64, pivot uint64, td *big.Int) er
 					}
 				}
 				// Unless we're doing light chains, schedule the headers for associated content retrieval
-				if d.mode == FullSync || d.mode == FastSync {
+				if d.mode != LightSync {
 					// If we've reached the allowed number of pending headers, stall a bit
 					for d.queue.PendingBlocks() >= maxQueuedHeaders || d.queue.PendingReceipts() >= maxQueuedHeaders {
 						select {
@@ -1535,6 +1550,21 @@ func (d *Downloader) DeliverNodeData(id string, data [][]byte) (err error) {
 	return d.deliver(id, d.stateCh, &statePack{id, data}, stateInMeter, stateDropMeter)
 }
 
+// DeliverAccountRange injects a range of accounts received from a remote node.
+func (d *Downloader) DeliverAccountRange(id string, hashes []common.Hash, accounts [][]byte, proof [][]byte) (err error) {
+	return d.deliver(id, d.snapCh, &accountRangePack{id, hashes, accounts, proof}, snapInMeter, snapDropMeter)
+}
+
+// DeliverStorageRange injects a range of storage slots received from a remote node.
+func (d *Downloader) DeliverStorageRange(id string, hashes []common.Hash, slots [][]byte, proof [][]byte) (err error) {
+	return d.deliver(id, d.snapCh, &storageRangePack{id, hashes, slots, proof}, snapInMeter, snapDropMeter)
+}
+
+// DeliverByteCodes injects a batch of contract codes received from a remote node.
+func (d *Downloader) DeliverByteCodes(id string, codes [][]byte) (err error) {
+	return d.deliver(id, d.snapCh, &byteCodesPack{id, codes}, snapInMeter, snapDropMeter)
+}
+
 // deliver injects a new batch of data received from a remote node.
 func (d *Downloader) deliver(id string, destCh chan dataPack, packet dataPack, inMeter, dropMeter metrics.Meter) (err error) {
 	// Update the delivery metrics for both good and failed deliveries
@@ -28,6 +28,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
@@ -399,6 +400,9 @@ func (dl *downloadTester) newSlowPeer(id string, version int, hashes []common.Ha
 	defer dl.lock.Unlock()
 
 	var err = dl.downloader.RegisterPeer(id, version, &downloadTesterPeer{dl: dl, id: id, delay: delay})
+	if err == nil {
+		err = dl.downloader.RegisterSnapPeer(id, &downloadTesterPeer{dl: dl, id: id, delay: delay})
+	}
 	if err == nil {
 		// Assign the owned hashes, headers and blocks to the peer (deep copy)
 		dl.peerHashes[id] = make([]common.Hash, len(hashes))
@@ -603,6 +607,56 @@ func (dlp *downloadTesterPeer) RequestNodeData(hashes []common.Hash) error {
 	return nil
 }
 
+// RequestAccountRange constructs a getAccountRange method associated with a
+// particular peer in the download tester. The returned function can be used to
+// retrieve batches of accounts along with their range proofs.
+func (dlp *downloadTesterPeer) RequestAccountRange(root, origin, limit common.Hash, bytes uint64) error {
+	dlp.waitDelay()
+
+	dlp.dl.lock.RLock()
+	defer dlp.dl.lock.RUnlock()
+
+	hashes, accounts, proof := serveTrieRange(dlp.dl.peerDb, root, origin, limit, bytes)
+	go dlp.dl.downloader.DeliverAccountRange(dlp.id, hashes, accounts, proof)
+
+	return nil
+}
+
+// RequestStorageRange constructs a getStorageRange method associated with a
+// particular peer in the download tester. The returned function can be used to
+// retrieve batches of storage slots along with their range proofs.
+func (dlp *downloadTesterPeer) RequestStorageRange(root, origin, limit common.Hash, bytes uint64) error {
+	dlp.waitDelay()
+
+	dlp.dl.lock.RLock()
+	defer dlp.dl.lock.RUnlock()
+
+	hashes, slots, proof := serveTrieRange(dlp.dl.peerDb, root, origin, limit, bytes)
+	go dlp.dl.downloader.DeliverStorageRange(dlp.id, hashes, slots, proof)
+
+	return nil
+}
+
+// RequestByteCodes constructs a getByteCodes method associated with a particular
+// peer in the download tester. The returned function can be used to retrieve
+// batches of contract codes from the particularly requested peer.
+func (dlp *downloadTesterPeer) RequestByteCodes(hashes []common.Hash, bytes uint64) error {
+	dlp.waitDelay()
+
+	dlp.dl.lock.RLock()
+	defer dlp.dl.lock.RUnlock()
+
+	codes := make([][]byte, 0, len(hashes))
+	for _, hash := range hashes {
+		if code, err := dlp.dl.peerDb.Get(hash.Bytes()); err == nil {
+			codes = append(codes, code)
+		}
+	}
+	go dlp.dl.downloader.DeliverByteCodes(dlp.id, codes)
+
+	return nil
+}
+
 // assertOwnChain checks if the local chain contains the correct number of items
 // of the various chain components.
 func assertOwnChain(t *testing.T, tester *downloadTester, length int) {
@@ -665,6 +719,7 @@ func TestCanonicalSynchronisation63Fast(t *testing.T)  { testCanonicalSynchronis
 func TestCanonicalSynchronisation64Full(t *testing.T)  { testCanonicalSynchronisation(t, 64, FullSync) }
 func TestCanonicalSynchronisation64Fast(t *testing.T)  { testCanonicalSynchronisation(t, 64, FastSync) }
 func TestCanonicalSynchronisation64Light(t *testing.T) { testCanonicalSynchronisation(t, 64, LightSync) }
+func TestCanonicalSynchronisation64Snap(t *testing.T)  { testCanonicalSynchronisation(t, 64, SnapSync) }
 
 func testCanonicalSynchronisation(t *testing.T, protocol int, mode SyncMode) {
 	t.Parallel()
@@ -779,6 +834,7 @@ func TestForkedSync63Fast(t *testing.T)  { testForkedSync(t, 63, FastSync) }
 func TestForkedSync64Full(t *testing.T)  { testForkedSync(t, 64, FullSync) }
 func TestForkedSync64Fast(t *testing.T)  { testForkedSync(t, 64, FastSync) }
 func TestForkedSync64Light(t *testing.T) { testForkedSync(t, 64, LightSync) }
+func TestForkedSync64Snap(t *testing.T)  { testForkedSync(t, 64, SnapSync) }
 
 func testForkedSync(t *testing.T, protocol int, mode SyncMode) {
 	t.Parallel()
@@ -1753,3 +1809,187 @@ func testDeliverHeadersHang(t *testing.T, protocol int, mode SyncMode) {
 		tester.downloader.peers.peers["peer"].peer.(*floodingTestPeer).pend.Wait()
 	}
 }
+
+// makeSnapState assembles a state of plain accounts and contracts with storage in
+// the given database, modified by the given salt, on top of the given root.
+func makeSnapState(t *testing.T, db ethdb.Database, root common.Hash, salt int) common.Hash {
+	statedb, _ := state.New(root, state.NewDatabase(db))
+	for i := 0; i < 1000; i++ {
+		addr := common.BigToAddress(big.NewInt(int64(i)))
+		if salt > 0 && i%(3*salt) != 0 {
+			continue
+		}
+		statedb.SetBalance(addr, big.NewInt(int64(i+salt+1)))
+		if i%10 == 0 {
+			statedb.SetCode(addr, []byte{byte(i >> 8), byte(i), byte(salt)})
+			for j := 0; j < 100; j++ {
+				statedb.SetState(addr, common.BigToHash(big.NewInt(int64(j))), common.BigToHash(big.NewInt(int64(i*j+salt+1))))
+			}
+		}
+	}
+	root, _ = statedb.Commit(false)
+	if err := statedb.Database().TrieDB().Commit(root, false); err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	return root
+}
+
+// checkSnapState checks that the state with the given root is complete.
+func checkSnapState(t *testing.T, db ethdb.Database, root common.Hash) {
+	synced, err := state.New(root, state.NewDatabase(db))
+	if err != nil {
+		t.Fatalf("failed to open synced state: %v", err)
+	}
+	it := state.NewNodeIterator(synced)
+	for it.Next() {
+	}
+	if it.Error != nil {
+		t.Fatalf("synced state incomplete: %v", it.Error)
+	}
+}
+
+// noStorageSnapPeer is a snap peer which doesn't have any storage tries.
+type noStorageSnapPeer struct {
+	*downloadTesterPeer
+}
+
+func (p *noStorageSnapPeer) RequestStorageRange(root, origin, limit common.Hash, bytes uint64) error {
+	go p.dl.downloader.DeliverStorageRange(p.id, nil, nil, nil)
+	return nil
+}
+
+// Tests that snap sync retrieves a state containing contract code and storage,
+// and that anything the snap peers can't provide, up to the whole state if no
+// peer supports range retrievals, gets synced by trie node healing instead.
+func TestSnapSyncState(t *testing.T) {
+	testSnapSyncState(t, func(tester *downloadTester) SnapPeer {
+		return &downloadTesterPeer{dl: tester, id: "peer"}
+	})
+}
+
+func TestSnapSyncStateNoStorage(t *testing.T) {
+	testSnapSyncState(t, func(tester *downloadTester) SnapPeer {
+		return &noStorageSnapPeer{&downloadTesterPeer{dl: tester, id: "peer"}}
+	})
+}
+
+func TestSnapSyncStateNoSnap(t *testing.T) {
+	testSnapSyncState(t, func(tester *downloadTester) SnapPeer { return nil })
+}
+
+func testSnapSyncState(t *testing.T, snapPeer func(*downloadTester) SnapPeer) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	root := makeSnapState(t, tester.peerDb, common.Hash{}, 0)
+
+	tester.downloader.RegisterPeer("peer", 63, &downloadTesterPeer{dl: tester, id: "peer"})
+	if snap := snapPeer(tester); snap != nil {
+		tester.downloader.RegisterSnapPeer("peer", snap)
+	}
+	// Sync the state and check that it is complete (deliveries need a running sync)
+	tester.downloader.mode = SnapSync
+	tester.downloader.cancelCh = make(chan struct{})
+	if err := tester.downloader.syncState(root).Wait(); err != nil {
+		t.Fatalf("failed to sync state: %v", err)
+	}
+	checkSnapState(t, tester.stateDb, root)
+}
+
+// interruptingSnapPeer is a snap peer which stops answering requests after the
+// given number of them were served.
+type interruptingSnapPeer struct {
+	*downloadTesterPeer
+	budget  int32
+	stopped chan struct{}
+	once    sync.Once
+}
+
+func (p *interruptingSnapPeer) serve() bool {
+	if atomic.AddInt32(&p.budget, -1) >= 0 {
+		return true
+	}
+	p.once.Do(func() { close(p.stopped) })
+	return false
+}
+
+func (p *interruptingSnapPeer) RequestAccountRange(root, origin, limit common.Hash, bytes uint64) error {
+	if !p.serve() {
+		return nil
+	}
+	return p.downloadTesterPeer.RequestAccountRange(root, origin, limit, bytes)
+}
+
+func (p *interruptingSnapPeer) RequestStorageRange(root, origin, limit common.Hash, bytes uint64) error {
+	if !p.serve() {
+		return nil
+	}
+	return p.downloadTesterPeer.RequestStorageRange(root, origin, limit, bytes)
+}
+
+func (p *interruptingSnapPeer) RequestByteCodes(hashes []common.Hash, bytes uint64) error {
+	if !p.serve() {
+		return nil
+	}
+	return p.downloadTesterPeer.RequestByteCodes(hashes, bytes)
+}
+
+// Tests that an interrupted snap sync is continued against a new state root, as
+// happens when the pivot moves, healing the paths changed in between. Continuing
+// from the progress persisted in the database, as after a restart, is tested too.
+func TestSnapSyncPivotMove(t *testing.T) { testSnapSyncResume(t, false) }
+func TestSnapSyncRestart(t *testing.T)   { testSnapSyncResume(t, true) }
+
+func testSnapSyncResume(t *testing.T, restart bool) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	hashes, headers, blocks, receipts := tester.makeChain(0, 0, tester.genesis, nil, false)
+	tester.newPeer("peer", 63, hashes, headers, blocks, receipts)
+
+	// Start syncing a state, interrupting the sync midway
+	root := makeSnapState(t, tester.peerDb, common.Hash{}, 0)
+
+	peer := &interruptingSnapPeer{downloadTesterPeer: &downloadTesterPeer{dl: tester, id: "peer"}, budget: 40, stopped: make(chan struct{})}
+	tester.downloader.RegisterSnapPeer("peer", peer)
+
+	tester.downloader.mode = SnapSync
+	tester.downloader.cancelCh = make(chan struct{})
+
+	sync := tester.downloader.syncState(root)
+	select {
+	case <-peer.stopped:
+	case <-sync.done:
+		t.Fatalf("sync finished before being interrupted: %v", sync.err)
+	}
+	if err := sync.Cancel(); err != errCancelStateFetch {
+		t.Fatalf("interrupted sync error mismatch: have %v, want %v", err, errCancelStateFetch)
+	}
+	if progress := core.GetSnapSyncProgress(tester.stateDb); len(progress) == 0 {
+		t.Fatalf("snap sync progress not persisted")
+	}
+	if restart {
+		tester.downloader.snapState = nil
+
+		resumed := newSnapState(tester.stateDb)
+		if len(resumed.accountTasks) == snapAccountChunks && len(resumed.storageTasks) == 0 {
+			t.Fatalf("snap sync progress not resumed")
+		}
+	}
+	// Move the pivot to a modified state and finish the sync against it
+	root = makeSnapState(t, tester.peerDb, root, 1)
+	tester.downloader.RegisterSnapPeer("peer", &downloadTesterPeer{dl: tester, id: "peer"})
+
+	if err := tester.downloader.syncState(root).Wait(); err != nil {
+		t.Fatalf("failed to sync state: %v", err)
+	}
+	checkSnapState(t, tester.stateDb, root)
+
+	if progress := core.GetSnapSyncProgress(tester.stateDb); len(progress) != 0 {
+		t.Fatalf("snap sync progress not deleted")
+	}
+}
@@ -17,12 +17,14 @@
 package downloader
 
 import (
+	"bytes"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/trie"
 )
 
 // FakePeer is a mock downloader peer that operates on a local database instance
@@ -158,3 +160,71 @@ func (p *FakePeer) RequestNodeData(hashes []common.Hash) error {
 	p.dl.DeliverNodeData(p.id, data)
 	return nil
 }
+
+// RequestAccountRange implements downloader.SnapPeer, returning a range of accounts
+// of the given state trie along with its edge proofs.
+func (p *FakePeer) RequestAccountRange(root common.Hash, origin common.Hash, limit common.Hash, bytes uint64) error {
+	hashes, accounts, proof := serveTrieRange(p.db, root, origin, limit, bytes)
+	p.dl.DeliverAccountRange(p.id, hashes, accounts, proof)
+	return nil
+}
+
+// RequestStorageRange implements downloader.SnapPeer, returning a range of storage
+// slots of the given storage trie along with its edge proofs.
+func (p *FakePeer) RequestStorageRange(root common.Hash, origin common.Hash, limit common.Hash, bytes uint64) error {
+	hashes, slots, proof := serveTrieRange(p.db, root, origin, limit, bytes)
+	p.dl.DeliverStorageRange(p.id, hashes, slots, proof)
+	return nil
+}
+
+// RequestByteCodes implements downloader.SnapPeer, returning a batch of contract
+// codes corresponding to the specified code hashes.
+func (p *FakePeer) RequestByteCodes(hashes []common.Hash, bytes uint64) error {
+	var codes [][]byte
+	for _, hash := range hashes {
+		if code, err := p.db.Get(hash.Bytes()); err == nil {
+			codes = append(codes, code)
+		}
+	}
+	p.dl.DeliverByteCodes(p.id, codes)
+	return nil
+}
+
+// serveTrieRange collects the entries of a trie starting at origin, until the
+// first entry at or beyond limit is included or the size limit is reached, along
+// with the merkle proofs of the range edges. Nothing is returned if the trie is
+// not available.
+func serveTrieRange(db ethdb.Database, root common.Hash, origin common.Hash, limit common.Hash, size uint64) ([]common.Hash, [][]byte, [][]byte) {
+	tr, err := trie.New(root, trie.NewDatabase(db))
+	if err != nil {
+		return nil, nil, nil
+	}
+	var (
+		hashes []common.Hash
+		values [][]byte
+		total  uint64
+	)
+	it := trie.NewIterator(tr.NodeIterator(origin[:]))
+	for it.Next() {
+		hashes = append(hashes, common.BytesToHash(it.Key))
+		values = append(values, common.CopyBytes(it.Value))
+		total += uint64(common.HashLength + len(it.Value))
+
+		if bytes.Compare(it.Key, limit[:]) >= 0 || total >= size {
+			break
+		}
+	}
+	if it.Err != nil {
+		return nil, nil, nil
+	}
+	proofDb, _ := ethdb.NewMemDatabase()
+	tr.Prove(origin[:], 0, proofDb)
+	if len(hashes) > 0 {
+		tr.Prove(hashes[len(hashes)-1][:], 0, proofDb)
+	}
+	var proof [][]byte
+	for it := proofDb.NewIterator(); it.Next(); {
+		proof = append(proof, common.CopyBytes(it.Value()))
+	}
+	return hashes, values, proof
+}
@@ -40,4 +40,7 @@ var (
 
 	stateInMeter   = metrics.NewRegisteredMeter("eth/downloader/states/in", nil)
 	stateDropMeter = metrics.NewRegisteredMeter("eth/downloader/states/drop", nil)
+
+	snapInMeter   = metrics.NewRegisteredMeter("eth/downloader/snap/in", nil)
+	snapDropMeter = metrics.NewRegisteredMeter("eth/downloader/snap/drop", nil)
 )
@@ -69,6 +69,7 @@ type peerConnection struct {
 	lacking map[common.Hash]struct{} // Set of hashes not to request (didn't have previously)
 
 	peer Peer
+	snap SnapPeer // Range protocol connection of the peer, nil if not supported
 
 	version int        // Eth protocol version number to switch strategies
 	log     log.Logger // Contextual logger to add extra infos to peer logs
@@ -90,6 +91,14 @@ type Peer interface {
 	RequestNodeData([]common.Hash) error
 }
 
+// SnapPeer encapsulates the methods required to retrieve the state in ranges from
+// a remote peer running the range protocol.
+type SnapPeer interface {
+	RequestAccountRange(root common.Hash, origin common.Hash, limit common.Hash, bytes uint64) error
+	RequestStorageRange(root common.Hash, origin common.Hash, limit common.Hash, bytes uint64) error
+	RequestByteCodes(hashes []common.Hash, bytes uint64) error
+}
+
 // lightPeerWrapper wraps a LightPeer struct, stubbing out the Peer-only methods.
 type lightPeerWrapper struct {
 	peer LightPeer
@@ -222,6 +231,70 @@ func (p *peerConnection) FetchNodeData(hashes []common.Hash) error {
 	return nil
 }
 
+// Snap retrieves the range protocol connection of the peer, or nil if the peer
+// doesn't support range retrievals.
+func (p *peerConnection) Snap() SnapPeer {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.snap
+}
+
+// FetchAccountRange sends an account range retrieval request to the remote peer.
+// Range requests share the activity state of node data requests.
+func (p *peerConnection) FetchAccountRange(root common.Hash, origin common.Hash, limit common.Hash) error {
+	// Sanity check the protocol support
+	snap := p.Snap()
+	if snap == nil {
+		panic("account range fetch [range/1] requested on peer without range support")
+	}
+	// Short circuit if the peer is already fetching
+	if !atomic.CompareAndSwapInt32(&p.stateIdle, 0, 1) {
+		return errAlreadyFetching
+	}
+	p.stateStarted = time.Now()
+
+	go snap.RequestAccountRange(root, origin, limit, snapResponseBytes)
+
+	return nil
+}
+
+// FetchStorageRange sends a storage range retrieval request to the remote peer.
+func (p *peerConnection) FetchStorageRange(root common.Hash, origin common.Hash, limit common.Hash) error {
+	// Sanity check the protocol support
+	snap := p.Snap()
+	if snap == nil {
+		panic("storage range fetch [range/1] requested on peer without range support")
+	}
+	// Short circuit if the peer is already fetching
+	if !atomic.CompareAndSwapInt32(&p.stateIdle, 0, 1) {
+		return errAlreadyFetching
+	}
+	p.stateStarted = time.Now()
+
+	go snap.RequestStorageRange(root, origin, limit, snapResponseBytes)
+
+	return nil
+}
+
+// FetchByteCodes sends a contract code retrieval request to the remote peer.
+func (p *peerConnection) FetchByteCodes(hashes []common.Hash) error {
+	// Sanity check the protocol support
+	snap := p.Snap()
+	if snap == nil {
+		panic("bytecode fetch [range/1] requested on peer without range support")
+	}
+	// Short circuit if the peer is already fetching
+	if !atomic.CompareAndSwapInt32(&p.stateIdle, 0, 1) {
+		return errAlreadyFetching
+	}
+	p.stateStarted = time.Now()
+
+	go snap.RequestByteCodes(hashes, snapResponseBytes)
+
+	return nil
+}
+
 // SetHeadersIdle sets the peer to idle, allowing it to execute new header retrieval
 // requests. Its estimated header retrieval throughput is updated with that measured
 // just now.
@@ -422,6 +495,23 @@ func (ps *peerSet) Register(p *peerConnection) error {
 	return nil
 }
 
+// RegisterSnap attaches the range protocol connection of an already registered
+// peer, announcing the peer again so the state sync can assign it range requests.
+func (ps *peerSet) RegisterSnap(id string, snap SnapPeer) error {
+	ps.lock.RLock()
+	p, ok := ps.peers[id]
+	ps.lock.RUnlock()
+	if !ok {
+		return errNotRegistered
+	}
+	p.lock.Lock()
+	p.snap = snap
+	p.lock.Unlock()
+
+	ps.newPeerFeed.Send(p)
+	return nil
+}
+
 // Unregister removes a remote peer from the active set, disabling any further
 // actions to/from that particular entity.
 func (ps *peerSet) Unregister(id string) error {
@@ -522,6 +612,20 @@ func (ps *peerSet) NodeDataIdlePeers() ([]*peerConnection, int) {
 	return ps.idlePeers(63, 64, idle, throughput)
 }
 
+// SnapIdlePeers retrieves a flat list of all the currently node-data-idle peers
+// which can serve account and storage ranges, ordered by their reputation.
+func (ps *peerSet) SnapIdlePeers() ([]*peerConnection, int) {
+	idle := func(p *peerConnection) bool {
+		return atomic.LoadInt32(&p.stateIdle) == 0 && p.Snap() != nil
+	}
+	throughput := func(p *peerConnection) float64 {
+		p.lock.RLock()
+		defer p.lock.RUnlock()
+		return p.stateThroughput
+	}
+	return ps.idlePeers(63, 64, idle, throughput)
+}
+
 // idlePeers retrieves a flat list of all currently idle peers satisfying the
 // protocol version constraints, using the provided function to check idleness.
 // The resulting set of peers are sorted by their measure throughput.
@@ -0,0 +1,703 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+const (
+	snapResponseBytes = 512 * 1024 // Soft size limit of the account, storage and code responses
+	snapAccountChunks = 16         // Number of account ranges to download concurrently
+	maxCodeFetch      = 64         // Amount of contract codes to allow fetching per request
+	snapTrieCacheGens = 16         // Number of commits to keep account trie nodes cached for
+)
+
+var (
+	emptyCode = crypto.Keccak256Hash(nil)                                                              // Hash of the empty contract code
+	maxHash   = common.HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff") // Last hash of the key space
+)
+
+// snapReq represents a single account range, storage range or contract code
+// request of a snap sync. Exactly one of account, storage and codes is set.
+type snapReq struct {
+	account *accountTask              // Account range being downloaded
+	storage *storageTask              // Storage trie being downloaded
+	codes   map[common.Hash]*codeTask // Contract codes being downloaded
+
+	origin   common.Hash // First hash of the requested range
+	response dataPack    // Response data of the peer (nil for timeouts)
+}
+
+// accountTask is a chunk of the account hash space to download.
+type accountTask struct {
+	next     common.Hash         // Next account hash to retrieve
+	last     common.Hash         // Last account hash of the chunk
+	pending  bool                // Flag whether a request is in flight
+	attempts map[string]struct{} // Peers which failed to deliver the chunk
+}
+
+// storageTask is a storage trie to download. The trie is written out to the
+// database in chunks while it's being rebuilt, so only its right edge needs to
+// be kept in memory.
+type storageTask struct {
+	root     common.Hash         // Root hash of the storage trie
+	next     common.Hash         // Next slot hash to retrieve
+	trie     *trie.Trie          // Storage trie being rebuilt from the ranges
+	accounts []common.Hash       // Hashes of the accounts using the storage trie
+	pending  bool                // Flag whether a request is in flight
+	attempts map[string]struct{} // Peers which failed to deliver the trie
+}
+
+// codeTask is a contract code to download.
+type codeTask struct {
+	accounts []common.Hash       // Hashes of the accounts using the code
+	attempts map[string]struct{} // Peers which failed to deliver the code
+}
+
+// snapState is the progress of the range download phase of a snap sync. The
+// account trie is rebuilt from proven account ranges, while the storage tries and
+// contract codes referenced by the accounts are retrieved on the fly. Anything
+// the ranges can't provide is left for the trie node sync to heal.
+//
+// The state outlives the syncs of individual state roots: if the pivot block
+// moves, the download continues against the new root and the paths changed in
+// between are healed afterwards. Its progress is also persisted whenever the
+// rebuilt tries are flushed, so the download can be resumed after a restart.
+type snapState struct {
+	db       ethdb.Database // Database to persist the download progress into
+	triedb   *trie.Database // Database to rebuild the tries in
+	accounts *trie.Trie     // Account trie being rebuilt from the ranges
+
+	accountTasks []*accountTask               // Account chunks still being downloaded
+	storageTasks map[common.Hash]*storageTask // Storage tries still being downloaded
+	codeTasks    map[common.Hash]*codeTask    // Contract codes still to be requested
+	requests     map[*snapReq]struct{}        // Requests currently in flight
+	uncommitted  int                          // Size of the ranges inserted since the last flush
+
+	accountsSynced uint64 // Number of accounts downloaded
+	slotsSynced    uint64 // Number of storage slots downloaded
+	codesSynced    uint64 // Number of contract codes downloaded
+}
+
+// snapProgress is the database representation of the snap sync progress, taken
+// whenever the rebuilt tries are flushed.
+type snapProgress struct {
+	Accounts     common.Hash // Root of the partial account trie flushed
+	AccountTasks []accountProgress
+	StorageTasks []storageProgress
+	CodeTasks    []codeProgress
+}
+
+// accountProgress is the database representation of an account chunk.
+type accountProgress struct {
+	Next common.Hash
+	Last common.Hash
+}
+
+// storageProgress is the database representation of a storage trie download.
+type storageProgress struct {
+	Root     common.Hash   // Root hash of the storage trie
+	Next     common.Hash   // Next slot hash to retrieve
+	Trie     common.Hash   // Root of the partial storage trie flushed
+	Accounts []common.Hash // Hashes of the accounts using the storage trie
+}
+
+// codeProgress is the database representation of a contract code download.
+type codeProgress struct {
+	Hash     common.Hash   // Hash of the contract code
+	Accounts []common.Hash // Hashes of the accounts using the code
+}
+
+// newSnapState creates the range download state, resuming the progress persisted
+// by a previous run if there is any, or splitting the account hash space into
+// evenly sized chunks otherwise.
+func newSnapState(db ethdb.Database) *snapState {
+	s := &snapState{
+		db:           db,
+		triedb:       trie.NewDatabase(db),
+		storageTasks: make(map[common.Hash]*storageTask),
+		codeTasks:    make(map[common.Hash]*codeTask),
+		requests:     make(map[*snapReq]struct{}),
+	}
+	if blob := core.GetSnapSyncProgress(db); len(blob) > 0 {
+		err := s.load(blob)
+		if err == nil {
+			log.Info("Resuming snap sync state download", "accounts", len(s.accountTasks), "storage", len(s.storageTasks), "codes", len(s.codeTasks))
+			return s
+		}
+		log.Warn("Discarding invalid snap sync progress", "err", err)
+	}
+	s.accounts, _ = trie.New(common.Hash{}, s.triedb)
+	s.accounts.SetCacheLimit(snapTrieCacheGens)
+
+	var (
+		next = new(big.Int)
+		step = new(big.Int).Div(new(big.Int).Add(maxHash.Big(), common.Big1), big.NewInt(snapAccountChunks))
+	)
+	for i := 0; i < snapAccountChunks; i++ {
+		last := new(big.Int).Sub(new(big.Int).Add(next, step), common.Big1)
+		if i == snapAccountChunks-1 {
+			last = maxHash.Big()
+		}
+		s.accountTasks = append(s.accountTasks, &accountTask{
+			next:     common.BigToHash(next),
+			last:     common.BigToHash(last),
+			attempts: make(map[string]struct{}),
+		})
+		next = new(big.Int).Add(last, common.Big1)
+	}
+	return s
+}
+
+// load restores the download tasks and the partially rebuilt tries from the
+// persisted progress.
+func (s *snapState) load(blob []byte) error {
+	var progress snapProgress
+	if err := rlp.DecodeBytes(blob, &progress); err != nil {
+		return err
+	}
+	accounts, err := trie.New(progress.Accounts, s.triedb)
+	if err != nil {
+		return err
+	}
+	accounts.SetCacheLimit(snapTrieCacheGens)
+
+	var (
+		accountTasks []*accountTask
+		storageTasks = make(map[common.Hash]*storageTask)
+		codeTasks    = make(map[common.Hash]*codeTask)
+	)
+	for _, t := range progress.AccountTasks {
+		accountTasks = append(accountTasks, &accountTask{next: t.Next, last: t.Last, attempts: make(map[string]struct{})})
+	}
+	for _, t := range progress.StorageTasks {
+		tr, err := trie.New(t.Trie, s.triedb)
+		if err != nil {
+			return err
+		}
+		tr.SetCacheLimit(snapTrieCacheGens)
+		storageTasks[t.Root] = &storageTask{root: t.Root, next: t.Next, trie: tr, accounts: t.Accounts, attempts: make(map[string]struct{})}
+	}
+	for _, t := range progress.CodeTasks {
+		codeTasks[t.Hash] = &codeTask{accounts: t.Accounts, attempts: make(map[string]struct{})}
+	}
+	s.accounts, s.accountTasks, s.storageTasks, s.codeTasks = accounts, accountTasks, storageTasks, codeTasks
+	return nil
+}
+
+// reset prepares the download for being continued by a new sync, possibly of a
+// different state root: the tasks of the requests abandoned by the previous sync
+// are returned to the task set and the failed attempts are forgotten.
+func (s *snapState) reset() {
+	for req := range s.requests {
+		switch {
+		case req.account != nil:
+			req.account.pending = false
+		case req.storage != nil:
+			req.storage.pending = false
+		default:
+			for hash, task := range req.codes {
+				s.codeTasks[hash] = task
+			}
+		}
+	}
+	s.requests = make(map[*snapReq]struct{})
+
+	for _, t := range s.accountTasks {
+		t.attempts = make(map[string]struct{})
+	}
+	for _, t := range s.storageTasks {
+		t.attempts = make(map[string]struct{})
+	}
+	for _, t := range s.codeTasks {
+		t.attempts = make(map[string]struct{})
+	}
+}
+
+// done returns whether all the ranges and codes were downloaded.
+func (s *snapState) done() bool {
+	return len(s.accountTasks) == 0 && len(s.storageTasks) == 0 && len(s.codeTasks) == 0 && len(s.requests) == 0
+}
+
+// nextRequest creates a request for the given peer, preferring storage and code
+// downloads over new account ranges to keep the number of pending tasks low.
+func (s *snapState) nextRequest(peer string) *snapReq {
+	var req *snapReq
+	if len(s.codeTasks) > 0 {
+		codes := make(map[common.Hash]*codeTask)
+		for hash, t := range s.codeTasks {
+			if len(codes) == maxCodeFetch {
+				break
+			}
+			if _, ok := t.attempts[peer]; ok {
+				continue
+			}
+			t.attempts[peer] = struct{}{}
+			codes[hash] = t
+			delete(s.codeTasks, hash)
+		}
+		if len(codes) > 0 {
+			req = &snapReq{codes: codes}
+		}
+	}
+	if req == nil {
+		for _, t := range s.storageTasks {
+			if _, ok := t.attempts[peer]; ok || t.pending {
+				continue
+			}
+			t.pending = true
+			req = &snapReq{storage: t, origin: t.next}
+			break
+		}
+	}
+	if req == nil {
+		for _, t := range s.accountTasks {
+			if _, ok := t.attempts[peer]; ok || t.pending {
+				continue
+			}
+			t.pending = true
+			req = &snapReq{account: t, origin: t.next}
+			break
+		}
+	}
+	if req != nil {
+		s.requests[req] = struct{}{}
+	}
+	return req
+}
+
+// flush commits the account and storage tries rebuilt so far to the database,
+// so that their nodes can be evicted from memory, and persists the progress of
+// the download along with them. The root of the account trie is returned.
+func (s *snapState) flush() (common.Hash, error) {
+	root, err := s.accounts.Commit(nil)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if err := s.triedb.Commit(root, false); err != nil {
+		return common.Hash{}, err
+	}
+	progress := &snapProgress{Accounts: root}
+	for _, t := range s.accountTasks {
+		progress.AccountTasks = append(progress.AccountTasks, accountProgress{Next: t.next, Last: t.last})
+	}
+	for _, t := range s.storageTasks {
+		partial, err := t.trie.Commit(nil)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		if err := s.triedb.Commit(partial, false); err != nil {
+			return common.Hash{}, err
+		}
+		progress.StorageTasks = append(progress.StorageTasks, storageProgress{Root: t.root, Next: t.next, Trie: partial, Accounts: t.accounts})
+	}
+	for hash, t := range s.codeTasks {
+		progress.CodeTasks = append(progress.CodeTasks, codeProgress{Hash: hash, Accounts: t.accounts})
+	}
+	for req := range s.requests {
+		for hash, t := range req.codes {
+			progress.CodeTasks = append(progress.CodeTasks, codeProgress{Hash: hash, Accounts: t.accounts})
+		}
+	}
+	blob, err := rlp.EncodeToBytes(progress)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	core.WriteSnapSyncProgress(s.db, blob)
+
+	s.uncommitted = 0
+	return root, nil
+}
+
+// heal schedules the storage tries and contract codes the ranges didn't provide
+// for retrieval by the trie node sync. It must be called once the account trie
+// of the given root is complete: tasks of accounts which changed since their
+// range was downloaded are skipped, as the healing of the account trie already
+// scheduled whatever the changed accounts reference.
+func (s *snapState) heal(root common.Hash, sched *trie.TrieSync) error {
+	accounts, err := trie.New(root, s.triedb)
+	if err != nil {
+		return err
+	}
+	// used reports whether any of the accounts still satisfies the check
+	used := func(hashes []common.Hash, check func(*state.Account) bool) bool {
+		for _, hash := range hashes {
+			blob, err := accounts.TryGet(hash[:])
+			if err != nil || blob == nil {
+				continue
+			}
+			var account state.Account
+			if err := rlp.DecodeBytes(blob, &account); err == nil && check(&account) {
+				return true
+			}
+		}
+		return false
+	}
+	for hash, t := range s.storageTasks {
+		if used(t.accounts, func(account *state.Account) bool { return account.Root == hash }) {
+			sched.AddSubTrie(hash, 64, common.Hash{}, nil)
+		}
+	}
+	for hash, t := range s.codeTasks {
+		if used(t.accounts, func(account *state.Account) bool { return common.BytesToHash(account.CodeHash) == hash }) {
+			sched.AddRawEntry(hash, 64, common.Hash{})
+		}
+	}
+	return nil
+}
+
+// finish drops the remaining tasks along with the persisted progress once the
+// state was fully synced.
+func (s *snapState) finish() {
+	s.accountTasks = nil
+	s.storageTasks = make(map[common.Hash]*storageTask)
+	s.codeTasks = make(map[common.Hash]*codeTask)
+
+	core.DeleteSnapSyncProgress(s.db)
+}
+
+// snapLoop is the event loop of the range download phase of a snap sync. Like
+// the trie node sync loop, it assigns tasks to idle peers and processes the
+// responses buffered up by the downloader. It returns without an error if the
+// peers can't serve the state, leaving it to the trie node sync to complete.
+func (s *stateSync) snapLoop() error {
+	// Listen for new peer events to assign tasks to them
+	newPeer := make(chan *peerConnection, 1024)
+	peerSub := s.d.peers.SubscribeNewPeers(newPeer)
+	defer peerSub.Unsubscribe()
+
+	start := time.Now()
+	for !s.snap.done() {
+		if err := s.commitSnap(); err != nil {
+			return err
+		}
+		s.assignSnapTasks()
+
+		// If nobody is working on the remaining tasks, no peer can serve them
+		if len(s.snap.requests) == 0 {
+			log.Info("Snap sync ranges unavailable, falling back to trie node sync", "accounts", len(s.snap.accountTasks), "storage", len(s.snap.storageTasks), "codes", len(s.snap.codeTasks))
+			return nil
+		}
+		// Tasks assigned, wait for something to happen
+		select {
+		case <-newPeer:
+			// New peer arrived, try to assign it download tasks
+
+		case <-s.cancel:
+			return errCancelStateFetch
+
+		case <-s.d.cancelCh:
+			return errCancelStateFetch
+
+		case req := <-s.deliver:
+			if req.snap == nil {
+				// Stale trie node response, ignore
+				req.peer.SetNodeDataIdle(len(req.response))
+				continue
+			}
+			if err := s.processSnap(req); err != nil {
+				return err
+			}
+		}
+	}
+	root, err := s.snap.flush()
+	if err != nil {
+		return fmt.Errorf("DB write error: %v", err)
+	}
+	if root != s.root {
+		// Expected if the pivot moved during the download
+		log.Info("Snap synced state differs from pivot, healing", "have", root, "want", s.root)
+	} else {
+		log.Info("Snap sync state download complete", "accounts", s.snap.accountsSynced, "slots", s.snap.slotsSynced, "codes", s.snap.codesSynced, "elapsed", common.PrettyDuration(time.Since(start)))
+	}
+	return nil
+}
+
+// assignSnapTasks attempts to assign new range or code requests to all the idle
+// peers supporting them.
+func (s *stateSync) assignSnapTasks() {
+	peers, _ := s.d.peers.SnapIdlePeers()
+	for _, p := range peers {
+		snap := s.snap.nextRequest(p.id)
+		if snap == nil {
+			continue
+		}
+		req := &stateReq{snap: snap, peer: p, timeout: s.d.requestTTL()}
+
+		select {
+		case s.d.trackStateReq <- req:
+			switch {
+			case snap.account != nil:
+				p.log.Trace("Requesting account range", "origin", snap.origin, "limit", snap.account.last)
+				p.FetchAccountRange(s.root, snap.origin, snap.account.last)
+			case snap.storage != nil:
+				p.log.Trace("Requesting storage range", "root", snap.storage.root, "origin", snap.origin)
+				p.FetchStorageRange(snap.storage.root, snap.origin, maxHash)
+			default:
+				hashes := make([]common.Hash, 0, len(snap.codes))
+				for hash := range snap.codes {
+					hashes = append(hashes, hash)
+				}
+				p.log.Trace("Requesting contract codes", "count", len(hashes))
+				p.FetchByteCodes(hashes)
+			}
+		case <-s.cancel:
+		case <-s.d.cancelCh:
+		}
+	}
+}
+
+// processSnap handles the response (or the failure) of a range or code request.
+func (s *stateSync) processSnap(req *stateReq) error {
+	delete(s.snap.requests, req.snap)
+
+	var (
+		delivered int
+		err       error
+	)
+	switch {
+	case req.snap.account != nil:
+		delivered, err = s.processAccountRange(req)
+	case req.snap.storage != nil:
+		delivered, err = s.processStorageRange(req)
+	default:
+		delivered, err = s.processByteCodes(req)
+	}
+	req.peer.SetNodeDataIdle(delivered)
+	return err
+}
+
+// verifyRange checks that a range response is proven by its edge proofs, returning
+// whether there are more entries in the trie after the range. Responses without
+// any data or proof mean that the peer doesn't have the requested trie.
+func verifyRange(root common.Hash, origin common.Hash, hashes []common.Hash, values [][]byte, proof [][]byte) (bool, error) {
+	proofDb, _ := ethdb.NewMemDatabase()
+	for _, node := range proof {
+		proofDb.Put(crypto.Keccak256(node), node)
+	}
+	keys := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		keys[i] = common.CopyBytes(hash[:])
+	}
+	last := origin
+	if len(hashes) > 0 {
+		last = hashes[len(hashes)-1]
+	}
+	return trie.VerifyRangeProof(root, origin[:], last[:], keys, values, proofDb)
+}
+
+// processAccountRange verifies a delivered account range, inserts it into the
+// account trie and schedules the storage tries and codes of the accounts.
+func (s *stateSync) processAccountRange(req *stateReq) (int, error) {
+	task := req.snap.account
+	task.pending = false
+
+	pack, _ := req.snap.response.(*accountRangePack)
+	if pack == nil || (len(pack.accounts) == 0 && len(pack.proof) == 0) {
+		// Timed out, dropped or the peer doesn't have the state
+		task.attempts[req.peer.id] = struct{}{}
+		return 0, nil
+	}
+	more, err := verifyRange(s.root, req.snap.origin, pack.hashes, pack.accounts, pack.proof)
+	if err != nil {
+		log.Warn("Invalid account range, dropping peer", "peer", req.peer.id, "err", err)
+		task.attempts[req.peer.id] = struct{}{}
+		s.d.dropPeer(req.peer.id)
+		return 0, nil
+	}
+	accounts := 0
+	for i, hash := range pack.hashes {
+		// The peer may deliver one account beyond the chunk, proving its end
+		if bytes.Compare(hash[:], task.last[:]) > 0 {
+			break
+		}
+		var account state.Account
+		if err := rlp.DecodeBytes(pack.accounts[i], &account); err != nil {
+			return 0, fmt.Errorf("invalid account %x: %v", hash, err)
+		}
+		if err := s.snap.accounts.TryUpdate(hash[:], pack.accounts[i]); err != nil {
+			return 0, err
+		}
+		s.snap.uncommitted += common.HashLength + len(pack.accounts[i])
+		accounts++
+
+		if root := account.Root; root != types.EmptyRootHash {
+			if task := s.snap.storageTasks[root]; task != nil {
+				task.accounts = append(task.accounts, hash)
+			} else if ok, _ := s.d.stateDB.Has(root[:]); !ok {
+				st, _ := trie.New(common.Hash{}, s.snap.triedb)
+				st.SetCacheLimit(snapTrieCacheGens)
+				s.snap.storageTasks[root] = &storageTask{root: root, trie: st, accounts: []common.Hash{hash}, attempts: make(map[string]struct{})}
+			}
+		}
+		if code := common.BytesToHash(account.CodeHash); code != emptyCode {
+			if task := s.snap.codeTasks[code]; task != nil {
+				task.accounts = append(task.accounts, hash)
+			} else if ok, _ := s.d.stateDB.Has(code[:]); !ok {
+				s.snap.codeTasks[code] = &codeTask{accounts: []common.Hash{hash}, attempts: make(map[string]struct{})}
+			}
+		}
+	}
+	s.snap.accountsSynced += uint64(accounts)
+
+	// Move the chunk forward, or drop it if it was completed
+	last := req.snap.origin
+	if len(pack.hashes) > 0 {
+		last = pack.hashes[len(pack.hashes)-1]
+	}
+	if !more || bytes.Compare(last[:], task.last[:]) >= 0 {
+		for i, t := range s.snap.accountTasks {
+			if t == task {
+				s.snap.accountTasks = append(s.snap.accountTasks[:i], s.snap.accountTasks[i+1:]...)
+				break
+			}
+		}
+	} else {
+		task.next = incHash(last)
+	}
+	return accounts, nil
+}
+
+// processStorageRange verifies a delivered storage range and inserts it into the
+// storage trie, writing the trie out once it's complete. Incomplete tries are
+// written out in chunks along with the account trie.
+func (s *stateSync) processStorageRange(req *stateReq) (int, error) {
+	task := req.snap.storage
+	task.pending = false
+
+	pack, _ := req.snap.response.(*storageRangePack)
+	if pack == nil || (len(pack.slots) == 0 && len(pack.proof) == 0) {
+		// Timed out, dropped or the peer doesn't have the storage
+		task.attempts[req.peer.id] = struct{}{}
+		return 0, nil
+	}
+	more, err := verifyRange(task.root, req.snap.origin, pack.hashes, pack.slots, pack.proof)
+	if err != nil {
+		log.Warn("Invalid storage range, dropping peer", "peer", req.peer.id, "err", err)
+		task.attempts[req.peer.id] = struct{}{}
+		s.d.dropPeer(req.peer.id)
+		return 0, nil
+	}
+	for i, hash := range pack.hashes {
+		if err := task.trie.TryUpdate(hash[:], pack.slots[i]); err != nil {
+			return 0, err
+		}
+		s.snap.uncommitted += common.HashLength + len(pack.slots[i])
+	}
+	s.snap.slotsSynced += uint64(len(pack.slots))
+
+	if more {
+		task.next = incHash(pack.hashes[len(pack.hashes)-1])
+		return len(pack.slots), nil
+	}
+	// Storage trie complete, write it out
+	delete(s.snap.storageTasks, task.root)
+
+	root, err := task.trie.Commit(nil)
+	if err != nil {
+		return 0, err
+	}
+	if root != task.root {
+		// Can't happen with proven ranges, leave it to the healing
+		log.Warn("Snap synced storage root mismatch", "have", root, "want", task.root)
+		return len(pack.slots), nil
+	}
+	if err := s.snap.triedb.Commit(root, false); err != nil {
+		return 0, fmt.Errorf("DB write error: %v", err)
+	}
+	return len(pack.slots), nil
+}
+
+// processByteCodes writes the delivered contract codes to the database, and puts
+// the undelivered ones back into the task set.
+func (s *stateSync) processByteCodes(req *stateReq) (int, error) {
+	tasks := req.snap.codes
+
+	delivered := 0
+	if pack, ok := req.snap.response.(*byteCodesPack); ok {
+		batch := s.d.stateDB.NewBatch()
+		for _, code := range pack.codes {
+			hash := crypto.Keccak256Hash(code)
+			if _, ok := tasks[hash]; !ok {
+				continue
+			}
+			batch.Put(hash[:], code)
+			delete(tasks, hash)
+			delivered++
+		}
+		if err := batch.Write(); err != nil {
+			return 0, fmt.Errorf("DB write error: %v", err)
+		}
+	}
+	s.snap.codesSynced += uint64(delivered)
+
+	for hash, task := range tasks {
+		s.snap.codeTasks[hash] = task
+	}
+	return delivered, nil
+}
+
+// commitSnap flushes the rebuilt tries and the download progress to the database
+// if enough data was accumulated since the last flush.
+func (s *stateSync) commitSnap() error {
+	if s.snap.uncommitted < ethdb.IdealBatchSize {
+		return nil
+	}
+	start := time.Now()
+	if _, err := s.snap.flush(); err != nil {
+		return fmt.Errorf("DB write error: %v", err)
+	}
+	s.updateSnapStats(time.Since(start))
+	return nil
+}
+
+// updateSnapStats bumps the state sync progress counters and displays a log
+// message for the user to see.
+func (s *stateSync) updateSnapStats(duration time.Duration) {
+	s.d.syncStatsLock.Lock()
+	defer s.d.syncStatsLock.Unlock()
+
+	s.d.syncStatsState.processed = s.snap.accountsSynced + s.snap.slotsSynced + s.snap.codesSynced
+	s.d.syncStatsState.pending = uint64(len(s.snap.storageTasks) + len(s.snap.codeTasks))
+
+	log.Info("Imported new state ranges", "accounts", s.snap.accountsSynced, "slots", s.snap.slotsSynced, "codes", s.snap.codesSynced, "elapsed", common.PrettyDuration(duration), "chunks", len(s.snap.accountTasks), "pending", s.d.syncStatsState.pending)
+}
+
+// incHash returns the hash following the given one.
+func incHash(h common.Hash) common.Hash {
+	for i := len(h) - 1; i >= 0; i-- {
+		h[i]++
+		if h[i] != 0 {
+			break
+		}
+	}
+	return h
+}
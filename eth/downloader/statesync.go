@@ -36,6 +36,7 @@ import (
 type stateReq struct {
 	items    []common.Hash              // Hashes of the state items to download
 	tasks    map[common.Hash]*stateTask // Download tasks to track previous attempts
+	snap     *snapReq                   // Range or code request of a snap sync (nil for node data)
 	timeout  time.Duration              // Maximum round trip time for this to complete
 	timer    *time.Timer                // Timer to fire when the RTT timeout expires
 	peer     *peerConnection            // Peer that we're requesting from
@@ -45,6 +46,9 @@ type stateReq struct {
 
 // timedOut returns if this request timed out.
 func (req *stateReq) timedOut() bool {
+	if req.snap != nil {
+		return req.snap.response == nil
+	}
 	return req.response == nil
 }
 
@@ -80,6 +84,7 @@ func (d *Downloader) stateFetcher() {
 			}
 		case <-d.stateCh:
 			// Ignore state responses while no sync is running.
+		case <-d.snapCh:
 		case <-d.quitCh:
 			return
 		}
@@ -141,7 +146,7 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 		case pack := <-d.stateCh:
 			// Discard any data not requested (or previsouly timed out)
 			req := active[pack.PeerId()]
-			if req == nil {
+			if req == nil || req.snap != nil {
 				log.Debug("Unrequested node data", "peer", pack.PeerId(), "len", pack.Items())
 				continue
 			}
@@ -152,7 +157,22 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 			finished = append(finished, req)
 			delete(active, pack.PeerId())
 
-			// Handle dropped peer connections:
+		// Handle incoming snap sync ranges and codes:
+		case pack := <-d.snapCh:
+			// Discard any data not requested (or previously timed out)
+			req := active[pack.PeerId()]
+			if req == nil || req.snap == nil {
+				log.Debug("Unrequested snap data", "peer", pack.PeerId(), "len", pack.Items())
+				continue
+			}
+			// Finalize the request and queue up for processing
+			req.timer.Stop()
+			req.snap.response = pack
+
+			finished = append(finished, req)
+			delete(active, pack.PeerId())
+
+		// Handle dropped peer connections:
 		case p := <-peerDrop:
 			// Skip if no request is currently pending
 			req := active[p.id]
@@ -212,7 +232,9 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 // stateSync schedules requests for downloading a particular state trie defined
 // by a given state root.
 type stateSync struct {
-	d *Downloader // Downloader instance to access and manage current peerset
+	d    *Downloader // Downloader instance to access and manage current peerset
+	root common.Hash // State root being synced
+	snap *snapState  // Range download state if snap syncing, nil otherwise
 
 	sched  *trie.TrieSync             // State trie sync scheduler defining the tasks
 	keccak hash.Hash                  // Keccak256 hasher to verify deliveries with
@@ -237,8 +259,19 @@ type stateTask struct {
 // newStateSync creates a new state trie download scheduler. This method does not
 // yet start the sync. The user needs to call run to initiate.
 func newStateSync(d *Downloader, root common.Hash) *stateSync {
+	var snap *snapState
+	if d.mode == SnapSync {
+		if d.snapState == nil {
+			d.snapState = newSnapState(d.stateDB)
+		} else {
+			d.snapState.reset()
+		}
+		snap = d.snapState
+	}
 	return &stateSync{
 		d:       d,
+		root:    root,
+		snap:    snap,
 		sched:   state.NewStateSync(root, d.stateDB),
 		keccak:  sha3.NewKeccak256(),
 		tasks:   make(map[common.Hash]*stateTask),
@@ -251,8 +284,27 @@ func newStateSync(d *Downloader, root common.Hash) *stateSync {
 // run starts the task assignment and response processing loop, blocking until
 // it finishes, and finally notifying any goroutines waiting for the loop to
 // finish.
+//
+// When snap syncing, the state is first downloaded in ranges, possibly against
+// older roots if the pivot moved meanwhile. The trie node sync then heals the
+// account trie, along with the storage and code of any accounts changed, before
+// retrieving the storage tries and codes the ranges didn't provide.
 func (s *stateSync) run() {
-	s.err = s.loop()
+	if s.snap != nil {
+		if s.err = s.snapLoop(); s.err == nil {
+			s.sched = state.NewStateSync(s.root, s.d.stateDB)
+			if s.err = s.loop(); s.err == nil {
+				s.sched = state.NewStateSync(s.root, s.d.stateDB)
+				s.err = s.snap.heal(s.root, s.sched)
+			}
+		}
+	}
+	if s.err == nil {
+		s.err = s.loop()
+	}
+	if s.err == nil && s.snap != nil {
+		s.snap.finish()
+	}
 	close(s.done)
 }
 
@@ -298,6 +350,11 @@ func (s *stateSync) loop() error {
 			return errCancelStateFetch
 
 		case req := <-s.deliver:
+			if req.snap != nil {
+				// Leftover range request of an abandoned snap sync, ignore
+				req.peer.SetNodeDataIdle(0)
+				continue
+			}
 			// Response, disconnect or timeout triggered, drop the peer if stalling
 			log.Trace("Received node data response", "peer", req.peer.id, "count", len(req.response), "dropped", req.dropped, "timeout", !req.dropped && req.timedOut())
 			if len(req.items) <= 2 && !req.dropped && req.timedOut() {
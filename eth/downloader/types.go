@@ -19,6 +19,7 @@ package downloader
 import (
 	"fmt"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 )
 
@@ -77,3 +78,41 @@ type statePack struct {
 func (p *statePack) PeerId() string { return p.peerId }
 func (p *statePack) Items() int     { return len(p.states) }
 func (p *statePack) Stats() string  { return fmt.Sprintf("%d", len(p.states)) }
+
+// accountRangePack is a range of accounts returned by a peer, along with the
+// merkle proofs of its edges.
+type accountRangePack struct {
+	peerId   string
+	hashes   []common.Hash
+	accounts [][]byte
+	proof    [][]byte
+}
+
+func (p *accountRangePack) PeerId() string { return p.peerId }
+func (p *accountRangePack) Items() int     { return len(p.accounts) }
+func (p *accountRangePack) Stats() string {
+	return fmt.Sprintf("%d:%d", len(p.accounts), len(p.proof))
+}
+
+// storageRangePack is a range of storage slots returned by a peer, along with
+// the merkle proofs of its edges.
+type storageRangePack struct {
+	peerId string
+	hashes []common.Hash
+	slots  [][]byte
+	proof  [][]byte
+}
+
+func (p *storageRangePack) PeerId() string { return p.peerId }
+func (p *storageRangePack) Items() int     { return len(p.slots) }
+func (p *storageRangePack) Stats() string  { return fmt.Sprintf("%d:%d", len(p.slots), len(p.proof)) }
+
+// byteCodesPack is a batch of contract codes returned by a peer.
+type byteCodesPack struct {
+	peerId string
+	codes  [][]byte
+}
+
+func (p *byteCodesPack) PeerId() string { return p.peerId }
+func (p *byteCodesPack) Items() int     { return len(p.codes) }
+func (p *byteCodesPack) Stats() string  { return fmt.Sprintf("%d", len(p.codes)) }
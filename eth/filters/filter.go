@@ -19,6 +19,7 @@ package filters
 import (
 	"context"
 	"math/big"
+	"sort"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core"
@@ -42,6 +43,7 @@ type Backend interface {
 	SubscribeLogsEvent(ch chan<- []*types.Log) event.Subscription
 
 	BloomStatus() (uint64, uint64)
+	AddressIndexStatus() (uint64, uint64)
 	ServiceFilter(ctx context.Context, session *bloombits.MatcherSession)
 }
 
@@ -109,18 +111,34 @@ func (f *Filter) Logs(ctx context.Context) ([]*types.Log, error) {
 	if f.end == -1 {
 		end = head
 	}
-	// Gather all indexed logs, and finish with non indexed ones
+	// Gather all exactly indexed logs, then the bloom indexed ones, and finish
+	// with non indexed ones
 	var (
 		logs []*types.Log
 		err  error
 	)
+	if len(f.addresses) > 0 {
+		size, sections := f.backend.AddressIndexStatus()
+		if indexed := sections * size; indexed > uint64(f.begin) {
+			if indexed > end {
+				logs, err = f.addressIndexedLogs(ctx, end)
+			} else {
+				logs, err = f.addressIndexedLogs(ctx, indexed-1)
+			}
+			if err != nil {
+				return logs, err
+			}
+		}
+	}
 	size, sections := f.backend.BloomStatus()
-	if indexed := sections * size; indexed > uint64(f.begin) {
+	if indexed := sections * size; indexed > uint64(f.begin) && uint64(f.begin) <= end {
+		var found []*types.Log
 		if indexed > end {
-			logs, err = f.indexedLogs(ctx, end)
+			found, err = f.indexedLogs(ctx, end)
 		} else {
-			logs, err = f.indexedLogs(ctx, indexed-1)
+			found, err = f.indexedLogs(ctx, indexed-1)
 		}
+		logs = append(logs, found...)
 		if err != nil {
 			return logs, err
 		}
@@ -130,6 +148,60 @@ func (f *Filter) Logs(ctx context.Context) ([]*types.Log, error) {
 	return logs, err
 }
 
+// addressIndexedLogs returns the logs matching the filter criteria based on the
+// exact address index available locally.
+func (f *Filter) addressIndexedLogs(ctx context.Context, end uint64) ([]*types.Log, error) {
+	// Only the first topic is indexed, all the others are checked on the logs
+	topics := []*common.Hash{nil}
+	if len(f.topics) > 0 && len(f.topics[0]) > 0 {
+		topics = topics[:0]
+		for i := range f.topics[0] {
+			topics = append(topics, &f.topics[0][i])
+		}
+	}
+	// Collect all the canonical blocks containing matching logs
+	blocks := make(map[uint64]struct{})
+	for _, address := range f.addresses {
+		for _, topic := range topics {
+			entries, err := core.FindAddressLogs(f.db, address, topic, uint64(f.begin), end)
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				blocks[entry.Number] = struct{}{}
+			}
+		}
+	}
+	numbers := make([]uint64, 0, len(blocks))
+	for number := range blocks {
+		numbers = append(numbers, number)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	// Retrieve the matching logs from the blocks in chain order
+	var logs []*types.Log
+	for _, number := range numbers {
+		select {
+		case <-ctx.Done():
+			return logs, ctx.Err()
+		default:
+		}
+		f.begin = int64(number)
+
+		header, err := f.backend.HeaderByNumber(ctx, rpc.BlockNumber(number))
+		if header == nil || err != nil {
+			return logs, err
+		}
+		found, err := f.checkMatches(ctx, header)
+		if err != nil {
+			return logs, err
+		}
+		logs = append(logs, found...)
+	}
+	f.begin = int64(end) + 1
+	return logs, nil
+}
+
 // indexedLogs returns the logs matching the filter criteria based on the bloom
 // bits indexed available locally or via the network.
 func (f *Filter) indexedLogs(ctx context.Context, end uint64) ([]*types.Log, error) {
@@ -241,3 +241,88 @@ func TestFilters(t *testing.T) {
 		t.Error("expected 0 log, got", len(logs))
 	}
 }
+
+// addrIndexBackend is a test backend reporting the address index as available
+// for the first section.
+type addrIndexBackend struct {
+	*testBackend
+}
+
+func (b *addrIndexBackend) AddressIndexStatus() (uint64, uint64) {
+	return params.BloomBitsBlocks, 1
+}
+
+// Tests that the logs of the filtered addresses are retrieved from the address
+// index when it covers the requested range.
+func TestAddressIndexedFilters(t *testing.T) {
+	var (
+		db, _   = ethdb.NewMemDatabase()
+		backend = &addrIndexBackend{&testBackend{new(event.TypeMux), db, 0, new(event.Feed), new(event.Feed), new(event.Feed), new(event.Feed)}}
+		addr    = common.Address{0xaa}
+		other   = common.Address{0xbb}
+
+		hash1 = common.BytesToHash([]byte("topic1"))
+		hash2 = common.BytesToHash([]byte("topic2"))
+		hash3 = common.BytesToHash([]byte("topic3"))
+	)
+	genesis := core.GenesisBlockForTesting(db, addr, big.NewInt(1000000))
+	logs := map[int]*types.Log{
+		1: {Address: addr, Topics: []common.Hash{hash1}, BlockNumber: 2},
+		4: {Address: addr, Topics: []common.Hash{hash2, hash3}, BlockNumber: 5},
+		6: {Address: addr, BlockNumber: 7},
+		7: {Address: other, Topics: []common.Hash{hash1}, BlockNumber: 8},
+		8: {Address: addr, Topics: []common.Hash{hash1}, BlockNumber: 9}, // left out of the index
+	}
+	chain, receipts := core.GenerateChain(params.TestChainConfig, genesis, ethash.NewFaker(), db, 10, func(i int, gen *core.BlockGen) {
+		if log, ok := logs[i]; ok {
+			receipt := types.NewReceipt(nil, false, 0)
+			receipt.Logs = []*types.Log{log}
+			gen.AddUncheckedReceipt(receipt)
+		}
+	})
+	for i, block := range chain {
+		core.WriteBlock(db, block)
+		core.WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+		core.WriteHeadBlockHash(db, block.Hash())
+		core.WriteBlockReceipts(db, block.Hash(), block.NumberU64(), receipts[i])
+
+		if log, ok := logs[i]; ok && i != 8 {
+			var topic common.Hash
+			if len(log.Topics) > 0 {
+				topic = log.Topics[0]
+			}
+			core.WriteAddressLogIndex(db, log.Address, topic, block.NumberU64(), block.Hash(), 0, 0)
+		}
+	}
+	// Insert an entry of a reorged block, which must be ignored
+	core.WriteAddressLogIndex(db, addr, hash1, 3, common.Hash{0x01}, 0, 0)
+
+	tests := []struct {
+		begin, end int64
+		addresses  []common.Address
+		topics     [][]common.Hash
+		want       []uint64
+	}{
+		{0, -1, []common.Address{addr}, nil, []uint64{2, 5, 7}},
+		{0, -1, []common.Address{addr}, [][]common.Hash{{hash2}}, []uint64{5}},
+		{0, -1, []common.Address{addr}, [][]common.Hash{{hash1, hash2}}, []uint64{2, 5}},
+		{0, -1, []common.Address{addr}, [][]common.Hash{nil, {hash3}}, []uint64{5}},
+		{0, -1, []common.Address{addr, other}, [][]common.Hash{{hash1}}, []uint64{2, 8}},
+		{3, 6, []common.Address{addr}, nil, []uint64{5}},
+	}
+	for i, tt := range tests {
+		found, err := New(backend, tt.begin, tt.end, tt.addresses, tt.topics).Logs(context.Background())
+		if err != nil {
+			t.Fatalf("test %d: failed to filter logs: %v", i, err)
+		}
+		if len(found) != len(tt.want) {
+			t.Errorf("test %d: log count mismatch: have %d, want %d", i, len(found), len(tt.want))
+			continue
+		}
+		for j, log := range found {
+			if log.BlockNumber != tt.want[j] {
+				t.Errorf("test %d, log %d: block mismatch: have %d, want %d", i, j, log.BlockNumber, tt.want[j])
+			}
+		}
+	}
+}
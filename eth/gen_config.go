@@ -25,6 +25,11 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		SkipBcVersionCheck      bool `toml:"-"`
 		DatabaseHandles         int  `toml:"-"`
 		DatabaseCache           int
+		DatabaseFreezer         string         `toml:",omitempty"`
+		DatabaseNoSnappy        bool           `toml:",omitempty"`
+		Snapshot                bool           `toml:",omitempty"`
+		StateHistory            bool           `toml:",omitempty"`
+		AddressIndex            bool           `toml:",omitempty"`
 		Etherbase               common.Address `toml:",omitempty"`
 		MinerThreads            int            `toml:",omitempty"`
 		ExtraData               hexutil.Bytes  `toml:",omitempty"`
@@ -44,6 +49,11 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.SkipBcVersionCheck = c.SkipBcVersionCheck
 	enc.DatabaseHandles = c.DatabaseHandles
 	enc.DatabaseCache = c.DatabaseCache
+	enc.DatabaseFreezer = c.DatabaseFreezer
+	enc.DatabaseNoSnappy = c.DatabaseNoSnappy
+	enc.Snapshot = c.Snapshot
+	enc.StateHistory = c.StateHistory
+	enc.AddressIndex = c.AddressIndex
 	enc.Etherbase = c.Etherbase
 	enc.MinerThreads = c.MinerThreads
 	enc.ExtraData = c.ExtraData
@@ -66,6 +76,11 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		SkipBcVersionCheck      *bool `toml:"-"`
 		DatabaseHandles         *int  `toml:"-"`
 		DatabaseCache           *int
+		DatabaseFreezer         *string         `toml:",omitempty"`
+		DatabaseNoSnappy        *bool           `toml:",omitempty"`
+		Snapshot                *bool           `toml:",omitempty"`
+		StateHistory            *bool           `toml:",omitempty"`
+		AddressIndex            *bool           `toml:",omitempty"`
 		Etherbase               *common.Address `toml:",omitempty"`
 		MinerThreads            *int            `toml:",omitempty"`
 		ExtraData               *hexutil.Bytes  `toml:",omitempty"`
@@ -104,6 +119,21 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.DatabaseCache != nil {
 		c.DatabaseCache = *dec.DatabaseCache
 	}
+	if dec.DatabaseFreezer != nil {
+		c.DatabaseFreezer = *dec.DatabaseFreezer
+	}
+	if dec.DatabaseNoSnappy != nil {
+		c.DatabaseNoSnappy = *dec.DatabaseNoSnappy
+	}
+	if dec.Snapshot != nil {
+		c.Snapshot = *dec.Snapshot
+	}
+	if dec.StateHistory != nil {
+		c.StateHistory = *dec.StateHistory
+	}
+	if dec.AddressIndex != nil {
+		c.AddressIndex = *dec.AddressIndex
+	}
 	if dec.Etherbase != nil {
 		c.Etherbase = *dec.Etherbase
 	}
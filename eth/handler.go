@@ -49,6 +49,12 @@ const (
 	// txChanSize is the size of channel listening to TxPreEvent.
 	// The number is referenced from the size of tx pool.
 	txChanSize = 4096
+
+	// Reputation adjustments of peers, see p2p.Peer.Penalize.
+	syncDropPenalty  = 5   // Peer was dropped by the downloader, most often for timing out
+	fetchDropPenalty = 25  // Peer was dropped by the fetcher for propagating an invalid block
+	forkPenalty      = 100 // Peer is on the other side of the DAO fork (bans it)
+	newHeadReward    = 1   // Peer propagated a block advancing its head
 )
 
 var (
@@ -67,6 +73,7 @@ type ProtocolManager struct {
 	networkId uint64
 
 	fastSync  uint32 // Flag whether fast sync is enabled (gets disabled if we already have blocks)
+	snapSync  bool   // Flag whether fast sync retrieves the state in ranges instead of trie nodes
 	acceptTxs uint32 // Flag whether we're considered synchronised (enables transaction processing)
 
 	txpool      txPool
@@ -78,6 +85,8 @@ type ProtocolManager struct {
 	fetcher    *fetcher.Fetcher
 	peers      *peerSet
 
+	consensusHandler consensus.Handler // Consensus engine exchanging its own messages, if any
+
 	SubProtocols []p2p.Protocol
 
 	eventMux      *event.TypeMux
@@ -113,26 +122,36 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 		quitSync:    make(chan struct{}),
 	}
 	// Figure out whether to allow fast sync or not
-	if mode == downloader.FastSync && blockchain.CurrentBlock().NumberU64() > 0 {
+	manager.snapSync = mode == downloader.SnapSync
+	if (mode == downloader.FastSync || mode == downloader.SnapSync) && blockchain.CurrentBlock().NumberU64() > 0 {
 		log.Warn("Blockchain not empty, fast sync disabled")
 		mode = downloader.FullSync
 	}
-	if mode == downloader.FastSync {
+	if mode == downloader.FastSync || mode == downloader.SnapSync {
 		manager.fastSync = uint32(1)
 	}
+	// If the consensus engine has its own messages, route them through the protocol
+	if handler, ok := engine.(consensus.Handler); ok {
+		manager.consensusHandler = handler
+		handler.SetBroadcaster(manager)
+	}
 	// Initiate a sub-protocol for every implemented version we can handle
 	manager.SubProtocols = make([]p2p.Protocol, 0, len(ProtocolVersions))
 	for i, version := range ProtocolVersions {
 		// Skip protocol version if incompatible with the mode of operation
-		if mode == downloader.FastSync && version < eth63 {
+		if (mode == downloader.FastSync || mode == downloader.SnapSync) && version < eth63 {
 			continue
 		}
 		// Compatible; initialise the sub-protocol
+		length := ProtocolLengths[i]
+		if manager.consensusHandler != nil {
+			length = ConsensusMsg + 1
+		}
 		version := version // Closure for the run
 		manager.SubProtocols = append(manager.SubProtocols, p2p.Protocol{
 			Name:    ProtocolName,
 			Version: version,
-			Length:  ProtocolLengths[i],
+			Length:  length,
 			Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
 				peer := manager.newPeer(int(version), p, rw)
 				select {
@@ -158,8 +177,19 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 	if len(manager.SubProtocols) == 0 {
 		return nil, errIncompatibleConfig
 	}
+	// Serve the state in ranges to snap syncing peers, running alongside eth
+	for i, version := range SnapProtocolVersions {
+		manager.SubProtocols = append(manager.SubProtocols, p2p.Protocol{
+			Name:    SnapProtocolName,
+			Version: version,
+			Length:  SnapProtocolLengths[i],
+			Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+				return manager.handleSnap(newSnapPeer(p, rw))
+			},
+		})
+	}
 	// Construct the different synchronisation mechanisms
-	manager.downloader = downloader.New(mode, chaindb, manager.eventMux, blockchain, nil, manager.removePeer)
+	manager.downloader = downloader.New(mode, chaindb, manager.eventMux, blockchain, nil, manager.dropSyncPeer)
 
 	validator := func(header *types.Header) error {
 		return engine.VerifyHeader(blockchain, header, true)
@@ -176,7 +206,7 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 		atomic.StoreUint32(&manager.acceptTxs, 1) // Mark initial sync done on any fetcher import
 		return manager.blockchain.InsertChain(blocks)
 	}
-	manager.fetcher = fetcher.New(blockchain.GetBlockByHash, validator, manager.BroadcastBlock, heighter, inserter, manager.removePeer)
+	manager.fetcher = fetcher.New(blockchain.GetBlockByHash, validator, manager.BroadcastBlock, heighter, inserter, manager.dropFetchPeer)
 
 	return manager, nil
 }
@@ -200,6 +230,28 @@ func (pm *ProtocolManager) removePeer(id string) {
 	}
 }
 
+// dropSyncPeer penalizes and disconnects a peer dropped by the downloader. Most
+// downloader drops are timeouts an honest but slow peer may run into too, so the
+// penalty is only a fraction of the ban threshold.
+func (pm *ProtocolManager) dropSyncPeer(id string) {
+	pm.dropPeer(id, syncDropPenalty, "dropped by downloader")
+}
+
+// dropFetchPeer penalizes and disconnects a peer dropped by the fetcher, which
+// only happens if the peer propagated an invalid block.
+func (pm *ProtocolManager) dropFetchPeer(id string) {
+	pm.dropPeer(id, fetchDropPenalty, "dropped by fetcher")
+}
+
+// dropPeer penalizes a peer for misbehaviour detected by the synchronisation
+// subsystems and disconnects it.
+func (pm *ProtocolManager) dropPeer(id string, penalty float64, reason string) {
+	if peer := pm.peers.Peer(id); peer != nil {
+		peer.Penalize(penalty, reason)
+	}
+	pm.removePeer(id)
+}
+
 func (pm *ProtocolManager) Start(maxPeers int) {
 	pm.maxPeers = maxPeers
 
@@ -281,6 +333,11 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	if err := pm.downloader.RegisterPeer(p.id, p.version, p); err != nil {
 		return err
 	}
+	// Link the snap connection of the peer if it already started up, otherwise
+	// it will link itself when it does
+	if snap := pm.peers.SnapPeer(p.id); snap != nil {
+		pm.downloader.RegisterSnapPeer(p.id, snap)
+	}
 	// Propagate existing transactions. new transactions appearing
 	// after this will be sent via broadcasts.
 	pm.syncTransactions(p)
@@ -449,6 +506,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				// Validate the header and either drop the peer or continue
 				if err := misc.VerifyDAOHeaderExtraData(pm.chainconfig, headers[0]); err != nil {
 					p.Log().Debug("Verified to be on the other side of the DAO fork, dropping")
+					p.Penalize(forkPenalty, "DAO fork mismatch")
 					return err
 				}
 				p.Log().Debug("Verified to be on the same side of the DAO fork")
@@ -644,6 +702,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		// Update the peers total difficulty if better than the previous
 		if _, td := p.Head(); trueTD.Cmp(td) > 0 {
 			p.SetHead(trueHead, trueTD)
+			p.Reward(newHeadReward)
 
 			// Schedule a sync if above ours. Note, this will not fire a sync for a gap of
 			// a singe block (as the true TD is below the propagated block), however this
@@ -673,12 +732,32 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		}
 		pm.txpool.AddRemotes(txs)
 
+	case pm.consensusHandler != nil && msg.Code == ConsensusMsg:
+		// Consensus engine message arrived, let the engine handle it
+		return pm.consensusHandler.HandleMsg(p.address, msg)
+
 	default:
 		return errResp(ErrInvalidMsgCode, "%v", msg.Code)
 	}
 	return nil
 }
 
+// Enqueue implements consensus.Broadcaster, scheduling a block for import as if
+// it was propagated by the given origin.
+func (pm *ProtocolManager) Enqueue(id string, block *types.Block) {
+	pm.fetcher.Enqueue(id, block)
+}
+
+// FindPeers implements consensus.Broadcaster, retrieving the connected peers
+// among the given addresses.
+func (pm *ProtocolManager) FindPeers(targets map[common.Address]bool) map[common.Address]consensus.Peer {
+	peers := make(map[common.Address]consensus.Peer)
+	for address, p := range pm.peers.PeersWithAddresses(targets) {
+		peers[address] = p
+	}
+	return peers
+}
+
 // BroadcastBlock will either propagate a block to a subset of it's peers, or
 // will only announce it's availability (depending what's requested).
 func (pm *ProtocolManager) BroadcastBlock(block *types.Block, propagate bool) {
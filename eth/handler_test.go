@@ -34,7 +34,9 @@ import (
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
 )
 
 // Tests that protocol versions and modes of operations are matched up properly.
@@ -389,6 +391,56 @@ func testGetNodeData(t *testing.T, protocol int) {
 	}
 }
 
+// Tests that account ranges can be retrieved along with proofs of their edges.
+func TestGetAccountRange(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 4, nil, nil)
+	peer, _ := newTestSnapPeer(discover.NodeID{}, "peer", pm)
+	defer peer.close()
+
+	// Request the entire account trie of the head state
+	root := pm.blockchain.CurrentBlock().Root()
+	req := getRangeData{Root: root, Limit: common.HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"), Bytes: softResponseLimit}
+	if err := p2p.Send(peer.app, GetAccountRangeMsg, &req); err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	msg, err := peer.app.ReadMsg()
+	if err != nil {
+		t.Fatalf("failed to read account range response: %v", err)
+	}
+	if msg.Code != AccountRangeMsg {
+		t.Fatalf("response packet code mismatch: have %x, want %x", msg.Code, AccountRangeMsg)
+	}
+	var data rangeData
+	if err := msg.Decode(&data); err != nil {
+		t.Fatalf("failed to decode account range: %v", err)
+	}
+	// Verify that the range contains all accounts and is proven
+	tr, _ := pm.blockchain.StateCache().OpenTrie(root)
+	accounts := 0
+	for it := trie.NewIterator(tr.NodeIterator(nil)); it.Next(); {
+		accounts++
+	}
+	if len(data.Entries) != accounts {
+		t.Fatalf("account count mismatch: have %d, want %d", len(data.Entries), accounts)
+	}
+	keys, values := make([][]byte, len(data.Entries)), make([][]byte, len(data.Entries))
+	for i, entry := range data.Entries {
+		keys[i], values[i] = common.CopyBytes(entry.Hash[:]), entry.Body
+	}
+	proofDb, _ := ethdb.NewMemDatabase()
+	for _, node := range data.Proof {
+		proofDb.Put(crypto.Keccak256(node), node)
+	}
+	last := keys[len(keys)-1]
+	more, err := trie.VerifyRangeProof(root, common.Hash{}.Bytes(), last, keys, values, proofDb)
+	if err != nil {
+		t.Fatalf("failed to verify account range: %v", err)
+	}
+	if more {
+		t.Errorf("range reported incomplete")
+	}
+}
+
 // Tests that the transaction receipts can be retrieved based on hashes.
 func TestGetReceipt63(t *testing.T) { testGetReceipt(t, 63) }
 
@@ -199,3 +199,31 @@ func (p *testPeer) handshake(t *testing.T, td *big.Int, head common.Hash, genesi
 func (p *testPeer) close() {
 	p.app.Close()
 }
+
+// testSnapPeer is a simulated snap connection of a peer to allow testing direct
+// network calls.
+type testSnapPeer struct {
+	net p2p.MsgReadWriter // Network layer reader/writer to simulate remote messaging
+	app *p2p.MsgPipeRW    // Application layer reader/writer to simulate the local side
+	*snapPeer
+}
+
+// newTestSnapPeer creates a new snap connection of the peer with the given id,
+// registered at the given protocol manager.
+func newTestSnapPeer(id discover.NodeID, name string, pm *ProtocolManager) (*testSnapPeer, <-chan error) {
+	app, net := p2p.MsgPipe()
+
+	peer := newSnapPeer(p2p.NewPeer(id, name, nil), net)
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- pm.handleSnap(peer)
+	}()
+	return &testSnapPeer{app: app, net: net, snapPeer: peer}, errc
+}
+
+// close terminates the local side of the snap connection, notifying the remote
+// protocol manager of termination.
+func (p *testSnapPeer) close() {
+	p.app.Close()
+}
@@ -25,6 +25,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/rlp"
 	"gopkg.in/fatih/set.v0"
@@ -54,7 +55,8 @@ type peer struct {
 	id string
 
 	*p2p.Peer
-	rw p2p.MsgReadWriter
+	rw      p2p.MsgReadWriter
+	address common.Address // Ethereum address derived from the node key of the peer
 
 	version  int         // Protocol version negotiated
 	forkDrop *time.Timer // Timed connection dropper if forks aren't validated in time
@@ -70,9 +72,14 @@ type peer struct {
 func newPeer(version int, p *p2p.Peer, rw p2p.MsgReadWriter) *peer {
 	id := p.ID()
 
+	var address common.Address
+	if pubkey, err := id.Pubkey(); err == nil {
+		address = crypto.PubkeyToAddress(*pubkey)
+	}
 	return &peer{
 		Peer:        p,
 		rw:          rw,
+		address:     address,
 		version:     version,
 		id:          fmt.Sprintf("%x", id[:8]),
 		knownTxs:    set.New(),
@@ -130,6 +137,11 @@ func (p *peer) MarkTransaction(hash common.Hash) {
 	p.knownTxs.Add(hash)
 }
 
+// SendConsensus sends a consensus engine specific message to the peer.
+func (p *peer) SendConsensus(data interface{}) error {
+	return p2p.Send(p.rw, ConsensusMsg, data)
+}
+
 // SendTransactions sends transactions to the peer and includes the hashes
 // in its transaction hash set for future reference.
 func (p *peer) SendTransactions(txs types.Transactions) error {
@@ -301,6 +313,7 @@ func (p *peer) String() string {
 // the Ethereum sub-protocol.
 type peerSet struct {
 	peers  map[string]*peer
+	snaps  map[string]*snapPeer
 	lock   sync.RWMutex
 	closed bool
 }
@@ -309,6 +322,7 @@ type peerSet struct {
 func newPeerSet() *peerSet {
 	return &peerSet{
 		peers: make(map[string]*peer),
+		snaps: make(map[string]*snapPeer),
 	}
 }
 
@@ -341,6 +355,39 @@ func (ps *peerSet) Unregister(id string) error {
 	return nil
 }
 
+// RegisterSnap injects the snap connection of a peer into the working set, or
+// returns an error if the peer already has one. The snap connection may start
+// up before or after the eth one.
+func (ps *peerSet) RegisterSnap(p *snapPeer) error {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	if ps.closed {
+		return errClosed
+	}
+	if _, ok := ps.snaps[p.id]; ok {
+		return errAlreadyRegistered
+	}
+	ps.snaps[p.id] = p
+	return nil
+}
+
+// UnregisterSnap removes the snap connection of a peer from the active set.
+func (ps *peerSet) UnregisterSnap(id string) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	delete(ps.snaps, id)
+}
+
+// SnapPeer retrieves the registered snap connection of the peer with the given id.
+func (ps *peerSet) SnapPeer(id string) *snapPeer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	return ps.snaps[id]
+}
+
 // Peer retrieves the registered peer with the given id.
 func (ps *peerSet) Peer(id string) *peer {
 	ps.lock.RLock()
@@ -387,6 +434,21 @@ func (ps *peerSet) PeersWithoutTx(hash common.Hash) []*peer {
 	return list
 }
 
+// PeersWithAddresses retrieves the peers whose node keys correspond to any of the
+// given addresses.
+func (ps *peerSet) PeersWithAddresses(addresses map[common.Address]bool) map[common.Address]*peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	peers := make(map[common.Address]*peer)
+	for _, p := range ps.peers {
+		if addresses[p.address] {
+			peers[p.address] = p
+		}
+	}
+	return peers
+}
+
 // BestPeer retrieves the known peer with the currently highest total difficulty.
 func (ps *peerSet) BestPeer() *peer {
 	ps.lock.RLock()
@@ -62,6 +62,37 @@ const (
 	NodeDataMsg    = 0x0e
 	GetReceiptsMsg = 0x0f
 	ReceiptsMsg    = 0x10
+
+	// Consensus engine specific messages, only supported if the engine is a
+	// consensus.Handler
+	ConsensusMsg = 0x11
+)
+
+// Constants to match up range protocol versions and messages
+const (
+	range1 = 1
+)
+
+// Official short name of the protocol serving the state in proven ranges for
+// snap sync, running alongside the eth protocol. Its messages are not compatible
+// with the standardized snap protocol, hence the distinct name.
+var SnapProtocolName = "range"
+
+// Supported versions of the range protocol (first is primary).
+var SnapProtocolVersions = []uint{range1}
+
+// Number of implemented message corresponding to different protocol versions.
+var SnapProtocolLengths = []uint64{6}
+
+// range protocol message codes
+const (
+	// Protocol messages belonging to range/1
+	GetAccountRangeMsg = 0x00
+	AccountRangeMsg    = 0x01
+	GetStorageRangeMsg = 0x02
+	StorageRangeMsg    = 0x03
+	GetByteCodesMsg    = 0x04
+	ByteCodesMsg       = 0x05
 )
 
 type errCode int
@@ -181,3 +212,31 @@ type blockBody struct {
 
 // blockBodiesData is the network packet for block content distribution.
 type blockBodiesData []*blockBody
+
+// getRangeData is the network packet for an account or storage range query.
+type getRangeData struct {
+	Root   common.Hash // Root of the state or storage trie to serve the range from
+	Origin common.Hash // Hash of the first entry to retrieve
+	Limit  common.Hash // Hash of the last entry to retrieve
+	Bytes  uint64      // Soft limit of the response size
+}
+
+// rangeEntry is a single account or storage slot of a range, as stored in the trie.
+type rangeEntry struct {
+	Hash common.Hash // Hash of the account address or storage slot
+	Body []byte      // RLP encoded account or slot value
+}
+
+// rangeData is the network packet for the account or storage range delivery. It
+// contains the merkle proofs of the first and the last retrieved entries, which
+// prove the range as a whole.
+type rangeData struct {
+	Entries []rangeEntry
+	Proof   [][]byte
+}
+
+// getByteCodesData is the network packet for a contract code query.
+type getByteCodesData struct {
+	Hashes []common.Hash // Hashes of the contract codes to retrieve
+	Bytes  uint64        // Soft limit of the response size
+}
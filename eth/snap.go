@@ -0,0 +1,250 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/eth/downloader"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// snapPeer is the range protocol connection of a remote peer. It runs alongside
+// the eth connection of the same peer, with which it shares its id.
+type snapPeer struct {
+	id string
+
+	*p2p.Peer
+	rw p2p.MsgReadWriter
+}
+
+func newSnapPeer(p *p2p.Peer, rw p2p.MsgReadWriter) *snapPeer {
+	id := p.ID()
+
+	return &snapPeer{
+		id:   fmt.Sprintf("%x", id[:8]),
+		Peer: p,
+		rw:   rw,
+	}
+}
+
+// SendRange sends an account or storage range along with its edge proofs.
+func (p *snapPeer) SendRange(code uint64, entries []rangeEntry, proof [][]byte) error {
+	return p2p.Send(p.rw, code, &rangeData{Entries: entries, Proof: proof})
+}
+
+// SendByteCodes sends a batch of contract codes, corresponding to the hashes
+// requested.
+func (p *snapPeer) SendByteCodes(codes [][]byte) error {
+	return p2p.Send(p.rw, ByteCodesMsg, codes)
+}
+
+// RequestAccountRange fetches a range of accounts from the state trie with the
+// given root, proven by the merkle proofs of its edges.
+func (p *snapPeer) RequestAccountRange(root common.Hash, origin common.Hash, limit common.Hash, bytes uint64) error {
+	p.Log().Debug("Fetching range of accounts", "root", root, "origin", origin, "limit", limit)
+	return p2p.Send(p.rw, GetAccountRangeMsg, &getRangeData{Root: root, Origin: origin, Limit: limit, Bytes: bytes})
+}
+
+// RequestStorageRange fetches a range of storage slots from the storage trie
+// with the given root, proven by the merkle proofs of its edges.
+func (p *snapPeer) RequestStorageRange(root common.Hash, origin common.Hash, limit common.Hash, bytes uint64) error {
+	p.Log().Debug("Fetching range of storage slots", "root", root, "origin", origin, "limit", limit)
+	return p2p.Send(p.rw, GetStorageRangeMsg, &getRangeData{Root: root, Origin: origin, Limit: limit, Bytes: bytes})
+}
+
+// RequestByteCodes fetches a batch of contract codes corresponding to the hashes
+// specified.
+func (p *snapPeer) RequestByteCodes(hashes []common.Hash, bytes uint64) error {
+	p.Log().Debug("Fetching batch of contract codes", "count", len(hashes))
+	return p2p.Send(p.rw, GetByteCodesMsg, &getByteCodesData{Hashes: hashes, Bytes: bytes})
+}
+
+// handleSnap is the callback invoked to manage the life cycle of a snap peer.
+// The connection is linked to the eth connection of the same peer in the
+// downloader, so that state ranges can be retrieved from it. When this function
+// terminates, the peer is disconnected.
+func (pm *ProtocolManager) handleSnap(p *snapPeer) error {
+	if err := pm.peers.RegisterSnap(p); err != nil {
+		p.Log().Debug("Snap peer registration failed", "err", err)
+		return err
+	}
+	defer pm.peers.UnregisterSnap(p.id)
+
+	// Link the eth connection if it already started up, otherwise it will link
+	// this one when it does
+	if pm.peers.Peer(p.id) != nil {
+		pm.downloader.RegisterSnapPeer(p.id, p)
+	}
+	for {
+		if err := pm.handleSnapMsg(p); err != nil {
+			p.Log().Debug("Snap message handling failed", "err", err)
+			return err
+		}
+	}
+}
+
+// handleSnapMsg is invoked whenever an inbound message is received from a remote
+// snap peer. The remote connection is torn down upon returning any error.
+func (pm *ProtocolManager) handleSnapMsg(p *snapPeer) error {
+	// Read the next message from the remote peer, and ensure it's fully consumed
+	msg, err := p.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	if msg.Size > ProtocolMaxMsgSize {
+		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, ProtocolMaxMsgSize)
+	}
+	defer msg.Discard()
+
+	// Handle the message depending on its contents
+	switch {
+	case msg.Code == GetAccountRangeMsg || msg.Code == GetStorageRangeMsg:
+		// Decode the range query and serve it from the state tries
+		var query getRangeData
+		if err := msg.Decode(&query); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		entries, proof := serveRange(pm.blockchain.StateCache().TrieDB(), &query)
+		if msg.Code == GetAccountRangeMsg {
+			return p.SendRange(AccountRangeMsg, entries, proof)
+		}
+		return p.SendRange(StorageRangeMsg, entries, proof)
+
+	case msg.Code == AccountRangeMsg || msg.Code == StorageRangeMsg:
+		// A range of accounts or storage slots arrived to one of our previous requests
+		var res rangeData
+		if err := msg.Decode(&res); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		hashes, values := splitRange(res.Entries)
+
+		var err error
+		if msg.Code == AccountRangeMsg {
+			err = pm.downloader.DeliverAccountRange(p.id, hashes, values, res.Proof)
+		} else {
+			err = pm.downloader.DeliverStorageRange(p.id, hashes, values, res.Proof)
+		}
+		if err != nil {
+			log.Debug("Failed to deliver state range", "err", err)
+		}
+
+	case msg.Code == GetByteCodesMsg:
+		// Decode the retrieval message
+		var query getByteCodesData
+		if err := msg.Decode(&query); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		limit := query.Bytes
+		if limit > softResponseLimit {
+			limit = softResponseLimit
+		}
+		// Gather contract codes until the fetch or network limits is reached
+		var (
+			bytes uint64
+			codes [][]byte
+		)
+		for _, hash := range query.Hashes {
+			if bytes >= limit || len(codes) >= downloader.MaxStateFetch {
+				break
+			}
+			if code, err := pm.blockchain.TrieNode(hash); err == nil {
+				codes = append(codes, code)
+				bytes += uint64(len(code))
+			}
+		}
+		return p.SendByteCodes(codes)
+
+	case msg.Code == ByteCodesMsg:
+		// A batch of contract codes arrived to one of our previous requests
+		var codes [][]byte
+		if err := msg.Decode(&codes); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if err := pm.downloader.DeliverByteCodes(p.id, codes); err != nil {
+			log.Debug("Failed to deliver contract codes", "err", err)
+		}
+
+	default:
+		return errResp(ErrInvalidMsgCode, "%v", msg.Code)
+	}
+	return nil
+}
+
+// serveRange collects the entries of the trie with the given root, starting at
+// origin, until the first entry at or beyond limit is included or the size limit
+// is reached. The merkle proofs of origin and of the last entry are returned
+// along with the entries, proving the range as a whole.
+//
+// If the trie is not available, neither entries nor proofs are returned.
+func serveRange(triedb *trie.Database, req *getRangeData) ([]rangeEntry, [][]byte) {
+	tr, err := trie.New(req.Root, triedb)
+	if err != nil {
+		return nil, nil
+	}
+	limit := req.Bytes
+	if limit > softResponseLimit {
+		limit = softResponseLimit
+	}
+	var (
+		entries []rangeEntry
+		size    uint64
+	)
+	it := trie.NewIterator(tr.NodeIterator(req.Origin[:]))
+	for it.Next() {
+		entries = append(entries, rangeEntry{Hash: common.BytesToHash(it.Key), Body: common.CopyBytes(it.Value)})
+		size += uint64(common.HashLength + len(it.Value))
+
+		if bytes.Compare(it.Key, req.Limit[:]) >= 0 || size >= limit {
+			break
+		}
+	}
+	if it.Err != nil {
+		log.Debug("Failed to iterate trie range", "root", req.Root, "err", it.Err)
+		return nil, nil
+	}
+	// Prove the edges of the range
+	proofDb, _ := ethdb.NewMemDatabase()
+	if err := tr.Prove(req.Origin[:], 0, proofDb); err != nil {
+		return nil, nil
+	}
+	if len(entries) > 0 {
+		if err := tr.Prove(entries[len(entries)-1].Hash[:], 0, proofDb); err != nil {
+			return nil, nil
+		}
+	}
+	var proof [][]byte
+	for it := proofDb.NewIterator(); it.Next(); {
+		proof = append(proof, common.CopyBytes(it.Value()))
+	}
+	return entries, proof
+}
+
+// splitRange splits the entries of a range into their hashes and values.
+func splitRange(entries []rangeEntry) ([]common.Hash, [][]byte) {
+	hashes := make([]common.Hash, len(entries))
+	values := make([][]byte, len(entries))
+	for i, entry := range entries {
+		hashes[i], values[i] = entry.Hash, entry.Body
+	}
+	return hashes, values
+}
@@ -189,7 +189,10 @@ func (pm *ProtocolManager) synchronise(peer *peer) {
 		mode = downloader.FastSync
 	}
 
-	if mode == downloader.FastSync {
+	if mode == downloader.FastSync && pm.snapSync {
+		mode = downloader.SnapSync
+	}
+	if mode != downloader.FullSync {
 		// Make sure the peer's total difficulty we are synchronizing is higher.
 		if pm.blockchain.GetTdByHash(pm.blockchain.CurrentFastBlock().Hash()).Cmp(pTd) >= 0 {
 			return
@@ -17,6 +17,7 @@
 package eth
 
 import (
+	"fmt"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -53,3 +54,64 @@ func TestFastSyncDisabling(t *testing.T) {
 		t.Fatalf("fast sync not disabled after successful synchronisation")
 	}
 }
+
+// snapRequestCounter wraps a snap connection, counting the range requests sent.
+type snapRequestCounter struct {
+	p2p.MsgReadWriter
+	requests int32
+}
+
+func (rw *snapRequestCounter) WriteMsg(msg p2p.Msg) error {
+	if msg.Code == GetAccountRangeMsg {
+		atomic.AddInt32(&rw.requests, 1)
+	}
+	return rw.MsgReadWriter.WriteMsg(msg)
+}
+
+// Tests that snap sync retrieves the state over the snap connection of the peer
+// it synchronises with, linked to the peer's eth connection regardless of which
+// one starts up first.
+func TestSnapSyncSnapFirst(t *testing.T) { testSnapSync(t, true) }
+func TestSnapSyncEthFirst(t *testing.T)  { testSnapSync(t, false) }
+
+func testSnapSync(t *testing.T, snapFirst bool) {
+	pmEmpty, _ := newTestProtocolManagerMust(t, downloader.SnapSync, 0, nil, nil)
+	pmFull, _ := newTestProtocolManagerMust(t, downloader.SnapSync, 1024, nil, nil)
+
+	var (
+		id       = fmt.Sprintf("%x", discover.NodeID{}.Bytes()[:8])
+		io1, io2 = p2p.MsgPipe()
+		sp1, sp2 = p2p.MsgPipe()
+		counter  = &snapRequestCounter{MsgReadWriter: sp1}
+	)
+	startEth := func() {
+		go pmFull.handle(pmFull.newPeer(63, p2p.NewPeer(discover.NodeID{}, "empty", nil), io2))
+		go pmEmpty.handle(pmEmpty.newPeer(63, p2p.NewPeer(discover.NodeID{}, "full", nil), io1))
+		for pmEmpty.peers.Peer(id) == nil {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	startSnap := func() {
+		go pmFull.handleSnap(newSnapPeer(p2p.NewPeer(discover.NodeID{}, "empty", nil), sp2))
+		go pmEmpty.handleSnap(newSnapPeer(p2p.NewPeer(discover.NodeID{}, "full", nil), counter))
+		for pmEmpty.peers.SnapPeer(id) == nil {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if snapFirst {
+		startSnap()
+		startEth()
+	} else {
+		startEth()
+		startSnap()
+	}
+	time.Sleep(250 * time.Millisecond)
+	pmEmpty.synchronise(pmEmpty.peers.BestPeer())
+
+	if head := pmEmpty.blockchain.CurrentBlock().NumberU64(); head != 1024 {
+		t.Fatalf("chain head mismatch: have %d, want %d", head, 1024)
+	}
+	if atomic.LoadInt32(&counter.requests) == 0 {
+		t.Fatalf("no account ranges requested over the snap connection")
+	}
+}
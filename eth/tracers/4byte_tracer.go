@@ -0,0 +1,112 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// fourByteTracer is a native Go implementation of the JavaScript 4byteTracer. It
+// searches for 4byte-identifiers, and collects them for post-processing. It
+// collects the methods identifiers along with the size of the supplied data, so
+// a reversed signature can be matched against the size of the data.
+type fourByteTracer struct {
+	interrupter
+
+	ids   *orderedObject // Aggregated 4byte ids found, keyed by id and data size
+	input []byte         // Input data of the outer call
+}
+
+// newFourByteTracer creates a native 4byte tracer.
+func newFourByteTracer() ResultTracer {
+	return &fourByteTracer{ids: newOrderedObject()}
+}
+
+// store saves the given identifier and data size.
+func (t *fourByteTracer) store(id []byte, size uint64) {
+	key := hexutil.Encode(id) + "-" + strconv.FormatUint(size, 10)
+
+	count, _ := t.ids.get(key)
+	n, _ := count.(int)
+	t.ids.set(key, n+1)
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (t *fourByteTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	t.input = input
+	return nil
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (t *fourByteTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if t.interrupted() {
+		return nil
+	}
+	// Skip any opcodes that are not internal calls, otherwise find the stack
+	// position of the input memory offset (i.e. the first param after 'value')
+	var ct int
+	switch op {
+	case vm.CALL, vm.CALLCODE:
+		ct = 3 // gas, addr, val, memin, meminsz, memout, memoutsz
+	case vm.DELEGATECALL, vm.STATICCALL:
+		ct = 2 // gas, addr, memin, meminsz, memout, memoutsz
+	default:
+		return nil
+	}
+	// Note, the JavaScript tracer means to skip pre-compile invocations here, but
+	// its check never matches (it converts the raw stack item, not its hex form,
+	// into an address). Those calls are retained for output compatibility.
+
+	// Gather internal call details
+	if inSz := peekUint64(stack, ct+1); inSz >= 4 {
+		inOff := peekUint64(stack, ct)
+		t.store(sliceMemory(memory, inOff, inOff+4), inSz-4)
+	}
+	return nil
+}
+
+// CaptureFault implements the Tracer interface to trace an execution fault
+// while running an opcode.
+func (t *fourByteTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *fourByteTracer) CaptureEnd(output []byte, gasUsed uint64, elapsed time.Duration, err error) error {
+	return nil
+}
+
+// GetResult returns the JSON encoded 4byte identifiers found during the trace,
+// along with any error that occurred during tracing.
+func (t *fourByteTracer) GetResult() (json.RawMessage, error) {
+	// Save the outer calldata also
+	if len(t.input) > 4 {
+		t.store(t.input[:4], uint64(len(t.input)-4))
+	}
+	blob, err := encodeJSON(t.ids)
+	if err != nil {
+		return nil, err
+	}
+	return blob, t.err
+}
@@ -0,0 +1,293 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// callFrame is a single internal call reported by the call tracer. The fields
+// are ordered the same way the JavaScript call tracer serializes them.
+type callFrame struct {
+	Type    string       `json:"type"`
+	From    string       `json:"from,omitempty"`
+	To      string       `json:"to,omitempty"`
+	Value   string       `json:"value,omitempty"`
+	Gas     string       `json:"gas,omitempty"`
+	GasUsed string       `json:"gasUsed,omitempty"`
+	Input   string       `json:"input,omitempty"`
+	Output  string       `json:"output,omitempty"`
+	Error   string       `json:"error,omitempty"`
+	Time    string       `json:"time,omitempty"`
+	Calls   []*callFrame `json:"calls,omitempty"`
+
+	gasIn   uint64 // Gas available before the call opcode executed
+	gasCost uint64 // Cost of the call opcode itself
+	gas     uint64 // Gas allowance inside the call, if gasSet
+	gasSet  bool   // Whether the gas allowance inside the call is known
+	outOff  uint64 // Memory offset of the call's return data
+	outLen  uint64 // Memory length of the call's return data
+}
+
+// callTracer is a native Go implementation of the JavaScript callTracer. It
+// extracts and reports all the internal calls made by a transaction, along with
+// any useful information.
+type callTracer struct {
+	interrupter
+
+	callstack []*callFrame // Current recursive call stack of the EVM execution
+	descended bool         // Whether we've just descended into an inner call
+	suicides  bool         // Whether to report the beneficiary and balance of self destructs
+
+	typ     string // Type of the outer call (CALL or CREATE)
+	from    common.Address
+	to      common.Address
+	input   []byte
+	gas     uint64
+	value   *big.Int
+	output  []byte
+	gasUsed uint64
+	time    string
+	error   string
+}
+
+// newCallTracer creates a native call tracer.
+func newCallTracer() ResultTracer {
+	return &callTracer{callstack: []*callFrame{{}}}
+}
+
+// newFlatCallTracer creates a native call tracer which, unlike the JavaScript
+// one, also reports the destructed contract, the beneficiary and the refunded
+// balance of self destructs, as needed by the flat trace format.
+func newFlatCallTracer() ResultTracer {
+	return &callTracer{callstack: []*callFrame{{}}, suicides: true}
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (t *callTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	t.typ = "CALL"
+	if create {
+		t.typ = "CREATE"
+	}
+	t.from, t.to, t.input, t.gas, t.value = from, to, input, gas, value
+	return nil
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (t *callTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if t.interrupted() {
+		return nil
+	}
+	// Capture any errors immediately
+	if err != nil {
+		t.fault(err)
+		return nil
+	}
+	// We only care about system opcodes, faster if we pre-check once
+	syscall := op&0xf0 == 0xf0
+
+	// If a new contract is being created, add to the call stack
+	if syscall && (op == vm.CREATE || op == vm.CREATE2) {
+		inOff := peekUint64(stack, 1)
+		inEnd := inOff + peekUint64(stack, 2)
+
+		t.callstack = append(t.callstack, &callFrame{
+			Type:    op.String(),
+			From:    hexutil.Encode(contract.Address().Bytes()),
+			Input:   hexutil.Encode(sliceMemory(memory, inOff, inEnd)),
+			gasIn:   gas,
+			gasCost: cost,
+			Value:   hexBig(peekStack(stack, 0)),
+		})
+		t.descended = true
+		return nil
+	}
+	// If a contract is being self destructed, gather that as a subcall too
+	if syscall && op == vm.SELFDESTRUCT {
+		frame := &callFrame{Type: op.String()}
+		if t.suicides {
+			frame.From = hexutil.Encode(contract.Address().Bytes())
+			frame.To = hexutil.Encode(common.BigToAddress(peekStack(stack, 0)).Bytes())
+			frame.Value = hexBig(env.StateDB.GetBalance(contract.Address()))
+		}
+		parent := t.callstack[len(t.callstack)-1]
+		parent.Calls = append(parent.Calls, frame)
+		return nil
+	}
+	// If a new method invocation is being done, add to the call stack
+	if syscall && (op == vm.CALL || op == vm.CALLCODE || op == vm.DELEGATECALL || op == vm.STATICCALL) {
+		// Skip any pre-compile invocations, those are just fancy opcodes
+		to := common.BigToAddress(peekStack(stack, 1))
+		if _, ok := vm.PrecompiledContractsByzantium[to]; ok {
+			return nil
+		}
+		off := 1
+		if op == vm.DELEGATECALL || op == vm.STATICCALL {
+			off = 0
+		}
+		inOff := peekUint64(stack, 2+off)
+		inEnd := inOff + peekUint64(stack, 3+off)
+
+		call := &callFrame{
+			Type:    op.String(),
+			From:    hexutil.Encode(contract.Address().Bytes()),
+			To:      hexutil.Encode(to.Bytes()),
+			Input:   hexutil.Encode(sliceMemory(memory, inOff, inEnd)),
+			gasIn:   gas,
+			gasCost: cost,
+			outOff:  peekUint64(stack, 4+off),
+			outLen:  peekUint64(stack, 5+off),
+		}
+		if op != vm.DELEGATECALL && op != vm.STATICCALL {
+			call.Value = hexBig(peekStack(stack, 2))
+		}
+		t.callstack = append(t.callstack, call)
+		t.descended = true
+		return nil
+	}
+	// If we've just descended into an inner call, retrieve it's true allowance. We
+	// need to extract if from within the call as there may be funky gas dynamics
+	// with regard to requested and actually given gas (2300 stipend, 63/64 rule).
+	if t.descended {
+		if depth >= len(t.callstack) {
+			t.callstack[len(t.callstack)-1].gas = gas
+			t.callstack[len(t.callstack)-1].gasSet = true
+		}
+		t.descended = false
+	}
+	// If an existing call is returning, pop off the call stack
+	if syscall && op == vm.REVERT {
+		t.callstack[len(t.callstack)-1].Error = "execution reverted"
+		return nil
+	}
+	if depth == len(t.callstack)-1 {
+		// Pop off the last call and get the execution results
+		call := t.callstack[len(t.callstack)-1]
+		t.callstack = t.callstack[:len(t.callstack)-1]
+
+		if call.Type == vm.CREATE.String() || call.Type == vm.CREATE2.String() {
+			// If the call was a CREATE, retrieve the contract address and output code
+			call.GasUsed = hexInt(int64(call.gasIn) - int64(call.gasCost) - int64(gas))
+
+			if ret := peekStack(stack, 0); ret.Sign() != 0 {
+				addr := common.BigToAddress(ret)
+				call.To = hexutil.Encode(addr.Bytes())
+				call.Output = hexutil.Encode(env.StateDB.GetCode(addr))
+			} else if call.Error == "" {
+				call.Error = "internal failure"
+			}
+		} else {
+			// If the call was a contract call, retrieve the gas usage and output
+			if call.gasSet {
+				call.GasUsed = hexInt(int64(call.gasIn) - int64(call.gasCost) + int64(call.gas) - int64(gas))
+
+				if ret := peekStack(stack, 0); ret.Sign() != 0 {
+					call.Output = hexutil.Encode(sliceMemory(memory, call.outOff, call.outOff+call.outLen))
+				} else if call.Error == "" {
+					call.Error = "internal failure"
+				}
+			}
+		}
+		if call.gasSet {
+			call.Gas = hexInt(int64(call.gas))
+		}
+		// Inject the call into the previous one
+		parent := t.callstack[len(t.callstack)-1]
+		parent.Calls = append(parent.Calls, call)
+	}
+	return nil
+}
+
+// CaptureFault implements the Tracer interface to trace an execution fault
+// while running an opcode.
+func (t *callTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if t.err == nil {
+		t.fault(err)
+	}
+	return nil
+}
+
+// fault handles the failure of the current call.
+func (t *callTracer) fault(err error) {
+	// If the topmost call already reverted, don't handle the additional fault again
+	if t.callstack[len(t.callstack)-1].Error != "" {
+		return
+	}
+	// Pop off the just failed call
+	call := t.callstack[len(t.callstack)-1]
+	t.callstack = t.callstack[:len(t.callstack)-1]
+	call.Error = err.Error()
+
+	// Consume all available gas and clean any leftovers
+	if call.gasSet {
+		call.Gas = hexInt(int64(call.gas))
+		call.GasUsed = call.Gas
+	}
+	// Flatten the failed call into its parent
+	if len(t.callstack) > 0 {
+		parent := t.callstack[len(t.callstack)-1]
+		parent.Calls = append(parent.Calls, call)
+		return
+	}
+	// Last call failed too, leave it in the stack
+	t.callstack = append(t.callstack, call)
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *callTracer) CaptureEnd(output []byte, gasUsed uint64, elapsed time.Duration, err error) error {
+	t.output, t.gasUsed, t.time = output, gasUsed, elapsed.String()
+	if err != nil {
+		t.error = err.Error()
+	}
+	return nil
+}
+
+// GetResult returns the JSON encoded call tree of the traced transaction, along
+// with any error that occurred during tracing.
+func (t *callTracer) GetResult() (json.RawMessage, error) {
+	result := &callFrame{
+		Type:    t.typ,
+		From:    hexutil.Encode(t.from.Bytes()),
+		To:      hexutil.Encode(t.to.Bytes()),
+		Value:   hexBig(t.value),
+		Gas:     hexInt(int64(t.gas)),
+		GasUsed: hexInt(int64(t.gasUsed)),
+		Input:   hexutil.Encode(t.input),
+		Output:  hexutil.Encode(t.output),
+		Time:    t.time,
+		Calls:   t.callstack[0].Calls,
+	}
+	if t.callstack[0].Error != "" {
+		result.Error = t.callstack[0].Error
+	} else if t.error != "" {
+		result.Error = t.error
+	}
+	if result.Error != "" {
+		result.Output = ""
+	}
+	blob, err := encodeJSON(result)
+	if err != nil {
+		return nil, err
+	}
+	return blob, t.err
+}
@@ -0,0 +1,69 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/eth/tracers/profiler"
+)
+
+// gasProfiler exposes the gas profiler through the tracing API. Its result holds
+// the gas used per instruction along with the whole profile in the folded stack
+// and the gzipped pprof formats.
+type gasProfiler struct {
+	interrupter
+	*profiler.Profiler
+}
+
+// newGasProfiler creates a native gas profiling tracer.
+func newGasProfiler() ResultTracer {
+	return &gasProfiler{Profiler: profiler.New(nil)}
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (t *gasProfiler) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if t.interrupted() {
+		return nil
+	}
+	return t.Profiler.CaptureState(env, pc, op, gas, cost, memory, stack, contract, depth, err)
+}
+
+// GetResult returns the JSON encoded gas profile of the traced transaction, along
+// with any error that occurred during tracing.
+func (t *gasProfiler) GetResult() (json.RawMessage, error) {
+	var folded, pprof bytes.Buffer
+	if err := t.WriteFolded(&folded); err != nil {
+		return nil, err
+	}
+	if err := t.WritePprof(&pprof); err != nil {
+		return nil, err
+	}
+	res, err := json.Marshal(struct {
+		Gas       uint64                  `json:"gas"`
+		Locations []profiler.LocationStat `json:"locations"`
+		Folded    string                  `json:"folded"`
+		Pprof     hexutil.Bytes           `json:"pprof"`
+	}{t.Total(), t.Locations(), folded.String(), pprof.Bytes()})
+	if err != nil {
+		return nil, err
+	}
+	return res, t.err
+}
@@ -0,0 +1,222 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// ResultTracer is a vm.Tracer which aggregates the trace of an execution into a
+// single JSON result and which can be interrupted mid-execution. Both the
+// JavaScript tracers and the native Go tracers implement it.
+type ResultTracer interface {
+	vm.Tracer
+
+	// GetResult returns the JSON encoded result of the trace, along with any
+	// error that occurred during tracing.
+	GetResult() (json.RawMessage, error)
+
+	// Stop terminates execution of the tracer at the first opportune moment.
+	Stop(err error)
+}
+
+// natives contains the constructors of all the built in Go tracers by name. The
+// names are shared with the JavaScript tracers they replace.
+var natives = map[string]func() ResultTracer{
+	"callTracer":     newCallTracer,
+	"prestateTracer": newPrestateTracer,
+	"4byteTracer":    newFourByteTracer,
+}
+
+// nativeOnly contains the constructors of the built in Go tracers which have no
+// JavaScript counterpart.
+var nativeOnly = map[string]func() ResultTracer{
+	"gasProfiler":    newGasProfiler,
+	"flatCallTracer": newFlatCallTracer,
+}
+
+// NewNative instantiates a built in Go tracer by name. The boolean return value
+// reports whether a native implementation of the requested tracer exists.
+//
+// Native tracers produce the exact same output as their JavaScript counterparts,
+// but run orders of magnitude faster.
+func NewNative(name string) (ResultTracer, bool) {
+	if constructor, ok := natives[name]; ok {
+		return constructor(), true
+	}
+	if constructor, ok := nativeOnly[name]; ok {
+		return constructor(), true
+	}
+	return nil, false
+}
+
+// interrupter implements the interruption logic shared by the native tracers.
+type interrupter struct {
+	interrupt uint32 // Atomic flag to signal execution interruption
+	reason    error  // Textual reason for the interruption
+	err       error  // Error, if one has occurred
+}
+
+// Stop terminates execution of the tracer at the first opportune moment.
+func (i *interrupter) Stop(err error) {
+	i.reason = err
+	atomic.StoreUint32(&i.interrupt, 1)
+}
+
+// interrupted checks whether tracing was already aborted, recording the reason
+// of the interruption as the tracing error the first time around.
+func (i *interrupter) interrupted() bool {
+	if i.err != nil {
+		return true
+	}
+	if atomic.LoadUint32(&i.interrupt) > 0 {
+		i.err = i.reason
+		return true
+	}
+	return false
+}
+
+// peekStack returns the nth-from-the-top element of the stack, or zero if the
+// stack is not deep enough.
+func peekStack(stack *vm.Stack, n int) *big.Int {
+	data := stack.Data()
+	if len(data) <= n {
+		return new(big.Int)
+	}
+	return data[len(data)-n-1]
+}
+
+// peekUint64 returns the nth-from-the-top element of the stack as an integer,
+// saturating at the maximum value if it does not fit.
+func peekUint64(stack *vm.Stack, n int) uint64 {
+	val := peekStack(stack, n)
+	if !val.IsUint64() {
+		return ^uint64(0)
+	}
+	return val.Uint64()
+}
+
+// sliceMemory returns the requested range of memory as a byte slice, or nil if
+// the range is out of bounds.
+func sliceMemory(memory *vm.Memory, begin, end uint64) []byte {
+	if end < begin || end > uint64(memory.Len()) {
+		return nil
+	}
+	return memory.Get(int64(begin), int64(end-begin))
+}
+
+// hexBig formats a big integer the same way the JavaScript tracers do, as a
+// 0x prefixed hexadecimal number (with the sign following the prefix).
+func hexBig(n *big.Int) string {
+	if n == nil {
+		return "0x0"
+	}
+	return "0x" + n.Text(16)
+}
+
+// hexInt formats a signed integer the same way the JavaScript tracers do.
+func hexInt(n int64) string {
+	if n < 0 {
+		return "0x-" + strconv.FormatUint(uint64(-n), 16)
+	}
+	return "0x" + strconv.FormatUint(uint64(n), 16)
+}
+
+// orderedObject is a JSON object retaining the insertion order of its keys, the
+// same way JavaScript objects are serialized.
+type orderedObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// newOrderedObject creates an empty insertion ordered JSON object.
+func newOrderedObject() *orderedObject {
+	return &orderedObject{values: make(map[string]interface{})}
+}
+
+// get retrieves the value associated with a key.
+func (o *orderedObject) get(key string) (interface{}, bool) {
+	val, ok := o.values[key]
+	return val, ok
+}
+
+// set associates a value with a key, appending the key to the end of the object
+// if it was not yet present.
+func (o *orderedObject) set(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+// delete removes a key and its associated value from the object.
+func (o *orderedObject) delete(key string) {
+	if _, ok := o.values[key]; !ok {
+		return
+	}
+	delete(o.values, key)
+	for i, k := range o.keys {
+		if k == key {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// MarshalJSON implements json.Marshaler, encoding the object fields in their
+// insertion order.
+func (o *orderedObject) MarshalJSON() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		blob, err := encodeJSON(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(blob)
+		buf.WriteByte(':')
+
+		if blob, err = encodeJSON(o.values[key]); err != nil {
+			return nil, err
+		}
+		buf.Write(blob)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// encodeJSON serializes a value into compact JSON without escaping HTML special
+// characters, matching the output of the JavaScript tracers.
+func encodeJSON(v interface{}) (json.RawMessage, error) {
+	buf := new(bytes.Buffer)
+
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}), nil
+}
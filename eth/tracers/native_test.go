@@ -0,0 +1,291 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/tests"
+)
+
+// timeRegexp matches the execution time field of the call tracer, which is the
+// only part of the output that differs between two runs.
+var timeRegexp = regexp.MustCompile(`"time":"[^"]*"`)
+
+// runTracerTest executes the transaction of a call tracer test case with the
+// given tracer attached and returns the trace result.
+func runTracerTest(t *testing.T, test *callTracerTest, tracer ResultTracer) json.RawMessage {
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(common.FromHex(test.Input), tx); err != nil {
+		t.Fatalf("failed to parse testcase input: %v", err)
+	}
+	signer := types.MakeSigner(test.Genesis.Config, new(big.Int).SetUint64(uint64(test.Context.Number)))
+	origin, _ := signer.Sender(tx)
+
+	context := vm.Context{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		Origin:      origin,
+		Coinbase:    test.Context.Miner,
+		BlockNumber: new(big.Int).SetUint64(uint64(test.Context.Number)),
+		Time:        new(big.Int).SetUint64(uint64(test.Context.Time)),
+		Difficulty:  (*big.Int)(test.Context.Difficulty),
+		GasLimit:    uint64(test.Context.GasLimit),
+		GasPrice:    tx.GasPrice(),
+	}
+	db, _ := ethdb.NewMemDatabase()
+	statedb := tests.MakePreState(db, test.Genesis.Alloc)
+
+	evm := vm.NewEVM(context, statedb, test.Genesis.Config, vm.Config{Debug: true, Tracer: tracer})
+	msg, err := tx.AsMessage(signer)
+	if err != nil {
+		t.Fatalf("failed to prepare transaction for tracing: %v", err)
+	}
+	st := core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(tx.Gas()))
+	if _, _, _, err = st.TransitionDb(); err != nil {
+		t.Fatalf("failed to execute transaction: %v", err)
+	}
+	res, err := tracer.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve trace result: %v", err)
+	}
+	return res
+}
+
+// Tests that the native tracers produce byte-for-byte the same output as their
+// JavaScript counterparts over the entire call tracer test suite.
+func TestNativeTracerConformance(t *testing.T) {
+	files, err := ioutil.ReadDir("testdata")
+	if err != nil {
+		t.Fatalf("failed to retrieve tracer test suite: %v", err)
+	}
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), "call_tracer_") {
+			continue
+		}
+		blob, err := ioutil.ReadFile(filepath.Join("testdata", file.Name()))
+		if err != nil {
+			t.Fatalf("failed to read testcase: %v", err)
+		}
+		test := new(callTracerTest)
+		if err := json.Unmarshal(blob, test); err != nil {
+			t.Fatalf("failed to parse testcase: %v", err)
+		}
+		for name := range natives {
+			jstracer, err := New(name)
+			if err != nil {
+				t.Fatalf("failed to create JavaScript %s: %v", name, err)
+			}
+			native, _ := NewNative(name)
+
+			want := timeRegexp.ReplaceAll(runTracerTest(t, test, jstracer), []byte(`"time":""`))
+			have := timeRegexp.ReplaceAll(runTracerTest(t, test, native), []byte(`"time":""`))
+			if string(have) != string(want) {
+				t.Errorf("%s: %s mismatch:\nhave %s\nwant %s", file.Name(), name, have, want)
+			}
+		}
+	}
+}
+
+// Tests that the native call tracer produces the expected call trees.
+func TestCallTracerNative(t *testing.T) {
+	files, err := ioutil.ReadDir("testdata")
+	if err != nil {
+		t.Fatalf("failed to retrieve tracer test suite: %v", err)
+	}
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), "call_tracer_") {
+			continue
+		}
+		blob, err := ioutil.ReadFile(filepath.Join("testdata", file.Name()))
+		if err != nil {
+			t.Fatalf("failed to read testcase: %v", err)
+		}
+		test := new(callTracerTest)
+		if err := json.Unmarshal(blob, test); err != nil {
+			t.Fatalf("failed to parse testcase: %v", err)
+		}
+		tracer, _ := NewNative("callTracer")
+
+		ret := new(callTrace)
+		if err := json.Unmarshal(runTracerTest(t, test, tracer), ret); err != nil {
+			t.Fatalf("%s: failed to unmarshal trace result: %v", file.Name(), err)
+		}
+		if !jsonEqual(ret, test.Result) {
+			t.Errorf("%s: trace mismatch: have %+v, want %+v", file.Name(), ret, test.Result)
+		}
+	}
+}
+
+// jsonEqual compares two values by their JSON encodings.
+func jsonEqual(a, b interface{}) bool {
+	ablob, _ := json.Marshal(a)
+	bblob, _ := json.Marshal(b)
+	return string(ablob) == string(bblob)
+}
+
+// Tests that the native tracers match the JavaScript ones on a synthetic contract
+// touching storage, precompiles, reverting subcalls, foreign balances and self
+// destructing.
+func TestNativeTracerSynthetic(t *testing.T) {
+	var (
+		origin  = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+		caller  = common.HexToAddress("0x00000000000000000000000000000000000000bb")
+		reverts = common.HexToAddress("0x00000000000000000000000000000000000000cc")
+		foreign = common.HexToAddress("0x00000000000000000000000000000000000000dd")
+	)
+	code := []byte{
+		byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x00, byte(vm.SSTORE), // Store 1 into slot 0
+		byte(vm.PUSH1), 0x00, byte(vm.SLOAD), byte(vm.POP), // Load slot 0 back
+		// Call the ecrecover precompile with 128 bytes of input
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x80, byte(vm.PUSH1), 0x00,
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x01, byte(vm.PUSH2), 0xff, 0xff, byte(vm.CALL), byte(vm.POP),
+		// Call the reverting contract with 4 bytes of input
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x04, byte(vm.PUSH1), 0x00,
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0xcc, byte(vm.PUSH2), 0xff, 0xff, byte(vm.CALL), byte(vm.POP),
+		// Retrieve the balance of a foreign account
+		byte(vm.PUSH1), 0xdd, byte(vm.BALANCE), byte(vm.POP),
+		// Self destruct, refunding the foreign account
+		byte(vm.PUSH1), 0xdd, byte(vm.SELFDESTRUCT),
+	}
+	alloc := core.GenesisAlloc{
+		origin:  {Balance: big.NewInt(1000000000), Nonce: 3},
+		caller:  {Balance: big.NewInt(5), Code: code},
+		reverts: {Code: []byte{byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.REVERT)}},
+		foreign: {Balance: big.NewInt(42)},
+	}
+	run := func(tracer ResultTracer) json.RawMessage {
+		db, _ := ethdb.NewMemDatabase()
+		statedb := tests.MakePreState(db, alloc)
+
+		context := vm.Context{
+			CanTransfer: core.CanTransfer,
+			Transfer:    core.Transfer,
+			Origin:      origin,
+			BlockNumber: big.NewInt(1),
+			Time:        big.NewInt(1),
+			Difficulty:  big.NewInt(1),
+			GasLimit:    10000000,
+			GasPrice:    big.NewInt(1),
+		}
+		evm := vm.NewEVM(context, statedb, params.AllEthashProtocolChanges, vm.Config{Debug: true, Tracer: tracer})
+		evm.Call(vm.AccountRef(origin), caller, common.FromHex("0xdeadbeef00000001"), 1000000, big.NewInt(7))
+
+		res, err := tracer.GetResult()
+		if err != nil {
+			t.Fatalf("failed to retrieve trace result: %v", err)
+		}
+		return timeRegexp.ReplaceAll(res, []byte(`"time":""`))
+	}
+	for name := range natives {
+		jstracer, err := New(name)
+		if err != nil {
+			t.Fatalf("failed to create JavaScript %s: %v", name, err)
+		}
+		native, _ := NewNative(name)
+		if want, have := run(jstracer), run(native); string(have) != string(want) {
+			t.Errorf("%s mismatch:\nhave %s\nwant %s", name, have, want)
+		}
+	}
+	// The flat call tracer should additionally report the beneficiary and the
+	// refunded balance of self destructs
+	flat, _ := NewNative("flatCallTracer")
+	have := run(flat)
+
+	suicide := `{"type":"SELFDESTRUCT","from":"0x00000000000000000000000000000000000000bb","to":"0x00000000000000000000000000000000000000dd","value":"0xc"}`
+	if !strings.Contains(string(have), suicide) {
+		t.Errorf("self destruct missing from flat call trace:\nhave %s\nwant %s", have, suicide)
+	}
+}
+
+// Tests that the native gas profiler is available through the tracing API and
+// reports the gas used by the execution.
+func TestGasProfilerResult(t *testing.T) {
+	var (
+		origin   = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+		contract = common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	)
+	alloc := core.GenesisAlloc{
+		origin: {Balance: big.NewInt(1000000000)},
+		contract: {
+			Balance: big.NewInt(0),
+			Code:    []byte{byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x00, byte(vm.SSTORE), byte(vm.STOP)},
+		},
+	}
+	db, _ := ethdb.NewMemDatabase()
+	statedb := tests.MakePreState(db, alloc)
+
+	tracer, ok := NewNative("gasProfiler")
+	if !ok {
+		t.Fatalf("gas profiler not available")
+	}
+	context := vm.Context{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		Origin:      origin,
+		BlockNumber: big.NewInt(1),
+		Time:        big.NewInt(1),
+		Difficulty:  big.NewInt(1),
+		GasLimit:    10000000,
+		GasPrice:    big.NewInt(1),
+	}
+	evm := vm.NewEVM(context, statedb, params.AllEthashProtocolChanges, vm.Config{Debug: true, Tracer: tracer})
+	if _, left, err := evm.Call(vm.AccountRef(origin), contract, nil, 100000, new(big.Int)); err != nil {
+		t.Fatalf("execution failed: %v", err)
+	} else if used := 100000 - left; used != 20006 {
+		t.Fatalf("gas used mismatch: have %d, want 20006", used)
+	}
+	res, err := tracer.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve trace result: %v", err)
+	}
+	var profile struct {
+		Gas       uint64
+		Locations []struct {
+			Op  string
+			Gas uint64
+		}
+		Folded string
+		Pprof  hexutil.Bytes
+	}
+	if err := json.Unmarshal(res, &profile); err != nil {
+		t.Fatalf("failed to decode trace result: %v", err)
+	}
+	if profile.Gas != 20006 {
+		t.Errorf("profiled gas mismatch: have %d, want 20006", profile.Gas)
+	}
+	if len(profile.Locations) != 4 || profile.Locations[0].Op != "SSTORE" || profile.Locations[0].Gas != 20000 {
+		t.Errorf("locations mismatch: have %+v", profile.Locations)
+	}
+	if profile.Folded == "" || len(profile.Pprof) == 0 {
+		t.Errorf("exported profiles missing: folded %q, pprof %d bytes", profile.Folded, len(profile.Pprof))
+	}
+}
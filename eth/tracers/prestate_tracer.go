@@ -0,0 +1,184 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// errNoPrestate is returned by the prestate tracer if the traced transaction did
+// not execute any EVM code, so there was no state to gather.
+var errNoPrestate = errors.New("no EVM execution to gather the prestate from")
+
+// prestateAccount is the prestate of a single account. The fields are ordered
+// the same way the JavaScript prestate tracer serializes them.
+type prestateAccount struct {
+	Balance string         `json:"balance"`
+	Nonce   int64          `json:"nonce"`
+	Code    string         `json:"code"`
+	Storage *orderedObject `json:"storage"`
+}
+
+// prestateTracer is a native Go implementation of the JavaScript prestateTracer.
+// It outputs sufficient information to create a local execution of the traced
+// transaction from a custom assembled genesis block.
+type prestateTracer struct {
+	interrupter
+
+	prestate *orderedObject // Genesis allocations that we're building
+	db       vm.StateDB     // State database of the last executed step
+
+	create bool
+	from   common.Address
+	to     common.Address
+	value  *big.Int
+}
+
+// newPrestateTracer creates a native prestate tracer.
+func newPrestateTracer() ResultTracer {
+	return new(prestateTracer)
+}
+
+// lookupAccount injects the specified account into the prestate object.
+func (t *prestateTracer) lookupAccount(addr common.Address) {
+	acc := hexutil.Encode(addr.Bytes())
+	if _, ok := t.prestate.get(acc); !ok {
+		t.prestate.set(acc, &prestateAccount{
+			Balance: hexBig(t.db.GetBalance(addr)),
+			Nonce:   int64(t.db.GetNonce(addr)),
+			Code:    hexutil.Encode(t.db.GetCode(addr)),
+			Storage: newOrderedObject(),
+		})
+	}
+}
+
+// lookupStorage injects the specified storage entry of the given account into
+// the prestate object.
+func (t *prestateTracer) lookupStorage(addr common.Address, key common.Hash) {
+	t.lookupAccount(addr)
+
+	acc, _ := t.prestate.get(hexutil.Encode(addr.Bytes()))
+	storage := acc.(*prestateAccount).Storage
+
+	idx := hexutil.Encode(key.Bytes())
+	if _, ok := storage.get(idx); !ok {
+		if val := t.db.GetState(addr, key); val != (common.Hash{}) {
+			storage.set(idx, hexutil.Encode(val.Bytes()))
+		}
+	}
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (t *prestateTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	t.create, t.from, t.to, t.value = create, from, to, value
+	return nil
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (t *prestateTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if t.interrupted() {
+		return nil
+	}
+	t.db = env.StateDB
+
+	// Add the current account if we just started tracing
+	if t.prestate == nil {
+		t.prestate = newOrderedObject()
+
+		// Balance will potentially be wrong here, since this will include the value
+		// sent along with the message. We fix that in GetResult.
+		t.lookupAccount(contract.Address())
+	}
+	// Whenever new state is accessed, add it to the prestate
+	switch op {
+	case vm.EXTCODECOPY, vm.EXTCODESIZE, vm.EXTCODEHASH, vm.BALANCE:
+		t.lookupAccount(common.BigToAddress(peekStack(stack, 0)))
+	case vm.CREATE:
+		from := contract.Address()
+		t.lookupAccount(crypto.CreateAddress(from, t.db.GetNonce(from)))
+	case vm.CREATE2:
+		inOff := peekUint64(stack, 1)
+		code := sliceMemory(memory, inOff, inOff+peekUint64(stack, 2))
+		t.lookupAccount(crypto.CreateAddress2(contract.Address(), common.BigToHash(peekStack(stack, 3)), crypto.Keccak256(code)))
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		t.lookupAccount(common.BigToAddress(peekStack(stack, 1)))
+	case vm.SSTORE, vm.SLOAD:
+		t.lookupStorage(contract.Address(), common.BigToHash(peekStack(stack, 0)))
+	}
+	return nil
+}
+
+// CaptureFault implements the Tracer interface to trace an execution fault
+// while running an opcode.
+func (t *prestateTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *prestateTracer) CaptureEnd(output []byte, gasUsed uint64, elapsed time.Duration, err error) error {
+	return nil
+}
+
+// GetResult returns the JSON encoded prestate allocations of the traced
+// transaction, along with any error that occurred during tracing.
+func (t *prestateTracer) GetResult() (json.RawMessage, error) {
+	if t.prestate == nil {
+		if t.err != nil {
+			return nil, t.err
+		}
+		return nil, errNoPrestate
+	}
+	// At this point, we need to deduct the 'value' from the outer transaction,
+	// and move it back to the origin
+	t.lookupAccount(t.from)
+	t.lookupAccount(t.to)
+
+	value := t.value
+	if value == nil {
+		value = new(big.Int)
+	}
+	fromAcc, _ := t.prestate.get(hexutil.Encode(t.from.Bytes()))
+	toAcc, _ := t.prestate.get(hexutil.Encode(t.to.Bytes()))
+
+	from, to := fromAcc.(*prestateAccount), toAcc.(*prestateAccount)
+	fromBal, _ := new(big.Int).SetString(from.Balance[2:], 16)
+	toBal, _ := new(big.Int).SetString(to.Balance[2:], 16)
+
+	to.Balance = hexBig(toBal.Sub(toBal, value))
+	from.Balance = hexBig(fromBal.Add(fromBal, value))
+
+	// Decrement the caller's nonce, and remove empty create targets
+	from.Nonce--
+	if t.create {
+		// We can blindly delete the contract prestate, as any existing state would
+		// have caused the transaction to be rejected as invalid in the first place.
+		t.prestate.delete(hexutil.Encode(t.to.Bytes()))
+	}
+	blob, err := encodeJSON(t.prestate)
+	if err != nil {
+		return nil, err
+	}
+	return blob, t.err
+}
@@ -0,0 +1,203 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package profiler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Field numbers of the pprof profile.proto messages used by the encoder.
+const (
+	profileSampleType  = 1
+	profileSample      = 2
+	profileLocation    = 4
+	profileFunction    = 5
+	profileStringTable = 6
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	locationID      = 1
+	locationAddress = 3
+	locationLine    = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+)
+
+// protoBuffer is a minimal protocol buffer encoder, sufficient to produce pprof
+// profiles without depending on the generated profile bindings.
+type protoBuffer struct {
+	bytes.Buffer
+	scratch [binary.MaxVarintLen64]byte
+}
+
+// varint appends a raw varint to the buffer.
+func (b *protoBuffer) varint(x uint64) {
+	n := binary.PutUvarint(b.scratch[:], x)
+	b.Write(b.scratch[:n])
+}
+
+// uint64 appends a varint field, omitting it if zero as proto3 does.
+func (b *protoBuffer) uint64(tag int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.varint(uint64(tag)<<3 | 0)
+	b.varint(x)
+}
+
+// bytes appends a length delimited field.
+func (b *protoBuffer) bytes(tag int, data []byte) {
+	b.varint(uint64(tag)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.Write(data)
+}
+
+// packed appends a packed repeated varint field.
+func (b *protoBuffer) packed(tag int, xs []uint64) {
+	inner := new(protoBuffer)
+	for _, x := range xs {
+		inner.varint(x)
+	}
+	b.bytes(tag, inner.Bytes())
+}
+
+// message appends an embedded message field, encoded by the given function.
+func (b *protoBuffer) message(tag int, encode func(*protoBuffer)) {
+	inner := new(protoBuffer)
+	encode(inner)
+	b.bytes(tag, inner.Bytes())
+}
+
+// WritePprof exports the gas used per call stack as a gzipped pprof protobuf
+// profile with two sample values: gas used and execution count. Every contract
+// is represented by a function named after its address, and every instruction
+// by a location at its program counter, with the source line attached if known.
+func (p *Profiler) WritePprof(w io.Writer) error {
+	var (
+		buf   = new(protoBuffer)
+		index = map[string]uint64{"": 0}
+		table = []string{""}
+	)
+	intern := func(s string) uint64 {
+		if id, ok := index[s]; ok {
+			return id
+		}
+		index[s] = uint64(len(table))
+		table = append(table, s)
+		return index[s]
+	}
+	// Declare the sample value types
+	for _, typ := range []string{"gas", "count"} {
+		buf.message(profileSampleType, func(b *protoBuffer) {
+			b.uint64(valueTypeType, intern(typ))
+			b.uint64(valueTypeUnit, intern(typ))
+		})
+	}
+	// Assign ids to all the locations in a deterministic order
+	locs := make([]Location, 0, len(p.locations))
+	for loc := range p.locations {
+		locs = append(locs, loc)
+	}
+	sort.Slice(locs, func(i, j int) bool { return lessLocation(locs[i], locs[j]) })
+
+	var (
+		locIDs  = make(map[Location]uint64)
+		funcIDs = make(map[common.Address]uint64)
+		funcs   []common.Address
+	)
+	for i, loc := range locs {
+		locIDs[loc] = uint64(i + 1)
+		if _, ok := funcIDs[loc.Address]; !ok {
+			funcIDs[loc.Address] = uint64(len(funcs) + 1)
+			funcs = append(funcs, loc.Address)
+		}
+	}
+	// Encode the samples, one per call stack with the leaf location first
+	keys := make([]string, 0, len(p.stacks))
+	for key := range p.stacks {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		stack := p.stacks[key]
+		ids := make([]uint64, len(stack.locations))
+		for i, loc := range stack.locations {
+			id, ok := locIDs[loc]
+			if !ok {
+				// Call sites are settled after their callees, so they may not be
+				// known yet if the profile is exported mid-execution
+				id = uint64(len(locs) + 1)
+				locIDs[loc] = id
+				locs = append(locs, loc)
+				if _, ok := funcIDs[loc.Address]; !ok {
+					funcIDs[loc.Address] = uint64(len(funcs) + 1)
+					funcs = append(funcs, loc.Address)
+				}
+			}
+			ids[len(ids)-1-i] = id
+		}
+		buf.message(profileSample, func(b *protoBuffer) {
+			b.packed(sampleLocationID, ids)
+			b.packed(sampleValue, []uint64{stack.Gas, stack.Count})
+		})
+	}
+	// Encode the locations and the functions they belong to
+	for _, loc := range locs {
+		loc := loc
+		buf.message(profileLocation, func(b *protoBuffer) {
+			b.uint64(locationID, locIDs[loc])
+			b.uint64(locationAddress, loc.PC)
+			b.message(locationLine, func(b *protoBuffer) {
+				b.uint64(lineFunctionID, funcIDs[loc.Address])
+				b.uint64(lineLine, uint64(p.lines[loc]))
+			})
+		})
+	}
+	for i, addr := range funcs {
+		name := intern(addr.Hex())
+		buf.message(profileFunction, func(b *protoBuffer) {
+			b.uint64(functionID, uint64(i+1))
+			b.uint64(functionName, name)
+			b.uint64(functionSystemName, name)
+		})
+	}
+	for _, s := range table {
+		buf.bytes(profileStringTable, []byte(s))
+	}
+	// Compress the profile the same way the Go runtime does
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return zw.Close()
+}
@@ -0,0 +1,344 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package profiler implements an EVM tracer aggregating the gas spent by the
+// executed code per instruction and per call stack.
+package profiler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/compiler"
+	"github.com/ethereum/go-ethereum/core/asm"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// Location is a single instruction of a contract.
+type Location struct {
+	Address common.Address `json:"address"` // Address of the contract the code belongs to
+	PC      uint64         `json:"pc"`      // Program counter of the instruction
+}
+
+// Stat is the gas spent and the number of executions aggregated for a location
+// or a call stack.
+type Stat struct {
+	Gas   uint64 `json:"gas"`
+	Count uint64 `json:"count"`
+}
+
+// LocationStat is the aggregated cost of a single instruction.
+type LocationStat struct {
+	Location
+	Stat
+	Op   string `json:"op"`             // Name of the opcode at the location
+	Line int    `json:"line,omitempty"` // Source line of the instruction, if known
+}
+
+// stackStat is the aggregated cost of a call stack.
+type stackStat struct {
+	Stat
+	locations []Location // Call sites of the stack, outermost first, the last being the leaf
+}
+
+// frame is an active call frame of the execution.
+type frame struct {
+	address common.Address // Address of the contract whose code is executed
+	lines   map[uint64]int // Source line of each program counter, nil if unknown
+
+	pending  bool      // Whether an instruction is executing in the frame
+	pc       uint64    // Program counter of the executing instruction
+	op       vm.OpCode // Opcode of the executing instruction
+	gas      uint64    // Gas available before the executing instruction
+	cost     uint64    // Cost of the executing instruction if the frame ends on it
+	children uint64    // Gas used by the calls made by the executing instruction
+	used     uint64    // Gas used by the frame so far, including nested calls
+}
+
+// sourceKey identifies the source mapping of a contract's code.
+type sourceKey struct {
+	address common.Address
+	create  bool
+}
+
+// Profiler is a vm.Tracer aggregating the gas spent by each executed instruction
+// and by each call stack. Gas is attributed to instructions exclusive of the
+// nested calls they make, so the costs of the individual locations add up to the
+// total gas used by the execution.
+//
+// The profile can be exported in the pprof protobuf format and as folded stacks
+// for flamegraph tools.
+type Profiler struct {
+	contracts map[common.Address]*compiler.Contract // Compiled contracts to map program counters to source lines
+	sources   map[sourceKey]map[uint64]int          // Cache of the parsed source mappings
+
+	frames    []*frame
+	locations map[Location]*Stat
+	ops       map[Location]vm.OpCode
+	lines     map[Location]int
+	stacks    map[string]*stackStat
+	total     uint64
+}
+
+// New creates a gas profiler. The optional compiled contracts, keyed by the
+// address their code is deployed at, are used to map program counters back to
+// source lines via the solc source mappings.
+func New(contracts map[common.Address]*compiler.Contract) *Profiler {
+	return &Profiler{
+		contracts: contracts,
+		sources:   make(map[sourceKey]map[uint64]int),
+		locations: make(map[Location]*Stat),
+		ops:       make(map[Location]vm.OpCode),
+		lines:     make(map[Location]int),
+		stacks:    make(map[string]*stackStat),
+	}
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (p *Profiler) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM
+// execution. The gas used by an instruction is the difference between the gas
+// available before it and before the next instruction of the same frame.
+func (p *Profiler) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	// Settle any frames that returned since the last step and enter new ones
+	for len(p.frames) > depth {
+		p.exit()
+	}
+	if len(p.frames) < depth {
+		p.enter(contract)
+	}
+	// Settle the previous instruction of the frame and start tracking this one
+	f := p.frames[len(p.frames)-1]
+	if f.pending {
+		p.settle(f, f.gas-gas)
+	}
+	f.pending, f.pc, f.op, f.gas, f.cost = true, pc, op, gas, cost
+
+	// An instruction failing before execution consumes all the remaining gas
+	if err != nil {
+		f.cost = gas
+	}
+	return nil
+}
+
+// CaptureFault implements the Tracer interface. Failing instructions are already
+// accounted for by CaptureState.
+func (p *Profiler) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnd is called after the call finishes to settle the remaining frames.
+func (p *Profiler) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error {
+	for len(p.frames) > 0 {
+		p.exit()
+	}
+	return nil
+}
+
+// enter pushes a new call frame for the contract onto the call stack.
+func (p *Profiler) enter(contract *vm.Contract) {
+	address := contract.Address()
+	if contract.CodeAddr != nil {
+		address = *contract.CodeAddr
+	}
+	p.frames = append(p.frames, &frame{
+		address: address,
+		lines:   p.sourceLines(address, contract.Code),
+	})
+}
+
+// exit settles the last instruction of the innermost frame and pops it off the
+// call stack, charging the gas it used to the calling instruction.
+func (p *Profiler) exit() {
+	f := p.frames[len(p.frames)-1]
+	if f.pending {
+		p.settle(f, f.cost)
+	}
+	p.frames = p.frames[:len(p.frames)-1]
+
+	if len(p.frames) > 0 {
+		p.frames[len(p.frames)-1].children += f.used
+	}
+}
+
+// settle attributes the gas used by the executing instruction of a frame, less
+// the gas used by the calls it made, to its location and call stack.
+func (p *Profiler) settle(f *frame, used uint64) {
+	if used < f.children {
+		used = f.children
+	}
+	self := used - f.children
+	f.used += used
+	f.pending, f.children = false, 0
+
+	// Aggregate the cost by location
+	loc := Location{Address: f.address, PC: f.pc}
+	stat, ok := p.locations[loc]
+	if !ok {
+		stat = new(Stat)
+		p.locations[loc] = stat
+		p.ops[loc] = f.op
+		if line, ok := f.lines[f.pc]; ok {
+			p.lines[loc] = line
+		}
+	}
+	stat.Gas += self
+	stat.Count++
+
+	// Aggregate the cost by call stack, made up of the call sites of the outer
+	// frames and the instruction itself
+	var (
+		key       bytes.Buffer
+		locations = make([]Location, 0, len(p.frames))
+	)
+	for _, outer := range p.frames {
+		site := Location{Address: outer.address, PC: outer.pc}
+		locations = append(locations, site)
+
+		key.Write(site.Address[:])
+		fmt.Fprintf(&key, "%d;", site.PC)
+		if outer == f {
+			break
+		}
+	}
+	stack, ok := p.stacks[key.String()]
+	if !ok {
+		stack = &stackStat{locations: locations}
+		p.stacks[key.String()] = stack
+	}
+	stack.Gas += self
+	stack.Count++
+
+	p.total += self
+}
+
+// sourceLines maps the program counters of a contract's code to source lines if
+// the compiled contract is known and carries source mappings.
+func (p *Profiler) sourceLines(address common.Address, code []byte) map[uint64]int {
+	contract := p.contracts[address]
+	if contract == nil {
+		return nil
+	}
+	// Creation code runs with the constructor arguments appended
+	key := sourceKey{address: address}
+	if creation := common.FromHex(contract.Code); len(creation) > 0 && bytes.HasPrefix(code, creation) {
+		key.create = true
+	}
+	if lines, ok := p.sources[key]; ok {
+		return lines
+	}
+	srcmap := contract.Info.SrcMapRuntime
+	if key.create {
+		srcmap = contract.Info.SrcMap
+	}
+	ranges, err := compiler.ParseSourceMap(srcmap)
+	if err != nil {
+		ranges = nil
+	}
+	var (
+		source = contract.Info.Source
+		lines  = make(map[uint64]int)
+	)
+	it := asm.NewInstructionIterator(code)
+	for i := 0; it.Next() && i < len(ranges); i++ {
+		if r := ranges[i]; r.File >= 0 && r.Start >= 0 && r.Start <= len(source) {
+			lines[it.PC()] = 1 + strings.Count(source[:r.Start], "\n")
+		}
+	}
+	p.sources[key] = lines
+	return lines
+}
+
+// Total returns the gas used by all the profiled instructions.
+func (p *Profiler) Total() uint64 {
+	return p.total
+}
+
+// Locations returns the aggregated cost of every executed instruction, sorted by
+// decreasing gas usage.
+func (p *Profiler) Locations() []LocationStat {
+	stats := make([]LocationStat, 0, len(p.locations))
+	for loc, stat := range p.locations {
+		stats = append(stats, LocationStat{
+			Location: loc,
+			Stat:     *stat,
+			Op:       p.ops[loc].String(),
+			Line:     p.lines[loc],
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Gas != stats[j].Gas {
+			return stats[i].Gas > stats[j].Gas
+		}
+		return lessLocation(stats[i].Location, stats[j].Location)
+	})
+	return stats
+}
+
+// label returns the human readable name of a location used in the exported
+// profiles, referring to the source line if known or to the opcode otherwise.
+func (p *Profiler) label(loc Location) string {
+	if line, ok := p.lines[loc]; ok {
+		return fmt.Sprintf("%s:line%d", loc.Address.Hex(), line)
+	}
+	return fmt.Sprintf("%s:pc%d:%v", loc.Address.Hex(), loc.PC, p.ops[loc])
+}
+
+// WriteFolded exports the gas used per call stack in the folded stack format
+// consumed by flamegraph tools: one line per stack, with the frames separated by
+// semicolons and followed by the gas used.
+func (p *Profiler) WriteFolded(w io.Writer) error {
+	folded := make(map[string]uint64)
+	for _, stack := range p.stacks {
+		if stack.Gas == 0 {
+			continue
+		}
+		labels := make([]string, len(stack.locations))
+		for i, loc := range stack.locations {
+			labels[i] = p.label(loc)
+		}
+		folded[strings.Join(labels, ";")] += stack.Gas
+	}
+	lines := make([]string, 0, len(folded))
+	for stack := range folded {
+		lines = append(lines, stack)
+	}
+	sort.Strings(lines)
+
+	for _, stack := range lines {
+		if _, err := fmt.Fprintf(w, "%s %d\n", stack, folded[stack]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lessLocation orders locations by contract address and program counter.
+func lessLocation(a, b Location) bool {
+	if c := bytes.Compare(a.Address[:], b.Address[:]); c != 0 {
+		return c < 0
+	}
+	return a.PC < b.PC
+}
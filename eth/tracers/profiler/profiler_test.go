@@ -0,0 +1,156 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package profiler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/compiler"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/runtime"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+var (
+	callerAddr = common.HexToAddress("0xc0")
+	calleeAddr = common.HexToAddress("0xca")
+
+	// calleeCode stores 1 in slot 0: PUSH1 1 PUSH1 0 SSTORE STOP
+	calleeCode = common.Hex2Bytes("600160005500")
+
+	// calleeContract is the compiled form of the callee, each of its instructions
+	// mapped to a separate source line
+	calleeContract = &compiler.Contract{
+		Info: compiler.ContractInfo{
+			Source:        "a\nb\nc\nd",
+			SrcMapRuntime: "0:1:0:-;2:1;4:1;6:1",
+		},
+	}
+)
+
+// runProfiler executes the caller code, which may call into the callee, with a
+// gas profiler attached and returns it along with the gas used.
+func runProfiler(t *testing.T, code []byte) (*Profiler, uint64) {
+	db, _ := ethdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+	statedb.SetCode(callerAddr, code)
+	statedb.SetCode(calleeAddr, calleeCode)
+
+	profiler := New(map[common.Address]*compiler.Contract{calleeAddr: calleeContract})
+	cfg := &runtime.Config{
+		State:     statedb,
+		GasLimit:  1000000,
+		EVMConfig: vm.Config{Debug: true, Tracer: profiler},
+	}
+	_, left, err := runtime.Call(callerAddr, nil, cfg)
+	if err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+	return profiler, cfg.GasLimit - left
+}
+
+// stats returns the aggregated costs of the profiled locations, keyed by address and pc.
+func stats(p *Profiler) map[Location]LocationStat {
+	res := make(map[Location]LocationStat)
+	for _, stat := range p.Locations() {
+		res[stat.Location] = stat
+	}
+	return res
+}
+
+// Tests that gas is attributed to instructions exclusive of the calls they make,
+// adding up to the total gas used by the execution.
+func TestProfilerCalls(t *testing.T) {
+	// CALL(gas, 0xca, 0, 0, 0, 0, 0) POP STOP
+	profiler, used := runProfiler(t, common.Hex2Bytes("6000600060006000600060ca5af15000"))
+
+	if total := profiler.Total(); total != used {
+		t.Errorf("total gas mismatch: have %d, want %d", total, used)
+	}
+	locs := stats(profiler)
+	if call := locs[Location{callerAddr, 13}]; call.Op != "CALL" || call.Gas != 700 || call.Count != 1 {
+		t.Errorf("call cost mismatch: have %+v, want 700 gas", call)
+	}
+	sstore := locs[Location{calleeAddr, 4}]
+	if sstore.Op != "SSTORE" || sstore.Gas != 20000 {
+		t.Errorf("sstore cost mismatch: have %+v, want 20000 gas", sstore)
+	}
+	if sstore.Line != 3 {
+		t.Errorf("sstore source line mismatch: have %d, want 3", sstore.Line)
+	}
+	// Ensure the folded stacks nest the callee below the call site
+	folded := new(bytes.Buffer)
+	if err := profiler.WriteFolded(folded); err != nil {
+		t.Fatalf("failed to export folded stacks: %v", err)
+	}
+	want := fmt.Sprintf("%s:pc13:CALL;%s:line3 20000\n", callerAddr.Hex(), calleeAddr.Hex())
+	if !strings.Contains(folded.String(), want) {
+		t.Errorf("folded stacks missing %q:\n%s", want, folded)
+	}
+	var sum uint64
+	for _, line := range strings.Split(strings.TrimSpace(folded.String()), "\n") {
+		var gas uint64
+		fmt.Sscan(line[strings.LastIndex(line, " ")+1:], &gas)
+		sum += gas
+	}
+	if sum != used {
+		t.Errorf("folded stacks gas mismatch: have %d, want %d", sum, used)
+	}
+	// Ensure the pprof profile is a valid gzip stream containing the functions
+	blob := new(bytes.Buffer)
+	if err := profiler.WritePprof(blob); err != nil {
+		t.Fatalf("failed to export pprof profile: %v", err)
+	}
+	zr, err := gzip.NewReader(blob)
+	if err != nil {
+		t.Fatalf("pprof profile not gzipped: %v", err)
+	}
+	raw, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("failed to decompress pprof profile: %v", err)
+	}
+	for _, name := range []string{"gas", "count", callerAddr.Hex(), calleeAddr.Hex()} {
+		if !bytes.Contains(raw, []byte(name)) {
+			t.Errorf("pprof profile missing string %q", name)
+		}
+	}
+}
+
+// Tests that an instruction running out of gas is charged with all the gas left
+// in its frame.
+func TestProfilerOutOfGas(t *testing.T) {
+	// CALL(100, 0xca, 0, 0, 0, 0, 0) POP STOP
+	profiler, used := runProfiler(t, common.Hex2Bytes("6000600060006000600060ca6064f15000"))
+
+	if total := profiler.Total(); total != used {
+		t.Errorf("total gas mismatch: have %d, want %d", total, used)
+	}
+	locs := stats(profiler)
+	if sstore := locs[Location{calleeAddr, 4}]; sstore.Gas != 100-6 {
+		t.Errorf("failed sstore cost mismatch: have %+v, want %d gas", sstore, 100-6)
+	}
+	if call := locs[Location{callerAddr, 14}]; call.Gas != 700 {
+		t.Errorf("call cost mismatch: have %+v, want 700 gas", call)
+	}
+}
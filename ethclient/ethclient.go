@@ -346,6 +346,79 @@ func (ec *Client) NonceAt(ctx context.Context, account common.Address, blockNumb
 	return uint64(result), err
 }
 
+// AccountResult is the Merkle proof of an account and some of its storage
+// slots, as returned by GetProof.
+type AccountResult struct {
+	Address      common.Address
+	AccountProof [][]byte
+	Balance      *big.Int
+	CodeHash     common.Hash
+	Nonce        uint64
+	StorageHash  common.Hash
+	StorageProof []StorageResult
+}
+
+// StorageResult is the value and Merkle proof of a single storage slot.
+type StorageResult struct {
+	Key   common.Hash
+	Value *big.Int
+	Proof [][]byte
+}
+
+type rpcStorageResult struct {
+	Key   common.Hash     `json:"key"`
+	Value *hexutil.Big    `json:"value"`
+	Proof []hexutil.Bytes `json:"proof"`
+}
+
+type rpcAccountResult struct {
+	Address      common.Address     `json:"address"`
+	AccountProof []hexutil.Bytes    `json:"accountProof"`
+	Balance      *hexutil.Big       `json:"balance"`
+	CodeHash     common.Hash        `json:"codeHash"`
+	Nonce        hexutil.Uint64     `json:"nonce"`
+	StorageHash  common.Hash        `json:"storageHash"`
+	StorageProof []rpcStorageResult `json:"storageProof"`
+}
+
+// GetProof returns the Merkle proof of the given account and of the requested
+// storage keys within it. The block number can be nil, in which case the proof
+// is taken from the latest known block.
+func (ec *Client) GetProof(ctx context.Context, account common.Address, keys []common.Hash, blockNumber *big.Int) (*AccountResult, error) {
+	var res rpcAccountResult
+	if keys == nil {
+		keys = []common.Hash{}
+	}
+	if err := ec.c.CallContext(ctx, &res, "eth_getProof", account, keys, toBlockNumArg(blockNumber)); err != nil {
+		return nil, err
+	}
+	storage := make([]StorageResult, len(res.StorageProof))
+	for i, st := range res.StorageProof {
+		storage[i] = StorageResult{
+			Key:   st.Key,
+			Value: (*big.Int)(st.Value),
+			Proof: fromHexSlice(st.Proof),
+		}
+	}
+	return &AccountResult{
+		Address:      res.Address,
+		AccountProof: fromHexSlice(res.AccountProof),
+		Balance:      (*big.Int)(res.Balance),
+		CodeHash:     res.CodeHash,
+		Nonce:        uint64(res.Nonce),
+		StorageHash:  res.StorageHash,
+		StorageProof: storage,
+	}, nil
+}
+
+func fromHexSlice(h []hexutil.Bytes) [][]byte {
+	b := make([][]byte, len(h))
+	for i := range h {
+		b[i] = h[i]
+	}
+	return b
+}
+
 // Filters
 
 // FilterLogs executes a filter query.
@@ -441,6 +514,29 @@ func (ec *Client) PendingCallContract(ctx context.Context, msg ethereum.CallMsg)
 	return hex, nil
 }
 
+// OverrideAccount specifies the fields of an account to be overridden while
+// executing a contract call or estimating gas. Nil fields are left untouched.
+// State replaces the entire storage of the account, whereas StateDiff only
+// patches the given slots; the two can't be used together.
+type OverrideAccount struct {
+	Nonce     *uint64
+	Code      []byte
+	Balance   *big.Int
+	State     map[common.Hash]common.Hash
+	StateDiff map[common.Hash]common.Hash
+}
+
+// CallContractWithOverrides executes a message call transaction like CallContract,
+// but with the state of the given accounts overridden before execution.
+func (ec *Client) CallContractWithOverrides(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int, overrides map[common.Address]OverrideAccount) ([]byte, error) {
+	var hex hexutil.Bytes
+	err := ec.c.CallContext(ctx, &hex, "eth_call", toCallArg(msg), toBlockNumArg(blockNumber), toOverrideArg(overrides))
+	if err != nil {
+		return nil, err
+	}
+	return hex, nil
+}
+
 // SuggestGasPrice retrieves the currently suggested gas price to allow a timely
 // execution of a transaction.
 func (ec *Client) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
@@ -464,6 +560,17 @@ func (ec *Client) EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64
 	return uint64(hex), nil
 }
 
+// EstimateGasWithOverrides estimates the gas needed to execute a specific transaction
+// like EstimateGas, but with the state of the given accounts overridden first.
+func (ec *Client) EstimateGasWithOverrides(ctx context.Context, msg ethereum.CallMsg, overrides map[common.Address]OverrideAccount) (uint64, error) {
+	var hex hexutil.Uint64
+	err := ec.c.CallContext(ctx, &hex, "eth_estimateGas", toCallArg(msg), toOverrideArg(overrides))
+	if err != nil {
+		return 0, err
+	}
+	return uint64(hex), nil
+}
+
 // SendTransaction injects a signed transaction into the pending pool for execution.
 //
 // If the transaction was a contract creation use the TransactionReceipt method to get the
@@ -495,3 +602,27 @@ func toCallArg(msg ethereum.CallMsg) interface{} {
 	}
 	return arg
 }
+
+func toOverrideArg(overrides map[common.Address]OverrideAccount) interface{} {
+	arg := make(map[common.Address]interface{}, len(overrides))
+	for addr, account := range overrides {
+		override := make(map[string]interface{})
+		if account.Nonce != nil {
+			override["nonce"] = hexutil.Uint64(*account.Nonce)
+		}
+		if account.Code != nil {
+			override["code"] = hexutil.Bytes(account.Code)
+		}
+		if account.Balance != nil {
+			override["balance"] = (*hexutil.Big)(account.Balance)
+		}
+		if account.State != nil {
+			override["state"] = account.State
+		}
+		if account.StateDiff != nil {
+			override["stateDiff"] = account.StateDiff
+		}
+		arg[addr] = override
+	}
+	return arg
+}
@@ -17,6 +17,7 @@
 package ethdb
 
 import (
+	"bytes"
 	"strconv"
 	"strings"
 	"sync"
@@ -27,7 +28,6 @@ import (
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/filter"
-	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/util"
 )
@@ -118,15 +118,42 @@ func (db *LDBDatabase) Delete(key []byte) error {
 	return db.db.Delete(key, nil)
 }
 
-func (db *LDBDatabase) NewIterator() iterator.Iterator {
+// NewIterator returns an iterator over the entire database content.
+func (db *LDBDatabase) NewIterator() Iterator {
 	return db.db.NewIterator(nil, nil)
 }
 
+// NewIteratorWithStart returns an iterator over the database content starting
+// at a particular initial key (or after, if it does not exist).
+func (db *LDBDatabase) NewIteratorWithStart(start []byte) Iterator {
+	return db.db.NewIterator(&util.Range{Start: start}, nil)
+}
+
 // NewIteratorWithPrefix returns a iterator to iterate over subset of database content with a particular prefix.
-func (db *LDBDatabase) NewIteratorWithPrefix(prefix []byte) iterator.Iterator {
+func (db *LDBDatabase) NewIteratorWithPrefix(prefix []byte) Iterator {
 	return db.db.NewIterator(util.BytesPrefix(prefix), nil)
 }
 
+// Stat returns a particular internal stat of the database.
+func (db *LDBDatabase) Stat(property string) (string, error) {
+	return db.db.GetProperty(property)
+}
+
+// Compact flattens the underlying data store for the given key range. A nil
+// start or limit is treated as a key before or after all keys respectively.
+func (db *LDBDatabase) Compact(start []byte, limit []byte) error {
+	return db.db.CompactRange(util.Range{Start: start, Limit: limit})
+}
+
+// NewSnapshot creates a point-in-time, read-only view of the database.
+func (db *LDBDatabase) NewSnapshot() (Snapshot, error) {
+	snap, err := db.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &ldbSnapshot{snap: snap}, nil
+}
+
 func (db *LDBDatabase) Close() {
 	// Stop the metrics collection to avoid internal database races
 	db.quitLock.Lock()
@@ -311,6 +338,12 @@ func (b *ldbBatch) Put(key, value []byte) error {
 	return nil
 }
 
+func (b *ldbBatch) Delete(key []byte) error {
+	b.b.Delete(key)
+	b.size += 1
+	return nil
+}
+
 func (b *ldbBatch) Write() error {
 	return b.db.Write(b.b, nil)
 }
@@ -324,6 +357,22 @@ func (b *ldbBatch) Reset() {
 	b.size = 0
 }
 
+type ldbSnapshot struct {
+	snap *leveldb.Snapshot
+}
+
+func (s *ldbSnapshot) Get(key []byte) ([]byte, error) {
+	return s.snap.Get(key, nil)
+}
+
+func (s *ldbSnapshot) Has(key []byte) (bool, error) {
+	return s.snap.Has(key, nil)
+}
+
+func (s *ldbSnapshot) Release() {
+	s.snap.Release()
+}
+
 type table struct {
 	db     Database
 	prefix string
@@ -358,6 +407,94 @@ func (dt *table) Close() {
 	// Do nothing; don't close the underlying DB.
 }
 
+func (dt *table) NewIterator() Iterator {
+	return dt.NewIteratorWithPrefix(nil)
+}
+
+func (dt *table) NewIteratorWithStart(start []byte) Iterator {
+	return &tableIterator{
+		it:     dt.db.NewIteratorWithStart(append([]byte(dt.prefix), start...)),
+		prefix: []byte(dt.prefix),
+	}
+}
+
+func (dt *table) NewIteratorWithPrefix(prefix []byte) Iterator {
+	return &tableIterator{
+		it:     dt.db.NewIteratorWithPrefix(append([]byte(dt.prefix), prefix...)),
+		prefix: []byte(dt.prefix),
+	}
+}
+
+func (dt *table) Stat(property string) (string, error) {
+	return dt.db.Stat(property)
+}
+
+func (dt *table) Compact(start []byte, limit []byte) error {
+	// Limit the compaction to the table's own key space if no explicit limit was given
+	if limit == nil {
+		limit = util.BytesPrefix([]byte(dt.prefix)).Limit
+	} else {
+		limit = append([]byte(dt.prefix), limit...)
+	}
+	return dt.db.Compact(append([]byte(dt.prefix), start...), limit)
+}
+
+func (dt *table) NewSnapshot() (Snapshot, error) {
+	snap, err := dt.db.NewSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &tableSnapshot{snap: snap, prefix: dt.prefix}, nil
+}
+
+// tableIterator wraps a database iterator, stripping the table prefix from the
+// returned keys and stopping once the iteration leaves the table's key space.
+type tableIterator struct {
+	it     Iterator
+	prefix []byte
+}
+
+func (it *tableIterator) Next() bool {
+	return it.it.Next() && bytes.HasPrefix(it.it.Key(), it.prefix)
+}
+
+func (it *tableIterator) Error() error {
+	return it.it.Error()
+}
+
+func (it *tableIterator) Key() []byte {
+	key := it.it.Key()
+	if !bytes.HasPrefix(key, it.prefix) {
+		return nil
+	}
+	return key[len(it.prefix):]
+}
+
+func (it *tableIterator) Value() []byte {
+	return it.it.Value()
+}
+
+func (it *tableIterator) Release() {
+	it.it.Release()
+}
+
+type tableSnapshot struct {
+	snap   Snapshot
+	prefix string
+}
+
+func (s *tableSnapshot) Get(key []byte) ([]byte, error) {
+	return s.snap.Get(append([]byte(s.prefix), key...))
+}
+
+func (s *tableSnapshot) Has(key []byte) (bool, error) {
+	return s.snap.Has(append([]byte(s.prefix), key...))
+}
+
+func (s *tableSnapshot) Release() {
+	s.snap.Release()
+}
+
 type tableBatch struct {
 	batch  Batch
 	prefix string
@@ -376,6 +513,10 @@ func (tb *tableBatch) Put(key, value []byte) error {
 	return tb.batch.Put(append([]byte(tb.prefix), key...), value)
 }
 
+func (tb *tableBatch) Delete(key []byte) error {
+	return tb.batch.Delete(append([]byte(tb.prefix), key...))
+}
+
 func (tb *tableBatch) Write() error {
 	return tb.batch.Write()
 }
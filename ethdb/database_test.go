@@ -190,3 +190,96 @@ func testParallelPutGet(db ethdb.Database, t *testing.T) {
 	}
 	pending.Wait()
 }
+
+func TestLDB_Iterator(t *testing.T) {
+	db, remove := newTestLDB()
+	defer remove()
+	testIterator(db, t)
+}
+
+func TestMemoryDB_Iterator(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	testIterator(db, t)
+}
+
+func TestTable_Iterator(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	db.Put([]byte("a"), []byte("outside"))
+	db.Put([]byte("u"), []byte("outside"))
+	testIterator(ethdb.NewTable(db, "t-"), t)
+}
+
+func testIterator(db ethdb.Database, t *testing.T) {
+	keys := []string{"1", "2", "3", "5", "6", "a1", "a2", "b1"}
+	for _, k := range keys {
+		if err := db.Put([]byte(k), []byte("v"+k)); err != nil {
+			t.Fatalf("put failed: %v", err)
+		}
+	}
+	tests := []struct {
+		it   ethdb.Iterator
+		want []string
+	}{
+		{db.NewIterator(), keys},
+		{db.NewIteratorWithStart([]byte("4")), []string{"5", "6", "a1", "a2", "b1"}},
+		{db.NewIteratorWithStart([]byte("a1")), []string{"a1", "a2", "b1"}},
+		{db.NewIteratorWithPrefix([]byte("a")), []string{"a1", "a2"}},
+		{db.NewIteratorWithPrefix([]byte("c")), nil},
+	}
+	for i, tt := range tests {
+		var have []string
+		for tt.it.Next() {
+			if want := "v" + string(tt.it.Key()); string(tt.it.Value()) != want {
+				t.Errorf("test %d: value mismatch for key %q: have %q, want %q", i, tt.it.Key(), tt.it.Value(), want)
+			}
+			have = append(have, string(tt.it.Key()))
+		}
+		if err := tt.it.Error(); err != nil {
+			t.Errorf("test %d: iteration failed: %v", i, err)
+		}
+		tt.it.Release()
+
+		if fmt.Sprint(have) != fmt.Sprint(tt.want) {
+			t.Errorf("test %d: key mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
+
+func TestLDB_Snapshot(t *testing.T) {
+	db, remove := newTestLDB()
+	defer remove()
+	testSnapshot(db, t)
+}
+
+func TestMemoryDB_Snapshot(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	testSnapshot(db, t)
+}
+
+func TestTable_Snapshot(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	testSnapshot(ethdb.NewTable(db, "t-"), t)
+}
+
+func testSnapshot(db ethdb.Database, t *testing.T) {
+	db.Put([]byte("old"), []byte("1"))
+
+	snap, err := db.NewSnapshot()
+	if err != nil {
+		t.Fatalf("failed to create snapshot: %v", err)
+	}
+	defer snap.Release()
+
+	db.Put([]byte("old"), []byte("2"))
+	db.Put([]byte("new"), []byte("2"))
+
+	if data, err := snap.Get([]byte("old")); err != nil || !bytes.Equal(data, []byte("1")) {
+		t.Errorf("snapshot value mismatch: have %q (%v), want %q", data, err, "1")
+	}
+	if has, _ := snap.Has([]byte("new")); has {
+		t.Errorf("snapshot contains item written after its creation")
+	}
+	if data, _ := db.Get([]byte("old")); !bytes.Equal(data, []byte("2")) {
+		t.Errorf("database value mismatch: have %q, want %q", data, "2")
+	}
+}
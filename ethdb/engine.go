@@ -0,0 +1,92 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethdb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Names of the built in database engines.
+const (
+	EngineLevelDB = "leveldb" // Persistent LevelDB store
+	EngineMemory  = "memory"  // Non-persistent in-memory store
+)
+
+// Constructor opens a database of a particular engine at the given path, using
+// the given amount of memory in megabytes for caching and the given number of
+// file handles. Engines may ignore any of the parameters they have no use for.
+type Constructor func(path string, cache, handles int) (Database, error)
+
+var (
+	enginesLock sync.RWMutex
+	engines     = make(map[string]Constructor)
+)
+
+func init() {
+	Register(EngineLevelDB, func(path string, cache, handles int) (Database, error) {
+		return NewLDBDatabase(path, cache, handles)
+	})
+	Register(EngineMemory, func(string, int, int) (Database, error) {
+		return NewMemDatabase()
+	})
+}
+
+// Register makes a database engine available by the given name. It is meant to
+// be called from the init function of the package implementing the engine, so
+// that alternative key-value stores can be plugged in without modifying the
+// code opening the databases. Registering a name twice panics.
+func Register(name string, constructor Constructor) {
+	enginesLock.Lock()
+	defer enginesLock.Unlock()
+
+	if constructor == nil {
+		panic("ethdb: nil constructor for engine " + name)
+	}
+	if _, ok := engines[name]; ok {
+		panic("ethdb: engine " + name + " registered twice")
+	}
+	engines[name] = constructor
+}
+
+// Engines returns the sorted names of all the registered database engines.
+func Engines() []string {
+	enginesLock.RLock()
+	defer enginesLock.RUnlock()
+
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open opens a database at the given path using the registered engine of the
+// given name.
+func Open(engine string, path string, cache, handles int) (Database, error) {
+	enginesLock.RLock()
+	constructor, ok := engines[engine]
+	enginesLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown database engine %q (available: %s)", engine, strings.Join(Engines(), ", "))
+	}
+	return constructor(path, cache, handles)
+}
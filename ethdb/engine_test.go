@@ -0,0 +1,57 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethdb_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// Tests that database engines registered by name can be opened without any
+// changes to the code opening the databases.
+func TestRegisterEngine(t *testing.T) {
+	var opened string
+	ethdb.Register("testengine", func(path string, cache, handles int) (ethdb.Database, error) {
+		opened = path
+		return ethdb.NewMemDatabase()
+	})
+	if have, want := ethdb.Engines(), []string{ethdb.EngineLevelDB, ethdb.EngineMemory, "testengine"}; !reflect.DeepEqual(have, want) {
+		t.Fatalf("registered engines mismatch: have %v, want %v", have, want)
+	}
+	db, err := ethdb.Open("testengine", "/path/to/db", 16, 16)
+	if err != nil {
+		t.Fatalf("failed to open registered engine: %v", err)
+	}
+	db.Close()
+	if opened != "/path/to/db" {
+		t.Errorf("engine opened path mismatch: have %q, want %q", opened, "/path/to/db")
+	}
+	// Unknown engines should be rejected, listing the available ones
+	if _, err := ethdb.Open("nosuchengine", "", 0, 0); err == nil || !strings.Contains(err.Error(), "testengine") {
+		t.Errorf("unknown engine error mismatch: have %v, want available engines listed", err)
+	}
+	// Registering a name twice should panic
+	defer func() {
+		if recover() == nil {
+			t.Errorf("duplicate engine registration didn't panic")
+		}
+	}()
+	ethdb.Register("testengine", func(string, int, int) (ethdb.Database, error) { return nil, nil })
+}
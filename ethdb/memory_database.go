@@ -18,6 +18,8 @@ package ethdb
 
 import (
 	"errors"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -90,13 +92,80 @@ func (db *MemDatabase) Delete(key []byte) error {
 
 func (db *MemDatabase) Close() {}
 
+// NewIterator returns an iterator over the entire database content.
+func (db *MemDatabase) NewIterator() Iterator {
+	return db.newIterator(nil, nil)
+}
+
+// NewIteratorWithStart returns an iterator over the database content starting
+// at a particular initial key (or after, if it does not exist).
+func (db *MemDatabase) NewIteratorWithStart(start []byte) Iterator {
+	return db.newIterator(start, nil)
+}
+
+// NewIteratorWithPrefix returns an iterator over the subset of database content
+// with a particular key prefix.
+func (db *MemDatabase) NewIteratorWithPrefix(prefix []byte) Iterator {
+	return db.newIterator(prefix, prefix)
+}
+
+// newIterator collects and sorts all the keys starting from start and having
+// the given prefix, capturing their values at the time of creation.
+func (db *MemDatabase) newIterator(start []byte, prefix []byte) Iterator {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	var (
+		pr     = string(prefix)
+		st     = string(start)
+		keys   = make([]string, 0, len(db.db))
+		values = make([][]byte, 0, len(db.db))
+	)
+	for key := range db.db {
+		if strings.HasPrefix(key, pr) && key >= st {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values = append(values, db.db[key])
+	}
+	return &memIterator{keys: keys, values: values, index: -1}
+}
+
+// Stat returns a particular internal stat of the database. The memory database
+// doesn't maintain any internal statistics.
+func (db *MemDatabase) Stat(property string) (string, error) {
+	return "", errors.New("unknown property")
+}
+
+// Compact is not supported on a memory database, it's a noop.
+func (db *MemDatabase) Compact(start []byte, limit []byte) error {
+	return nil
+}
+
+// NewSnapshot creates a point-in-time copy of the current database content.
+func (db *MemDatabase) NewSnapshot() (Snapshot, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	snap := &MemDatabase{db: make(map[string][]byte, len(db.db))}
+	for key, value := range db.db {
+		snap.db[key] = value
+	}
+	return &memSnapshot{snap}, nil
+}
+
 func (db *MemDatabase) NewBatch() Batch {
 	return &memBatch{db: db}
 }
 
 func (db *MemDatabase) Len() int { return len(db.db) }
 
-type kv struct{ k, v []byte }
+type kv struct {
+	k, v []byte
+	del  bool
+}
 
 type memBatch struct {
 	db     *MemDatabase
@@ -105,16 +174,26 @@ type memBatch struct {
 }
 
 func (b *memBatch) Put(key, value []byte) error {
-	b.writes = append(b.writes, kv{common.CopyBytes(key), common.CopyBytes(value)})
+	b.writes = append(b.writes, kv{common.CopyBytes(key), common.CopyBytes(value), false})
 	b.size += len(value)
 	return nil
 }
 
+func (b *memBatch) Delete(key []byte) error {
+	b.writes = append(b.writes, kv{common.CopyBytes(key), nil, true})
+	b.size += 1
+	return nil
+}
+
 func (b *memBatch) Write() error {
 	b.db.lock.Lock()
 	defer b.db.lock.Unlock()
 
 	for _, kv := range b.writes {
+		if kv.del {
+			delete(b.db.db, string(kv.k))
+			continue
+		}
 		b.db.db[string(kv.k)] = kv.v
 	}
 	return nil
@@ -128,3 +207,56 @@ func (b *memBatch) Reset() {
 	b.writes = b.writes[:0]
 	b.size = 0
 }
+
+// memIterator iterates over a sorted copy of the keys and values of a memory
+// database taken at the time of creation.
+type memIterator struct {
+	keys   []string
+	values [][]byte
+	index  int
+}
+
+func (it *memIterator) Next() bool {
+	if it.index >= len(it.keys) {
+		return false
+	}
+	it.index++
+	return it.index < len(it.keys)
+}
+
+func (it *memIterator) Error() error {
+	return nil
+}
+
+func (it *memIterator) Key() []byte {
+	if it.index < 0 || it.index >= len(it.keys) {
+		return nil
+	}
+	return []byte(it.keys[it.index])
+}
+
+func (it *memIterator) Value() []byte {
+	if it.index < 0 || it.index >= len(it.keys) {
+		return nil
+	}
+	return it.values[it.index]
+}
+
+func (it *memIterator) Release() {
+	it.keys, it.values = nil, nil
+}
+
+// memSnapshot is a frozen copy of a memory database.
+type memSnapshot struct {
+	db *MemDatabase
+}
+
+func (s *memSnapshot) Get(key []byte) ([]byte, error) {
+	return s.db.Get(key)
+}
+
+func (s *memSnapshot) Has(key []byte) (bool, error) {
+	return s.db.Has(key)
+}
+
+func (s *memSnapshot) Release() {}
@@ -0,0 +1,145 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package prometheus
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+var (
+	typeGaugeTpl           = "# TYPE %s gauge\n"
+	typeCounterTpl         = "# TYPE %s counter\n"
+	typeSummaryTpl         = "# TYPE %s summary\n"
+	keyValueTpl            = "%s %v\n\n"
+	keyQuantileTagValueTpl = "%s{quantile=\"%s\"} %v\n"
+)
+
+var (
+	// histogramQuantiles are the quantiles reported for histograms and timers.
+	histogramQuantiles = []float64{0.5, 0.75, 0.95, 0.99, 0.999, 0.9999}
+
+	// resettingQuantiles are the percentiles reported for resetting timers. Note,
+	// resetting timers expect them on a 0-100 scale instead of 0-1.
+	resettingQuantiles = []float64{50, 95, 99}
+)
+
+// collector is a collection of byte buffers that aggregate Prometheus reports
+// for different metric types.
+type collector struct {
+	buff *bytes.Buffer
+}
+
+// newCollector creates a new Prometheus metric aggregator.
+func newCollector() *collector {
+	return &collector{
+		buff: &bytes.Buffer{},
+	}
+}
+
+func (c *collector) addCounter(name string, m metrics.Counter) {
+	c.writeGaugeCounter(name, m.Count())
+}
+
+func (c *collector) addGauge(name string, m metrics.Gauge) {
+	c.writeGaugeCounter(name, m.Value())
+}
+
+func (c *collector) addGaugeFloat64(name string, m metrics.GaugeFloat64) {
+	c.writeGaugeCounter(name, m.Value())
+}
+
+func (c *collector) addMeter(name string, m metrics.Meter) {
+	c.writeCounter(name, m.Count())
+}
+
+func (c *collector) addHistogram(name string, m metrics.Histogram) {
+	ps := m.Percentiles(histogramQuantiles)
+	c.writeSummaryCounter(name, m.Count())
+	c.writeSummarySum(name, m.Sum())
+	for i := range histogramQuantiles {
+		c.writeSummaryPercentile(name, strconv.FormatFloat(histogramQuantiles[i], 'f', -1, 64), ps[i])
+	}
+	c.buff.WriteRune('\n')
+}
+
+func (c *collector) addTimer(name string, m metrics.Timer) {
+	ps := m.Percentiles(histogramQuantiles)
+	c.writeSummaryCounter(name, m.Count())
+	c.writeSummarySum(name, m.Sum())
+	for i := range histogramQuantiles {
+		c.writeSummaryPercentile(name, strconv.FormatFloat(histogramQuantiles[i], 'f', -1, 64), ps[i])
+	}
+	c.buff.WriteRune('\n')
+}
+
+func (c *collector) addResettingTimer(name string, m metrics.ResettingTimer) {
+	if len(m.Values()) <= 0 {
+		return
+	}
+	ps := m.Percentiles(resettingQuantiles)
+	c.writeSummaryCounter(name, len(m.Values()))
+	for i := range resettingQuantiles {
+		c.writeSummaryPercentile(name, strconv.FormatFloat(resettingQuantiles[i]/100, 'f', -1, 64), ps[i])
+	}
+	c.buff.WriteRune('\n')
+}
+
+func (c *collector) writeGaugeCounter(name string, value interface{}) {
+	name = mutateKey(name)
+	c.buff.WriteString(fmt.Sprintf(typeGaugeTpl, name))
+	c.buff.WriteString(fmt.Sprintf(keyValueTpl, name, value))
+}
+
+func (c *collector) writeCounter(name string, value interface{}) {
+	name = mutateKey(name)
+	c.buff.WriteString(fmt.Sprintf(typeCounterTpl, name))
+	c.buff.WriteString(fmt.Sprintf(keyValueTpl, name, value))
+}
+
+func (c *collector) writeSummaryCounter(name string, value interface{}) {
+	name = mutateKey(name)
+	c.buff.WriteString(fmt.Sprintf(typeSummaryTpl, name))
+	c.buff.WriteString(fmt.Sprintf("%s_count %v\n", name, value))
+}
+
+func (c *collector) writeSummarySum(name string, value interface{}) {
+	name = mutateKey(name)
+	c.buff.WriteString(fmt.Sprintf("%s_sum %v\n", name, value))
+}
+
+func (c *collector) writeSummaryPercentile(name, p string, value interface{}) {
+	name = mutateKey(name)
+	c.buff.WriteString(fmt.Sprintf(keyQuantileTagValueTpl, name, p, value))
+}
+
+// mutateKey converts a go-metrics registry name into a valid Prometheus metric
+// name, replacing all the characters not allowed by the exposition format.
+func mutateKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == ':':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+}
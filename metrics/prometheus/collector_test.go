@@ -0,0 +1,142 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package prometheus
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+func TestMain(m *testing.M) {
+	metrics.Enabled = true
+	os.Exit(m.Run())
+}
+
+func TestCollector(t *testing.T) {
+	c := newCollector()
+
+	counter := metrics.NewCounter()
+	counter.Inc(12345)
+	c.addCounter("test/counter", counter)
+
+	gauge := metrics.NewGauge()
+	gauge.Update(23456)
+	c.addGauge("test/gauge", gauge)
+
+	gaugeFloat64 := metrics.NewGaugeFloat64()
+	gaugeFloat64.Update(34567.89)
+	c.addGaugeFloat64("test/gauge_float64", gaugeFloat64)
+
+	histogram := metrics.NewHistogram(&metrics.NilSample{})
+	c.addHistogram("test/histogram", histogram)
+
+	meter := metrics.NewMeter()
+	defer meter.Stop()
+	meter.Mark(9999999)
+	c.addMeter("test/meter", meter)
+
+	timer := metrics.NewTimer()
+	defer timer.Stop()
+	timer.Update(20 * time.Millisecond)
+	timer.Update(21 * time.Millisecond)
+	timer.Update(22 * time.Millisecond)
+	timer.Update(120 * time.Millisecond)
+	timer.Update(23 * time.Millisecond)
+	timer.Update(24 * time.Millisecond)
+	c.addTimer("test/timer", timer)
+
+	resettingTimer := metrics.NewResettingTimer()
+	resettingTimer.Update(10 * time.Millisecond)
+	resettingTimer.Update(11 * time.Millisecond)
+	resettingTimer.Update(12 * time.Millisecond)
+	resettingTimer.Update(120 * time.Millisecond)
+	resettingTimer.Update(13 * time.Millisecond)
+	resettingTimer.Update(14 * time.Millisecond)
+	c.addResettingTimer("test/resetting_timer", resettingTimer.Snapshot())
+
+	emptyResettingTimer := metrics.NewResettingTimer().Snapshot()
+	c.addResettingTimer("test/empty_resetting_timer", emptyResettingTimer)
+
+	const expectedOutput = `# TYPE test_counter gauge
+test_counter 12345
+
+# TYPE test_gauge gauge
+test_gauge 23456
+
+# TYPE test_gauge_float64 gauge
+test_gauge_float64 34567.89
+
+# TYPE test_histogram summary
+test_histogram_count 0
+test_histogram_sum 0
+test_histogram{quantile="0.5"} 0
+test_histogram{quantile="0.75"} 0
+test_histogram{quantile="0.95"} 0
+test_histogram{quantile="0.99"} 0
+test_histogram{quantile="0.999"} 0
+test_histogram{quantile="0.9999"} 0
+
+# TYPE test_meter counter
+test_meter 9999999
+
+# TYPE test_timer summary
+test_timer_count 6
+test_timer_sum 230000000
+test_timer{quantile="0.5"} 2.25e+07
+test_timer{quantile="0.75"} 4.8e+07
+test_timer{quantile="0.95"} 1.2e+08
+test_timer{quantile="0.99"} 1.2e+08
+test_timer{quantile="0.999"} 1.2e+08
+test_timer{quantile="0.9999"} 1.2e+08
+
+# TYPE test_resetting_timer summary
+test_resetting_timer_count 6
+test_resetting_timer{quantile="0.5"} 12000000
+test_resetting_timer{quantile="0.95"} 120000000
+test_resetting_timer{quantile="0.99"} 120000000
+
+`
+	if have := c.buff.String(); have != expectedOutput {
+		t.Fatalf("collector output mismatch:\nhave:\n%s\nwant:\n%s", have, expectedOutput)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	reg := metrics.NewRegistry()
+	metrics.NewRegisteredGauge("chain/head", reg).Update(42)
+	metrics.NewRegisteredCounter("p2p/peers-count", reg).Inc(7)
+
+	rec := httptest.NewRecorder()
+	Handler(reg).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/metrics/prometheus", nil))
+
+	body, _ := ioutil.ReadAll(rec.Body)
+	for _, want := range []string{"chain_head 42\n", "p2p_peers_count 7\n"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("exposition missing %q:\n%s", want, body)
+		}
+	}
+	// Metrics should be listed in a deterministic, sorted order
+	if strings.Index(string(body), "chain_head") > strings.Index(string(body), "p2p_peers_count") {
+		t.Errorf("metrics not sorted:\n%s", body)
+	}
+}
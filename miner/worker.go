@@ -250,6 +250,10 @@ func (self *worker) update() {
 		select {
 		// Handle ChainHeadEvent
 		case <-self.chainHeadCh:
+			// Let engines running their own consensus rounds know of the new head
+			if handler, ok := self.engine.(consensus.Handler); ok {
+				handler.NewChainHead()
+			}
 			self.commitNewWork()
 
 		// Handle ChainSideEvent
@@ -360,6 +364,9 @@ func (self *worker) makeCurrent(parent *types.Block, header *types.Header) error
 	if err != nil {
 		return err
 	}
+	if self.chain.StateHistoryEnabled() {
+		state.TrackHistory()
+	}
 	work := &Work{
 		config:    self.config,
 		signer:    types.NewEIP155Signer(self.config.ChainId),
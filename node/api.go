@@ -19,6 +19,7 @@ package node
 import (
 	"context"
 	"fmt"
+	"math"
 	"strings"
 	"time"
 
@@ -30,6 +31,10 @@ import (
 	"github.com/ethereum/go-ethereum/rpc"
 )
 
+// maxBanSeconds is the longest ban, in seconds, which can be represented as a
+// time.Duration without overflowing.
+const maxBanSeconds = math.MaxInt64 / uint64(time.Second)
+
 // PrivateAdminAPI is the collection of administrative API methods exposed only
 // over a secure RPC channel.
 type PrivateAdminAPI struct {
@@ -75,6 +80,46 @@ func (api *PrivateAdminAPI) RemovePeer(url string) (bool, error) {
 	return true, nil
 }
 
+// Ban bans a remote node for the given number of seconds, disconnecting it if
+// it is connected. If no duration is given, the node is banned for an hour.
+func (api *PrivateAdminAPI) Ban(url string, seconds *uint64) (bool, error) {
+	// Make sure the server is running, fail otherwise
+	server := api.node.Server()
+	if server == nil {
+		return false, ErrNodeStopped
+	}
+	node, err := discover.ParseNode(url)
+	if err != nil {
+		return false, fmt.Errorf("invalid enode: %v", err)
+	}
+	duration := time.Hour
+	if seconds != nil {
+		if *seconds > maxBanSeconds {
+			return false, fmt.Errorf("ban duration too long: %d seconds > %d", *seconds, maxBanSeconds)
+		}
+		duration = time.Duration(*seconds) * time.Second
+	}
+	if err := server.BanNode(node.ID, duration); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Unban lifts the ban of a remote node and forgives its misbehaviour. It reports
+// whether the node was banned.
+func (api *PrivateAdminAPI) Unban(url string) (bool, error) {
+	// Make sure the server is running, fail otherwise
+	server := api.node.Server()
+	if server == nil {
+		return false, ErrNodeStopped
+	}
+	node, err := discover.ParseNode(url)
+	if err != nil {
+		return false, fmt.Errorf("invalid enode: %v", err)
+	}
+	return server.UnbanNode(node.ID)
+}
+
 // PeerEvents creates an RPC subscription which receives peer events from the
 // node's p2p.Server
 func (api *PrivateAdminAPI) PeerEvents(ctx context.Context) (*rpc.Subscription, error) {
@@ -251,6 +296,16 @@ func (api *PublicAdminAPI) Peers() ([]*p2p.PeerInfo, error) {
 	return server.PeersInfo(), nil
 }
 
+// PeerScores retrieves the reputation of all remote nodes the node has an
+// opinion about, including the expiry of their bans.
+func (api *PublicAdminAPI) PeerScores() ([]*p2p.PeerScore, error) {
+	server := api.node.Server()
+	if server == nil {
+		return nil, ErrNodeStopped
+	}
+	return server.PeerScores(), nil
+}
+
 // NodeInfo retrieves all the information we know about the host node at the
 // protocol granularity.
 func (api *PublicAdminAPI) NodeInfo() (*p2p.NodeInfo, error) {
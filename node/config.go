@@ -18,6 +18,8 @@ package node
 
 import (
 	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -30,9 +32,17 @@ import (
 	"github.com/ethereum/go-ethereum/accounts/usbwallet"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Built in database engines, further ones may be added via ethdb.Register.
+const (
+	DatabaseEngineLevelDB = ethdb.EngineLevelDB // Persistent LevelDB store (default)
+	DatabaseEngineMemory  = ethdb.EngineMemory  // Non-persistent in-memory store
 )
 
 const (
@@ -41,6 +51,7 @@ const (
 	datadirStaticNodes     = "static-nodes.json"  // Path within the datadir to the static node list
 	datadirTrustedNodes    = "trusted-nodes.json" // Path within the datadir to the trusted node list
 	datadirNodeDatabase    = "nodes"              // Path within the datadir to store the node infos
+	datadirReputation      = "reputation"         // Path within the datadir to store the node scores
 )
 
 // Config represents a small collection of configuration values to fine tune the
@@ -66,6 +77,13 @@ type Config struct {
 	// in memory.
 	DataDir string
 
+	// DatabaseEngine selects the key-value store implementation backing the databases
+	// opened by the node and its services, by the name it was registered with in
+	// ethdb ("leveldb", "memory" or any engine added via ethdb.Register). If unset,
+	// LevelDB is used for persistent nodes. Ephemeral nodes always use memory
+	// databases.
+	DatabaseEngine string `toml:",omitempty"`
+
 	// Configuration of peer-to-peer networking.
 	P2P p2p.Config
 
@@ -145,6 +163,35 @@ type Config struct {
 	// private APIs to untrusted users is a major security risk.
 	WSExposeAll bool `toml:",omitempty"`
 
+	// RPCBatchLimit is the maximum number of requests allowed in a single batch
+	// on the HTTP and websocket RPC interfaces. Zero means no limit.
+	RPCBatchLimit int `toml:",omitempty"`
+
+	// RPCBatchResponseMaxSize is the maximum number of bytes a batch response may
+	// take up on the HTTP and websocket RPC interfaces. Zero means no limit.
+	RPCBatchResponseMaxSize int `toml:",omitempty"`
+
+	// RPCConnRateLimit is the number of requests per second a single websocket
+	// connection, or a single remote host over HTTP, may issue. Zero means no limit.
+	RPCConnRateLimit float64 `toml:",omitempty"`
+
+	// RPCMethodRateLimits is the number of requests per second allowed for the
+	// individual methods on the HTTP and websocket RPC interfaces, across all the
+	// connections (e.g. {"eth_getLogs": 10}).
+	RPCMethodRateLimits map[string]float64 `toml:",omitempty"`
+
+	// RPCAuthTokens is a list of static bearer tokens, any of which authorizes a
+	// request on the HTTP and websocket RPC interfaces.
+	RPCAuthTokens []string `toml:",omitempty"`
+
+	// RPCJWTSecret is the path to a file containing a hex encoded HMAC secret. If
+	// set, requests on the HTTP and websocket RPC interfaces bearing a JSON Web
+	// Token signed by it are authorized.
+	//
+	// If neither auth tokens nor a JWT secret are configured, the HTTP and websocket
+	// RPC interfaces are unauthenticated.
+	RPCJWTSecret string `toml:",omitempty"`
+
 	// Logger is a custom logger to use with the p2p.Server.
 	Logger log.Logger `toml:",omitempty"`
 }
@@ -174,6 +221,48 @@ func (c *Config) IPCEndpoint() string {
 	return c.IPCPath
 }
 
+// RPCLimits returns the resource quotas enforced by the HTTP and websocket RPC
+// interfaces.
+func (c *Config) RPCLimits() rpc.Limits {
+	return rpc.Limits{
+		BatchItems:        c.RPCBatchLimit,
+		BatchResponseSize: c.RPCBatchResponseMaxSize,
+		ConnRate:          c.RPCConnRateLimit,
+		MethodRates:       c.RPCMethodRateLimits,
+	}
+}
+
+// RPCAuthenticator returns the authenticator verifying requests on the HTTP and
+// websocket RPC interfaces, or nil if authentication is disabled.
+func (c *Config) RPCAuthenticator() (rpc.Authenticator, error) {
+	var auths []rpc.Authenticator
+	if len(c.RPCAuthTokens) > 0 {
+		auths = append(auths, rpc.NewTokenAuthenticator(c.RPCAuthTokens))
+	}
+	if c.RPCJWTSecret != "" {
+		blob, err := ioutil.ReadFile(c.RPCJWTSecret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT secret: %v", err)
+		}
+		secret, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(string(blob)), "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT secret: %v", err)
+		}
+		if len(secret) == 0 {
+			return nil, errors.New("empty JWT secret")
+		}
+		auths = append(auths, rpc.NewJWTAuthenticator(secret))
+	}
+	switch len(auths) {
+	case 0:
+		return nil, nil
+	case 1:
+		return auths[0], nil
+	default:
+		return rpc.AnyAuthenticator(auths...), nil
+	}
+}
+
 // NodeDB returns the path to the discovery node database.
 func (c *Config) NodeDB() string {
 	if c.DataDir == "" {
@@ -182,6 +271,30 @@ func (c *Config) NodeDB() string {
 	return c.resolvePath(datadirNodeDatabase)
 }
 
+// ReputationDB returns the path to the database of remote node scores and bans.
+func (c *Config) ReputationDB() string {
+	if c.DataDir == "" {
+		return "" // ephemeral
+	}
+	return c.resolvePath(datadirReputation)
+}
+
+// openDatabase opens a key-value database with the given name within the
+// instance directory, using the configured database engine.
+func (c *Config) openDatabase(name string, cache, handles int) (ethdb.Database, error) {
+	if c.DataDir == "" {
+		return ethdb.NewMemDatabase()
+	}
+	engine := c.DatabaseEngine
+	if engine == "" {
+		engine = DatabaseEngineLevelDB
+	}
+	if engine == DatabaseEngineMemory {
+		log.Warn("Using non-persistent in-memory database, all data will be lost on exit", "database", name)
+	}
+	return ethdb.Open(engine, c.resolvePath(name), cache, handles)
+}
+
 // DefaultIPCEndpoint returns the IPC path used by default.
 func DefaultIPCEndpoint(clientIdentifier string) string {
 	if clientIdentifier == "" {
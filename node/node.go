@@ -162,6 +162,9 @@ func (n *Node) Start() error {
 	if n.serverConfig.NodeDatabase == "" {
 		n.serverConfig.NodeDatabase = n.config.NodeDB()
 	}
+	if n.serverConfig.ReputationDatabase == "" {
+		n.serverConfig.ReputationDatabase = n.config.ReputationDB()
+	}
 	running := &p2p.Server{Config: n.serverConfig}
 	n.log.Info("Starting peer-to-peer node", "instance", n.serverConfig.Name)
 
@@ -171,6 +174,7 @@ func (n *Node) Start() error {
 		// Create a new context for the particular service
 		ctx := &ServiceContext{
 			config:         n.config,
+			nodeKey:        n.serverConfig.PrivateKey,
 			services:       make(map[reflect.Type]Service),
 			EventMux:       n.eventmux,
 			AccountManager: n.accman,
@@ -385,16 +389,20 @@ func (n *Node) startHTTP(endpoint string, apis []rpc.API, modules []string, cors
 			n.log.Debug("HTTP registered", "service", api.Service, "namespace", api.Namespace)
 		}
 	}
+	// Enforce the configured quotas and authentication on the endpoint
+	handler.SetLimits(n.config.RPCLimits())
+
+	auth, err := n.config.RPCAuthenticator()
+	if err != nil {
+		return err
+	}
 	// All APIs registered, start the HTTP listener
-	var (
-		listener net.Listener
-		err      error
-	)
+	var listener net.Listener
 	if listener, err = net.Listen("tcp", endpoint); err != nil {
 		return err
 	}
-	go rpc.NewHTTPServer(cors, vhosts, handler).Serve(listener)
-	n.log.Info("HTTP endpoint opened", "url", fmt.Sprintf("http://%s", endpoint), "cors", strings.Join(cors, ","), "vhosts", strings.Join(vhosts, ","))
+	go rpc.NewAuthenticatedHTTPServer(cors, vhosts, auth, handler).Serve(listener)
+	n.log.Info("HTTP endpoint opened", "url", fmt.Sprintf("http://%s", endpoint), "cors", strings.Join(cors, ","), "vhosts", strings.Join(vhosts, ","), "auth", auth != nil)
 	// All listeners booted successfully
 	n.httpEndpoint = endpoint
 	n.httpListener = listener
@@ -438,16 +446,20 @@ func (n *Node) startWS(endpoint string, apis []rpc.API, modules []string, wsOrig
 			n.log.Debug("WebSocket registered", "service", api.Service, "namespace", api.Namespace)
 		}
 	}
+	// Enforce the configured quotas and authentication on the endpoint
+	handler.SetLimits(n.config.RPCLimits())
+
+	auth, err := n.config.RPCAuthenticator()
+	if err != nil {
+		return err
+	}
 	// All APIs registered, start the HTTP listener
-	var (
-		listener net.Listener
-		err      error
-	)
+	var listener net.Listener
 	if listener, err = net.Listen("tcp", endpoint); err != nil {
 		return err
 	}
-	go rpc.NewWSServer(wsOrigins, handler).Serve(listener)
-	n.log.Info("WebSocket endpoint opened", "url", fmt.Sprintf("ws://%s", listener.Addr()))
+	go rpc.NewAuthenticatedWSServer(wsOrigins, auth, handler).Serve(listener)
+	n.log.Info("WebSocket endpoint opened", "url", fmt.Sprintf("ws://%s", listener.Addr()), "auth", auth != nil)
 
 	// All listeners booted successfully
 	n.wsEndpoint = endpoint
@@ -639,13 +651,10 @@ func (n *Node) EventMux() *event.TypeMux {
 }
 
 // OpenDatabase opens an existing database with the given name (or creates one if no
-// previous can be found) from within the node's instance directory. If the node is
-// ephemeral, a memory database is returned.
+// previous can be found) from within the node's instance directory, backed by the
+// configured database engine. If the node is ephemeral, a memory database is returned.
 func (n *Node) OpenDatabase(name string, cache, handles int) (ethdb.Database, error) {
-	if n.config.DataDir == "" {
-		return ethdb.NewMemDatabase()
-	}
-	return ethdb.NewLDBDatabase(n.config.resolvePath(name), cache, handles)
+	return n.config.openDatabase(name, cache, handles)
 }
 
 // ResolvePath returns the absolute path of a resource in the instance directory.
@@ -19,6 +19,7 @@ package node
 import (
 	"errors"
 	"io/ioutil"
+	"math"
 	"os"
 	"reflect"
 	"testing"
@@ -573,3 +574,30 @@ func TestAPIGather(t *testing.T) {
 		}
 	}
 }
+
+// Tests that bans longer than representable are rejected instead of overflowing
+// into short or negative durations.
+func TestAdminBanDuration(t *testing.T) {
+	stack, err := New(testNodeConfig())
+	if err != nil {
+		t.Fatalf("failed to create protocol stack: %v", err)
+	}
+	if err := stack.Start(); err != nil {
+		t.Fatalf("failed to start node: %v", err)
+	}
+	defer stack.Stop()
+
+	api := NewPrivateAdminAPI(stack)
+	url := "enode://a979fb575495b8d6db44f750317d0f4622bf4c2aa3365d6af7c284339968eef29b69ad0dce72a4d8db5ebb4968de0e3bec910127f134779fbcb0cb6d3331163c@127.0.0.1:30303"
+
+	for _, seconds := range []uint64{1, maxBanSeconds} {
+		if ok, err := api.Ban(url, &seconds); !ok || err != nil {
+			t.Errorf("ban of %d seconds rejected: %v", seconds, err)
+		}
+	}
+	for _, seconds := range []uint64{maxBanSeconds + 1, math.MaxUint64} {
+		if ok, err := api.Ban(url, &seconds); ok || err == nil {
+			t.Errorf("ban of %d seconds accepted", seconds)
+		}
+	}
+}
@@ -17,6 +17,7 @@
 package node
 
 import (
+	"crypto/ecdsa"
 	"reflect"
 
 	"github.com/ethereum/go-ethereum/accounts"
@@ -31,23 +32,18 @@ import (
 // as well as utility methods to operate on the service environment.
 type ServiceContext struct {
 	config         *Config
+	nodeKey        *ecdsa.PrivateKey        // Private key of the node, also identifying it in the network
 	services       map[reflect.Type]Service // Index of the already constructed services
 	EventMux       *event.TypeMux           // Event multiplexer used for decoupled notifications
 	AccountManager *accounts.Manager        // Account manager created by the node.
 }
 
 // OpenDatabase opens an existing database with the given name (or creates one
-// if no previous can be found) from within the node's data directory. If the
-// node is an ephemeral one, a memory database is returned.
+// if no previous can be found) from within the node's data directory, backed by
+// the configured database engine. If the node is an ephemeral one, a memory
+// database is returned.
 func (ctx *ServiceContext) OpenDatabase(name string, cache int, handles int) (ethdb.Database, error) {
-	if ctx.config.DataDir == "" {
-		return ethdb.NewMemDatabase()
-	}
-	db, err := ethdb.NewLDBDatabase(ctx.config.resolvePath(name), cache, handles)
-	if err != nil {
-		return nil, err
-	}
-	return db, nil
+	return ctx.config.openDatabase(name, cache, handles)
 }
 
 // ResolvePath resolves a user path into the data directory if that was relative
@@ -57,6 +53,15 @@ func (ctx *ServiceContext) ResolvePath(path string) string {
 	return ctx.config.resolvePath(path)
 }
 
+// NodeKey retrieves the private key of the node, for services needing to sign
+// messages verifiable by their network peers.
+func (ctx *ServiceContext) NodeKey() *ecdsa.PrivateKey {
+	if ctx.nodeKey == nil {
+		return ctx.config.NodeKey()
+	}
+	return ctx.nodeKey
+}
+
 // Service retrieves a currently running service registered of a specific type.
 func (ctx *ServiceContext) Service(service interface{}) error {
 	element := reflect.ValueOf(service).Elem()
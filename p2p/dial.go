@@ -21,11 +21,13 @@ import (
 	"crypto/rand"
 	"errors"
 	"fmt"
+	mrand "math/rand"
 	"net"
 	"time"
 
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/enr"
 	"github.com/ethereum/go-ethereum/p2p/netutil"
 )
 
@@ -34,6 +36,9 @@ const (
 	// redialing a certain node.
 	dialHistoryExpiration = 30 * time.Second
 
+	// DNS node lists are resynced at this interval.
+	dnsSyncInterval = 30 * time.Minute
+
 	// Discovery lookups are throttled and can only run
 	// once every few seconds.
 	lookupInterval = 4 * time.Second
@@ -72,11 +77,13 @@ type dialstate struct {
 	maxDynDials int
 	ntab        discoverTable
 	netrestrict *netutil.Netlist
+	rep         *reputation
 
 	lookupRunning bool
 	dialing       map[discover.NodeID]connFlag
 	lookupBuf     []*discover.Node // current discovery lookup results
 	randomNodes   []*discover.Node // filled from Table
+	dnsNodes      []*discover.Node // nodes found via DNS discovery
 	static        map[discover.NodeID]*dialTask
 	hist          *dialHistory
 
@@ -90,6 +97,7 @@ type discoverTable interface {
 	Resolve(target discover.NodeID) *discover.Node
 	Lookup(target discover.NodeID) []*discover.Node
 	ReadRandomNodes([]*discover.Node) int
+	SetRecord(*enr.Record)
 }
 
 // the dial history remembers recent dials.
@@ -127,11 +135,12 @@ type waitExpireTask struct {
 	time.Duration
 }
 
-func newDialState(static []*discover.Node, bootnodes []*discover.Node, ntab discoverTable, maxdyn int, netrestrict *netutil.Netlist) *dialstate {
+func newDialState(static []*discover.Node, bootnodes []*discover.Node, ntab discoverTable, maxdyn int, netrestrict *netutil.Netlist, rep *reputation) *dialstate {
 	s := &dialstate{
 		maxDynDials: maxdyn,
 		ntab:        ntab,
 		netrestrict: netrestrict,
+		rep:         rep,
 		static:      make(map[discover.NodeID]*dialTask),
 		dialing:     make(map[discover.NodeID]connFlag),
 		bootnodes:   make([]*discover.Node, len(bootnodes)),
@@ -151,6 +160,11 @@ func (s *dialstate) addStatic(n *discover.Node) {
 	s.static[n.ID] = &dialTask{flags: staticDialedConn, dest: n}
 }
 
+// setDNSNodes replaces the dial candidates found via DNS discovery.
+func (s *dialstate) setDNSNodes(nodes []*discover.Node) {
+	s.dnsNodes = nodes
+}
+
 func (s *dialstate) removeStatic(n *discover.Node) {
 	// This removes a task so future attempts to connect will not be made.
 	delete(s.static, n.ID)
@@ -218,7 +232,7 @@ func (s *dialstate) newTasks(nRunning int, peers map[discover.NodeID]*Peer, now
 	// Use random nodes from the table for half of the necessary
 	// dynamic dials.
 	randomCandidates := needDynDials / 2
-	if randomCandidates > 0 {
+	if randomCandidates > 0 && s.ntab != nil {
 		n := s.ntab.ReadRandomNodes(s.randomNodes)
 		for i := 0; i < randomCandidates && i < n; i++ {
 			if addDial(dynDialedConn, s.randomNodes[i]) {
@@ -226,6 +240,23 @@ func (s *dialstate) newTasks(nRunning int, peers map[discover.NodeID]*Peer, now
 			}
 		}
 	}
+	// Use random nodes found via DNS for half of the remaining dynamic dials,
+	// or for all of them if the discovery table is disabled.
+	dnsCandidates := needDynDials / 2
+	if s.ntab == nil {
+		dnsCandidates = needDynDials
+	}
+	if dnsCandidates > 0 && len(s.dnsNodes) > 0 {
+		for _, i := range mrand.Perm(len(s.dnsNodes)) {
+			if dnsCandidates == 0 {
+				break
+			}
+			if addDial(dynDialedConn, s.dnsNodes[i]) {
+				dnsCandidates--
+				needDynDials--
+			}
+		}
+	}
 	// Create dynamic dials from random lookup results, removing tried
 	// items from the result buffer.
 	i := 0
@@ -236,7 +267,7 @@ func (s *dialstate) newTasks(nRunning int, peers map[discover.NodeID]*Peer, now
 	}
 	s.lookupBuf = s.lookupBuf[:copy(s.lookupBuf, s.lookupBuf[i:])]
 	// Launch a discovery lookup if more candidates are needed.
-	if len(s.lookupBuf) < needDynDials && !s.lookupRunning {
+	if len(s.lookupBuf) < needDynDials && !s.lookupRunning && s.ntab != nil {
 		s.lookupRunning = true
 		newtasks = append(newtasks, &discoverTask{})
 	}
@@ -258,6 +289,8 @@ var (
 	errAlreadyConnected = errors.New("already connected")
 	errRecentlyDialed   = errors.New("recently dialed")
 	errNotWhitelisted   = errors.New("not contained in netrestrict whitelist")
+	errBanned           = errors.New("is banned")
+	errLowScore         = errors.New("reputation score too low")
 )
 
 func (s *dialstate) checkDial(n *discover.Node, peers map[discover.NodeID]*Peer) error {
@@ -273,6 +306,10 @@ func (s *dialstate) checkDial(n *discover.Node, peers map[discover.NodeID]*Peer)
 		return errNotWhitelisted
 	case s.hist.contains(n.ID):
 		return errRecentlyDialed
+	case s.rep.banned(n.ID):
+		return errBanned
+	case s.rep.score(n.ID) < dialScoreThreshold:
+		return errLowScore
 	}
 	return nil
 }
@@ -285,6 +322,8 @@ func (s *dialstate) taskDone(t task, now time.Time) {
 	case *discoverTask:
 		s.lookupRunning = false
 		s.lookupBuf = append(s.lookupBuf, t.results...)
+		// Dial the nodes with the best reputation first.
+		s.rep.sortByScore(s.lookupBuf)
 	}
 }
 
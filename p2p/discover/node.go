@@ -34,6 +34,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/crypto/secp256k1"
+	"github.com/ethereum/go-ethereum/p2p/enr"
 )
 
 const NodeIDBits = 512
@@ -45,6 +46,12 @@ type Node struct {
 	UDP, TCP uint16 // port numbers
 	ID       NodeID // the node's public key
 
+	// Record is the signed node record of the node, if it was retrieved
+	// through discovery. Protocols may use it to check the capabilities
+	// the node advertises before dialing it. It is not persisted in the
+	// node database.
+	Record *enr.Record `rlp:"-"`
+
 	// This is a cached copy of sha3(ID) which is used for node
 	// distance calculations. This is part of Node in order to make it
 	// possible to write tests that need a node at a certain distance.
@@ -36,6 +36,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p/enr"
 	"github.com/ethereum/go-ethereum/p2p/netutil"
 )
 
@@ -86,6 +87,9 @@ type Table struct {
 
 	net  transport
 	self *Node // metadata of the local node
+
+	record   *enr.Record  // signed record of the local node, served to ENR requests
+	recordMu sync.RWMutex // protects record
 }
 
 type bondproc struct {
@@ -98,9 +102,10 @@ type bondproc struct {
 // it is an interface so we can test without opening lots of UDP
 // sockets and without generating a private key.
 type transport interface {
-	ping(NodeID, *net.UDPAddr) error
+	ping(NodeID, *net.UDPAddr) (uint64, error)
 	waitping(NodeID) error
 	findnode(toid NodeID, addr *net.UDPAddr, target NodeID) ([]*Node, error)
+	requestENR(toid NodeID, addr *net.UDPAddr) (*enr.Record, error)
 	close()
 }
 
@@ -167,6 +172,40 @@ func (tab *Table) Self() *Node {
 	return tab.self
 }
 
+// Record returns the signed record of the local node, or nil if none was set.
+// The returned record should not be modified by the caller.
+func (tab *Table) Record() *enr.Record {
+	tab.recordMu.RLock()
+	defer tab.recordMu.RUnlock()
+
+	return tab.record
+}
+
+// SetRecord updates the signed record of the local node. Remote nodes learn about
+// the update through the record sequence number announced in ping and pong packets.
+func (tab *Table) SetRecord(r *enr.Record) {
+	tab.recordMu.Lock()
+	defer tab.recordMu.Unlock()
+
+	tab.record = r
+}
+
+// RequestENR retrieves the current record of the given node. If the node already
+// carries a record at least as recent as the retrieved one, it is returned as is,
+// otherwise a copy of the node carrying the new record is returned.
+func (tab *Table) RequestENR(n *Node) (*Node, error) {
+	record, err := tab.net.requestENR(n.ID, n.addr())
+	if err != nil {
+		return nil, err
+	}
+	if n.Record != nil && n.Record.Seq() >= record.Seq() {
+		return n, nil
+	}
+	cpy := *n
+	cpy.Record = record
+	return &cpy, nil
+}
+
 // ReadRandomNodes fills the given slice with random nodes from the
 // table. It will not write the same node more than once. The nodes in
 // the slice are copies and can be modified by the caller.
@@ -473,7 +512,7 @@ func (tab *Table) doRevalidate(done chan<- struct{}) {
 	}
 
 	// Ping the selected node and wait for a pong.
-	err := tab.ping(last.ID, last.addr())
+	_, err := tab.ping(last.ID, last.addr())
 
 	tab.mutex.Lock()
 	defer tab.mutex.Unlock()
@@ -641,7 +680,8 @@ func (tab *Table) pingpong(w *bondproc, pinged bool, id NodeID, addr *net.UDPAdd
 	defer func() { tab.bondslots <- struct{}{} }()
 
 	// Ping the remote side and wait for a pong.
-	if w.err = tab.ping(id, addr); w.err != nil {
+	seq, err := tab.ping(id, addr)
+	if w.err = err; w.err != nil {
 		close(w.done)
 		return
 	}
@@ -653,18 +693,30 @@ func (tab *Table) pingpong(w *bondproc, pinged bool, id NodeID, addr *net.UDPAdd
 	}
 	// Bonding succeeded, update the node database.
 	w.n = NewNode(id, addr.IP, uint16(addr.Port), tcpPort)
+
+	// If the remote node announced a record, retrieve it too. Failing to do
+	// so doesn't affect the bond, the node simply won't carry the record.
+	if seq > 0 {
+		if record, err := tab.net.requestENR(id, addr); err != nil {
+			log.Trace("Failed to retrieve node record", "id", id, "addr", addr, "err", err)
+		} else {
+			w.n.Record = record
+		}
+	}
 	close(w.done)
 }
 
 // ping a remote endpoint and wait for a reply, also updating the node
-// database accordingly.
-func (tab *Table) ping(id NodeID, addr *net.UDPAddr) error {
+// database accordingly. It returns the sequence number of the remote node
+// record, or zero if it has none.
+func (tab *Table) ping(id NodeID, addr *net.UDPAddr) (uint64, error) {
 	tab.db.updateLastPing(id, time.Now())
-	if err := tab.net.ping(id, addr); err != nil {
-		return err
+	seq, err := tab.net.ping(id, addr)
+	if err != nil {
+		return 0, err
 	}
 	tab.db.updateBondTime(id, time.Now())
-	return nil
+	return seq, nil
 }
 
 // bucket returns the bucket for the given node ID hash.
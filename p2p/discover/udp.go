@@ -27,6 +27,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p/enr"
 	"github.com/ethereum/go-ethereum/p2p/nat"
 	"github.com/ethereum/go-ethereum/p2p/netutil"
 	"github.com/ethereum/go-ethereum/rlp"
@@ -44,6 +45,7 @@ var (
 	errTimeout          = errors.New("RPC timeout")
 	errClockWarp        = errors.New("reply deadline too far in the future")
 	errClosed           = errors.New("socket closed")
+	errNoRecord         = errors.New("no local node record")
 )
 
 // Timeouts
@@ -63,6 +65,8 @@ const (
 	pongPacket
 	findnodePacket
 	neighborsPacket
+	enrRequestPacket
+	enrResponsePacket
 )
 
 // RPC request structures
@@ -88,6 +92,21 @@ type (
 		Rest []rlp.RawValue `rlp:"tail"`
 	}
 
+	// enrRequest is a query for the node record of the recipient (EIP-868).
+	enrRequest struct {
+		Expiration uint64
+		// Ignore additional fields (for forward compatibility).
+		Rest []rlp.RawValue `rlp:"tail"`
+	}
+
+	// enrResponse is the reply to enrRequest.
+	enrResponse struct {
+		ReplyTok []byte // Hash of the enrRequest packet.
+		Record   enr.Record
+		// Ignore additional fields (for forward compatibility).
+		Rest []rlp.RawValue `rlp:"tail"`
+	}
+
 	// findnode is a query for nodes close to the given target.
 	findnode struct {
 		Target     NodeID // doesn't need to be an actual public key
@@ -126,6 +145,39 @@ func makeEndpoint(addr *net.UDPAddr, tcpPort uint16) rpcEndpoint {
 	return rpcEndpoint{IP: ip, UDP: uint16(addr.Port), TCP: tcpPort}
 }
 
+// makeSeqTail encodes the sequence number of a node record as the optional
+// trailing field of ping and pong packets (EIP-868). Nodes without a record
+// don't announce a sequence number.
+func makeSeqTail(seq uint64) []rlp.RawValue {
+	if seq == 0 {
+		return nil
+	}
+	blob, _ := rlp.EncodeToBytes(seq)
+	return []rlp.RawValue{blob}
+}
+
+// seqFromTail decodes the node record sequence number announced in the trailing
+// fields of a ping or pong packet, or zero if there is none.
+func seqFromTail(rest []rlp.RawValue) uint64 {
+	var seq uint64
+	if len(rest) == 0 || rlp.DecodeBytes(rest[0], &seq) != nil {
+		return 0
+	}
+	return seq
+}
+
+// checkRecordID verifies that a node record was signed by the given node.
+func checkRecordID(r *enr.Record, id NodeID) error {
+	var key enr.Secp256k1
+	if err := r.Load(&key); err != nil {
+		return err
+	}
+	if PubkeyID((*ecdsa.PublicKey)(&key)) != id {
+		return errors.New("record signed by different node")
+	}
+	return nil
+}
+
 func (t *udp) nodeFromRPC(sender *net.UDPAddr, rn rpcNode) (*Node, error) {
 	if rn.UDP <= 1024 {
 		return nil, errors.New("low port")
@@ -271,23 +323,71 @@ func (t *udp) close() {
 	// TODO: wait for the loops to end.
 }
 
-// ping sends a ping message to the given node and waits for a reply.
-func (t *udp) ping(toid NodeID, toaddr *net.UDPAddr) error {
+// ping sends a ping message to the given node and waits for a reply. It returns
+// the sequence number of the remote node record announced in the pong, or zero
+// if the remote node announced none.
+func (t *udp) ping(toid NodeID, toaddr *net.UDPAddr) (uint64, error) {
 	req := &ping{
 		Version:    Version,
 		From:       t.ourEndpoint,
 		To:         makeEndpoint(toaddr, 0), // TODO: maybe use known TCP port from DB
 		Expiration: uint64(time.Now().Add(expiration).Unix()),
+		Rest:       makeSeqTail(t.localSeq()),
 	}
 	packet, hash, err := encodePacket(t.priv, pingPacket, req)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	var seq uint64
 	errc := t.pending(toid, pongPacket, func(p interface{}) bool {
-		return bytes.Equal(p.(*pong).ReplyTok, hash)
+		reply := p.(*pong)
+		if !bytes.Equal(reply.ReplyTok, hash) {
+			return false
+		}
+		seq = seqFromTail(reply.Rest)
+		return true
+	})
+	t.write(toaddr, req.name(), packet)
+	err = <-errc
+	return seq, err
+}
+
+// requestENR sends an ENR request to the given node and waits for the record in
+// the reply. The record is verified to be signed by the node.
+func (t *udp) requestENR(toid NodeID, toaddr *net.UDPAddr) (*enr.Record, error) {
+	req := &enrRequest{
+		Expiration: uint64(time.Now().Add(expiration).Unix()),
+	}
+	packet, hash, err := encodePacket(t.priv, enrRequestPacket, req)
+	if err != nil {
+		return nil, err
+	}
+	var record *enr.Record
+	errc := t.pending(toid, enrResponsePacket, func(p interface{}) bool {
+		reply := p.(*enrResponse)
+		if !bytes.Equal(reply.ReplyTok, hash) {
+			return false
+		}
+		record = &reply.Record
+		return true
 	})
 	t.write(toaddr, req.name(), packet)
-	return <-errc
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	if err := checkRecordID(record, toid); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// localSeq returns the sequence number of the local node record, or zero if the
+// local node has no record.
+func (t *udp) localSeq() uint64 {
+	if r := t.Record(); r != nil {
+		return r.Seq()
+	}
+	return 0
 }
 
 func (t *udp) waitping(from NodeID) error {
@@ -571,6 +671,10 @@ func decodePacket(buf []byte) (packet, NodeID, []byte, error) {
 		req = new(findnode)
 	case neighborsPacket:
 		req = new(neighbors)
+	case enrRequestPacket:
+		req = new(enrRequest)
+	case enrResponsePacket:
+		req = new(enrResponse)
 	default:
 		return nil, fromID, hash, fmt.Errorf("unknown type: %d", ptype)
 	}
@@ -587,6 +691,7 @@ func (req *ping) handle(t *udp, from *net.UDPAddr, fromID NodeID, mac []byte) er
 		To:         makeEndpoint(from, req.From.TCP),
 		ReplyTok:   mac,
 		Expiration: uint64(time.Now().Add(expiration).Unix()),
+		Rest:       makeSeqTail(t.localSeq()),
 	})
 	if !t.handleReply(fromID, pingPacket, req) {
 		// Note: we're ignoring the provided IP address right now
@@ -662,6 +767,37 @@ func (req *neighbors) handle(t *udp, from *net.UDPAddr, fromID NodeID, mac []byt
 
 func (req *neighbors) name() string { return "NEIGHBORS/v4" }
 
+func (req *enrRequest) handle(t *udp, from *net.UDPAddr, fromID NodeID, mac []byte) error {
+	if expired(req.Expiration) {
+		return errExpired
+	}
+	if !t.db.hasBond(fromID) {
+		// Same as for findnode, don't reply to unbonded nodes so the
+		// response can't be used for traffic amplification.
+		return errUnknownNode
+	}
+	record := t.Record()
+	if record == nil {
+		return errNoRecord
+	}
+	t.send(from, enrResponsePacket, &enrResponse{
+		ReplyTok: mac,
+		Record:   *record,
+	})
+	return nil
+}
+
+func (req *enrRequest) name() string { return "ENRREQUEST/v4" }
+
+func (req *enrResponse) handle(t *udp, from *net.UDPAddr, fromID NodeID, mac []byte) error {
+	if !t.handleReply(fromID, enrResponsePacket, req) {
+		return errUnsolicitedReply
+	}
+	return nil
+}
+
+func (req *enrResponse) name() string { return "ENRRESPONSE/v4" }
+
 func expired(ts uint64) bool {
 	return time.Unix(int64(ts), 0).Before(time.Now())
 }
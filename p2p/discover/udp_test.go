@@ -36,6 +36,7 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enr"
 	"github.com/ethereum/go-ethereum/rlp"
 )
 
@@ -128,6 +129,110 @@ func TestUDP_packetErrors(t *testing.T) {
 	test.packetIn(errUnsolicitedReply, pongPacket, &pong{ReplyTok: []byte{}, Expiration: futureExp})
 	test.packetIn(errUnknownNode, findnodePacket, &findnode{Expiration: futureExp})
 	test.packetIn(errUnsolicitedReply, neighborsPacket, &neighbors{Expiration: futureExp})
+	test.packetIn(errUnknownNode, enrRequestPacket, &enrRequest{Expiration: futureExp})
+	test.packetIn(errUnsolicitedReply, enrResponsePacket, &enrResponse{ReplyTok: []byte{}, Record: *signedRecord(t, test.remotekey)})
+}
+
+// signedRecord creates a node record signed by the given key.
+func signedRecord(t *testing.T, key *ecdsa.PrivateKey) *enr.Record {
+	var r enr.Record
+	r.Set(enr.IP4(net.IP{10, 0, 1, 99}))
+	r.Set(enr.UDP(30303))
+	if err := r.Sign(key); err != nil {
+		t.Fatalf("failed to sign record: %v", err)
+	}
+	return &r
+}
+
+func TestUDP_enrRequest(t *testing.T) {
+	test := newUDPTest(t)
+	defer test.table.Close()
+
+	// ensure there's a bond with the test node,
+	// the request won't be answered otherwise.
+	test.table.db.updateBondTime(PubkeyID(&test.remotekey.PublicKey), time.Now())
+
+	// Without a local record there is nothing to serve
+	test.packetIn(errNoRecord, enrRequestPacket, &enrRequest{Expiration: futureExp})
+
+	local := signedRecord(t, test.localkey)
+	test.table.SetRecord(local)
+	test.packetIn(nil, enrRequestPacket, &enrRequest{Expiration: futureExp})
+	test.waitPacketOut(func(p *enrResponse) {
+		reqhash := test.sent[len(test.sent)-1][:macSize]
+		if !bytes.Equal(p.ReplyTok, reqhash) {
+			t.Errorf("got enrResponse.ReplyTok %x, want %x", p.ReplyTok, reqhash)
+		}
+		if p.Record.Seq() != local.Seq() {
+			t.Errorf("record seq mismatch: got %d, want %d", p.Record.Seq(), local.Seq())
+		}
+		if err := checkRecordID(&p.Record, PubkeyID(&test.localkey.PublicKey)); err != nil {
+			t.Errorf("record identity mismatch: %v", err)
+		}
+	})
+}
+
+func TestUDP_requestENR(t *testing.T) {
+	test := newUDPTest(t)
+	defer test.table.Close()
+
+	remoteID := PubkeyID(&test.remotekey.PublicKey)
+	tests := []struct {
+		record *enr.Record
+		ok     bool
+	}{
+		{signedRecord(t, test.remotekey), true},
+		{signedRecord(t, newkey()), false},
+	}
+	for i, tt := range tests {
+		type result struct {
+			record *enr.Record
+			err    error
+		}
+		done := make(chan result, 1)
+		go func() {
+			record, err := test.udp.requestENR(remoteID, test.remoteaddr)
+			done <- result{record, err}
+		}()
+		hash, _ := test.waitPacketOut(func(p *enrRequest) {})
+		test.packetIn(nil, enrResponsePacket, &enrResponse{ReplyTok: hash, Record: *tt.record})
+
+		res := <-done
+		if tt.ok && (res.err != nil || res.record.Seq() != tt.record.Seq()) {
+			t.Errorf("test %d: unexpected result: record %v, err %v", i, res.record, res.err)
+		}
+		if !tt.ok && res.err == nil {
+			t.Errorf("test %d: record of foreign node accepted", i)
+		}
+	}
+}
+
+func TestUDP_bondRetrievesRecord(t *testing.T) {
+	test := newUDPTest(t)
+	added := make(chan *Node, 1)
+	test.table.nodeAddedHook = func(n *Node) { added <- n }
+	defer test.table.Close()
+
+	record := signedRecord(t, test.remotekey)
+
+	// The remote side pings, the table pings back and learns about the record.
+	go test.packetIn(nil, pingPacket, &ping{From: testRemote, To: testLocalAnnounced, Version: Version, Expiration: futureExp})
+	test.waitPacketOut(func(p *pong) {})
+	hash, _ := test.waitPacketOut(func(p *ping) {})
+	test.packetIn(nil, pongPacket, &pong{ReplyTok: hash, Expiration: futureExp, Rest: makeSeqTail(record.Seq())})
+
+	// The announced record is requested before the node is added.
+	hash, _ = test.waitPacketOut(func(p *enrRequest) {})
+	test.packetIn(nil, enrResponsePacket, &enrResponse{ReplyTok: hash, Record: *record})
+
+	select {
+	case n := <-added:
+		if n.Record == nil || n.Record.Seq() != record.Seq() {
+			t.Errorf("node has wrong record: got %v, want seq %d", n.Record, record.Seq())
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("node was not added within 2 seconds")
+	}
 }
 
 func TestUDP_pingTimeout(t *testing.T) {
@@ -137,7 +242,7 @@ func TestUDP_pingTimeout(t *testing.T) {
 
 	toaddr := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 2222}
 	toid := NodeID{1, 2, 3, 4}
-	if err := test.udp.ping(toid, toaddr); err != errTimeout {
+	if _, err := test.udp.ping(toid, toaddr); err != errTimeout {
 		t.Error("expected timeout error, got", err)
 	}
 }
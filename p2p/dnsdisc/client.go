@@ -0,0 +1,294 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package dnsdisc implements node discovery via DNS, as proposed in EIP-1459.
+//
+// Node lists are published as a merkle tree of signed node records, stored in
+// DNS TXT records below a domain. The root of the tree is signed by the list
+// publisher, whose public key is part of the tree URL:
+//
+//	enrtree://<base32 public key>@<domain>
+//
+// Clients sync the tree by resolving the root at the domain and walking the
+// branches down to the node records, verifying every entry against the hash
+// in its parent.
+package dnsdisc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+)
+
+var (
+	errNoEntry      = errors.New("no valid tree entry found")
+	errHashMismatch = errors.New("hash mismatch")
+	errTooManyTrees = errors.New("too many linked trees")
+)
+
+// maxLinkedTrees is the maximum number of trees synced when following links.
+const maxLinkedTrees = 32
+
+// Resolver is a DNS resolver that can query TXT records. It is implemented by
+// *net.Resolver and can be replaced by a stand-in for tests.
+type Resolver interface {
+	LookupTXT(ctx context.Context, domain string) ([]string, error)
+}
+
+// Config holds the settings of a DNS discovery client.
+type Config struct {
+	Timeout  time.Duration // Timeout of a single DNS query (default 5s)
+	Resolver Resolver      // DNS resolver to use (default system resolver)
+	Logger   log.Logger    // Logger to use (default root logger)
+}
+
+// Client syncs node lists published in DNS. It caches the tree entries it has
+// seen, so resyncing an updated tree only resolves the changed entries.
+type Client struct {
+	cfg Config
+
+	entries map[string]entry // Cache of verified entries, keyed by hash
+	lock    sync.Mutex       // Protects the entry cache
+}
+
+// NewClient creates a DNS discovery client.
+func NewClient(cfg Config) *Client {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.Resolver == nil {
+		cfg.Resolver = new(net.Resolver)
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.Root()
+	}
+	return &Client{cfg: cfg, entries: make(map[string]entry)}
+}
+
+// SyncTree downloads and verifies the complete tree at the given URL. Linked
+// trees are not synced, use SyncTrees to follow them.
+func (c *Client) SyncTree(url string) (*Tree, error) {
+	link, err := parseLink(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tree URL %q: %v", url, err)
+	}
+	return c.syncTree(link)
+}
+
+// SyncTrees syncs the trees at the given URLs and all the trees linked from
+// them, returning the nodes contained in any of them. Trees that fail to sync
+// are skipped, the error of the last failure is returned along with the nodes
+// of the others. Only the cached entries of the trees synced successfully are
+// retained, so the cache can't grow without bound even if syncs keep failing.
+func (c *Client) SyncTrees(urls ...string) ([]*discover.Node, error) {
+	var (
+		nodes   []*discover.Node
+		queue   = append([]string{}, urls...)
+		visited = make(map[string]bool)
+		live    = make(map[string]entry)
+		failure error
+	)
+	defer func() {
+		c.lock.Lock()
+		c.entries = live
+		c.lock.Unlock()
+	}()
+	for len(queue) > 0 {
+		url := queue[0]
+		queue = queue[1:]
+		if visited[url] {
+			continue
+		}
+		if len(visited) >= maxLinkedTrees {
+			failure = errTooManyTrees
+			return nodes, failure
+		}
+		visited[url] = true
+
+		tree, err := c.SyncTree(url)
+		if err != nil {
+			c.cfg.Logger.Debug("Failed to sync DNS node tree", "url", url, "err", err)
+			failure = err
+			continue
+		}
+		for hash, e := range tree.entries {
+			live[hash] = e
+		}
+		for _, r := range tree.Records() {
+			n, err := NodeFromRecord(r)
+			if err != nil {
+				c.cfg.Logger.Trace("Skipping unusable node record", "url", url, "err", err)
+				continue
+			}
+			nodes = append(nodes, n)
+		}
+		queue = append(queue, tree.Links()...)
+	}
+	return nodes, failure
+}
+
+// syncTree resolves the root of a tree, verifies its signature and resolves both
+// its subtrees.
+func (c *Client) syncTree(link *linkEntry) (*Tree, error) {
+	root, err := c.resolveRoot(link)
+	if err != nil {
+		return nil, err
+	}
+	tree := &Tree{root: root, entries: make(map[string]entry)}
+	if err := c.syncSubtree(tree, link.domain, root.eroot, false); err != nil {
+		return nil, err
+	}
+	if err := c.syncSubtree(tree, link.domain, root.lroot, true); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// syncSubtree resolves the entry with the given hash and everything below it,
+// adding the entries to the tree.
+func (c *Client) syncSubtree(tree *Tree, domain, hash string, links bool) error {
+	e, err := c.resolveEntry(domain, hash, links)
+	if err != nil {
+		return err
+	}
+	tree.entries[hash] = e
+	if branch, ok := e.(*branchEntry); ok {
+		for _, child := range branch.children {
+			if _, ok := tree.entries[child]; ok {
+				continue
+			}
+			if err := c.syncSubtree(tree, domain, child, links); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveRoot retrieves the root entry of a tree and verifies its signature.
+func (c *Client) resolveRoot(link *linkEntry) (*rootEntry, error) {
+	txts, err := c.lookupTXT(link.domain)
+	if err != nil {
+		return nil, err
+	}
+	for _, txt := range txts {
+		if !strings.HasPrefix(txt, rootPrefix) {
+			continue
+		}
+		root, err := parseRoot(txt)
+		if err != nil {
+			return nil, err
+		}
+		if !root.verifySignature(link.pubkey) {
+			return nil, errInvalidSig
+		}
+		return root, nil
+	}
+	return nil, fmt.Errorf("%v at %s", errNoEntry, link.domain)
+}
+
+// resolveEntry retrieves the entry with the given hash, either from the cache or
+// from DNS, verifying that its content matches the hash.
+func (c *Client) resolveEntry(domain, hash string, links bool) (entry, error) {
+	c.lock.Lock()
+	cached, ok := c.entries[hash]
+	c.lock.Unlock()
+	if ok {
+		// Entries are cached regardless of the subtree they were found in
+		switch cached.(type) {
+		case *linkEntry:
+			if !links {
+				return nil, errUnknownEntry
+			}
+		case *enrEntry:
+			if links {
+				return nil, errUnknownEntry
+			}
+		}
+		return cached, nil
+	}
+	name := hash + "." + domain
+	txts, err := c.lookupTXT(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, txt := range txts {
+		e, err := parseEntry(txt, links)
+		if err == errUnknownEntry {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry at %s: %v", name, err)
+		}
+		if subdomain(e) != hash {
+			return nil, fmt.Errorf("invalid entry at %s: %v", name, errHashMismatch)
+		}
+		c.lock.Lock()
+		c.entries[hash] = e
+		c.lock.Unlock()
+		return e, nil
+	}
+	return nil, fmt.Errorf("%v at %s", errNoEntry, name)
+}
+
+// lookupTXT resolves the TXT records of a name with the configured timeout.
+func (c *Client) lookupTXT(name string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.cfg.Timeout)
+	defer cancel()
+
+	return c.cfg.Resolver.LookupTXT(ctx, name)
+}
+
+// NodeFromRecord converts a node record into a dialable node. The record must
+// contain an IP address and a TCP port.
+func NodeFromRecord(r *enr.Record) (*discover.Node, error) {
+	var (
+		key enr.Secp256k1
+		ip4 enr.IP4
+		ip6 enr.IP6
+		tcp enr.TCP
+		udp enr.UDP
+		ip  net.IP
+	)
+	if err := r.Load(&key); err != nil {
+		return nil, err
+	}
+	if err := r.Load(&ip4); err == nil {
+		ip = net.IP(ip4)
+	} else if err := r.Load(&ip6); err == nil {
+		ip = net.IP(ip6)
+	} else {
+		return nil, errors.New("record has no IP address")
+	}
+	if err := r.Load(&tcp); err != nil {
+		return nil, err
+	}
+	if err := r.Load(&udp); err != nil {
+		udp = enr.UDP(tcp)
+	}
+	n := discover.NewNode(discover.PubkeyID((*ecdsa.PublicKey)(&key)), ip, uint16(udp), uint16(tcp))
+	n.Record = r
+	return n, nil
+}
@@ -0,0 +1,282 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package dnsdisc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+)
+
+// mapResolver is a stand-in resolver serving TXT records from memory.
+type mapResolver map[string]string
+
+func (m mapResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if txt, ok := m[name]; ok {
+		return []string{txt}, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name}
+}
+
+// add publishes the TXT records of a tree.
+func (m mapResolver) add(records map[string]string) {
+	for name, txt := range records {
+		m[name] = txt
+	}
+}
+
+// testRecords creates a number of signed node records with distinct endpoints.
+func testRecords(t *testing.T, n int) []*enr.Record {
+	records := make([]*enr.Record, n)
+	for i := range records {
+		key, _ := crypto.GenerateKey()
+
+		var r enr.Record
+		r.Set(enr.IP4(net.IP{10, 0, byte(i >> 8), byte(i)}))
+		r.Set(enr.TCP(30303))
+		r.Set(enr.UDP(30301))
+		if err := r.Sign(key); err != nil {
+			t.Fatalf("failed to sign record: %v", err)
+		}
+		records[i] = &r
+	}
+	return records
+}
+
+// signedTree creates and signs a tree for the given domain.
+func signedTree(t *testing.T, key *ecdsa.PrivateKey, domain string, records []*enr.Record, links []string) (*Tree, string) {
+	tree, err := MakeTree(1, records, links)
+	if err != nil {
+		t.Fatalf("failed to create tree: %v", err)
+	}
+	url, err := tree.Sign(key, domain)
+	if err != nil {
+		t.Fatalf("failed to sign tree: %v", err)
+	}
+	return tree, url
+}
+
+// Tests that a tree spanning multiple branch levels can be synced completely.
+func TestClientSyncTree(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	records := testRecords(t, 2*maxChildren+3)
+	tree, url := signedTree(t, key, "nodes.example.org", records, nil)
+
+	resolver := make(mapResolver)
+	resolver.add(tree.ToTXT("nodes.example.org"))
+
+	synced, err := NewClient(Config{Resolver: resolver}).SyncTree(url)
+	if err != nil {
+		t.Fatalf("failed to sync tree: %v", err)
+	}
+	if synced.Seq() != tree.Seq() {
+		t.Errorf("sequence number mismatch: have %d, want %d", synced.Seq(), tree.Seq())
+	}
+	if len(synced.Records()) != len(records) {
+		t.Errorf("record count mismatch: have %d, want %d", len(synced.Records()), len(records))
+	}
+	if len(synced.entries) != len(tree.entries) {
+		t.Errorf("entry count mismatch: have %d, want %d", len(synced.entries), len(tree.entries))
+	}
+}
+
+// Tests that linked trees are followed and their nodes are returned too.
+func TestClientSyncLinks(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	resolver := make(mapResolver)
+
+	leafRecords := testRecords(t, 3)
+	leaf, leafURL := signedTree(t, key, "leaf.example.org", leafRecords, nil)
+	resolver.add(leaf.ToTXT("leaf.example.org"))
+
+	rootRecords := testRecords(t, 2)
+	root, rootURL := signedTree(t, key, "root.example.org", rootRecords, []string{leafURL})
+	resolver.add(root.ToTXT("root.example.org"))
+
+	nodes, err := NewClient(Config{Resolver: resolver}).SyncTrees(rootURL)
+	if err != nil {
+		t.Fatalf("failed to sync trees: %v", err)
+	}
+	want := make(map[discover.NodeID]bool)
+	for _, r := range append(leafRecords, rootRecords...) {
+		n, _ := NodeFromRecord(r)
+		want[n.ID] = true
+	}
+	if len(nodes) != len(want) {
+		t.Fatalf("node count mismatch: have %d, want %d", len(nodes), len(want))
+	}
+	for _, n := range nodes {
+		if !want[n.ID] {
+			t.Errorf("unexpected node %v", n)
+		}
+		if n.TCP != 30303 || n.UDP != 30301 {
+			t.Errorf("node %x: port mismatch: tcp %d, udp %d", n.ID[:8], n.TCP, n.UDP)
+		}
+	}
+}
+
+// Tests that the entry cache only retains the entries of the trees synced
+// successfully, dropping the ones of trees failing to sync.
+func TestClientSyncPrune(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	resolver := make(mapResolver)
+
+	good, goodURL := signedTree(t, key, "good.example.org", testRecords(t, 3), nil)
+	resolver.add(good.ToTXT("good.example.org"))
+
+	bad, badURL := signedTree(t, key, "bad.example.org", testRecords(t, 8), nil)
+	resolver.add(bad.ToTXT("bad.example.org"))
+	for name, txt := range resolver {
+		if strings.HasSuffix(name, ".bad.example.org") && strings.HasPrefix(txt, enrPrefix) {
+			delete(resolver, name)
+			break
+		}
+	}
+
+	client := NewClient(Config{Resolver: resolver})
+	for i := 0; i < 3; i++ {
+		nodes, err := client.SyncTrees(goodURL, badURL)
+		if err == nil {
+			t.Fatalf("pass %d: failing tree accepted", i)
+		}
+		if len(nodes) != 3 {
+			t.Errorf("pass %d: node count mismatch: have %d, want %d", i, len(nodes), 3)
+		}
+		if len(client.entries) != len(good.entries) {
+			t.Errorf("pass %d: cached entry count mismatch: have %d, want %d", i, len(client.entries), len(good.entries))
+		}
+		for hash := range client.entries {
+			if _, ok := good.entries[hash]; !ok {
+				t.Errorf("pass %d: entry %s of failed tree cached", i, hash)
+			}
+		}
+	}
+}
+
+// Tests that trees with invalid signatures or tampered entries are rejected.
+func TestClientSyncInvalid(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	other, _ := crypto.GenerateKey()
+	records := testRecords(t, 4)
+
+	tests := []struct {
+		name   string
+		tamper func(tree *Tree, resolver mapResolver) string
+	}{
+		{
+			name: "signed by other key",
+			tamper: func(tree *Tree, resolver mapResolver) string {
+				tree.Sign(other, "nodes.example.org")
+				resolver.add(tree.ToTXT("nodes.example.org"))
+				return (&linkEntry{domain: "nodes.example.org", pubkey: &key.PublicKey}).String()
+			},
+		},
+		{
+			name: "tampered record",
+			tamper: func(tree *Tree, resolver mapResolver) string {
+				url, _ := tree.Sign(key, "nodes.example.org")
+				resolver.add(tree.ToTXT("nodes.example.org"))
+				// Replace one of the records with another valid one
+				for name, txt := range resolver {
+					if strings.HasPrefix(txt, enrPrefix) {
+						resolver[name] = EncodeRecord(testRecords(t, 1)[0])
+						break
+					}
+				}
+				return url
+			},
+		},
+		{
+			name: "missing entry",
+			tamper: func(tree *Tree, resolver mapResolver) string {
+				url, _ := tree.Sign(key, "nodes.example.org")
+				resolver.add(tree.ToTXT("nodes.example.org"))
+				delete(resolver, tree.root.eroot+".nodes.example.org")
+				return url
+			},
+		},
+	}
+	for _, tt := range tests {
+		tree, err := MakeTree(1, records, nil)
+		if err != nil {
+			t.Fatalf("%s: failed to create tree: %v", tt.name, err)
+		}
+		resolver := make(mapResolver)
+		url := tt.tamper(tree, resolver)
+
+		if _, err := NewClient(Config{Resolver: resolver}).SyncTree(url); err == nil {
+			t.Errorf("%s: invalid tree accepted", tt.name)
+		}
+	}
+}
+
+// Tests that the textual forms of the tree entries survive a round trip.
+func TestParseEntries(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	record := testRecords(t, 1)[0]
+
+	tree, url := signedTree(t, key, "nodes.example.org", []*enr.Record{record}, nil)
+	root, err := parseRoot(tree.root.String())
+	if err != nil {
+		t.Fatalf("failed to parse root: %v", err)
+	}
+	if root.String() != tree.root.String() || !root.verifySignature(&key.PublicKey) {
+		t.Errorf("root mismatch: have %q, want %q", root, tree.root)
+	}
+	link, err := parseLink(url)
+	if err != nil {
+		t.Fatalf("failed to parse link: %v", err)
+	}
+	if link.String() != url {
+		t.Errorf("link mismatch: have %q, want %q", link, url)
+	}
+	dec, err := DecodeRecord(EncodeRecord(record))
+	if err != nil {
+		t.Fatalf("failed to decode record: %v", err)
+	}
+	if EncodeRecord(dec) != EncodeRecord(record) {
+		t.Errorf("record mismatch after round trip")
+	}
+	// Links may only appear in the link subtree, records only in the other one
+	if _, err := parseEntry(url, false); err != errUnknownEntry {
+		t.Errorf("link accepted in record subtree: %v", err)
+	}
+	if _, err := parseEntry(EncodeRecord(record), true); err != errUnknownEntry {
+		t.Errorf("record accepted in link subtree: %v", err)
+	}
+	for _, invalid := range []string{
+		"enrtree-root:v1 e=foo l=bar seq=1 sig=baz",
+		"enrtree-root:v1 seq=1",
+		"enrtree-branch:notahash",
+		"enrtree://nokey",
+		fmt.Sprintf("enrtree://%s@", strings.Repeat("A", 53)),
+	} {
+		if _, err := parseRoot(invalid); err == nil && strings.HasPrefix(invalid, rootPrefix) {
+			t.Errorf("invalid root %q accepted", invalid)
+		}
+		if _, err := parseEntry(invalid, true); err == nil && !strings.HasPrefix(invalid, rootPrefix) {
+			t.Errorf("invalid entry %q accepted", invalid)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package dnsdisc
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Entry prefixes of the tree, as stored in the TXT records.
+const (
+	rootPrefix   = "enrtree-root:v1"
+	branchPrefix = "enrtree-branch:"
+	linkPrefix   = "enrtree://"
+	enrPrefix    = "enr:"
+)
+
+const (
+	// hashLength is the length of the base32 encoded entry hashes.
+	hashLength = 26
+
+	// sigLength is the length of the root signature, including the recovery id.
+	sigLength = 65
+
+	// maxChildren is the maximum number of children of a branch entry, so that
+	// the entry still fits into a single TXT record.
+	maxChildren = 370 / (hashLength + 1)
+)
+
+var (
+	b32format = base32.StdEncoding.WithPadding(base32.NoPadding)
+	b64format = base64.RawURLEncoding
+)
+
+var (
+	errUnknownEntry = errors.New("unknown entry type")
+	errNoPubkey     = errors.New("missing public key")
+	errBadPubkey    = errors.New("invalid public key")
+	errInvalidENR   = errors.New("invalid node record")
+	errInvalidChild = errors.New("invalid child hash")
+	errInvalidSig   = errors.New("invalid root signature")
+	errSyntax       = errors.New("invalid syntax")
+)
+
+// entry is a node of the tree, stored in a TXT record.
+type entry interface {
+	fmt.Stringer
+}
+
+type (
+	// rootEntry is the signed entry point of a tree, stored at the tree's domain.
+	rootEntry struct {
+		eroot string // Hash of the root of the node record subtree
+		lroot string // Hash of the root of the link subtree
+		seq   uint   // Sequence number, increased on every update of the tree
+		sig   []byte // Signature over the other fields
+	}
+
+	// branchEntry is an inner node of a subtree, listing the hashes of its children.
+	branchEntry struct {
+		children []string
+	}
+
+	// enrEntry is a leaf of the node record subtree.
+	enrEntry struct {
+		record *enr.Record
+	}
+
+	// linkEntry is a leaf of the link subtree, referencing another tree.
+	linkEntry struct {
+		domain string
+		pubkey *ecdsa.PublicKey
+	}
+)
+
+// Tree is a merkle tree of node records, signed by its publisher and stored in
+// DNS TXT records.
+type Tree struct {
+	root    *rootEntry
+	entries map[string]entry
+}
+
+// MakeTree creates a tree containing the given node records and links to other
+// trees. The tree still needs to be signed before publishing it.
+func MakeTree(seq uint, records []*enr.Record, links []string) (*Tree, error) {
+	// Sort the records by their identity so that the tree is deterministic
+	records = append([]*enr.Record{}, records...)
+	sort.Slice(records, func(i, j int) bool {
+		return bytes.Compare(records[i].NodeAddr(), records[j].NodeAddr()) < 0
+	})
+	enrEntries := make([]entry, len(records))
+	for i, r := range records {
+		if !r.Signed() {
+			return nil, errInvalidENR
+		}
+		enrEntries[i] = &enrEntry{record: r}
+	}
+	links = append([]string{}, links...)
+	sort.Strings(links)
+
+	linkEntries := make([]entry, len(links))
+	for i, link := range links {
+		le, err := parseLink(link)
+		if err != nil {
+			return nil, err
+		}
+		linkEntries[i] = le
+	}
+	tree := &Tree{entries: make(map[string]entry)}
+	tree.root = &rootEntry{
+		seq:   seq,
+		eroot: tree.build(enrEntries),
+		lroot: tree.build(linkEntries),
+	}
+	return tree, nil
+}
+
+// build adds the given leaves to the tree, grouping them below branch entries,
+// and returns the hash of the subtree root.
+func (t *Tree) build(entries []entry) string {
+	if len(entries) == 1 {
+		return t.add(entries[0])
+	}
+	if len(entries) <= maxChildren {
+		return t.add(&branchEntry{children: t.buildBranch(entries)})
+	}
+	var subtrees []entry
+	for len(entries) > 0 {
+		n := maxChildren
+		if len(entries) < n {
+			n = len(entries)
+		}
+		subtrees = append(subtrees, &branchEntry{children: t.buildBranch(entries[:n])})
+		entries = entries[n:]
+	}
+	return t.build(subtrees)
+}
+
+// buildBranch adds the given entries to the tree, returning their hashes.
+func (t *Tree) buildBranch(entries []entry) []string {
+	hashes := make([]string, len(entries))
+	for i, e := range entries {
+		hashes[i] = t.add(e)
+	}
+	return hashes
+}
+
+// add stores an entry in the tree and returns its hash.
+func (t *Tree) add(e entry) string {
+	hash := subdomain(e)
+	t.entries[hash] = e
+	return hash
+}
+
+// Sign signs the tree with the given key and returns the URL clients can use
+// to sync it from the given domain.
+func (t *Tree) Sign(key *ecdsa.PrivateKey, domain string) (string, error) {
+	sig, err := crypto.Sign(t.root.sigHash(), key)
+	if err != nil {
+		return "", err
+	}
+	t.root.sig = sig
+	return (&linkEntry{domain: domain, pubkey: &key.PublicKey}).String(), nil
+}
+
+// Seq returns the sequence number of the tree.
+func (t *Tree) Seq() uint {
+	return t.root.seq
+}
+
+// Records returns all node records contained in the tree.
+func (t *Tree) Records() []*enr.Record {
+	var records []*enr.Record
+	for _, e := range t.entries {
+		if ee, ok := e.(*enrEntry); ok {
+			records = append(records, ee.record)
+		}
+	}
+	return records
+}
+
+// Links returns the URLs of all trees linked from the tree.
+func (t *Tree) Links() []string {
+	var links []string
+	for _, e := range t.entries {
+		if le, ok := e.(*linkEntry); ok {
+			links = append(links, le.String())
+		}
+	}
+	return links
+}
+
+// ToTXT returns the TXT records of the tree to publish at the given domain,
+// keyed by their fully qualified names.
+func (t *Tree) ToTXT(domain string) map[string]string {
+	records := map[string]string{domain: t.root.String()}
+	for hash, e := range t.entries {
+		records[hash+"."+domain] = e.String()
+	}
+	return records
+}
+
+// subdomain returns the hash of an entry, which is also its subdomain.
+func subdomain(e entry) string {
+	return b32format.EncodeToString(crypto.Keccak256([]byte(e.String()))[:16])
+}
+
+func (e *rootEntry) String() string {
+	return fmt.Sprintf("%s sig=%s", e.signedText(), b64format.EncodeToString(e.sig))
+}
+
+func (e *rootEntry) signedText() string {
+	return fmt.Sprintf("%s e=%s l=%s seq=%d", rootPrefix, e.eroot, e.lroot, e.seq)
+}
+
+func (e *rootEntry) sigHash() []byte {
+	return crypto.Keccak256([]byte(e.signedText()))
+}
+
+// verifySignature checks that the root was signed by the given key.
+func (e *rootEntry) verifySignature(pubkey *ecdsa.PublicKey) bool {
+	if len(e.sig) != sigLength {
+		return false
+	}
+	return crypto.VerifySignature(crypto.CompressPubkey(pubkey), e.sigHash(), e.sig[:sigLength-1])
+}
+
+func (e *branchEntry) String() string {
+	return branchPrefix + strings.Join(e.children, ",")
+}
+
+func (e *enrEntry) String() string {
+	return EncodeRecord(e.record)
+}
+
+func (e *linkEntry) String() string {
+	return linkPrefix + b32format.EncodeToString(crypto.CompressPubkey(e.pubkey)) + "@" + e.domain
+}
+
+// EncodeRecord returns the textual representation of a signed node record, as
+// used in the leaves of the tree.
+func EncodeRecord(r *enr.Record) string {
+	blob, err := rlp.EncodeToBytes(r)
+	if err != nil {
+		panic(fmt.Errorf("dnsdisc: can't encode record: %v", err))
+	}
+	return enrPrefix + b64format.EncodeToString(blob)
+}
+
+// DecodeRecord parses the textual representation of a node record, verifying
+// its signature.
+func DecodeRecord(text string) (*enr.Record, error) {
+	if !strings.HasPrefix(text, enrPrefix) {
+		return nil, errInvalidENR
+	}
+	blob, err := b64format.DecodeString(text[len(enrPrefix):])
+	if err != nil {
+		return nil, errInvalidENR
+	}
+	var r enr.Record
+	if err := rlp.DecodeBytes(blob, &r); err != nil {
+		return nil, fmt.Errorf("%v: %v", errInvalidENR, err)
+	}
+	return &r, nil
+}
+
+// parseRoot parses the root entry of a tree.
+func parseRoot(text string) (*rootEntry, error) {
+	fields := strings.Fields(text)
+	if len(fields) != 5 || fields[0] != rootPrefix {
+		return nil, errSyntax
+	}
+	values := make([]string, 4)
+	for i, key := range []string{"e=", "l=", "seq=", "sig="} {
+		if !strings.HasPrefix(fields[i+1], key) {
+			return nil, errSyntax
+		}
+		values[i] = fields[i+1][len(key):]
+	}
+	e := &rootEntry{eroot: values[0], lroot: values[1]}
+	if !isValidHash(e.eroot) || !isValidHash(e.lroot) {
+		return nil, errInvalidChild
+	}
+	seq, err := strconv.ParseUint(values[2], 10, 32)
+	if err != nil {
+		return nil, errSyntax
+	}
+	e.seq = uint(seq)
+	if e.sig, err = b64format.DecodeString(values[3]); err != nil || len(e.sig) != sigLength {
+		return nil, errInvalidSig
+	}
+	return e, nil
+}
+
+// parseEntry parses a non-root entry of a tree. Links are only permitted if the
+// entry is part of the link subtree, and node records only if it isn't.
+func parseEntry(text string, links bool) (entry, error) {
+	switch {
+	case strings.HasPrefix(text, branchPrefix):
+		return parseBranch(text[len(branchPrefix):])
+	case strings.HasPrefix(text, linkPrefix) && links:
+		return parseLink(text)
+	case strings.HasPrefix(text, enrPrefix) && !links:
+		r, err := DecodeRecord(text)
+		if err != nil {
+			return nil, err
+		}
+		return &enrEntry{record: r}, nil
+	default:
+		return nil, errUnknownEntry
+	}
+}
+
+// parseBranch parses the child list of a branch entry.
+func parseBranch(text string) (entry, error) {
+	if text == "" {
+		return &branchEntry{}, nil
+	}
+	children := strings.Split(text, ",")
+	for _, child := range children {
+		if !isValidHash(child) {
+			return nil, errInvalidChild
+		}
+	}
+	return &branchEntry{children: children}, nil
+}
+
+// parseLink parses a tree URL of the form enrtree://<key>@<domain>.
+func parseLink(text string) (*linkEntry, error) {
+	if !strings.HasPrefix(text, linkPrefix) {
+		return nil, errSyntax
+	}
+	pos := strings.IndexByte(text, '@')
+	if pos == -1 {
+		return nil, errNoPubkey
+	}
+	keystring, domain := text[len(linkPrefix):pos], text[pos+1:]
+	if domain == "" {
+		return nil, errSyntax
+	}
+	keybytes, err := b32format.DecodeString(keystring)
+	if err != nil {
+		return nil, errBadPubkey
+	}
+	key, err := crypto.DecompressPubkey(keybytes)
+	if err != nil {
+		return nil, errBadPubkey
+	}
+	return &linkEntry{domain: domain, pubkey: key}, nil
+}
+
+// isValidHash reports whether the given string is a valid entry hash.
+func isValidHash(s string) bool {
+	if len(s) != hashLength {
+		return false
+	}
+	buf, err := b32format.DecodeString(s)
+	return err == nil && len(buf) == 16
+}
@@ -109,6 +109,9 @@ type Peer struct {
 
 	// events receives message send / receive events if set
 	events *event.Feed
+
+	// rep tracks the reputation of the remote node if set
+	rep *reputation
 }
 
 // NewPeer returns a peer for testing purposes.
@@ -155,6 +158,28 @@ func (p *Peer) Disconnect(reason DiscReason) {
 	}
 }
 
+// Penalize lowers the reputation score of the peer by the given amount, giving
+// the reason in the logs. If the score drops below the ban threshold, the node
+// is banned for a while and the peer is disconnected.
+func (p *Peer) Penalize(penalty float64, reason string) {
+	p.log.Debug("Penalizing peer", "penalty", penalty, "reason", reason)
+	if p.rep.adjust(p.ID(), -penalty) && !p.rw.is(trustedConn) {
+		p.log.Debug("Banning peer", "duration", defaultBanDuration)
+		p.Disconnect(DiscUselessPeer)
+	}
+}
+
+// Reward raises the reputation score of the peer by the given amount, e.g. for
+// delivering useful data.
+func (p *Peer) Reward(points float64) {
+	p.rep.adjust(p.ID(), points)
+}
+
+// Score returns the current reputation score of the peer.
+func (p *Peer) Score() float64 {
+	return p.rep.score(p.ID())
+}
+
 // String implements fmt.Stringer.
 func (p *Peer) String() string {
 	return fmt.Sprintf("Peer %x %v", p.rw.id[:8], p.RemoteAddr())
@@ -0,0 +1,98 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"crypto/ecdsa"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enr"
+)
+
+// recordNATInterval is the interval at which the external IP address of the NAT
+// is checked for changes which need to be reflected in the local node record.
+const recordNATInterval = 5 * time.Minute
+
+// localRecord maintains the signed node record of the local node, re-signing it
+// with an increased sequence number whenever its endpoint changes.
+type localRecord struct {
+	key   *ecdsa.PrivateKey // Key to sign the record with
+	attrs []enr.Entry       // Protocol specific entries of the record
+
+	ip       net.IP      // IP address in the current record
+	tcp, udp int         // Ports in the current record
+	record   *enr.Record // Current signed record
+	lock     sync.Mutex  // Protects the endpoint and the record
+}
+
+// newLocalRecord creates a local node record maintainer. The record itself is
+// only created on the first update.
+func newLocalRecord(key *ecdsa.PrivateKey, attrs []enr.Entry) *localRecord {
+	return &localRecord{key: key, attrs: attrs}
+}
+
+// Record returns the current signed record, or nil if it wasn't created yet.
+func (r *localRecord) Record() *enr.Record {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.record
+}
+
+// update re-signs the record if the given endpoint differs from the current one,
+// returning the current record and whether it changed. Zero ports and nil or
+// unspecified IP addresses are left out of the record.
+func (r *localRecord) update(ip net.IP, tcp, udp int) (*enr.Record, bool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.record != nil && r.ip.Equal(ip) && r.tcp == tcp && r.udp == udp {
+		return r.record, false, nil
+	}
+	// Records are cached by remote nodes based on their sequence number, so the
+	// first record is numbered by the current time to supersede any record of a
+	// previous run.
+	var record enr.Record
+	if r.record != nil {
+		record.SetSeq(r.record.Seq())
+	} else {
+		record.SetSeq(uint64(time.Now().Unix()))
+	}
+	if ip != nil && !ip.IsUnspecified() {
+		if ip4 := ip.To4(); ip4 != nil {
+			record.Set(enr.IP4(ip4))
+		} else {
+			record.Set(enr.IP6(ip))
+		}
+	}
+	if tcp != 0 {
+		record.Set(enr.TCP(tcp))
+	}
+	if udp != 0 {
+		record.Set(enr.UDP(udp))
+	}
+	for _, attr := range r.attrs {
+		record.Set(attr)
+	}
+	if err := record.Sign(r.key); err != nil {
+		return nil, false, err
+	}
+	r.ip, r.tcp, r.udp, r.record = ip, tcp, udp, &record
+	return r.record, true, nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"net"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Tests that the local node record is only re-signed if the endpoint changes,
+// and that it carries the endpoint and the protocol attributes.
+func TestLocalRecordUpdate(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	local := newLocalRecord(key, []enr.Entry{enr.WithEntry("eth", uint(63))})
+
+	if local.Record() != nil {
+		t.Fatalf("record exists before first update")
+	}
+	first, changed, err := local.update(net.ParseIP("1.2.3.4"), 30303, 30301)
+	if err != nil {
+		t.Fatalf("failed to create record: %v", err)
+	}
+	if !changed {
+		t.Fatalf("first record not reported as changed")
+	}
+	// Updating with the same endpoint should be a noop
+	same, changed, _ := local.update(net.ParseIP("1.2.3.4"), 30303, 30301)
+	if changed || same != first {
+		t.Fatalf("record re-signed without endpoint change")
+	}
+	// Changing the IP address should bump the sequence number
+	second, changed, err := local.update(net.ParseIP("5.6.7.8"), 30303, 30301)
+	if err != nil {
+		t.Fatalf("failed to update record: %v", err)
+	}
+	if !changed || second.Seq() != first.Seq()+1 {
+		t.Fatalf("sequence number mismatch: have %d, want %d", second.Seq(), first.Seq()+1)
+	}
+	// Ensure the record survives a round trip and has the correct contents
+	blob, err := rlp.EncodeToBytes(second)
+	if err != nil {
+		t.Fatalf("failed to encode record: %v", err)
+	}
+	var dec enr.Record
+	if err := rlp.DecodeBytes(blob, &dec); err != nil {
+		t.Fatalf("failed to decode record: %v", err)
+	}
+	var (
+		ip      enr.IP4
+		tcp     enr.TCP
+		udp     enr.UDP
+		version uint
+	)
+	if err := dec.Load(&ip); err != nil || !net.IP(ip).Equal(net.ParseIP("5.6.7.8")) {
+		t.Errorf("ip mismatch: have %v, want %v (%v)", net.IP(ip), "5.6.7.8", err)
+	}
+	if err := dec.Load(&tcp); err != nil || tcp != 30303 {
+		t.Errorf("tcp port mismatch: have %d, want %d (%v)", tcp, 30303, err)
+	}
+	if err := dec.Load(&udp); err != nil || udp != 30301 {
+		t.Errorf("udp port mismatch: have %d, want %d (%v)", udp, 30301, err)
+	}
+	if err := dec.Load(enr.WithEntry("eth", &version)); err != nil || version != 63 {
+		t.Errorf("protocol attribute mismatch: have %d, want %d (%v)", version, 63, err)
+	}
+}
@@ -0,0 +1,355 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	// scoreHalfLife is the time after which half of a score is forgotten. Scores
+	// decay towards zero, so both misbehaviour and merit are eventually forgotten.
+	scoreHalfLife = 30 * time.Minute
+
+	// maxScore caps the score a node can build up by rewards, so that a long
+	// history of good behaviour can't offset an arbitrary amount of penalties.
+	maxScore = 100
+
+	// dialScoreThreshold is the score below which nodes are not dialed.
+	dialScoreThreshold = -50
+
+	// banScoreThreshold is the score at which a node is banned automatically.
+	banScoreThreshold = -100
+
+	// defaultBanDuration is the duration of automatic bans.
+	defaultBanDuration = time.Hour
+
+	// minStoredScore is the absolute score below which unbanned entries are
+	// dropped instead of being stored.
+	minStoredScore = 0.5
+
+	// reputationPruneInterval is the interval at which expired entries are
+	// dropped, so that nodes seen only once don't accumulate forever.
+	reputationPruneInterval = 10 * time.Minute
+)
+
+// Schema layout of the reputation database.
+var reputationItemPrefix = []byte("s:") // Identifier to prefix score entries with
+
+// PeerScore is the reputation of a remote node, as reported by the admin API.
+type PeerScore struct {
+	ID          string     `json:"id"`                    // Unique node identifier
+	Score       float64    `json:"score"`                 // Current (decayed) score
+	BannedUntil *time.Time `json:"bannedUntil,omitempty"` // Expiry of the ban, if banned
+}
+
+// scoreEntry is the reputation of a single node.
+type scoreEntry struct {
+	score   float64   // Score at the time of the last update
+	updated time.Time // Time of the last update, used for decaying
+	banned  time.Time // Time at which the ban expires, zero if not banned
+}
+
+// storedScore is the database representation of a score entry.
+type storedScore struct {
+	Score   uint64 // IEEE 754 bits of the score
+	Updated uint64 // Unix time of the last update
+	Banned  uint64 // Unix time of ban expiry, zero if not banned
+}
+
+// reputation keeps track of the behaviour of remote nodes. Scores are raised
+// and lowered by the sub-protocols through the peer, and decay exponentially
+// over time. The scores and bans are persisted, so they survive restarts.
+type reputation struct {
+	db      *leveldb.DB
+	entries map[discover.NodeID]*scoreEntry
+	now     func() time.Time // Time source, replaceable for tests
+	lock    sync.Mutex
+}
+
+// newReputation opens the reputation database at the given path. If no path is
+// given, an in-memory, temporary database is used.
+func newReputation(path string) (*reputation, error) {
+	var (
+		db  *leveldb.DB
+		err error
+	)
+	if path == "" {
+		db, err = leveldb.Open(storage.NewMemStorage(), nil)
+	} else {
+		db, err = leveldb.OpenFile(path, &opt.Options{OpenFilesCacheCapacity: 5})
+		if _, iscorrupted := err.(*errors.ErrCorrupted); iscorrupted {
+			db, err = leveldb.RecoverFile(path, nil)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := &reputation{
+		db:      db,
+		entries: make(map[discover.NodeID]*scoreEntry),
+		now:     time.Now,
+	}
+	r.load()
+	return r, nil
+}
+
+// load reads all entries from the database, dropping the expired ones.
+func (r *reputation) load() {
+	it := r.db.NewIterator(util.BytesPrefix(reputationItemPrefix), nil)
+	defer it.Release()
+
+	now := r.now()
+	for it.Next() {
+		var (
+			id     discover.NodeID
+			stored storedScore
+		)
+		key := it.Key()[len(reputationItemPrefix):]
+		if len(key) != len(id) || rlp.DecodeBytes(it.Value(), &stored) != nil {
+			r.db.Delete(it.Key(), nil)
+			continue
+		}
+		copy(id[:], key)
+		e := &scoreEntry{
+			score:   math.Float64frombits(stored.Score),
+			updated: time.Unix(int64(stored.Updated), 0),
+		}
+		if stored.Banned != 0 {
+			e.banned = time.Unix(int64(stored.Banned), 0)
+		}
+		r.decay(e, now)
+		if r.expired(e) {
+			r.db.Delete(it.Key(), nil)
+			continue
+		}
+		r.entries[id] = e
+	}
+}
+
+// close flushes and closes the database.
+func (r *reputation) close() {
+	r.db.Close()
+}
+
+// decay applies the exponential decay since the last update of an entry.
+func (r *reputation) decay(e *scoreEntry, now time.Time) {
+	if elapsed := now.Sub(e.updated); elapsed > 0 {
+		e.score *= math.Exp2(-float64(elapsed) / float64(scoreHalfLife))
+		e.updated = now
+	}
+	if !e.banned.IsZero() && !now.Before(e.banned) {
+		e.banned = time.Time{}
+	}
+}
+
+// expired reports whether an entry carries no information anymore.
+func (r *reputation) expired(e *scoreEntry) bool {
+	return e.banned.IsZero() && math.Abs(e.score) < minStoredScore
+}
+
+// entry retrieves the decayed entry of a node. The lock must be held.
+func (r *reputation) entry(id discover.NodeID, now time.Time) *scoreEntry {
+	e := r.entries[id]
+	if e != nil {
+		r.decay(e, now)
+	}
+	return e
+}
+
+// store updates the entry of a node in memory and in the database, dropping it
+// if it expired. The lock must be held.
+func (r *reputation) store(id discover.NodeID, e *scoreEntry, now time.Time) {
+	key := append(append([]byte{}, reputationItemPrefix...), id[:]...)
+	if r.expired(e) {
+		delete(r.entries, id)
+		r.db.Delete(key, nil)
+		return
+	}
+	r.entries[id] = e
+
+	stored := storedScore{
+		Score:   math.Float64bits(e.score),
+		Updated: uint64(e.updated.Unix()),
+	}
+	if !e.banned.IsZero() {
+		stored.Banned = uint64(e.banned.Unix())
+	}
+	blob, _ := rlp.EncodeToBytes(&stored)
+	if err := r.db.Put(key, blob, nil); err != nil {
+		log.Warn("Failed to store node score", "id", id, "err", err)
+	}
+}
+
+// score returns the current score of a node. Unknown nodes have a zero score.
+func (r *reputation) score(id discover.NodeID) float64 {
+	if r == nil {
+		return 0
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if e := r.entry(id, r.now()); e != nil {
+		return e.score
+	}
+	return 0
+}
+
+// banned reports whether a node is currently banned.
+func (r *reputation) banned(id discover.NodeID) bool {
+	if r == nil {
+		return false
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	e := r.entry(id, r.now())
+	return e != nil && !e.banned.IsZero()
+}
+
+// adjust adds delta to the score of a node. If the score drops to the ban
+// threshold, the node is banned for the default duration and true is returned.
+func (r *reputation) adjust(id discover.NodeID, delta float64) bool {
+	if r == nil {
+		return false
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.now()
+	e := r.entry(id, now)
+	if e == nil {
+		e = &scoreEntry{updated: now}
+	}
+	e.score = math.Min(e.score+delta, maxScore)
+
+	banned := false
+	if e.banned.IsZero() && e.score <= banScoreThreshold {
+		e.banned = now.Add(defaultBanDuration)
+		banned = true
+	}
+	r.store(id, e, now)
+	return banned
+}
+
+// ban bans a node for the given duration, replacing any existing ban.
+func (r *reputation) ban(id discover.NodeID, duration time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.now()
+	e := r.entry(id, now)
+	if e == nil {
+		e = &scoreEntry{updated: now}
+	}
+	e.banned = now.Add(duration)
+	r.store(id, e, now)
+}
+
+// unban lifts the ban of a node, also forgiving a negative score so that the
+// node is dialed again and not banned again right away. It reports whether the
+// node was banned.
+func (r *reputation) unban(id discover.NodeID) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.now()
+	e := r.entry(id, now)
+	if e == nil {
+		return false
+	}
+	wasBanned := !e.banned.IsZero()
+	e.banned = time.Time{}
+	if e.score < 0 {
+		e.score = 0
+	}
+	r.store(id, e, now)
+	return wasBanned
+}
+
+// prune drops all entries which decayed into insignificance or whose ban ran
+// out without leaving a significant score behind. It returns the number of
+// entries dropped.
+func (r *reputation) prune() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.now()
+	pruned := 0
+	for id := range r.entries {
+		if e := r.entry(id, now); r.expired(e) {
+			r.store(id, e, now)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// list returns the reputation of all known nodes, sorted by descending score.
+func (r *reputation) list() []*PeerScore {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.now()
+	scores := make([]*PeerScore, 0, len(r.entries))
+	for id := range r.entries {
+		e := r.entry(id, now)
+		if r.expired(e) {
+			continue
+		}
+		score := &PeerScore{ID: id.String(), Score: e.score}
+		if !e.banned.IsZero() {
+			banned := e.banned
+			score.BannedUntil = &banned
+		}
+		scores = append(scores, score)
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		if scores[i].Score != scores[j].Score {
+			return scores[i].Score > scores[j].Score
+		}
+		return scores[i].ID < scores[j].ID
+	})
+	return scores
+}
+
+// sortByScore sorts nodes by descending score, keeping the original order of
+// nodes with equal scores.
+func (r *reputation) sortByScore(nodes []*discover.Node) {
+	if r == nil || len(nodes) < 2 {
+		return
+	}
+	scores := make(map[discover.NodeID]float64, len(nodes))
+	for _, n := range nodes {
+		scores[n.ID] = r.score(n.ID)
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return scores[nodes[i].ID] > scores[nodes[j].ID]
+	})
+}
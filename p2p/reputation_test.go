@@ -0,0 +1,213 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+// newTestReputation creates an in-memory reputation store with a fake clock.
+func newTestReputation(t *testing.T, now *time.Time) *reputation {
+	rep, err := newReputation("")
+	if err != nil {
+		t.Fatalf("failed to create reputation store: %v", err)
+	}
+	rep.now = func() time.Time { return *now }
+	return rep
+}
+
+// Tests that scores decay towards zero and are capped by the maximum.
+func TestReputationDecay(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	rep := newTestReputation(t, &now)
+	defer rep.close()
+
+	rep.adjust(uintID(1), -40)
+	rep.adjust(uintID(2), 2*maxScore)
+
+	if score := rep.score(uintID(2)); score != maxScore {
+		t.Errorf("reward not capped: have %v, want %v", score, maxScore)
+	}
+	now = now.Add(scoreHalfLife)
+	if score := rep.score(uintID(1)); math.Abs(score+20) > 1e-9 {
+		t.Errorf("penalty not decayed: have %v, want %v", score, -20)
+	}
+	if score := rep.score(uintID(2)); math.Abs(score-maxScore/2) > 1e-9 {
+		t.Errorf("reward not decayed: have %v, want %v", score, maxScore/2)
+	}
+	// Negligible scores should be forgotten
+	now = now.Add(10 * scoreHalfLife)
+	rep.adjust(uintID(1), 0)
+	if _, ok := rep.entries[uintID(1)]; ok {
+		t.Errorf("expired entry not dropped")
+	}
+	if scores := rep.list(); len(scores) != 0 {
+		t.Errorf("expired entries listed: %+v", scores)
+	}
+}
+
+// Tests that pruning drops the entries which decayed into insignificance or whose
+// ban expired, both from memory and from the database, keeping the others.
+func TestReputationPrune(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	rep := newTestReputation(t, &now)
+	defer rep.close()
+
+	rep.adjust(uintID(1), 10)
+	rep.adjust(uintID(2), maxScore)
+	rep.ban(uintID(3), time.Minute)
+
+	if pruned := rep.prune(); pruned != 0 {
+		t.Errorf("pruned fresh entries: have %d, want 0", pruned)
+	}
+	now = now.Add(5 * scoreHalfLife)
+	if pruned := rep.prune(); pruned != 2 {
+		t.Errorf("pruned entry count mismatch: have %d, want 2", pruned)
+	}
+	if len(rep.entries) != 1 {
+		t.Fatalf("entry count mismatch: have %d, want 1", len(rep.entries))
+	}
+	if _, ok := rep.entries[uintID(2)]; !ok {
+		t.Errorf("significant entry pruned")
+	}
+	it := rep.db.NewIterator(nil, nil)
+	defer it.Release()
+
+	stored := 0
+	for it.Next() {
+		stored++
+	}
+	if stored != 1 {
+		t.Errorf("stored entry count mismatch: have %d, want 1", stored)
+	}
+}
+
+// Tests that nodes get banned when their score drops to the threshold, and that
+// bans expire and can be lifted.
+func TestReputationBan(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	rep := newTestReputation(t, &now)
+	defer rep.close()
+
+	if rep.adjust(uintID(1), banScoreThreshold/2) {
+		t.Fatalf("banned above threshold")
+	}
+	if !rep.adjust(uintID(1), banScoreThreshold/2) {
+		t.Fatalf("not banned at threshold")
+	}
+	if !rep.banned(uintID(1)) {
+		t.Fatalf("ban not reported")
+	}
+	// Further penalties should not report a new ban
+	if rep.adjust(uintID(1), -1) {
+		t.Errorf("ban reported twice")
+	}
+	now = now.Add(defaultBanDuration)
+	if rep.banned(uintID(1)) {
+		t.Errorf("ban did not expire")
+	}
+	// Explicit bans can be lifted, forgiving the score
+	rep.ban(uintID(2), time.Minute)
+	rep.adjust(uintID(2), -10)
+	if !rep.banned(uintID(2)) {
+		t.Fatalf("explicit ban not reported")
+	}
+	if !rep.unban(uintID(2)) {
+		t.Errorf("unban did not report ban")
+	}
+	if rep.banned(uintID(2)) || rep.score(uintID(2)) != 0 {
+		t.Errorf("unban ineffective: banned %v, score %v", rep.banned(uintID(2)), rep.score(uintID(2)))
+	}
+	if rep.unban(uintID(3)) {
+		t.Errorf("unknown node reported as banned")
+	}
+}
+
+// Tests that scores and bans survive a restart.
+func TestReputationPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reputation")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	rep, err := newReputation(dir)
+	if err != nil {
+		t.Fatalf("failed to create reputation store: %v", err)
+	}
+	rep.adjust(uintID(1), 50)
+	rep.ban(uintID(2), time.Hour)
+	rep.close()
+
+	if rep, err = newReputation(dir); err != nil {
+		t.Fatalf("failed to reopen reputation store: %v", err)
+	}
+	defer rep.close()
+
+	if score := rep.score(uintID(1)); score < 49 || score > 50 {
+		t.Errorf("score not persisted: have %v, want ~50", score)
+	}
+	if !rep.banned(uintID(2)) {
+		t.Errorf("ban not persisted")
+	}
+	scores := rep.list()
+	if len(scores) != 2 || scores[0].ID != uintID(1).String() || scores[1].BannedUntil == nil {
+		t.Errorf("score list mismatch: %+v", scores)
+	}
+}
+
+// Tests that banned and badly scored nodes are not dialed, and that lookup
+// results are dialed in the order of their scores.
+func TestDialStateReputation(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	rep := newTestReputation(t, &now)
+	defer rep.close()
+
+	rep.ban(uintID(1), time.Hour)
+	rep.adjust(uintID(2), dialScoreThreshold-1)
+	rep.adjust(uintID(5), 10)
+
+	runDialTest(t, dialtest{
+		init: newDialState(nil, nil, fakeTable{}, 2, nil, rep),
+		rounds: []round{
+			{
+				new: []task{&discoverTask{}},
+			},
+			{
+				done: []task{
+					&discoverTask{results: []*discover.Node{
+						{ID: uintID(1)}, // banned
+						{ID: uintID(2)}, // score too low
+						{ID: uintID(3)},
+						{ID: uintID(4)}, // not dialed, the better scored node 5 comes first
+						{ID: uintID(5)},
+					}},
+				},
+				new: []task{
+					&dialTask{flags: dynDialedConn, dest: &discover.Node{ID: uintID(5)}},
+					&dialTask{flags: dynDialedConn, dest: &discover.Node{ID: uintID(3)}},
+				},
+			},
+		},
+	})
+}
@@ -31,6 +31,8 @@ import (
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/p2p/discover"
 	"github.com/ethereum/go-ethereum/p2p/discv5"
+	"github.com/ethereum/go-ethereum/p2p/dnsdisc"
+	"github.com/ethereum/go-ethereum/p2p/enr"
 	"github.com/ethereum/go-ethereum/p2p/nat"
 	"github.com/ethereum/go-ethereum/p2p/netutil"
 )
@@ -93,6 +95,11 @@ type Config struct {
 	// protocol.
 	BootstrapNodesV5 []*discv5.Node `toml:",omitempty"`
 
+	// DNSDiscoveryURLs are the enrtree:// URLs of DNS node lists. The nodes
+	// contained in the lists are used as dial candidates, which allows finding
+	// peers even if discovery is disabled or blocked.
+	DNSDiscoveryURLs []string `toml:",omitempty"`
+
 	// Static nodes are used as pre-configured connections which are always
 	// maintained and re-connected on disconnects.
 	StaticNodes []*discover.Node
@@ -110,6 +117,10 @@ type Config struct {
 	// live nodes in the network.
 	NodeDatabase string `toml:",omitempty"`
 
+	// ReputationDatabase is the path to the database containing the reputation
+	// scores and bans of remote nodes. If empty, an in-memory database is used.
+	ReputationDatabase string `toml:",omitempty"`
+
 	// Protocols should contain the protocols supported
 	// by the server. Matching protocols are launched for
 	// each peer.
@@ -157,6 +168,8 @@ type Server struct {
 	running bool
 
 	ntab         discoverTable
+	record       *localRecord
+	reputation   *reputation
 	listener     net.Listener
 	ourHandshake *protoHandshake
 	lastLookup   time.Time
@@ -169,6 +182,7 @@ type Server struct {
 	quit          chan struct{}
 	addstatic     chan *discover.Node
 	removestatic  chan *discover.Node
+	dnsnodes      chan []*discover.Node
 	posthandshake chan *conn
 	addpeer       chan *conn
 	delpeer       chan peerDrop
@@ -300,6 +314,59 @@ func (srv *Server) RemovePeer(node *discover.Node) {
 	}
 }
 
+// PeerScores returns the reputation of all nodes the server has an opinion
+// about, sorted by descending score.
+func (srv *Server) PeerScores() []*PeerScore {
+	rep := srv.peerReputation()
+	if rep == nil {
+		return nil
+	}
+	return rep.list()
+}
+
+// BanNode bans a node for the given duration, disconnecting it if it is
+// connected. Banned nodes are neither dialed nor accepted, unless trusted.
+func (srv *Server) BanNode(id discover.NodeID, duration time.Duration) error {
+	rep := srv.peerReputation()
+	if rep == nil {
+		return errServerStopped
+	}
+	rep.ban(id, duration)
+
+	select {
+	case srv.peerOp <- func(peers map[discover.NodeID]*Peer) {
+		if p := peers[id]; p != nil && !p.rw.is(trustedConn) {
+			p.Disconnect(DiscUselessPeer)
+		}
+	}:
+		<-srv.peerOpDone
+	case <-srv.quit:
+	}
+	return nil
+}
+
+// UnbanNode lifts the ban of a node and resets a negative score. It reports
+// whether the node was banned.
+func (srv *Server) UnbanNode(id discover.NodeID) (bool, error) {
+	rep := srv.peerReputation()
+	if rep == nil {
+		return false, errServerStopped
+	}
+	return rep.unban(id), nil
+}
+
+// peerReputation returns the reputation store, or nil if the server is not
+// running.
+func (srv *Server) peerReputation() *reputation {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	if !srv.running {
+		return nil
+	}
+	return srv.reputation
+}
+
 // SubscribePeers subscribes the given channel to peer events
 func (srv *Server) SubscribeEvents(ch chan *PeerEvent) event.Subscription {
 	return srv.peerFeed.Subscribe(ch)
@@ -410,6 +477,7 @@ func (srv *Server) Start() (err error) {
 	srv.posthandshake = make(chan *conn)
 	srv.addstatic = make(chan *discover.Node)
 	srv.removestatic = make(chan *discover.Node)
+	srv.dnsnodes = make(chan []*discover.Node)
 	srv.peerOp = make(chan peerOpFunc)
 	srv.peerOpDone = make(chan struct{})
 
@@ -434,7 +502,7 @@ func (srv *Server) Start() (err error) {
 			if !realaddr.IP.IsLoopback() {
 				go nat.Map(srv.NAT, srv.quit, "udp", realaddr.Port, realaddr.Port, "ethereum discovery")
 			}
-			// TODO: react to external IP changes over time.
+			// External IP changes over time are tracked by the node record.
 			if ext, err := srv.NAT.ExternalIP(); err == nil {
 				realaddr = &net.UDPAddr{IP: ext, Port: realaddr.Port}
 			}
@@ -482,8 +550,14 @@ func (srv *Server) Start() (err error) {
 		srv.DiscV5 = ntab
 	}
 
+	rep, err := newReputation(srv.ReputationDatabase)
+	if err != nil {
+		return err
+	}
+	srv.reputation = rep
+
 	dynPeers := srv.maxDialedConns()
-	dialer := newDialState(srv.StaticNodes, srv.BootstrapNodes, srv.ntab, dynPeers, srv.NetRestrict)
+	dialer := newDialState(srv.StaticNodes, srv.BootstrapNodes, srv.ntab, dynPeers, srv.NetRestrict, srv.reputation)
 
 	// handshake
 	srv.ourHandshake = &protoHandshake{Version: baseProtocolVersion, Name: srv.Name, ID: discover.PubkeyID(&srv.PrivateKey.PublicKey)}
@@ -496,12 +570,21 @@ func (srv *Server) Start() (err error) {
 			return err
 		}
 	}
+	if err := srv.setupRecord(realaddr); err != nil {
+		return err
+	}
 	if srv.NoDial && srv.ListenAddr == "" {
 		srv.log.Warn("P2P server will be useless, neither dialing nor listening")
 	}
 
 	srv.loopWG.Add(1)
 	go srv.run(dialer)
+	srv.loopWG.Add(1)
+	go srv.reputationLoop()
+	if len(srv.DNSDiscoveryURLs) > 0 && !srv.NoDial {
+		srv.loopWG.Add(1)
+		go srv.dnsDiscoveryLoop()
+	}
 	srv.running = true
 	return nil
 }
@@ -528,11 +611,142 @@ func (srv *Server) startListening() error {
 	return nil
 }
 
+// setupRecord creates the signed record of the local node from the discovery and
+// listener endpoints and hands it to the discovery table. If a NAT is configured,
+// the record is kept up to date with its external IP address.
+func (srv *Server) setupRecord(realaddr *net.UDPAddr) error {
+	var attrs []enr.Entry
+	for _, p := range srv.Protocols {
+		attrs = append(attrs, p.Attributes...)
+	}
+	srv.record = newLocalRecord(srv.PrivateKey, attrs)
+
+	var (
+		ip       net.IP
+		tcp, udp int
+	)
+	if srv.listener != nil {
+		laddr := srv.listener.Addr().(*net.TCPAddr)
+		ip, tcp = laddr.IP, laddr.Port
+	}
+	if realaddr != nil {
+		ip, udp = realaddr.IP, realaddr.Port
+	}
+	record, _, err := srv.record.update(ip, tcp, udp)
+	if err != nil {
+		return err
+	}
+	if srv.ntab != nil {
+		srv.ntab.SetRecord(record)
+	}
+	if srv.NAT != nil {
+		srv.loopWG.Add(1)
+		go srv.recordNATLoop(tcp, udp)
+	}
+	return nil
+}
+
+// recordNATLoop periodically checks the external IP address of the NAT, updating
+// the local node record if it changed.
+func (srv *Server) recordNATLoop(tcp, udp int) {
+	defer srv.loopWG.Done()
+
+	ticker := time.NewTicker(recordNATInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ext, err := srv.NAT.ExternalIP()
+			if err != nil {
+				srv.log.Debug("Failed to retrieve external IP", "err", err)
+				continue
+			}
+			record, changed, err := srv.record.update(ext, tcp, udp)
+			if err != nil {
+				srv.log.Warn("Failed to update local node record", "err", err)
+				continue
+			}
+			if changed {
+				srv.log.Info("Updated local node record", "seq", record.Seq(), "ip", ext)
+				if srv.ntab != nil {
+					srv.ntab.SetRecord(record)
+				}
+			}
+		case <-srv.quit:
+			return
+		}
+	}
+}
+
+// reputationLoop periodically drops the expired entries of the reputation store.
+func (srv *Server) reputationLoop() {
+	defer srv.loopWG.Done()
+
+	ticker := time.NewTicker(reputationPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if pruned := srv.reputation.prune(); pruned > 0 {
+				srv.log.Trace("Pruned expired node scores", "count", pruned)
+			}
+		case <-srv.quit:
+			return
+		}
+	}
+}
+
+// NodeRecord returns the signed record of the local node, or nil if the server
+// is not running.
+func (srv *Server) NodeRecord() *enr.Record {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	if !srv.running || srv.record == nil {
+		return nil
+	}
+	return srv.record.Record()
+}
+
+// dnsDiscoveryLoop periodically syncs the configured DNS node lists and hands
+// the nodes found to the dialer.
+func (srv *Server) dnsDiscoveryLoop() {
+	defer srv.loopWG.Done()
+
+	client := dnsdisc.NewClient(dnsdisc.Config{Logger: srv.log})
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			nodes, err := client.SyncTrees(srv.DNSDiscoveryURLs...)
+			if err != nil {
+				srv.log.Warn("Failed to sync DNS node lists", "err", err)
+			}
+			srv.log.Debug("Synced DNS node lists", "nodes", len(nodes))
+			if len(nodes) > 0 {
+				select {
+				case srv.dnsnodes <- nodes:
+				case <-srv.quit:
+					return
+				}
+			}
+			timer.Reset(dnsSyncInterval)
+		case <-srv.quit:
+			return
+		}
+	}
+}
+
 type dialer interface {
 	newTasks(running int, peers map[discover.NodeID]*Peer, now time.Time) []task
 	taskDone(task, time.Time)
 	addStatic(*discover.Node)
 	removeStatic(*discover.Node)
+	setDNSNodes([]*discover.Node)
 }
 
 func (srv *Server) run(dialstate dialer) {
@@ -605,6 +819,10 @@ running:
 			if p, ok := peers[n.ID]; ok {
 				p.Disconnect(DiscRequested)
 			}
+		case nodes := <-srv.dnsnodes:
+			// This channel is used by the DNS discovery loop to
+			// deliver the nodes of freshly synced node lists.
+			dialstate.setDNSNodes(nodes)
 		case op := <-srv.peerOp:
 			// This channel is used by Peers and PeerCount.
 			op(peers)
@@ -636,6 +854,7 @@ running:
 			if err == nil {
 				// The handshakes are done and it passed all checks.
 				p := newPeer(c, srv.Protocols)
+				p.rep = srv.reputation
 				// If message events are enabled, pass the peerFeed
 				// to the peer
 				if srv.EnableMsgEvents {
@@ -689,6 +908,9 @@ running:
 		p.log.Trace("<-delpeer (spindown)", "remainingTasks", len(runningTasks))
 		delete(peers, p.ID())
 	}
+	if srv.reputation != nil {
+		srv.reputation.close()
+	}
 }
 
 func (srv *Server) protoHandshakeChecks(peers map[discover.NodeID]*Peer, inboundCount int, c *conn) error {
@@ -711,6 +933,8 @@ func (srv *Server) encHandshakeChecks(peers map[discover.NodeID]*Peer, inboundCo
 		return DiscAlreadyConnected
 	case c.id == srv.Self().ID:
 		return DiscSelf
+	case !c.is(trustedConn) && srv.reputation.banned(c.id):
+		return DiscUselessPeer
 	default:
 		return nil
 	}
@@ -721,7 +945,7 @@ func (srv *Server) maxInboundConns() int {
 }
 
 func (srv *Server) maxDialedConns() int {
-	if srv.NoDiscovery || srv.NoDial {
+	if srv.NoDial || (srv.NoDiscovery && len(srv.DNSDiscoveryURLs) == 0) {
 		return 0
 	}
 	r := srv.DialRatio
@@ -907,10 +1131,11 @@ func (srv *Server) runPeer(p *Peer) {
 
 // NodeInfo represents a short summary of the information known about the host.
 type NodeInfo struct {
-	ID    string `json:"id"`    // Unique node identifier (also the encryption key)
-	Name  string `json:"name"`  // Name of the node, including client type, version, OS, custom data
-	Enode string `json:"enode"` // Enode URL for adding this peer from remote peers
-	IP    string `json:"ip"`    // IP address of the node
+	ID    string `json:"id"`            // Unique node identifier (also the encryption key)
+	Name  string `json:"name"`          // Name of the node, including client type, version, OS, custom data
+	Enode string `json:"enode"`         // Enode URL for adding this peer from remote peers
+	ENR   string `json:"enr,omitempty"` // Signed node record, as used in DNS node lists
+	IP    string `json:"ip"`            // IP address of the node
 	Ports struct {
 		Discovery int `json:"discovery"` // UDP listening port for discovery protocol
 		Listener  int `json:"listener"`  // TCP listening port for RLPx
@@ -934,6 +1159,9 @@ func (srv *Server) NodeInfo() *NodeInfo {
 	}
 	info.Ports.Discovery = int(node.UDP)
 	info.Ports.Listener = int(node.TCP)
+	if record := srv.NodeRecord(); record != nil {
+		info.ENR = dnsdisc.EncodeRecord(record)
+	}
 
 	// Gather all the running protocol infos (only once per protocol type)
 	for _, proto := range srv.Protocols {
@@ -309,6 +309,8 @@ func (tg taskgen) addStatic(*discover.Node) {
 }
 func (tg taskgen) removeStatic(*discover.Node) {
 }
+func (tg taskgen) setDNSNodes([]*discover.Node) {
+}
 
 type testTask struct {
 	index  int
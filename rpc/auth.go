@@ -0,0 +1,188 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	// errMissingToken is returned if a request carries no bearer token.
+	errMissingToken = errors.New("missing bearer token")
+
+	// errInvalidToken is returned if a request's bearer token is not accepted.
+	errInvalidToken = errors.New("invalid bearer token")
+
+	// errMissingIssuedAt is returned if a JWT doesn't carry its issuance time.
+	errMissingIssuedAt = errors.New("missing issued at claim")
+
+	// errStaleToken is returned if a JWT was issued too far from the current time.
+	errStaleToken = errors.New("stale token")
+)
+
+// jwtIssuedAtWindow is the maximum allowed difference between the issuance time
+// of a JWT and the local time. Tokens are expected to be minted per request, so
+// a captured one can't be replayed for longer than this.
+const jwtIssuedAtWindow = 5 * time.Second
+
+// Authenticator verifies the credentials of an incoming HTTP request (or
+// websocket handshake) before any of its RPC calls are dispatched.
+type Authenticator interface {
+	// Authenticate returns an error if the request is not authorized.
+	Authenticate(r *http.Request) error
+}
+
+// bearerToken extracts the bearer token from the Authorization header.
+func bearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "bearer ") {
+		return "", errMissingToken
+	}
+	return strings.TrimSpace(header[7:]), nil
+}
+
+// tokenAuthenticator accepts requests bearing one of a set of static tokens.
+type tokenAuthenticator struct {
+	tokens [][]byte
+}
+
+// NewTokenAuthenticator creates an authenticator which accepts requests bearing
+// any of the given static tokens.
+func NewTokenAuthenticator(tokens []string) Authenticator {
+	auth := new(tokenAuthenticator)
+	for _, token := range tokens {
+		auth.tokens = append(auth.tokens, []byte(token))
+	}
+	return auth
+}
+
+// Authenticate implements Authenticator, checking the request's bearer token
+// against the accepted ones in constant time.
+func (a *tokenAuthenticator) Authenticate(r *http.Request) error {
+	token, err := bearerToken(r)
+	if err != nil {
+		return err
+	}
+	for _, accepted := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), accepted) == 1 {
+			return nil
+		}
+	}
+	return errInvalidToken
+}
+
+// jwtAuthenticator accepts requests bearing a JWT signed with a shared secret.
+type jwtAuthenticator struct {
+	secret []byte
+}
+
+// NewJWTAuthenticator creates an authenticator which accepts requests bearing a
+// JSON Web Token signed by the given HMAC secret. Tokens must carry an issued at
+// claim within jwtIssuedAtWindow of the local time, the standard expiry and not
+// before claims are enforced if present.
+func NewJWTAuthenticator(secret []byte) Authenticator {
+	return &jwtAuthenticator{secret: secret}
+}
+
+// Authenticate implements Authenticator, verifying the signature and the claims
+// of the request's bearer token.
+func (a *jwtAuthenticator) Authenticate(r *http.Request) error {
+	token, err := bearerToken(r)
+	if err != nil {
+		return err
+	}
+	// Verify the signature, the claims are validated below to allow for clock skew
+	parser := &jwt.Parser{ValidMethods: []string{"HS256", "HS384", "HS512"}, SkipClaimsValidation: true}
+	keyfunc := func(*jwt.Token) (interface{}, error) { return a.secret, nil }
+
+	claims := new(jwt.StandardClaims)
+	if _, err := parser.ParseWithClaims(token, claims, keyfunc); err != nil {
+		return fmt.Errorf("%v: %v", errInvalidToken, err)
+	}
+	now := time.Now()
+	switch {
+	case claims.IssuedAt == 0:
+		return fmt.Errorf("%v: %v", errInvalidToken, errMissingIssuedAt)
+	case time.Unix(claims.IssuedAt, 0).Before(now.Add(-jwtIssuedAtWindow)), time.Unix(claims.IssuedAt, 0).After(now.Add(jwtIssuedAtWindow)):
+		return fmt.Errorf("%v: %v", errInvalidToken, errStaleToken)
+	case !claims.VerifyExpiresAt(now.Unix(), false):
+		return fmt.Errorf("%v: token is expired", errInvalidToken)
+	case !claims.VerifyNotBefore(now.Unix(), false):
+		return fmt.Errorf("%v: token is not valid yet", errInvalidToken)
+	}
+	return nil
+}
+
+// multiAuthenticator accepts requests accepted by any of its authenticators.
+type multiAuthenticator []Authenticator
+
+// AnyAuthenticator creates an authenticator which accepts requests accepted by
+// any of the given authenticators.
+func AnyAuthenticator(auths ...Authenticator) Authenticator {
+	return multiAuthenticator(auths)
+}
+
+// Authenticate implements Authenticator, returning the error of the last of the
+// authenticators if none of them accepted the request.
+func (m multiAuthenticator) Authenticate(r *http.Request) error {
+	err := errMissingToken
+	for _, auth := range m {
+		if err = auth.Authenticate(r); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// authHandler is a handler which authenticates incoming requests before passing
+// them to the wrapped handler.
+type authHandler struct {
+	auth Authenticator
+	next http.Handler
+}
+
+// ServeHTTP serves JSON-RPC requests over HTTP, implements http.Handler
+func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Permit dumb empty requests for remote health-checks, they execute nothing
+	if r.Method == http.MethodGet && r.ContentLength == 0 && r.URL.RawQuery == "" && r.Header.Get("Upgrade") == "" {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	if err := h.auth.Authenticate(r); err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// newAuthHandler wraps a handler with request authentication, or returns the
+// handler as is if no authenticator is given. CORS preflight requests carry no
+// credentials, so the CORS handler must be layered on top of this one.
+func newAuthHandler(auth Authenticator, next http.Handler) http.Handler {
+	if auth == nil {
+		return next
+	}
+	return &authHandler{auth: auth, next: next}
+}
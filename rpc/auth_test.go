@@ -0,0 +1,93 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// signToken creates a JWT with the given claims, signed by the given secret.
+func signToken(t *testing.T, method jwt.SigningMethod, secret []byte, claims jwt.Claims) string {
+	token, err := jwt.NewWithClaims(method, claims).SignedString(secret)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return token
+}
+
+// Tests that the HTTP server only dispatches requests with valid credentials.
+func TestHTTPAuthentication(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	auth := AnyAuthenticator(
+		NewTokenAuthenticator([]string{"static-token"}),
+		NewJWTAuthenticator(secret),
+	)
+	server := NewServer()
+	defer server.Stop()
+
+	handler := NewAuthenticatedHTTPServer(nil, []string{"*"}, auth, server).Handler
+
+	now := time.Now().Unix()
+	tests := []struct {
+		header string
+		code   int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Basic Zm9vOmJhcg==", http.StatusUnauthorized},
+		{"Bearer static-token", http.StatusOK},
+		{"bearer static-token", http.StatusOK},
+		{"Bearer other-token", http.StatusUnauthorized},
+		{"Bearer " + signToken(t, jwt.SigningMethodHS256, secret, jwt.StandardClaims{IssuedAt: now}), http.StatusOK},
+		{"Bearer " + signToken(t, jwt.SigningMethodHS256, secret, jwt.StandardClaims{IssuedAt: now + 2}), http.StatusOK},
+		{"Bearer " + signToken(t, jwt.SigningMethodHS512, secret, jwt.StandardClaims{IssuedAt: now, ExpiresAt: now + 60}), http.StatusOK},
+		{"Bearer " + signToken(t, jwt.SigningMethodHS512, secret, jwt.StandardClaims{ExpiresAt: now + 60}), http.StatusUnauthorized},
+		{"Bearer " + signToken(t, jwt.SigningMethodHS256, secret, jwt.StandardClaims{}), http.StatusUnauthorized},
+		{"Bearer " + signToken(t, jwt.SigningMethodHS256, secret, jwt.StandardClaims{IssuedAt: now - 60}), http.StatusUnauthorized},
+		{"Bearer " + signToken(t, jwt.SigningMethodHS256, secret, jwt.StandardClaims{IssuedAt: now + 60}), http.StatusUnauthorized},
+		{"Bearer " + signToken(t, jwt.SigningMethodHS256, secret, jwt.StandardClaims{IssuedAt: now, ExpiresAt: now - 1}), http.StatusUnauthorized},
+		{"Bearer " + signToken(t, jwt.SigningMethodHS256, secret, jwt.StandardClaims{IssuedAt: now, NotBefore: now + 60}), http.StatusUnauthorized},
+		{"Bearer " + signToken(t, jwt.SigningMethodHS256, []byte("wrong secret"), jwt.StandardClaims{IssuedAt: now}), http.StatusUnauthorized},
+	}
+	for i, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"rpc_modules"}`))
+		req.Header.Set("content-type", contentType)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.code {
+			t.Errorf("test %d: response code mismatch: have %d, want %d (%s)", i, rec.Code, tt.code, rec.Body.String())
+		}
+		if tt.code == http.StatusOK && !strings.Contains(rec.Body.String(), `"rpc"`) {
+			t.Errorf("test %d: unexpected response: %s", i, rec.Body.String())
+		}
+	}
+	// Empty health-check requests execute nothing, they shouldn't need credentials
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("health-check response code mismatch: have %d, want %d", rec.Code, http.StatusOK)
+	}
+}
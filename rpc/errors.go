@@ -64,3 +64,10 @@ type shutdownError struct{}
 func (e *shutdownError) ErrorCode() int { return -32000 }
 
 func (e *shutdownError) Error() string { return "server is shutting down" }
+
+// issued when a request or batch exceeds one of the quotas of the server.
+type limitExceededError struct{ message string }
+
+func (e *limitExceededError) ErrorCode() int { return -32005 }
+
+func (e *limitExceededError) Error() string { return e.message }
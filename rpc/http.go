@@ -150,8 +150,16 @@ func (t *httpReadWriteNopCloser) Close() error {
 //
 // Deprecated: Server implements http.Handler
 func NewHTTPServer(cors []string, vhosts []string, srv *Server) *http.Server {
-	// Wrap the CORS-handler within a host-handler
-	handler := newCorsHandler(srv, cors)
+	return NewAuthenticatedHTTPServer(cors, vhosts, nil, srv)
+}
+
+// NewAuthenticatedHTTPServer creates a new HTTP RPC server around an API provider,
+// which only dispatches requests accepted by the given authenticator. A nil
+// authenticator accepts all requests.
+func NewAuthenticatedHTTPServer(cors []string, vhosts []string, auth Authenticator, srv *Server) *http.Server {
+	// Wrap the authenticator within the CORS-handler within a host-handler
+	handler := newAuthHandler(auth, srv)
+	handler = newCorsHandler(handler, cors)
 	handler = newVHostHandler(vhosts, handler)
 	return &http.Server{Handler: handler}
 }
@@ -174,7 +182,13 @@ func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer codec.Close()
 
 	w.Header().Set("content-type", contentType)
-	srv.ServeSingleRequest(codec, OptionMethodInvocation)
+
+	// Every HTTP request is a new connection, so rate limit by remote host instead
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	srv.serveRequest(codec, true, OptionMethodInvocation, srv.limits.hostBucket(host))
 }
 
 // validateRequest returns a non-zero response code and error message if the
@@ -195,7 +209,7 @@ func validateRequest(r *http.Request) (int, error) {
 	return 0, nil
 }
 
-func newCorsHandler(srv *Server, allowedOrigins []string) http.Handler {
+func newCorsHandler(srv http.Handler, allowedOrigins []string) http.Handler {
 	// disable CORS support if user has not specified a custom CORS configuration
 	if len(allowedOrigins) == 0 {
 		return srv
@@ -0,0 +1,180 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// maxTrackedHosts is the number of remote hosts whose request rates are tracked
+// before idle ones are pruned.
+const maxTrackedHosts = 4096
+
+// Limits configures the resource quotas enforced by a server. The zero value of
+// any field disables the respective quota.
+type Limits struct {
+	// BatchItems is the maximum number of requests allowed in a single batch.
+	BatchItems int
+
+	// BatchResponseSize is the maximum number of bytes a batch response may take
+	// up. Requests of the batch past the limit are not executed.
+	BatchResponseSize int
+
+	// ConnRate is the number of requests per second a single connection may issue.
+	// For HTTP, where every request is a new connection, the quota is enforced per
+	// remote host instead.
+	ConnRate float64
+
+	// MethodRates is the number of requests per second allowed for individual
+	// methods (e.g. "eth_getLogs"), across all connections.
+	MethodRates map[string]float64
+}
+
+// tokenBucket is a simple token bucket rate limiter. Its burst size is the rate
+// itself, rounded up, so a full second worth of requests can be issued at once.
+type tokenBucket struct {
+	rate   float64 // Number of tokens refilled per second
+	burst  float64 // Maximum number of tokens the bucket can hold
+	tokens float64 // Number of tokens currently available
+	last   time.Time
+	lock   sync.Mutex
+}
+
+// newTokenBucket creates a full token bucket refilling at the given rate.
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := math.Max(1, math.Ceil(rate))
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// refill tops up the bucket with the tokens accumulated since the last refill.
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// allow consumes a token if one is available, reporting whether it succeeded.
+func (b *tokenBucket) allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// idle reports whether the bucket is full, meaning it holds no state worth
+// tracking anymore.
+func (b *tokenBucket) idle(now time.Time) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// serverLimits is the runtime state of the quotas enforced by a server.
+type serverLimits struct {
+	Limits
+
+	methods map[string]*tokenBucket // Rate limiters of the individual methods
+	hosts   map[string]*tokenBucket // Rate limiters of the remote HTTP hosts
+	lock    sync.Mutex              // Protects the remote host limiters
+}
+
+// newServerLimits creates the runtime state for the given quotas.
+func newServerLimits(limits Limits) *serverLimits {
+	l := &serverLimits{
+		Limits:  limits,
+		methods: make(map[string]*tokenBucket),
+		hosts:   make(map[string]*tokenBucket),
+	}
+	for method, rate := range limits.MethodRates {
+		if rate > 0 {
+			l.methods[method] = newTokenBucket(rate)
+		}
+	}
+	return l
+}
+
+// connBucket creates a rate limiter for a new connection, or nil if connections
+// are not rate limited.
+func (l *serverLimits) connBucket() *tokenBucket {
+	if l == nil || l.ConnRate <= 0 {
+		return nil
+	}
+	return newTokenBucket(l.ConnRate)
+}
+
+// hostBucket retrieves the rate limiter of a remote host, creating it if it's
+// not yet tracked, or nil if connections are not rate limited.
+func (l *serverLimits) hostBucket(host string) *tokenBucket {
+	if l == nil || l.ConnRate <= 0 {
+		return nil
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if bucket, ok := l.hosts[host]; ok {
+		return bucket
+	}
+	// Drop any hosts which are not rate limited anymore if too many are tracked
+	if len(l.hosts) >= maxTrackedHosts {
+		now := time.Now()
+		for host, bucket := range l.hosts {
+			if bucket.idle(now) {
+				delete(l.hosts, host)
+			}
+		}
+	}
+	bucket := newTokenBucket(l.ConnRate)
+	l.hosts[host] = bucket
+	return bucket
+}
+
+// checkBatch verifies that a batch of the given length is within the quotas.
+func (l *serverLimits) checkBatch(items int) Error {
+	if l == nil || l.BatchItems <= 0 || items <= l.BatchItems {
+		return nil
+	}
+	return &limitExceededError{fmt.Sprintf("batch too large (%d>%d)", items, l.BatchItems)}
+}
+
+// checkRequest consumes the quotas of a single request, returning an error if
+// either the connection's or the method's rate limit was exceeded.
+func (l *serverLimits) checkRequest(conn *tokenBucket, method string) Error {
+	if l == nil {
+		return nil
+	}
+	if conn != nil && !conn.allow() {
+		return &limitExceededError{"connection request rate exceeded"}
+	}
+	if bucket, ok := l.methods[method]; ok && !bucket.allow() {
+		return &limitExceededError{fmt.Sprintf("request rate of %s exceeded", method)}
+	}
+	return nil
+}
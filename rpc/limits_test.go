@@ -0,0 +1,174 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// limitedResponse is a JSON-RPC response with either a result or an error.
+type limitedResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *jsonError      `json:"error"`
+}
+
+// newLimitedServer starts a server with the given limits and returns a raw JSON
+// connection to it.
+func newLimitedServer(t *testing.T, limits Limits) (*json.Encoder, *json.Decoder, func()) {
+	server := NewServer()
+	server.SetLimits(limits)
+	if err := server.RegisterName("test", new(Service)); err != nil {
+		t.Fatal(err)
+	}
+	clientConn, serverConn := net.Pipe()
+	go server.ServeCodec(NewJSONCodec(serverConn), OptionMethodInvocation)
+
+	return json.NewEncoder(clientConn), json.NewDecoder(clientConn), func() { clientConn.Close() }
+}
+
+// echoBatch creates a batch of echo requests of the given size.
+func echoBatch(items int) []map[string]interface{} {
+	batch := make([]map[string]interface{}, items)
+	for i := range batch {
+		batch[i] = map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      i,
+			"method":  "test_echo",
+			"params":  []interface{}{"hello", i, &Args{"world"}},
+		}
+	}
+	return batch
+}
+
+// Tests that batches larger than the configured limit are rejected.
+func TestServerBatchLimit(t *testing.T) {
+	out, in, close := newLimitedServer(t, Limits{BatchItems: 2})
+	defer close()
+
+	// A batch within the limit should be executed
+	if err := out.Encode(echoBatch(2)); err != nil {
+		t.Fatal(err)
+	}
+	var resps []limitedResponse
+	if err := in.Decode(&resps); err != nil {
+		t.Fatal(err)
+	}
+	if len(resps) != 2 || resps[0].Error != nil || resps[1].Error != nil {
+		t.Fatalf("unexpected batch response: %+v", resps)
+	}
+	// A batch exceeding the limit should be rejected as a whole
+	if err := out.Encode(echoBatch(3)); err != nil {
+		t.Fatal(err)
+	}
+	var resp limitedResponse
+	if err := in.Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error == nil || resp.Error.Code != -32005 {
+		t.Fatalf("expected limit exceeded error, got %+v", resp)
+	}
+}
+
+// Tests that batch responses are cut off when growing past the size limit.
+func TestServerBatchResponseSize(t *testing.T) {
+	out, in, close := newLimitedServer(t, Limits{BatchResponseSize: 200})
+	defer close()
+
+	if err := out.Encode(echoBatch(5)); err != nil {
+		t.Fatal(err)
+	}
+	var resps []limitedResponse
+	if err := in.Decode(&resps); err != nil {
+		t.Fatal(err)
+	}
+	if len(resps) != 5 {
+		t.Fatalf("response count mismatch: have %d, want 5", len(resps))
+	}
+	if resps[0].Error != nil {
+		t.Fatalf("first response failed: %v", resps[0].Error)
+	}
+	if resps[4].Error == nil || resps[4].Error.Code != -32005 {
+		t.Fatalf("expected limit exceeded error for last response, got %+v", resps[4])
+	}
+}
+
+// Tests that the connection and method rate limits are enforced.
+func TestServerRateLimits(t *testing.T) {
+	tests := []struct {
+		limits Limits
+		method string
+		allow  int
+	}{
+		{Limits{ConnRate: 3}, "test_echo", 3},
+		{Limits{MethodRates: map[string]float64{"test_echo": 2}}, "test_echo", 2},
+		{Limits{MethodRates: map[string]float64{"test_echo": 2}}, "test_rets", 10},
+	}
+	for i, tt := range tests {
+		out, in, close := newLimitedServer(t, tt.limits)
+
+		allowed := 0
+		for j := 0; j < 10; j++ {
+			req := map[string]interface{}{"jsonrpc": "2.0", "id": j, "method": tt.method, "params": []interface{}{"hello", j, &Args{"world"}}}
+			if tt.method == "test_rets" {
+				req["params"] = []interface{}{}
+			}
+			if err := out.Encode(req); err != nil {
+				t.Fatal(err)
+			}
+			var resp limitedResponse
+			if err := in.Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Error == nil {
+				allowed++
+			} else if resp.Error.Code != -32005 || !strings.Contains(resp.Error.Message, "rate") {
+				t.Errorf("test %d: unexpected error: %v", i, resp.Error)
+			}
+		}
+		close()
+
+		// Tokens are refilled as time passes, so allow for a bit of slack
+		if allowed < tt.allow || allowed > tt.allow+1 {
+			t.Errorf("test %d: allowed request count mismatch: have %d, want %d", i, allowed, tt.allow)
+		}
+	}
+}
+
+// Tests that remote hosts are tracked independently and pruned when idle.
+func TestServerHostBuckets(t *testing.T) {
+	limits := newServerLimits(Limits{ConnRate: 1})
+
+	if !limits.hostBucket("a").allow() {
+		t.Fatalf("first request of host a rejected")
+	}
+	if limits.hostBucket("a").allow() {
+		t.Fatalf("second request of host a accepted")
+	}
+	if !limits.hostBucket("b").allow() {
+		t.Fatalf("first request of host b rejected")
+	}
+	for i := 0; i < maxTrackedHosts; i++ {
+		limits.hostBucket(fmt.Sprintf("host-%d", i))
+	}
+	if len(limits.hosts) > maxTrackedHosts {
+		t.Fatalf("idle hosts not pruned: %d tracked", len(limits.hosts))
+	}
+}
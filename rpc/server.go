@@ -18,6 +18,7 @@ package rpc
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"runtime"
@@ -73,6 +74,12 @@ func (s *RPCService) Modules() map[string]string {
 	return modules
 }
 
+// SetLimits configures the resource quotas enforced by the server. It must be
+// called before the server starts serving requests.
+func (s *Server) SetLimits(limits Limits) {
+	s.limits = newServerLimits(limits)
+}
+
 // RegisterName will create a service for the given rcvr type under the given name. When no methods on the given rcvr
 // match the criteria to be either a RPC method or a subscription an error is returned. Otherwise a new service is
 // created and added to the service collection this server instance serves.
@@ -125,7 +132,9 @@ func (s *Server) RegisterName(name string, rcvr interface{}) error {
 // If singleShot is true it will process a single request, otherwise it will handle
 // requests until the codec returns an error when reading a request (in most cases
 // an EOF). It executes requests in parallel when singleShot is false.
-func (s *Server) serveRequest(codec ServerCodec, singleShot bool, options CodecOption) error {
+//
+// The requests are rate limited using the given bucket, if any.
+func (s *Server) serveRequest(codec ServerCodec, singleShot bool, options CodecOption, bucket *tokenBucket) error {
 	var pend sync.WaitGroup
 
 	defer func() {
@@ -186,12 +195,22 @@ func (s *Server) serveRequest(codec ServerCodec, singleShot bool, options CodecO
 			}
 			return nil
 		}
+		// Reject the batch outright if it's too large to be served
+		if batch {
+			if err := s.limits.checkBatch(len(reqs)); err != nil {
+				codec.Write(codec.CreateErrorResponse(nil, err))
+				if singleShot {
+					return nil
+				}
+				continue
+			}
+		}
 		// If a single shot request is executing, run and return immediately
 		if singleShot {
 			if batch {
-				s.execBatch(ctx, codec, reqs)
+				s.execBatch(ctx, codec, reqs, bucket)
 			} else {
-				s.exec(ctx, codec, reqs[0])
+				s.exec(ctx, codec, reqs[0], bucket)
 			}
 			return nil
 		}
@@ -201,9 +220,9 @@ func (s *Server) serveRequest(codec ServerCodec, singleShot bool, options CodecO
 		go func(reqs []*serverRequest, batch bool) {
 			defer pend.Done()
 			if batch {
-				s.execBatch(ctx, codec, reqs)
+				s.execBatch(ctx, codec, reqs, bucket)
 			} else {
-				s.exec(ctx, codec, reqs[0])
+				s.exec(ctx, codec, reqs[0], bucket)
 			}
 		}(reqs, batch)
 	}
@@ -215,14 +234,14 @@ func (s *Server) serveRequest(codec ServerCodec, singleShot bool, options CodecO
 // stopped. In either case the codec is closed.
 func (s *Server) ServeCodec(codec ServerCodec, options CodecOption) {
 	defer codec.Close()
-	s.serveRequest(codec, false, options)
+	s.serveRequest(codec, false, options, s.limits.connBucket())
 }
 
 // ServeSingleRequest reads and processes a single RPC request from the given codec. It will not
 // close the codec unless a non-recoverable error has occurred. Note, this method will return after
 // a single request has been processed!
 func (s *Server) ServeSingleRequest(codec ServerCodec, options CodecOption) {
-	s.serveRequest(codec, true, options)
+	s.serveRequest(codec, true, options, nil)
 }
 
 // Stop will stop reading new requests, wait for stopPendingRequestTimeout to allow pending requests to finish,
@@ -324,11 +343,13 @@ func (s *Server) handle(ctx context.Context, codec ServerCodec, req *serverReque
 }
 
 // exec executes the given request and writes the result back using the codec.
-func (s *Server) exec(ctx context.Context, codec ServerCodec, req *serverRequest) {
+func (s *Server) exec(ctx context.Context, codec ServerCodec, req *serverRequest, bucket *tokenBucket) {
 	var response interface{}
 	var callback func()
 	if req.err != nil {
 		response = codec.CreateErrorResponse(&req.id, req.err)
+	} else if err := s.limits.checkRequest(bucket, req.method); err != nil {
+		response = codec.CreateErrorResponse(&req.id, err)
 	} else {
 		response, callback = s.handle(ctx, codec, req)
 	}
@@ -346,15 +367,39 @@ func (s *Server) exec(ctx context.Context, codec ServerCodec, req *serverRequest
 
 // execBatch executes the given requests and writes the result back using the codec.
 // It will only write the response back when the last request is processed.
-func (s *Server) execBatch(ctx context.Context, codec ServerCodec, requests []*serverRequest) {
+//
+// If the batch response grows past the configured size limit, the remaining
+// requests are not executed, but answered with an error instead.
+func (s *Server) execBatch(ctx context.Context, codec ServerCodec, requests []*serverRequest, bucket *tokenBucket) {
 	responses := make([]interface{}, len(requests))
-	var callbacks []func()
+	var (
+		callbacks []func()
+		size      int
+	)
 	for i, req := range requests {
 		if req.err != nil {
 			responses[i] = codec.CreateErrorResponse(&req.id, req.err)
+		} else if err := s.limits.checkRequest(bucket, req.method); err != nil {
+			responses[i] = codec.CreateErrorResponse(&req.id, err)
 		} else {
 			var callback func()
-			if responses[i], callback = s.handle(ctx, codec, req); callback != nil {
+			responses[i], callback = s.handle(ctx, codec, req)
+
+			// Enforce the response size limit, dropping anything past it
+			if s.limits != nil && s.limits.BatchResponseSize > 0 {
+				blob, err := json.Marshal(responses[i])
+				if err == nil {
+					responses[i], size = json.RawMessage(blob), size+len(blob)
+				}
+				if err != nil || size > s.limits.BatchResponseSize {
+					err := &limitExceededError{fmt.Sprintf("batch response too large (limit %d bytes)", s.limits.BatchResponseSize)}
+					for j := i; j < len(requests); j++ {
+						responses[j] = codec.CreateErrorResponse(&requests[j].id, err)
+					}
+					break
+				}
+			}
+			if callback != nil {
 				callbacks = append(callbacks, callback)
 			}
 		}
@@ -393,7 +438,7 @@ func (s *Server) readRequest(codec ServerCodec) ([]*serverRequest, bool, Error)
 		}
 
 		if r.isPubSub && strings.HasSuffix(r.method, unsubscribeMethodSuffix) {
-			requests[i] = &serverRequest{id: r.id, isUnsubscribe: true}
+			requests[i] = &serverRequest{id: r.id, method: r.method, isUnsubscribe: true}
 			argTypes := []reflect.Type{reflect.TypeOf("")} // expect subscription id as first arg
 			if args, err := codec.ParseRequestArguments(argTypes, r.params); err == nil {
 				requests[i].args = args
@@ -410,7 +455,7 @@ func (s *Server) readRequest(codec ServerCodec) ([]*serverRequest, bool, Error)
 
 		if r.isPubSub { // eth_subscribe, r.method contains the subscription method name
 			if callb, ok := svc.subscriptions[r.method]; ok {
-				requests[i] = &serverRequest{id: r.id, svcname: svc.name, callb: callb}
+				requests[i] = &serverRequest{id: r.id, svcname: svc.name, method: r.service + subscribeMethodSuffix, callb: callb}
 				if r.params != nil && len(callb.argTypes) > 0 {
 					argTypes := []reflect.Type{reflect.TypeOf("")}
 					argTypes = append(argTypes, callb.argTypes...)
@@ -427,7 +472,7 @@ func (s *Server) readRequest(codec ServerCodec) ([]*serverRequest, bool, Error)
 		}
 
 		if callb, ok := svc.callbacks[r.method]; ok { // lookup RPC method
-			requests[i] = &serverRequest{id: r.id, svcname: svc.name, callb: callb}
+			requests[i] = &serverRequest{id: r.id, svcname: svc.name, method: r.service + serviceMethodSeparator + r.method, callb: callb}
 			if r.params != nil && len(callb.argTypes) > 0 {
 				if args, err := codec.ParseRequestArguments(callb.argTypes, r.params); err == nil {
 					requests[i].args = args
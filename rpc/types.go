@@ -57,6 +57,7 @@ type service struct {
 type serverRequest struct {
 	id            interface{}
 	svcname       string
+	method        string // Full name of the invoked method, used for rate limiting
 	callb         *callback
 	args          []reflect.Value
 	isUnsubscribe bool
@@ -70,6 +71,7 @@ type subscriptions map[string]*callback  // collection of subscription callbacks
 // Server represents a RPC server
 type Server struct {
 	services serviceRegistry
+	limits   *serverLimits
 
 	run      int32
 	codecsMu sync.Mutex
@@ -80,6 +80,13 @@ func NewWSServer(allowedOrigins []string, srv *Server) *http.Server {
 	return &http.Server{Handler: srv.WebsocketHandler(allowedOrigins)}
 }
 
+// NewAuthenticatedWSServer creates a new websocket RPC server around an API
+// provider, which only accepts connections whose handshake is accepted by the
+// given authenticator. A nil authenticator accepts all connections.
+func NewAuthenticatedWSServer(allowedOrigins []string, auth Authenticator, srv *Server) *http.Server {
+	return &http.Server{Handler: newAuthHandler(auth, srv.WebsocketHandler(allowedOrigins))}
+}
+
 // wsHandshakeValidator returns a handler that verifies the origin during the
 // websocket upgrade process. When a '*' is specified as an allowed origins all
 // connections are accepted.
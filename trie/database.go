@@ -217,6 +217,11 @@ func (db *Database) dereference(child common.Hash, parent common.Hash) {
 	// Dereference the parent-child
 	node := db.nodes[parent]
 
+	// If the reference was never taken (e.g. the child was already flushed to
+	// disk at the time), there's nothing to release
+	if node.children[child] == 0 {
+		return
+	}
 	node.children[child]--
 	if node.children[child] == 0 {
 		delete(node.children, child)
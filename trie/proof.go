@@ -18,6 +18,7 @@ package trie
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -114,7 +115,7 @@ func VerifyProof(rootHash common.Hash, key []byte, proofDb DatabaseReader) (valu
 		if err != nil {
 			return nil, fmt.Errorf("bad proof node %d: %v", i, err), i
 		}
-		keyrest, cld := get(n, key)
+		keyrest, cld := get(n, key, true)
 		switch cld := cld.(type) {
 		case nil:
 			// The trie doesn't contain the key.
@@ -128,7 +129,363 @@ func VerifyProof(rootHash common.Hash, key []byte, proofDb DatabaseReader) (valu
 	}
 }
 
-func get(tn node, key []byte) ([]byte, node) {
+// proofToPath converts a merkle proof to a trie node path, resolving all the
+// nodes on the path to key from the proof and leaving the others as hash nodes.
+// If root is given, the path is merged into the already resolved nodes.
+//
+// If allowNonExistent is set, the proof may also prove the absence of the key.
+func proofToPath(rootHash common.Hash, root node, key []byte, proofDb DatabaseReader, allowNonExistent bool) (node, []byte, error) {
+	// resolveNode retrieves and decodes a trie node from the proof
+	resolveNode := func(hash common.Hash) (node, error) {
+		buf, _ := proofDb.Get(hash[:])
+		if buf == nil {
+			return nil, fmt.Errorf("proof node (hash %064x) missing", hash)
+		}
+		n, err := decodeNode(hash[:], buf, 0)
+		if err != nil {
+			return nil, fmt.Errorf("bad proof node: %v", err)
+		}
+		return n, nil
+	}
+	// The root node must always be included in the proof
+	if root == nil {
+		n, err := resolveNode(rootHash)
+		if err != nil {
+			return nil, nil, err
+		}
+		root = n
+	}
+	var (
+		err           error
+		child, parent node
+		keyrest       []byte
+		valnode       []byte
+	)
+	key, parent = keybytesToHex(key), root
+	for {
+		keyrest, child = get(parent, key, false)
+		switch cld := child.(type) {
+		case nil:
+			// The trie doesn't contain the key. All the resolved nodes are
+			// nonetheless proven, which is enough to prove a range.
+			if allowNonExistent {
+				return root, nil, nil
+			}
+			return nil, nil, errors.New("the node is not contained in trie")
+		case *shortNode, *fullNode:
+			// Already resolved (embedded or merged from a previous path)
+			key, parent = keyrest, child
+			continue
+		case hashNode:
+			child, err = resolveNode(common.BytesToHash(cld))
+			if err != nil {
+				return nil, nil, err
+			}
+		case valueNode:
+			valnode = cld
+		}
+		// Link the resolved child into its parent
+		switch pnode := parent.(type) {
+		case *shortNode:
+			pnode.Val = child
+		case *fullNode:
+			pnode.Children[key[0]] = child
+		default:
+			panic(fmt.Sprintf("%T: invalid node: %v", pnode, pnode))
+		}
+		if len(valnode) > 0 {
+			return root, valnode, nil
+		}
+		key, parent = keyrest, child
+	}
+}
+
+// unsetInternal removes all the nodes between the two edge paths of a trie
+// constructed by proofToPath, so that the range in between can be refilled from
+// the leaves. All the nodes on the edge paths are marked dirty, as their content
+// changes. The edge keys must be different, with right being the larger one.
+//
+// It reports whether the whole trie is inside the range and must be dropped.
+func unsetInternal(n node, left []byte, right []byte) (bool, error) {
+	left, right = keybytesToHex(left), keybytesToHex(right)
+
+	// Step down to the fork point of the two paths. It's either a short node
+	// whose key doesn't match one of the paths, or a full node in which the
+	// paths continue with different children (which may be missing).
+	var (
+		pos    = 0
+		parent node
+
+		// Fork indicators: 0 if the path matches the short node's key, -1 if it
+		// is smaller and 1 if it is larger.
+		shortForkLeft, shortForkRight int
+	)
+findFork:
+	for {
+		switch rn := n.(type) {
+		case *shortNode:
+			rn.flags = nodeFlag{dirty: true}
+
+			if len(left)-pos < len(rn.Key) {
+				shortForkLeft = bytes.Compare(left[pos:], rn.Key)
+			} else {
+				shortForkLeft = bytes.Compare(left[pos:pos+len(rn.Key)], rn.Key)
+			}
+			if len(right)-pos < len(rn.Key) {
+				shortForkRight = bytes.Compare(right[pos:], rn.Key)
+			} else {
+				shortForkRight = bytes.Compare(right[pos:pos+len(rn.Key)], rn.Key)
+			}
+			if shortForkLeft != 0 || shortForkRight != 0 {
+				break findFork
+			}
+			parent = n
+			n, pos = rn.Val, pos+len(rn.Key)
+		case *fullNode:
+			rn.flags = nodeFlag{dirty: true}
+
+			leftnode, rightnode := rn.Children[left[pos]], rn.Children[right[pos]]
+			if leftnode == nil || rightnode == nil || leftnode != rightnode {
+				break findFork
+			}
+			parent = n
+			n, pos = rn.Children[left[pos]], pos+1
+		default:
+			panic(fmt.Sprintf("%T: invalid node: %v", n, n))
+		}
+	}
+	switch rn := n.(type) {
+	case *shortNode:
+		// If both paths are on the same side of the short node, the range is empty
+		if shortForkLeft == shortForkRight {
+			return false, errors.New("empty range")
+		}
+		// If the short node is entirely inside the range, drop it
+		if shortForkLeft != 0 && shortForkRight != 0 {
+			if parent == nil {
+				return true, nil
+			}
+			parent.(*fullNode).Children[left[pos-1]] = nil
+			return false, nil
+		}
+		// Otherwise one of the paths goes through the short node
+		if shortForkRight != 0 {
+			if _, ok := rn.Val.(valueNode); ok {
+				if parent == nil {
+					return true, nil
+				}
+				parent.(*fullNode).Children[left[pos-1]] = nil
+				return false, nil
+			}
+			return false, unset(rn, rn.Val, left[pos:], len(rn.Key), false)
+		}
+		if _, ok := rn.Val.(valueNode); ok {
+			if parent == nil {
+				return true, nil
+			}
+			parent.(*fullNode).Children[right[pos-1]] = nil
+			return false, nil
+		}
+		return false, unset(rn, rn.Val, right[pos:], len(rn.Key), true)
+
+	case *fullNode:
+		// Drop all the children between the paths, then the inner sides of them
+		for i := left[pos] + 1; i < right[pos]; i++ {
+			rn.Children[i] = nil
+		}
+		if err := unset(rn, rn.Children[left[pos]], left[pos:], 1, false); err != nil {
+			return false, err
+		}
+		if err := unset(rn, rn.Children[right[pos]], right[pos:], 1, true); err != nil {
+			return false, err
+		}
+		return false, nil
+
+	default:
+		panic(fmt.Sprintf("%T: invalid node: %v", n, n))
+	}
+}
+
+// unset removes all the nodes on one side of the path below the fork point:
+// the nodes on the right of the left edge path if removeLeft is false, and the
+// nodes on the left of the right edge path otherwise. If the path doesn't exist
+// in the trie, the branch it diverges at is dropped if it's inside the range.
+func unset(parent node, child node, key []byte, pos int, removeLeft bool) error {
+	switch cld := child.(type) {
+	case *fullNode:
+		if removeLeft {
+			for i := 0; i < int(key[pos]); i++ {
+				cld.Children[i] = nil
+			}
+		} else {
+			for i := key[pos] + 1; i < 16; i++ {
+				cld.Children[i] = nil
+			}
+		}
+		cld.flags = nodeFlag{dirty: true}
+		return unset(cld, cld.Children[key[pos]], key, pos+1, removeLeft)
+
+	case *shortNode:
+		if len(key[pos:]) < len(cld.Key) || !bytes.Equal(cld.Key, key[pos:pos+len(cld.Key)]) {
+			// The path diverges here, drop the branch if it's inside the range.
+			// The parent is always a full node below the fork point.
+			cmp := bytes.Compare(cld.Key, key[pos:])
+			if (removeLeft && cmp < 0) || (!removeLeft && cmp > 0) {
+				parent.(*fullNode).Children[key[pos-1]] = nil
+			}
+			return nil
+		}
+		if _, ok := cld.Val.(valueNode); ok {
+			parent.(*fullNode).Children[key[pos-1]] = nil
+			return nil
+		}
+		cld.flags = nodeFlag{dirty: true}
+		return unset(cld, cld.Val, key, pos+len(cld.Key), removeLeft)
+
+	case nil:
+		// Non-existent branch of the fork point
+		return nil
+
+	default:
+		panic(fmt.Sprintf("%T: invalid node: %v", child, child))
+	}
+}
+
+// hasRightElement reports whether there are more elements on the right side of
+// the given path, which may point to an existing or a non-existent key. The whole
+// path must already be resolved.
+func hasRightElement(n node, key []byte) bool {
+	pos, key := 0, keybytesToHex(key)
+	for n != nil {
+		switch rn := n.(type) {
+		case *fullNode:
+			for i := key[pos] + 1; i < 16; i++ {
+				if rn.Children[i] != nil {
+					return true
+				}
+			}
+			n, pos = rn.Children[key[pos]], pos+1
+		case *shortNode:
+			if len(key)-pos < len(rn.Key) || !bytes.Equal(rn.Key, key[pos:pos+len(rn.Key)]) {
+				return bytes.Compare(rn.Key, key[pos:]) > 0
+			}
+			n, pos = rn.Val, pos+len(rn.Key)
+		case valueNode:
+			return false
+		default:
+			panic(fmt.Sprintf("%T: invalid node: %v", n, n))
+		}
+	}
+	return false
+}
+
+// VerifyRangeProof checks that the given leaves are exactly the leaves between
+// firstKey and lastKey in the trie with the given root hash. The proof must
+// contain the merkle proofs of both edge keys, which may prove the absence of
+// the keys. It reports whether the trie has more leaves on the right of the
+// range.
+//
+// If the proof is nil, the leaves must make up the whole trie. If there are no
+// leaves, the proof of firstKey must show that there is nothing on its right.
+func VerifyRangeProof(rootHash common.Hash, firstKey []byte, lastKey []byte, keys [][]byte, values [][]byte, proof DatabaseReader) (bool, error) {
+	if len(keys) != len(values) {
+		return false, fmt.Errorf("inconsistent proof data, keys: %d, values: %d", len(keys), len(values))
+	}
+	// Ensure the leaves are sorted, inside the range and contain no deletions
+	for i := 0; i < len(keys)-1; i++ {
+		if bytes.Compare(keys[i], keys[i+1]) >= 0 {
+			return false, errors.New("range is not monotonically increasing")
+		}
+	}
+	for _, value := range values {
+		if len(value) == 0 {
+			return false, errors.New("range contains deletion")
+		}
+	}
+	memdb, _ := ethdb.NewMemDatabase()
+	tr := &Trie{db: NewDatabase(memdb)}
+
+	// Without edge proofs, the leaves must make up the whole trie
+	if proof == nil {
+		for i, key := range keys {
+			if err := tr.TryUpdate(key, values[i]); err != nil {
+				return false, err
+			}
+		}
+		if have := tr.Hash(); have != rootHash {
+			return false, fmt.Errorf("invalid proof, want hash %x, got %x", rootHash, have)
+		}
+		return false, nil
+	}
+	if len(keys) > 0 && (bytes.Compare(keys[0], firstKey) < 0 || bytes.Compare(keys[len(keys)-1], lastKey) > 0) {
+		return false, errors.New("range exceeds edge keys")
+	}
+	// Without leaves, the proof must show that there is nothing right of firstKey
+	if len(keys) == 0 {
+		root, val, err := proofToPath(rootHash, nil, firstKey, proof, true)
+		if err != nil {
+			return false, err
+		}
+		if val != nil || hasRightElement(root, firstKey) {
+			return false, errors.New("more entries available")
+		}
+		return false, nil
+	}
+	// With a single leaf and equal edge keys, a single proof covers the range
+	if len(keys) == 1 && bytes.Equal(firstKey, lastKey) {
+		root, val, err := proofToPath(rootHash, nil, firstKey, proof, false)
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(firstKey, keys[0]) {
+			return false, errors.New("correct proof but invalid key")
+		}
+		if !bytes.Equal(val, values[0]) {
+			return false, errors.New("correct proof but invalid data")
+		}
+		return hasRightElement(root, firstKey), nil
+	}
+	// Otherwise both edge paths are needed
+	if bytes.Compare(firstKey, lastKey) >= 0 {
+		return false, errors.New("invalid edge keys")
+	}
+	if len(firstKey) != len(lastKey) {
+		return false, errors.New("inconsistent edge keys")
+	}
+	// Convert the edge proofs to paths, merging them into one partial trie
+	// with the shape of the original one.
+	root, _, err := proofToPath(rootHash, nil, firstKey, proof, true)
+	if err != nil {
+		return false, err
+	}
+	root, _, err = proofToPath(rootHash, root, lastKey, proof, true)
+	if err != nil {
+		return false, err
+	}
+	// Drop everything between the paths and refill it from the leaves. If the
+	// leaves are right, the trie is identical to the original one.
+	empty, err := unsetInternal(root, firstKey, lastKey)
+	if err != nil {
+		return false, err
+	}
+	if !empty {
+		tr.root = root
+	}
+	for i, key := range keys {
+		if err := tr.TryUpdate(key, values[i]); err != nil {
+			return false, err
+		}
+	}
+	if have := tr.Hash(); have != rootHash {
+		return false, fmt.Errorf("invalid proof, want hash %x, got %x", rootHash, have)
+	}
+	return hasRightElement(tr.root, keys[len(keys)-1]), nil
+}
+
+// get returns the child of the given node along the key, along with the rest
+// of the key. It returns nil if the key doesn't exist. If skipResolved is set,
+// it steps through resolved nodes and only returns at hash or value nodes.
+func get(tn node, key []byte, skipResolved bool) ([]byte, node) {
 	for {
 		switch n := tn.(type) {
 		case *shortNode:
@@ -137,9 +494,15 @@ func get(tn node, key []byte) ([]byte, node) {
 			}
 			tn = n.Val
 			key = key[len(n.Key):]
+			if !skipResolved {
+				return key, tn
+			}
 		case *fullNode:
 			tn = n.Children[key[0]]
 			key = key[1:]
+			if !skipResolved {
+				return key, tn
+			}
 		case hashNode:
 			return key, n
 		case nil:
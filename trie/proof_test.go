@@ -20,6 +20,7 @@ import (
 	"bytes"
 	crand "crypto/rand"
 	mrand "math/rand"
+	"sort"
 	"testing"
 	"time"
 
@@ -88,6 +89,181 @@ func TestVerifyBadProof(t *testing.T) {
 	}
 }
 
+// Tests that ranges of leaves are proven by the proofs of their edge keys.
+func TestRangeProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+	root := trie.Hash()
+
+	for i := 0; i < 200; i++ {
+		start := mrand.Intn(len(entries))
+		end := mrand.Intn(len(entries)-start) + start + 1
+
+		proof, _ := ethdb.NewMemDatabase()
+		if err := trie.Prove(entries[start].k, 0, proof); err != nil {
+			t.Fatalf("failed to prove the first node: %v", err)
+		}
+		if err := trie.Prove(entries[end-1].k, 0, proof); err != nil {
+			t.Fatalf("failed to prove the last node: %v", err)
+		}
+		keys, values := rangeOf(entries[start:end])
+		more, err := VerifyRangeProof(root, keys[0], keys[len(keys)-1], keys, values, proof)
+		if err != nil {
+			t.Fatalf("case %d(%d->%d): %v", i, start, end-1, err)
+		}
+		if more != (end < len(entries)) {
+			t.Fatalf("case %d(%d->%d): more elements mismatch: have %v", i, start, end-1, more)
+		}
+	}
+}
+
+// Tests that ranges can also be proven with edge keys missing from the trie.
+func TestRangeProofWithNonExistentProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+	root := trie.Hash()
+
+	for i := 0; i < 200; i++ {
+		start := mrand.Intn(len(entries))
+		end := mrand.Intn(len(entries)-start) + start + 1
+
+		first := decreaseKey(common.CopyBytes(entries[start].k))
+		if bytes.Compare(first, entries[start].k) > 0 || (start != 0 && bytes.Equal(first, entries[start-1].k)) {
+			continue
+		}
+		last := increaseKey(common.CopyBytes(entries[end-1].k))
+		if bytes.Compare(last, entries[end-1].k) < 0 || (end != len(entries) && bytes.Equal(last, entries[end].k)) {
+			continue
+		}
+		proof, _ := ethdb.NewMemDatabase()
+		trie.Prove(first, 0, proof)
+		trie.Prove(last, 0, proof)
+
+		keys, values := rangeOf(entries[start:end])
+		if _, err := VerifyRangeProof(root, first, last, keys, values, proof); err != nil {
+			t.Fatalf("case %d(%d->%d): %v", i, start, end-1, err)
+		}
+	}
+}
+
+// Tests that tampered ranges are rejected.
+func TestBadRangeProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+	root := trie.Hash()
+
+	for i := 0; i < 200; i++ {
+		start := mrand.Intn(len(entries))
+		end := mrand.Intn(len(entries)-start) + start + 1
+		if end-start < 3 {
+			continue
+		}
+		proof, _ := ethdb.NewMemDatabase()
+		trie.Prove(entries[start].k, 0, proof)
+		trie.Prove(entries[end-1].k, 0, proof)
+
+		keys, values := rangeOf(entries[start:end])
+		first, last := keys[0], keys[len(keys)-1]
+		index := mrand.Intn(end - start)
+		switch mrand.Intn(4) {
+		case 0: // Modified value
+			values[index] = randBytes(20)
+		case 1: // Dropped leaf (but not an edge)
+			index = mrand.Intn(end-start-2) + 1
+			keys = append(keys[:index], keys[index+1:]...)
+			values = append(values[:index], values[index+1:]...)
+		case 2: // Out of order leaves
+			index = mrand.Intn(end-start-1) + 1
+			keys[index-1], keys[index] = keys[index], keys[index-1]
+			values[index-1], values[index] = values[index], values[index-1]
+		case 3: // Deleted leaf
+			values[index] = nil
+		}
+		if _, err := VerifyRangeProof(root, first, last, keys, values, proof); err == nil {
+			t.Fatalf("case %d(%d->%d): tampered range accepted", i, start, end-1)
+		}
+	}
+}
+
+// Tests the special cases of single element, whole trie and empty ranges.
+func TestSpecialRangeProofs(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+	root := trie.Hash()
+
+	// Single element, proven by a single path
+	start := mrand.Intn(len(entries))
+	proof, _ := ethdb.NewMemDatabase()
+	trie.Prove(entries[start].k, 0, proof)
+	keys, values := rangeOf(entries[start : start+1])
+	if _, err := VerifyRangeProof(root, keys[0], keys[0], keys, values, proof); err != nil {
+		t.Fatalf("single element: %v", err)
+	}
+	// All elements, no proof needed
+	keys, values = rangeOf(entries)
+	if more, err := VerifyRangeProof(root, nil, nil, keys, values, nil); err != nil || more {
+		t.Fatalf("all elements: more %v, err %v", more, err)
+	}
+	if _, err := VerifyRangeProof(root, nil, nil, keys[1:], values[1:], nil); err == nil {
+		t.Fatalf("incomplete set of all elements accepted")
+	}
+	// No elements on the right of the last key
+	last := increaseKey(common.CopyBytes(entries[len(entries)-1].k))
+	proof, _ = ethdb.NewMemDatabase()
+	trie.Prove(last, 0, proof)
+	if _, err := VerifyRangeProof(root, last, nil, nil, nil, proof); err != nil {
+		t.Fatalf("empty range: %v", err)
+	}
+	first := entries[len(entries)-1].k
+	proof, _ = ethdb.NewMemDatabase()
+	trie.Prove(first, 0, proof)
+	if _, err := VerifyRangeProof(root, first, nil, nil, nil, proof); err == nil {
+		t.Fatalf("empty range with elements left accepted")
+	}
+}
+
+// sortedEntries returns the key/value pairs of a trie sorted by key.
+func sortedEntries(vals map[string]*kv) []*kv {
+	entries := make([]*kv, 0, len(vals))
+	for _, kv := range vals {
+		entries = append(entries, kv)
+	}
+	sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].k, entries[j].k) < 0 })
+	return entries
+}
+
+// rangeOf splits key/value pairs into separate lists.
+func rangeOf(entries []*kv) ([][]byte, [][]byte) {
+	var keys, values [][]byte
+	for _, kv := range entries {
+		keys = append(keys, kv.k)
+		values = append(values, common.CopyBytes(kv.v))
+	}
+	return keys, values
+}
+
+// increaseKey returns the key incremented by one, treated as a big endian number.
+func increaseKey(key []byte) []byte {
+	for i := len(key) - 1; i >= 0; i-- {
+		key[i]++
+		if key[i] != 0x0 {
+			break
+		}
+	}
+	return key
+}
+
+// decreaseKey returns the key decremented by one, treated as a big endian number.
+func decreaseKey(key []byte) []byte {
+	for i := len(key) - 1; i >= 0; i-- {
+		key[i]--
+		if key[i] != 0xff {
+			break
+		}
+	}
+	return key
+}
+
 // mutateByte changes one byte in b.
 func mutateByte(b []byte) {
 	for r := mrand.Intn(len(b)); ; {